@@ -0,0 +1,228 @@
+// Package admin provides an authenticated REST API for operators to inspect and manage rooms, clients and streams.
+//
+// All endpoints require the header "Authorization: Bearer <token>".
+//
+//	GET    /admin/rooms                              lists all rooms
+//	GET    /admin/rooms/{roomID}                     shows a room including its clients
+//	DELETE /admin/rooms/{roomID}                     closes a room by disconnecting all its clients
+//	DELETE /admin/rooms/{roomID}/streams/{clientID}  stops the stream of a client
+//	DELETE /admin/clients/{clientID}                 kicks a client
+//	GET    /admin/rooms/{roomID}/observe             joins a room as hidden observer (WebSocket), see [clients.RoleObserver]
+package admin
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"bjoernblessin.de/screenecho/clients"
+	"bjoernblessin.de/screenecho/rooms"
+	"bjoernblessin.de/screenecho/streams"
+	"bjoernblessin.de/screenecho/util/assert"
+	"github.com/google/uuid"
+)
+
+const closeReason = "closed by an administrator"
+
+type API struct {
+	// tokenHash is the SHA-256 hash of the admin token. Hashes have a fixed length, so comparing them doesn't leak the token's length.
+	tokenHash     [sha256.Size]byte
+	roomManager   *rooms.RoomManager
+	clientManager *clients.ClientManager
+	streamManager *streams.StreamManager
+}
+
+type RoomResponse struct {
+	RoomID           rooms.RoomID     `json:"roomID"`
+	ParticipantCount int              `json:"participantCount"`
+	ObserverCount    int              `json:"observerCount,omitempty"`
+	Streams          []string         `json:"streams"`
+	Clients          []ClientResponse `json:"clients,omitempty"`
+}
+
+type ClientResponse struct {
+	ClientID       string    `json:"clientID"`
+	DisplayName    string    `json:"displayName"`
+	RemoteAddress  string    `json:"remoteAddress"`
+	ConnectedSince time.Time `json:"connectedSince"`
+}
+
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// NewAPI creates the admin API. token must be non-empty.
+func NewAPI(token string, roomManager *rooms.RoomManager, clientManager *clients.ClientManager, streamManager *streams.StreamManager) *API {
+	assert.Assert(token != "", "admin token must not be empty")
+
+	return &API{
+		tokenHash:     sha256.Sum256([]byte(token)),
+		roomManager:   roomManager,
+		clientManager: clientManager,
+		streamManager: streamManager,
+	}
+}
+
+// Register registers all admin endpoints on mux.
+func (api *API) Register(mux *http.ServeMux) {
+	mux.Handle("GET /admin/rooms", api.authenticate(api.handleListRooms))
+	mux.Handle("GET /admin/rooms/{roomID}", api.authenticate(api.handleGetRoom))
+	mux.Handle("DELETE /admin/rooms/{roomID}", api.authenticate(api.handleCloseRoom))
+	mux.Handle("DELETE /admin/rooms/{roomID}/streams/{clientID}", api.authenticate(api.handleStopStream))
+	mux.Handle("DELETE /admin/clients/{clientID}", api.authenticate(api.handleKickClient))
+	mux.Handle("GET /admin/rooms/{roomID}/observe", api.authenticate(api.roomManager.HandleObserve))
+}
+
+// authenticate only calls next if the request carries the admin token as bearer token.
+func (api *API) authenticate(next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		token, found := strings.CutPrefix(request.Header.Get("Authorization"), "Bearer ")
+		tokenHash := sha256.Sum256([]byte(token))
+
+		if !found || subtle.ConstantTimeCompare(tokenHash[:], api.tokenHash[:]) != 1 {
+			writer.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			writeJSON(writer, http.StatusUnauthorized, ErrorResponse{Error: "invalid or missing admin token"})
+			return
+		}
+
+		next(writer, request)
+	})
+}
+
+func (api *API) handleListRooms(writer http.ResponseWriter, request *http.Request) {
+	snapshots := api.roomManager.Snapshot()
+
+	response := make([]RoomResponse, 0, len(snapshots))
+	for _, snapshot := range snapshots {
+		response = append(response, RoomResponse{
+			RoomID:           snapshot.RoomID,
+			ParticipantCount: len(snapshot.ClientIDs),
+			ObserverCount:    len(snapshot.ObserverIDs),
+			Streams:          clientIDStrings(api.streamManager.GetStreamingClients(snapshot.RoomID)),
+		})
+	}
+
+	slices.SortFunc(response, func(a RoomResponse, b RoomResponse) int {
+		return strings.Compare(string(a.RoomID), string(b.RoomID))
+	})
+
+	writeJSON(writer, http.StatusOK, response)
+}
+
+func (api *API) handleGetRoom(writer http.ResponseWriter, request *http.Request) {
+	room := api.roomManager.GetRoom(rooms.RoomID(request.PathValue("roomID")))
+	if room == nil {
+		writeJSON(writer, http.StatusNotFound, ErrorResponse{Error: "room not found"})
+		return
+	}
+
+	clientIDs := room.ClientIDs()
+
+	response := RoomResponse{
+		RoomID:           room.RoomID,
+		ParticipantCount: len(clientIDs),
+		Streams:          clientIDStrings(api.streamManager.GetStreamingClients(room.RoomID)),
+		Clients:          make([]ClientResponse, 0, len(clientIDs)),
+	}
+
+	for _, clientID := range clientIDs {
+		snapshot, exists := api.clientManager.GetClientSnapshot(clientID)
+		if !exists {
+			// Client disconnected in the meantime
+			continue
+		}
+
+		response.Clients = append(response.Clients, ClientResponse{
+			ClientID:       snapshot.ID.String(),
+			DisplayName:    snapshot.DisplayName,
+			RemoteAddress:  snapshot.RemoteAddr,
+			ConnectedSince: snapshot.ConnectedSince,
+		})
+	}
+
+	slices.SortFunc(response.Clients, func(a ClientResponse, b ClientResponse) int {
+		return a.ConnectedSince.Compare(b.ConnectedSince)
+	})
+
+	writeJSON(writer, http.StatusOK, response)
+}
+
+func (api *API) handleCloseRoom(writer http.ResponseWriter, request *http.Request) {
+	if !api.roomManager.CloseRoom(rooms.RoomID(request.PathValue("roomID")), closeReason) {
+		writeJSON(writer, http.StatusNotFound, ErrorResponse{Error: "room not found"})
+		return
+	}
+
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+func (api *API) handleStopStream(writer http.ResponseWriter, request *http.Request) {
+	clientID, ok := parseClientID(writer, request)
+	if !ok {
+		return
+	}
+
+	room := api.roomManager.GetRoom(rooms.RoomID(request.PathValue("roomID")))
+	if room == nil {
+		writeJSON(writer, http.StatusNotFound, ErrorResponse{Error: "room not found"})
+		return
+	}
+
+	if !api.streamManager.StopStream(room, clientID) {
+		writeJSON(writer, http.StatusNotFound, ErrorResponse{Error: "client has no active stream in this room"})
+		return
+	}
+
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+func (api *API) handleKickClient(writer http.ResponseWriter, request *http.Request) {
+	clientID, ok := parseClientID(writer, request)
+	if !ok {
+		return
+	}
+
+	client := api.clientManager.GetClientByID(clientID)
+	if client == nil {
+		writeJSON(writer, http.StatusNotFound, ErrorResponse{Error: "client not found"})
+		return
+	}
+
+	client.Disconnect(closeReason)
+
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+// parseClientID reads the clientID path value. If it's not a valid UUID, an error response is written and false is returned.
+func parseClientID(writer http.ResponseWriter, request *http.Request) (clients.ClientID, bool) {
+	clientID, err := uuid.Parse(request.PathValue("clientID"))
+	if err != nil {
+		writeJSON(writer, http.StatusBadRequest, ErrorResponse{Error: "clientID is not a valid UUID"})
+		return clients.ClientID{}, false
+	}
+
+	return clients.ClientID(clientID), true
+}
+
+func clientIDStrings(clientIDs []clients.ClientID) []string {
+	strs := make([]string, 0, len(clientIDs))
+	for _, clientID := range clientIDs {
+		strs = append(strs, clientID.String())
+	}
+	slices.Sort(strs)
+
+	return strs
+}
+
+func writeJSON(writer http.ResponseWriter, statusCode int, v any) {
+	body, err := json.Marshal(v)
+	assert.IsNil(err, "failed to marshal response")
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(statusCode)
+	writer.Write(body)
+}
@@ -0,0 +1,108 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"bjoernblessin.de/screenecho/clients"
+	"bjoernblessin.de/screenecho/connection"
+	"bjoernblessin.de/screenecho/events"
+	"bjoernblessin.de/screenecho/metrics"
+	"bjoernblessin.de/screenecho/rooms"
+	"bjoernblessin.de/screenecho/streams"
+)
+
+const testToken = "secret-token"
+
+func newTestMux() (*http.ServeMux, *rooms.RoomManager) {
+	bus := events.NewBus(events.DefaultAsyncBufferSize)
+	connManager := connection.NewConnectionManager(connection.Options{}, metrics.Nop{}, bus)
+	clientManager := clients.NewClientManager(connManager, nil, bus)
+	roomManager := rooms.NewRoomManager(clientManager, metrics.Nop{}, bus)
+	streamManager := streams.NewStreamManager(clientManager, roomManager, metrics.Nop{}, bus)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /room/generate-id", roomManager.GenerateIDHandler)
+	NewAPI(testToken, roomManager, clientManager, streamManager).Register(mux)
+
+	return mux, roomManager
+}
+
+func request(mux *http.ServeMux, method string, target string, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	recorder := httptest.NewRecorder()
+	mux.ServeHTTP(recorder, req)
+
+	return recorder
+}
+
+func TestAuthentication(t *testing.T) {
+	mux, _ := newTestMux()
+
+	tests := []struct {
+		Name         string
+		token        string
+		expectedCode int
+	}{
+		{Name: "Missing token", token: "", expectedCode: http.StatusUnauthorized},
+		{Name: "Wrong token", token: "wrong", expectedCode: http.StatusUnauthorized},
+		{Name: "Token prefix", token: "secret", expectedCode: http.StatusUnauthorized},
+		{Name: "Valid token", token: testToken, expectedCode: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			if code := request(mux, "GET", "/admin/rooms", tt.token).Code; code != tt.expectedCode {
+				t.Errorf("expected status code %d, got %d", tt.expectedCode, code)
+			}
+		})
+	}
+}
+
+func TestRoomLifecycle(t *testing.T) {
+	mux, roomManager := newTestMux()
+
+	var generated rooms.GenerateIDResponse
+	_ = json.Unmarshal(request(mux, "GET", "/room/generate-id", "").Body.Bytes(), &generated)
+
+	var listed []RoomResponse
+	_ = json.Unmarshal(request(mux, "GET", "/admin/rooms", testToken).Body.Bytes(), &listed)
+	if len(listed) != 1 || listed[0].RoomID != generated.RoomID || listed[0].ParticipantCount != 0 {
+		t.Fatalf("unexpected room list %+v", listed)
+	}
+
+	recorder := request(mux, "GET", "/admin/rooms/"+string(generated.RoomID), testToken)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, got %d", recorder.Code)
+	}
+
+	if code := request(mux, "DELETE", "/admin/rooms/"+string(generated.RoomID), testToken).Code; code != http.StatusNoContent {
+		t.Fatalf("expected status code 204, got %d", code)
+	}
+
+	if roomManager.GetRoom(generated.RoomID) != nil {
+		t.Errorf("expected empty room to be deleted immediately")
+	}
+
+	if code := request(mux, "GET", "/admin/rooms/"+string(generated.RoomID), testToken).Code; code != http.StatusNotFound {
+		t.Errorf("expected status code 404, got %d", code)
+	}
+}
+
+func TestInvalidClientID(t *testing.T) {
+	mux, _ := newTestMux()
+
+	if code := request(mux, "DELETE", "/admin/clients/not-a-uuid", testToken).Code; code != http.StatusBadRequest {
+		t.Errorf("expected status code 400, got %d", code)
+	}
+
+	if code := request(mux, "DELETE", "/admin/clients/6b1f5c3e-3f57-4a59-9d35-1c0a4a9b0f11", testToken).Code; code != http.StatusNotFound {
+		t.Errorf("expected status code 404, got %d", code)
+	}
+}
@@ -0,0 +1,529 @@
+// Package client is a Go client of the ScreenEcho protocol, e.g. for integration tests, bots and load tests.
+//
+// A [Client] joins a room with [Dial]. Received messages are passed to the handlers subscribed with
+// [Client.SubscribeMessage] or [Handle] and queued in the client's inbox, from where they are taken with [Client.Next],
+// [Receive] or the typed helpers like [Client.ReceiveStreamStarted]. Messages are sent with [Send] or the typed helpers
+// like [Client.StartStream].
+//
+// Example:
+//
+//	c, err := client.Dial(ctx, server.URL, "my-room", client.Options{})
+//	if err != nil {
+//	    return err
+//	}
+//	defer c.Close()
+//
+//	err = c.StartStream()
+//	...
+//	started, err := other.ReceiveStreamStarted(ctx)
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"bjoernblessin.de/screenecho/wire"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// ErrClosed is returned once the client was closed with [Client.Close] or lost its connection for good.
+var ErrClosed = errors.New("client is closed")
+
+const (
+	defaultInboxSize         = 256
+	defaultReconnectDelay    = 500 * time.Millisecond
+	defaultMaxReconnectDelay = 30 * time.Second
+	// joinTimeout is the time a reconnect attempt has to join the room again.
+	joinTimeout = 10 * time.Second
+	// closeTimeout is the time the server has to answer the close message of Close.
+	closeTimeout = time.Second
+)
+
+// Options configure a [Client]. The zero value is a client without reconnects.
+type Options struct {
+	// Dialer connects the WebSocket, defaults to [websocket.DefaultDialer].
+	Dialer *websocket.Dialer
+	// Header is sent with the WebSocket handshake, e.g. to set the Origin.
+	Header http.Header
+	// Observer joins the room as hidden observer through the admin API, which only receives the messages broadcast to the room.
+	// Header must hold the admin token as "Authorization: Bearer <token>". Observers can't send messages.
+	Observer bool
+	// InboxSize is the number of received messages kept for Next and Receive. If the inbox is full, the oldest message
+	// is dropped, see [Client.Dropped]. 0 means 256, a negative size disables the inbox if only handlers are used.
+	InboxSize int
+	// Reconnect enables reconnecting after the connection was lost. The client joins the same room again and gets a new ID.
+	Reconnect bool
+	// ReconnectDelay is the delay before the first reconnect attempt, it doubles with every failed attempt up to
+	// MaxReconnectDelay. The hint of a server-shutdown message is used instead if there is one.
+	// They default to 500ms and 30s.
+	ReconnectDelay    time.Duration
+	MaxReconnectDelay time.Duration
+	// MaxReconnectAttempts is the number of failed attempts after which the client gives up. 0 means no limit.
+	MaxReconnectAttempts int
+	// OnReconnect is called after the client joined the room again.
+	OnReconnect func(c *Client)
+	// OnMessage is called with every received message before the handlers, including the messages of joining the room
+	// that arrive before Dial returns. It runs on the receiving goroutine like the handlers.
+	OnMessage MessageHandler
+	// Logger receives the client's debug records and warnings about invalid messages, defaults to [slog.Default].
+	Logger *slog.Logger
+}
+
+// MessageHandler handles a message the client received.
+type MessageHandler func(*Client, wire.TypedMessage[json.RawMessage])
+
+// MessageHandlerID identifies a handler subscribed with [Client.SubscribeMessage] or [Handle].
+type MessageHandlerID uuid.UUID
+
+type messageHandlerWrapper struct {
+	id             MessageHandlerID
+	messageHandler MessageHandler
+}
+
+// Client is a connection to a room. All methods are safe for concurrent use.
+type Client struct {
+	url     string
+	options Options
+
+	// socketMutex guards socket and id, which are replaced on reconnect.
+	socketMutex sync.RWMutex
+	socket      *websocket.Conn
+	id          uuid.UUID
+	// From https://pkg.go.dev/github.com/gorilla/websocket#hdr-Concurrency: Connections support one concurrent reader and one concurrent writer.
+	writeMutex sync.Mutex
+
+	messageHandlers      map[wire.MessageType][]messageHandlerWrapper
+	messageHandlersMutex sync.RWMutex
+
+	inbox *inbox
+
+	// ctx is canceled by Close.
+	ctx    context.Context
+	cancel context.CancelFunc
+	// done is closed once the client stopped receiving for good, err holds the reason then.
+	done chan struct{}
+	err  error
+	// reconnectAfter is the reconnect hint in milliseconds of the last server-shutdown message.
+	reconnectAfter atomic.Int64
+}
+
+// Dial connects a client to the room roomID of the server at serverURL and returns once the client joined the room.
+//
+// serverURL is the base URL of the server, e.g. "ws://localhost:8080" or the URL of an [httptest.Server];
+// http and https URLs are turned into ws and wss URLs. ctx limits the time to connect and join.
+func Dial(ctx context.Context, serverURL string, roomID string, options Options) (*Client, error) {
+	connectURL, err := buildConnectURL(serverURL, roomID, options.Observer)
+	if err != nil {
+		return nil, err
+	}
+
+	if options.Dialer == nil {
+		options.Dialer = websocket.DefaultDialer
+	}
+	if options.InboxSize == 0 {
+		options.InboxSize = defaultInboxSize
+	}
+	if options.ReconnectDelay <= 0 {
+		options.ReconnectDelay = defaultReconnectDelay
+	}
+	if options.MaxReconnectDelay <= 0 {
+		options.MaxReconnectDelay = defaultMaxReconnectDelay
+	}
+	if options.Logger == nil {
+		options.Logger = slog.Default()
+	}
+
+	c := &Client{
+		url:             connectURL,
+		options:         options,
+		messageHandlers: make(map[wire.MessageType][]messageHandlerWrapper),
+		inbox:           newInbox(options.InboxSize),
+		done:            make(chan struct{}),
+	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+
+	socket, err := c.connect(ctx)
+	if err != nil {
+		c.cancel()
+		return nil, err
+	}
+
+	go c.run(socket)
+
+	return c, nil
+}
+
+func buildConnectURL(serverURL string, roomID string, observer bool) (string, error) {
+	parsed, err := url.Parse(serverURL)
+	if err != nil {
+		return "", err
+	}
+
+	switch parsed.Scheme {
+	case "http":
+		parsed.Scheme = "ws"
+	case "https":
+		parsed.Scheme = "wss"
+	case "ws", "wss":
+	default:
+		return "", fmt.Errorf("unsupported scheme of server URL %q", serverURL)
+	}
+
+	prefix, suffix := "/room/", "/connect"
+	if observer {
+		prefix, suffix = "/admin/rooms/", "/observe"
+	}
+
+	base := strings.TrimSuffix(parsed.EscapedPath(), "/")
+	parsed.Path = strings.TrimSuffix(parsed.Path, "/") + prefix + roomID + suffix
+	parsed.RawPath = base + prefix + url.PathEscape(roomID) + suffix
+
+	return parsed.String(), nil
+}
+
+// connect dials the server and reads the messages until the client joined the room, which the server confirms with
+// the streams-available message. The messages are dispatched like all others.
+func (c *Client) connect(ctx context.Context) (*websocket.Conn, error) {
+	socket, response, err := c.options.Dialer.DialContext(ctx, c.url, c.options.Header)
+	if err != nil {
+		if response != nil {
+			return nil, fmt.Errorf("connect to %s: %w (status %s)", c.url, err, response.Status)
+		}
+		return nil, fmt.Errorf("connect to %s: %w", c.url, err)
+	}
+
+	// Reading doesn't take a context, closing the socket interrupts it
+	stop := context.AfterFunc(ctx, func() { _ = socket.Close() })
+	defer stop()
+
+	var id uuid.UUID
+	for joined := false; !joined; {
+		message, err := c.readMessage(socket)
+		if err != nil {
+			_ = socket.Close()
+			if ctx.Err() != nil {
+				return nil, fmt.Errorf("join room: %w", ctx.Err())
+			}
+			return nil, fmt.Errorf("join room: %w", err)
+		}
+
+		switch message.Type {
+		case wire.CLIENT_ID_MESSAGE_TYPE:
+			var idMessage wire.ClientIDMessage
+			if err := json.Unmarshal(message.Msg, &idMessage); err != nil {
+				_ = socket.Close()
+				return nil, fmt.Errorf("join room: invalid %s message: %w", message.Type, err)
+			}
+			id, err = uuid.Parse(idMessage.ClientID)
+			if err != nil {
+				_ = socket.Close()
+				return nil, fmt.Errorf("join room: invalid %s message: %w", message.Type, err)
+			}
+
+			c.socketMutex.Lock()
+			c.socket, c.id = socket, id
+			c.socketMutex.Unlock()
+		case wire.AVAILABLE_STREAMS_MESSAGE_TYPE:
+			joined = true
+		}
+
+		c.dispatch(message)
+	}
+
+	if !stop() {
+		// ctx expired right after joining, the socket is closed already
+		return nil, fmt.Errorf("join room: %w", ctx.Err())
+	}
+
+	c.options.Logger.Debug("Joined room", "url", c.url, "client_id", id)
+
+	return socket, nil
+}
+
+// run receives the messages of socket and reconnects if enabled, until the client is closed or gives up.
+func (c *Client) run(socket *websocket.Conn) {
+	for {
+		err := c.receive(socket)
+		if c.ctx.Err() != nil {
+			c.finish(ErrClosed)
+			return
+		}
+		if !c.options.Reconnect {
+			c.finish(fmt.Errorf("%w: connection lost: %w", ErrClosed, err))
+			return
+		}
+
+		c.options.Logger.Debug("Connection lost, reconnecting", "url", c.url, "error", err)
+
+		socket, err = c.reconnect()
+		if err != nil {
+			c.finish(fmt.Errorf("%w: %w", ErrClosed, err))
+			return
+		}
+
+		if c.options.OnReconnect != nil {
+			c.options.OnReconnect(c)
+		}
+	}
+}
+
+// receive dispatches the messages of socket until reading fails.
+func (c *Client) receive(socket *websocket.Conn) error {
+	defer socket.Close()
+
+	for {
+		message, err := c.readMessage(socket)
+		if err != nil {
+			return err
+		}
+
+		c.dispatch(message)
+	}
+}
+
+func (c *Client) readMessage(socket *websocket.Conn) (wire.TypedMessage[json.RawMessage], error) {
+	var message wire.TypedMessage[json.RawMessage]
+	for {
+		_, data, err := socket.ReadMessage()
+		if err != nil {
+			return message, err
+		}
+
+		err = json.Unmarshal(data, &message)
+		if err == nil {
+			return message, nil
+		}
+		c.options.Logger.Warn("Received invalid message", "error", err, "size", len(data))
+	}
+}
+
+// reconnect connects again until it succeeds, the client is closed or MaxReconnectAttempts is reached.
+func (c *Client) reconnect() (*websocket.Conn, error) {
+	delay := c.options.ReconnectDelay
+
+	for attempt := 1; ; attempt++ {
+		wait := delay
+		if hint := c.reconnectAfter.Swap(0); hint > 0 {
+			wait = time.Duration(hint) * time.Millisecond
+		}
+
+		select {
+		case <-c.ctx.Done():
+			return nil, c.ctx.Err()
+		case <-time.After(wait):
+		}
+
+		ctx, cancel := context.WithTimeout(c.ctx, joinTimeout)
+		socket, err := c.connect(ctx)
+		cancel()
+		if err == nil {
+			if c.ctx.Err() != nil {
+				// Close was called while joining and may have missed the new socket
+				_ = socket.Close()
+				return nil, c.ctx.Err()
+			}
+			return socket, nil
+		}
+
+		c.options.Logger.Debug("Reconnect failed", "url", c.url, "attempt", attempt, "error", err)
+
+		if c.options.MaxReconnectAttempts > 0 && attempt >= c.options.MaxReconnectAttempts {
+			return nil, fmt.Errorf("reconnect failed after %d attempts: %w", attempt, err)
+		}
+		delay = min(2*delay, c.options.MaxReconnectDelay)
+	}
+}
+
+// dispatch passes message to the handlers of its type and queues it in the inbox.
+// Handlers run one after another on the receiving goroutine, so they see the messages in order.
+func (c *Client) dispatch(message wire.TypedMessage[json.RawMessage]) {
+	if message.Type == wire.SERVER_SHUTDOWN_MESSAGE_TYPE {
+		var shutdown wire.ServerShutdownMessage
+		if json.Unmarshal(message.Msg, &shutdown) == nil {
+			c.reconnectAfter.Store(shutdown.ReconnectAfterMs)
+		}
+	}
+
+	if c.options.OnMessage != nil {
+		c.options.OnMessage(c, message)
+	}
+
+	c.inbox.push(message)
+
+	c.messageHandlersMutex.RLock()
+	wrappers := slices.Clone(c.messageHandlers[message.Type])
+	c.messageHandlersMutex.RUnlock()
+
+	for _, wrapper := range wrappers {
+		wrapper.messageHandler(c, message)
+	}
+}
+
+func (c *Client) finish(err error) {
+	c.err = err
+	c.inbox.close()
+	close(c.done)
+}
+
+// SubscribeMessage subscribes handler to the messages of messageType, like the server's handlers.
+//
+// Unlike the server's handlers, handlers run one after another on the goroutine receiving the messages,
+// so they see the messages in the order the server sent them and must not block for long.
+// The messages are queued in the inbox as well.
+func (c *Client) SubscribeMessage(messageType wire.MessageType, handler MessageHandler) MessageHandlerID {
+	c.messageHandlersMutex.Lock()
+	defer c.messageHandlersMutex.Unlock()
+
+	id := MessageHandlerID(uuid.New())
+	c.messageHandlers[messageType] = append(c.messageHandlers[messageType], messageHandlerWrapper{id: id, messageHandler: handler})
+
+	return id
+}
+
+// UnsubscribeMessage removes the handler with handlerID from the handlers of messageType.
+func (c *Client) UnsubscribeMessage(messageType wire.MessageType, handlerID MessageHandlerID) {
+	c.messageHandlersMutex.Lock()
+	defer c.messageHandlersMutex.Unlock()
+
+	c.messageHandlers[messageType] = slices.DeleteFunc(c.messageHandlers[messageType], func(wrapper messageHandlerWrapper) bool {
+		return wrapper.id == handlerID
+	})
+}
+
+// Handle subscribes handler to the messages of messageType with their msg field decoded into T.
+// Messages whose payload can't be decoded are logged and skipped.
+func Handle[T any](c *Client, messageType wire.MessageType, handler func(*Client, T)) MessageHandlerID {
+	return c.SubscribeMessage(messageType, func(c *Client, message wire.TypedMessage[json.RawMessage]) {
+		var msg T
+		if err := json.Unmarshal(message.Msg, &msg); err != nil {
+			c.options.Logger.Warn("Received invalid payload", "message_type", message.Type, "error", err)
+			return
+		}
+
+		handler(c, msg)
+	})
+}
+
+// Send sends a message of messageType with the payload msg.
+func Send[T any](c *Client, messageType wire.MessageType, msg T) error {
+	data, err := json.Marshal(wire.TypedMessage[T]{Type: messageType, Msg: msg})
+	if err != nil {
+		return err
+	}
+
+	return c.SendRaw(data)
+}
+
+// SendRaw sends data as is, e.g. to test how the server handles invalid messages.
+func (c *Client) SendRaw(data []byte) error {
+	if c.ctx.Err() != nil {
+		return ErrClosed
+	}
+
+	c.socketMutex.RLock()
+	socket := c.socket
+	c.socketMutex.RUnlock()
+
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	return socket.WriteMessage(websocket.TextMessage, data)
+}
+
+// Next takes the oldest message from the inbox, waiting until there is one or ctx expires.
+// Once the client is closed and the inbox is empty, [ErrClosed] is returned.
+func (c *Client) Next(ctx context.Context) (wire.TypedMessage[json.RawMessage], error) {
+	return c.inbox.take(ctx, func(wire.MessageType) bool { return true })
+}
+
+// Receive takes the oldest message of messageType from the inbox and decodes its msg field into T.
+// It waits until there is one or ctx expires. Messages of other types stay in the inbox.
+func Receive[T any](ctx context.Context, c *Client, messageType wire.MessageType) (T, error) {
+	var msg T
+
+	message, err := c.inbox.take(ctx, func(other wire.MessageType) bool { return other == messageType })
+	if err != nil {
+		return msg, err
+	}
+
+	err = json.Unmarshal(message.Msg, &msg)
+	if err != nil {
+		return msg, fmt.Errorf("invalid %s message: %w", messageType, err)
+	}
+
+	return msg, nil
+}
+
+// Dropped returns the number of messages dropped because the inbox was full.
+func (c *Client) Dropped() uint64 {
+	return c.inbox.droppedCount()
+}
+
+// ID returns the ID the server assigned to the client. It changes on reconnect.
+func (c *Client) ID() uuid.UUID {
+	c.socketMutex.RLock()
+	defer c.socketMutex.RUnlock()
+
+	return c.id
+}
+
+// Done returns a channel that's closed once the client stopped receiving, see [Client.Err].
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns why the client stopped receiving, or nil while it's running. It wraps [ErrClosed].
+func (c *Client) Err() error {
+	select {
+	case <-c.done:
+		return c.err
+	default:
+		return nil
+	}
+}
+
+// Close closes the connection with a normal closure and stops reconnecting.
+// It waits until the server answered the close message, at most one second.
+func (c *Client) Close() error {
+	c.cancel()
+
+	select {
+	case <-c.done:
+		return nil
+	default:
+	}
+
+	c.socketMutex.RLock()
+	socket := c.socket
+	c.socketMutex.RUnlock()
+
+	c.writeMutex.Lock()
+	err := socket.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(closeTimeout))
+	c.writeMutex.Unlock()
+
+	select {
+	case <-c.done:
+	case <-time.After(closeTimeout):
+		_ = socket.Close()
+		<-c.done
+	}
+
+	if errors.Is(err, net.ErrClosed) {
+		// The connection was lost while reconnecting
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,373 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"go/build"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"bjoernblessin.de/screenecho/clients"
+	"bjoernblessin.de/screenecho/connection"
+	"bjoernblessin.de/screenecho/events"
+	"bjoernblessin.de/screenecho/metrics"
+	"bjoernblessin.de/screenecho/rooms"
+	"bjoernblessin.de/screenecho/signaling"
+	"bjoernblessin.de/screenecho/streams"
+	"github.com/google/uuid"
+)
+
+type testServer struct {
+	url           string
+	clientManager *clients.ClientManager
+}
+
+func startTestServer(t *testing.T) *testServer {
+	t.Helper()
+
+	bus := events.NewBus(events.DefaultAsyncBufferSize)
+	clientManager := clients.NewClientManager(connection.NewConnectionManager(connection.Options{}, metrics.Nop{}, bus), nil, bus)
+	roomManager := rooms.NewRoomManager(clientManager, metrics.Nop{}, bus)
+	streams.NewStreamManager(clientManager, roomManager, metrics.Nop{}, bus)
+	signaling.NewSignalingManager(clientManager)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /room/{roomID}/connect", roomManager.HandleConnect)
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	return &testServer{url: server.URL, clientManager: clientManager}
+}
+
+func dial(t *testing.T, server *testServer, roomID string, options Options) *Client {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	c, err := Dial(ctx, server.url, roomID, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = c.Close() })
+
+	return c
+}
+
+func testContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+
+	return ctx
+}
+
+func TestDial(t *testing.T) {
+	server := startTestServer(t)
+	ctx := testContext(t)
+
+	first := dial(t, server, "room", Options{})
+	second := dial(t, server, "room", Options{})
+
+	if first.ID() == second.ID() {
+		t.Errorf("expected different IDs, got %s twice", first.ID())
+	}
+
+	idMessage, err := first.ReceiveClientID(ctx)
+	if err != nil || idMessage.ClientID != first.ID().String() {
+		t.Errorf("expected client-id %s, got %v (%v)", first.ID(), idMessage, err)
+	}
+	available, err := second.ReceiveAvailableStreams(ctx)
+	if err != nil || len(available.ClientIDs) != 0 {
+		t.Errorf("expected no available streams, got %v (%v)", available, err)
+	}
+}
+
+func TestStreamsAndSignaling(t *testing.T) {
+	server := startTestServer(t)
+	ctx := testContext(t)
+
+	caller := dial(t, server, "room", Options{})
+	callee := dial(t, server, "room", Options{})
+
+	if err := caller.StartStream(); err != nil {
+		t.Fatal(err)
+	}
+	started, err := callee.ReceiveStreamStarted(ctx)
+	if err != nil || started.ClientID != caller.ID().String() {
+		t.Errorf("expected stream-started of %s, got %v (%v)", caller.ID(), started, err)
+	}
+
+	offer := json.RawMessage(`{"type":"offer","sdp":"v=0"}`)
+	if err := caller.SendSDPOffer(callee.ID(), offer); err != nil {
+		t.Fatal(err)
+	}
+	receivedOffer, err := callee.ReceiveSDPOffer(ctx)
+	if err != nil || receivedOffer.CallerClientID != caller.ID().String() || string(receivedOffer.Offer) != string(offer) {
+		t.Errorf("expected offer of %s, got %v (%v)", caller.ID(), receivedOffer, err)
+	}
+
+	if err := callee.SendICECandidate(caller.ID(), json.RawMessage(`{"candidate":""}`)); err != nil {
+		t.Fatal(err)
+	}
+	candidate, err := caller.ReceiveICECandidate(ctx)
+	if err != nil || candidate.RemoteClientID != callee.ID().String() {
+		t.Errorf("expected candidate of %s, got %v (%v)", callee.ID(), candidate, err)
+	}
+
+	calleeID := callee.ID()
+	if err := callee.Close(); err != nil {
+		t.Fatal(err)
+	}
+	disconnect, err := caller.ReceiveClientDisconnect(ctx)
+	if err != nil || disconnect.ClientID != calleeID.String() {
+		t.Errorf("expected client-disconnect of %s, got %v (%v)", calleeID, disconnect, err)
+	}
+}
+
+func TestReceiveKeepsOtherMessages(t *testing.T) {
+	server := startTestServer(t)
+	ctx := testContext(t)
+
+	c := dial(t, server, "room", Options{})
+
+	if _, err := c.ReceiveAvailableStreams(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// The client-id message was received first and is still queued
+	message, err := c.Next(ctx)
+	if err != nil || message.Type != clients.CLIENT_ID_MESSAGE_TYPE {
+		t.Errorf("expected %s, got %v (%v)", clients.CLIENT_ID_MESSAGE_TYPE, message, err)
+	}
+}
+
+func TestErrorMessage(t *testing.T) {
+	server := startTestServer(t)
+	ctx := testContext(t)
+
+	c := dial(t, server, "room", Options{})
+
+	if err := c.SendRaw([]byte(`{"type":"sdp-message","msg":{"remoteClientID":"nope"}}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	errorMessage, err := c.ReceiveError(ctx)
+	if err != nil || len(errorMessage.Fields) == 0 {
+		t.Errorf("expected an error message with invalid fields, got %v (%v)", errorMessage, err)
+	}
+}
+
+func TestHandlers(t *testing.T) {
+	server := startTestServer(t)
+
+	streamer := dial(t, server, "room", Options{})
+	viewer := dial(t, server, "room", Options{InboxSize: -1})
+
+	var mutex sync.Mutex
+	var received []connection.MessageType
+	record := func(_ *Client, message connection.TypedMessage[json.RawMessage]) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		received = append(received, message.Type)
+	}
+
+	startedCh := make(chan streams.StreamStartedMessage, 1)
+	stoppedCh := make(chan connection.TypedMessage[json.RawMessage], 1)
+	Handle(viewer, streams.STREAM_STARTED_MESSAGE_TYPE, func(_ *Client, msg streams.StreamStartedMessage) { startedCh <- msg })
+	recordID := viewer.SubscribeMessage(streams.STREAM_STOPPED_MESSAGE_TYPE, record)
+	viewer.SubscribeMessage(streams.STREAM_STOPPED_MESSAGE_TYPE, func(_ *Client, message connection.TypedMessage[json.RawMessage]) {
+		stoppedCh <- message
+	})
+
+	_ = streamer.StartStream()
+	select {
+	case started := <-startedCh:
+		if started.ClientID != streamer.ID().String() {
+			t.Errorf("expected stream-started of %s, got %v", streamer.ID(), started)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler wasn't called")
+	}
+
+	viewer.UnsubscribeMessage(streams.STREAM_STOPPED_MESSAGE_TYPE, recordID)
+	_ = streamer.StopStream()
+	select {
+	case <-stoppedCh:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler wasn't called")
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(received) != 0 {
+		t.Errorf("expected no calls of the unsubscribed handler, got %v", received)
+	}
+
+	// The inbox is disabled
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := viewer.Next(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected an empty inbox, got %v", err)
+	}
+}
+
+func TestOnMessage(t *testing.T) {
+	server := startTestServer(t)
+
+	received := make(chan connection.MessageType, 8)
+	c := dial(t, server, "room", Options{
+		OnMessage: func(_ *Client, message connection.TypedMessage[json.RawMessage]) { received <- message.Type },
+	})
+	other := dial(t, server, "room", Options{})
+
+	_ = other.StartStream()
+
+	expected := []connection.MessageType{clients.CLIENT_ID_MESSAGE_TYPE, streams.AVAILABLE_STREAMS_MESSAGE_TYPE, streams.STREAM_STARTED_MESSAGE_TYPE}
+	for _, messageType := range expected {
+		select {
+		case got := <-received:
+			if got != messageType {
+				t.Errorf("expected %s, got %s", messageType, got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("OnMessage wasn't called for %s", messageType)
+		}
+	}
+
+	// The messages are queued as well
+	if _, err := c.ReceiveStreamStarted(testContext(t)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	server := startTestServer(t)
+	ctx := testContext(t)
+
+	reconnected := make(chan uuid.UUID, 1)
+	c := dial(t, server, "room", Options{
+		Reconnect:      true,
+		ReconnectDelay: time.Millisecond,
+		OnReconnect:    func(c *Client) { reconnected <- c.ID() },
+	})
+	firstID := c.ID()
+
+	server.clientManager.GetClientByID(clients.ClientID(firstID)).Disconnect("kicked")
+
+	select {
+	case id := <-reconnected:
+		if id == firstID {
+			t.Errorf("expected a new ID after reconnecting, got %s again", id)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("client didn't reconnect")
+	}
+
+	if err := c.StartStream(); err != nil {
+		t.Fatal(err)
+	}
+	if c.Err() != nil {
+		t.Errorf("expected a running client, got %v", c.Err())
+	}
+
+	// Messages of both connections are kept
+	for range 2 {
+		if _, err := c.ReceiveClientID(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestClose(t *testing.T) {
+	server := startTestServer(t)
+	ctx := testContext(t)
+
+	tests := []struct {
+		Name  string
+		close func(c *Client)
+	}{
+		{Name: "Close", close: func(c *Client) { _ = c.Close() }},
+		{Name: "Connection lost", close: func(c *Client) { server.clientManager.GetClientByID(clients.ClientID(c.ID())).Disconnect("kicked") }},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			c := dial(t, server, "room", Options{})
+			test.close(c)
+
+			select {
+			case <-c.Done():
+			case <-time.After(5 * time.Second):
+				t.Fatal("client didn't stop")
+			}
+
+			if !errors.Is(c.Err(), ErrClosed) {
+				t.Errorf("expected ErrClosed, got %v", c.Err())
+			}
+			if err := c.StartStream(); test.Name == "Close" && !errors.Is(err, ErrClosed) {
+				t.Errorf("expected ErrClosed when sending, got %v", err)
+			}
+
+			// Queued messages are still returned
+			if _, err := c.ReceiveClientID(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := c.ReceiveClientID(ctx); !errors.Is(err, ErrClosed) {
+				t.Errorf("expected ErrClosed once the inbox is empty, got %v", err)
+			}
+		})
+	}
+}
+
+func TestBuildConnectURL(t *testing.T) {
+	tests := []struct {
+		Name      string
+		serverURL string
+		observer  bool
+		expected  string
+	}{
+		{Name: "HTTP", serverURL: "http://127.0.0.1:8080", expected: "ws://127.0.0.1:8080/room/a%20b/connect"},
+		{Name: "HTTPS with path", serverURL: "https://example.com/screenecho/", expected: "wss://example.com/screenecho/room/a%20b/connect"},
+		{Name: "WebSocket", serverURL: "ws://localhost", expected: "ws://localhost/room/a%20b/connect"},
+		{Name: "Observer", serverURL: "http://127.0.0.1:8080", observer: true, expected: "ws://127.0.0.1:8080/admin/rooms/a%20b/observe"},
+		{Name: "Unsupported scheme", serverURL: "ftp://localhost"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			connectURL, err := buildConnectURL(test.serverURL, "a b", test.observer)
+			if test.expected == "" {
+				if err == nil {
+					t.Errorf("expected an error, got %s", connectURL)
+				}
+				return
+			}
+			if err != nil || connectURL != test.expected {
+				t.Errorf("expected %s, got %s (%v)", test.expected, connectURL, err)
+			}
+		})
+	}
+}
+
+// TestImports guards that the client doesn't depend on the server. Besides package wire, which only uses the standard
+// library, the client must not import packages of the server, e.g. util/logger, whose init reads the server's environment.
+func TestImports(t *testing.T) {
+	for _, dir := range []string{".", "../wire"} {
+		pkg, err := build.ImportDir(dir, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, imported := range pkg.Imports {
+			if strings.HasPrefix(imported, "bjoernblessin.de/screenecho/") && imported != "bjoernblessin.de/screenecho/wire" {
+				t.Errorf("package %s imports %s", pkg.Name, imported)
+			}
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"sync"
+
+	"bjoernblessin.de/screenecho/wire"
+)
+
+// inbox queues the received messages of a client until they are taken. If it's full, the oldest message is dropped.
+type inbox struct {
+	mutex    sync.Mutex
+	messages []wire.TypedMessage[json.RawMessage]
+	// size is the maximum number of queued messages, the inbox is disabled if it's negative.
+	size    int
+	dropped uint64
+	closed  bool
+	// changed is closed and replaced whenever a message was queued or the inbox was closed.
+	changed chan struct{}
+}
+
+func newInbox(size int) *inbox {
+	return &inbox{
+		size:    size,
+		changed: make(chan struct{}),
+	}
+}
+
+func (in *inbox) push(message wire.TypedMessage[json.RawMessage]) {
+	if in.size < 0 {
+		return
+	}
+
+	in.mutex.Lock()
+	defer in.mutex.Unlock()
+
+	if len(in.messages) >= in.size {
+		in.messages = slices.Delete(in.messages, 0, 1)
+		in.dropped++
+	}
+	in.messages = append(in.messages, message)
+
+	in.notify()
+}
+
+// take removes and returns the oldest message whose type matches, waiting until there is one or ctx expires.
+// Queued messages are still returned after the inbox was closed.
+func (in *inbox) take(ctx context.Context, matches func(wire.MessageType) bool) (wire.TypedMessage[json.RawMessage], error) {
+	for {
+		in.mutex.Lock()
+		i := slices.IndexFunc(in.messages, func(message wire.TypedMessage[json.RawMessage]) bool {
+			return matches(message.Type)
+		})
+		if i >= 0 {
+			message := in.messages[i]
+			in.messages = slices.Delete(in.messages, i, i+1)
+			in.mutex.Unlock()
+			return message, nil
+		}
+		closed, changed := in.closed, in.changed
+		in.mutex.Unlock()
+
+		if closed {
+			return wire.TypedMessage[json.RawMessage]{}, ErrClosed
+		}
+
+		select {
+		case <-ctx.Done():
+			return wire.TypedMessage[json.RawMessage]{}, ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+func (in *inbox) close() {
+	in.mutex.Lock()
+	defer in.mutex.Unlock()
+
+	in.closed = true
+	in.notify()
+}
+
+func (in *inbox) droppedCount() uint64 {
+	in.mutex.Lock()
+	defer in.mutex.Unlock()
+
+	return in.dropped
+}
+
+// notify wakes up all waiting takes. in.mutex must be held.
+func (in *inbox) notify() {
+	close(in.changed)
+	in.changed = make(chan struct{})
+}
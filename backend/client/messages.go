@@ -0,0 +1,90 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+
+	"bjoernblessin.de/screenecho/wire"
+	"github.com/google/uuid"
+)
+
+// Typed helpers for the messages of the protocol. The payload types are shared with the server, see package wire.
+
+// StartStream announces that the client started streaming. The other clients of the room receive stream-started.
+func (c *Client) StartStream() error {
+	return Send(c, wire.STREAM_STARTED_MESSAGE_TYPE, wire.StreamStartedMessage{ClientID: c.ID().String()})
+}
+
+// StopStream announces that the client stopped streaming. The other clients of the room receive stream-stopped.
+func (c *Client) StopStream() error {
+	return Send(c, wire.STREAM_STOPPED_MESSAGE_TYPE, wire.StreamStoppedMessage{ClientID: c.ID().String()})
+}
+
+// SendSDPOffer sends a WebRTC offer to the client callee.
+func (c *Client) SendSDPOffer(callee uuid.UUID, offer json.RawMessage) error {
+	return Send(c, wire.SDP_OFFER_MESSAGE_TYPE, wire.ClientSDPOfferMessage{CalleeClientID: callee.String(), Offer: offer})
+}
+
+// SendSDPAnswer answers the offer of the client caller.
+func (c *Client) SendSDPAnswer(caller uuid.UUID, answer json.RawMessage) error {
+	return Send(c, wire.SDP_ANSWER_MESSAGE_TYPE, wire.SDPAnswerMessage{CallerClientID: caller.String(), Answer: answer})
+}
+
+// SendICECandidate sends an ICE candidate to the client remote.
+func (c *Client) SendICECandidate(remote uuid.UUID, candidate json.RawMessage) error {
+	return Send(c, wire.ICE_CANDIDATE_MESSAGE_TYPE, wire.ICEMessage{RemoteClientID: remote.String(), Candidate: candidate})
+}
+
+// SendSDPMessage sends a session description to the client remote.
+func (c *Client) SendSDPMessage(remote uuid.UUID, description wire.SessionDescription) error {
+	return Send(c, wire.SDP_MESSAGE_TYPE, wire.SDPMessage{RemoteClientID: remote.String(), Description: description})
+}
+
+func (c *Client) ReceiveClientID(ctx context.Context) (wire.ClientIDMessage, error) {
+	return Receive[wire.ClientIDMessage](ctx, c, wire.CLIENT_ID_MESSAGE_TYPE)
+}
+
+func (c *Client) ReceiveAvailableStreams(ctx context.Context) (wire.AvailableStreamsMessage, error) {
+	return Receive[wire.AvailableStreamsMessage](ctx, c, wire.AVAILABLE_STREAMS_MESSAGE_TYPE)
+}
+
+func (c *Client) ReceiveStreamStarted(ctx context.Context) (wire.StreamStartedMessage, error) {
+	return Receive[wire.StreamStartedMessage](ctx, c, wire.STREAM_STARTED_MESSAGE_TYPE)
+}
+
+func (c *Client) ReceiveStreamStopped(ctx context.Context) (wire.StreamStoppedMessage, error) {
+	return Receive[wire.StreamStoppedMessage](ctx, c, wire.STREAM_STOPPED_MESSAGE_TYPE)
+}
+
+func (c *Client) ReceiveClientDisconnect(ctx context.Context) (wire.ClientDisconnectMessage, error) {
+	return Receive[wire.ClientDisconnectMessage](ctx, c, wire.CLIENT_DISCONNECT_MESSAGE_TYPE)
+}
+
+func (c *Client) ReceiveSDPOffer(ctx context.Context) (wire.ServerSDPOfferMessage, error) {
+	return Receive[wire.ServerSDPOfferMessage](ctx, c, wire.SDP_OFFER_MESSAGE_TYPE)
+}
+
+func (c *Client) ReceiveSDPAnswer(ctx context.Context) (wire.SDPAnswerMessage, error) {
+	return Receive[wire.SDPAnswerMessage](ctx, c, wire.SDP_ANSWER_MESSAGE_TYPE)
+}
+
+func (c *Client) ReceiveICECandidate(ctx context.Context) (wire.ICEMessage, error) {
+	return Receive[wire.ICEMessage](ctx, c, wire.ICE_CANDIDATE_MESSAGE_TYPE)
+}
+
+func (c *Client) ReceiveSDPMessage(ctx context.Context) (wire.SDPMessage, error) {
+	return Receive[wire.SDPMessage](ctx, c, wire.SDP_MESSAGE_TYPE)
+}
+
+// ReceiveError returns the next error message, the server's answer to an invalid or rejected message.
+func (c *Client) ReceiveError(ctx context.Context) (wire.ErrorMessage, error) {
+	return Receive[wire.ErrorMessage](ctx, c, wire.ERROR_MESSAGE_TYPE)
+}
+
+func (c *Client) ReceiveInternalError(ctx context.Context) (wire.InternalErrorMessage, error) {
+	return Receive[wire.InternalErrorMessage](ctx, c, wire.INTERNAL_ERROR_MESSAGE_TYPE)
+}
+
+func (c *Client) ReceiveServerShutdown(ctx context.Context) (wire.ServerShutdownMessage, error) {
+	return Receive[wire.ServerShutdownMessage](ctx, c, wire.SERVER_SHUTDOWN_MESSAGE_TYPE)
+}
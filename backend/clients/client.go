@@ -1,30 +1,67 @@
 package clients
 
 import (
+	"context"
+	"time"
+
 	"bjoernblessin.de/screenecho/connection"
+	"bjoernblessin.de/screenecho/wire"
 	"github.com/google/uuid"
 )
 
 type ClientID uuid.UUID
 
-type Client struct {
-	ID          ClientID
-	DisplayName string
-	conn        *connection.Conn // conn is always unique to one Client
+// Role is the role of a client in its room.
+type Role int
+
+const (
+	// RoleParticipant is the role of regular clients, they stream, watch and signal each other.
+	RoleParticipant Role = iota
+	// RoleObserver is the role of hidden clients that only receive the messages broadcast to their room, e.g. to debug it.
+	// Observers aren't listed among the clients of their room, can't be signaled and can't send messages.
+	RoleObserver
+)
+
+func (role Role) String() string {
+	if role == RoleObserver {
+		return "observer"
+	}
+	return "participant"
 }
 
-const CLIENT_ID_MESSAGE_TYPE = "client-id"
+type Client struct {
+	ID             ClientID
+	DisplayName    string
+	Role           Role             // fixed when the client connects
+	RemoteAddr     string           // network address of the client (or of the last proxy in front of the server)
+	ConnectedSince time.Time        // time the WebSocket connection was established
+	conn           *connection.Conn // conn is always unique to one Client
+	resumeToken    string           // resumeToken is sent to the client with its ID, see [ClientManager.NewClientWithRole]
+}
 
-type clientIDMessage struct {
-	ClientID string `json:"clientID"`
+// ClientSnapshot is a copy of a Client's public information that is safe to read without locks.
+type ClientSnapshot struct {
+	ID             ClientID
+	DisplayName    string
+	Role           Role
+	RemoteAddr     string
+	ConnectedSince time.Time
 }
 
-// sendClientID sends the previously generated UUID to the client.
+const CLIENT_ID_MESSAGE_TYPE = wire.CLIENT_ID_MESSAGE_TYPE
+
+// ClientIDMessage is the first message a client receives, it holds the ID the server assigned to the client.
+type ClientIDMessage = wire.ClientIDMessage
+
+// RESUME_QUERY_PARAMETER is the query parameter that holds the resume token of a reconnecting client.
+const RESUME_QUERY_PARAMETER = wire.RESUME_QUERY_PARAMETER
+
+// sendClientID sends the previously generated UUID and the resume token to the client.
 // The client should only listen to this message type once because the UUID will last until the client leaves the room.
 func (client *Client) sendClientID() {
-	message := connection.TypedMessage[clientIDMessage]{
+	message := connection.TypedMessage[ClientIDMessage]{
 		Type: CLIENT_ID_MESSAGE_TYPE,
-		Msg:  clientIDMessage{ClientID: uuid.UUID(client.ID).String()},
+		Msg:  ClientIDMessage{ClientID: uuid.UUID(client.ID).String(), ResumeToken: client.resumeToken},
 	}
 
 	SendMessage(client, message)
@@ -38,10 +75,26 @@ func SendMessage[T any](client *Client, msg connection.TypedMessage[T]) {
 	_ = connection.SendMessage(client.conn, msg)
 }
 
+// Disconnect closes the client's connection, e.g. to kick the client.
+// The disconnect handlers are executed once the connection is closed.
+func (client *Client) Disconnect(reason string) {
+	client.conn.Close(connection.ClosePolicyViolation, reason)
+}
+
 // RegisterDisconnectHandler registers a handler function that is called when the client's connection is closed.
-// This allows for cleanup operations.
-func (client *Client) RegisterDisconnectHandler(handler func()) {
-	client.conn.AddCloseHandler(handler)
+// This allows for cleanup operations. Calling the returned function removes the handler, see [connection.Conn.AddCloseHandler].
+func (client *Client) RegisterDisconnectHandler(handler func()) (remove func()) {
+	return client.conn.AddCloseHandler(handler)
+}
+
+// IsObserver reports whether the client is a hidden observer, see [RoleObserver].
+func (client *Client) IsObserver() bool {
+	return client.Role == RoleObserver
+}
+
+// Context returns the context holding the log fields of the client (request ID, room ID, client ID).
+func (client *Client) Context() context.Context {
+	return client.conn.Context()
 }
 
 func (id ClientID) String() string {
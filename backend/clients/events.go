@@ -0,0 +1,14 @@
+package clients
+
+// Events published by the ClientManager, see package events.
+
+// ClientConnected is published when a client connected, after it received its ID and before it joins a room.
+type ClientConnected struct {
+	Client *Client
+}
+
+// ClientDisconnected is published when the connection of a client was closed, after the client was removed from the
+// managed clients. It's published by a close handler, so it isn't ordered with the events of the client's room.
+type ClientDisconnected struct {
+	Client *Client
+}
@@ -0,0 +1,152 @@
+package clients
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"slices"
+	"strings"
+
+	"bjoernblessin.de/screenecho/connection"
+	"bjoernblessin.de/screenecho/util/assert"
+	"bjoernblessin.de/screenecho/util/strictjson"
+)
+
+// Direction tells who sends the messages of a message type.
+type Direction int
+
+const (
+	// Inbound messages are sent by clients to the server, see [Handle].
+	Inbound Direction = iota
+	// Outbound messages are sent by the server to clients, see [RegisterOutbound].
+	Outbound
+)
+
+func (d Direction) String() string {
+	if d == Outbound {
+		return "outbound"
+	}
+	return "inbound"
+}
+
+// MessageTypeInfo describes a message type that was registered with [Handle] or [RegisterOutbound].
+type MessageTypeInfo struct {
+	Type      connection.MessageType
+	Direction Direction
+	// Payload is the type of the msg field of the message.
+	Payload reflect.Type
+}
+
+type messageTypeKey struct {
+	messageType connection.MessageType
+	direction   Direction
+}
+
+// Handle subscribes handler to messages of messageType. The msg field of a message is decoded into T with
+// [strictjson.Unmarshal], so handler only receives valid payloads, including the rules of validate tags.
+//
+// If the payload is invalid, the client receives an error message listing the invalid fields, see
+// [connection.BuildInvalidMessageError], and handler isn't called.
+// If handler returns an error, the client receives an error message with the error's text.
+// A returned [*strictjson.Error] or [strictjson.Errors] is reported like an invalid payload.
+//
+// The payload type is registered for messageType, see [ClientManager.MessageTypes].
+// A message type can't be registered with different payload types.
+// Invalid validate tags of T panic right away instead of with the first message.
+//
+// Example:
+//
+//	type RenameMessage struct {
+//	    DisplayName string `json:"displayName" validate:"min=1,max=32"`
+//	}
+//
+//	clients.Handle(cm, "rename", func(client *clients.Client, msg RenameMessage) error {
+//	    if taken(msg.DisplayName) {
+//	        return errors.New("Display name is already taken.")
+//	    }
+//	    ...
+//	    return nil
+//	})
+func Handle[T any](cm *ClientManager, messageType connection.MessageType, handler func(*Client, T) error) connection.MessageHandlerID {
+	cm.registerMessageType(messageType, Inbound, reflect.TypeFor[T]())
+
+	return cm.SubscribeMessage(messageType, func(client *Client, typedMessage connection.TypedMessage[json.RawMessage]) {
+		var msg T
+		err := strictjson.Unmarshal(typedMessage.Msg, &msg)
+		if err != nil {
+			SendMessage(client, connection.BuildInvalidMessageError(err))
+			return
+		}
+
+		err = handler(client, msg)
+		if err != nil {
+			SendMessage(client, buildHandlerErrorMessage(err))
+		}
+	})
+}
+
+func buildHandlerErrorMessage(err error) connection.TypedMessage[connection.ErrorMessage] {
+	var fieldError *strictjson.Error
+	var fieldErrors strictjson.Errors
+	if errors.As(err, &fieldError) || errors.As(err, &fieldErrors) {
+		return connection.BuildInvalidMessageError(err)
+	}
+
+	return connection.BuildErrorMessage(err.Error())
+}
+
+// RegisterOutbound registers T as the payload of the messages of messageType the server sends to clients.
+// It only documents the protocol, see [ClientManager.MessageTypes], sending works without it.
+// A message type can't be registered with different payload types. Invalid validate tags of T panic right away.
+func RegisterOutbound[T any](cm *ClientManager, messageType connection.MessageType) {
+	cm.registerMessageType(messageType, Outbound, reflect.TypeFor[T]())
+}
+
+func (cm *ClientManager) registerMessageType(messageType connection.MessageType, direction Direction, payload reflect.Type) {
+	compileFields(payload, make(map[reflect.Type]bool))
+
+	cm.messageTypesMutex.Lock()
+	defer cm.messageTypesMutex.Unlock()
+
+	key := messageTypeKey{messageType: messageType, direction: direction}
+	registered, exists := cm.messageTypes[key]
+	assert.Assert(!exists || registered == payload, direction, "message type", messageType, "is already registered with payload", registered)
+
+	cm.messageTypes[key] = payload
+}
+
+// compileFields compiles the validate tags of typ and of the types of its fields with [strictjson.Fields],
+// which panics for invalid tags. visited guards against recursive types.
+func compileFields(typ reflect.Type, visited map[reflect.Type]bool) {
+	for typ.Kind() == reflect.Pointer || typ.Kind() == reflect.Slice || typ.Kind() == reflect.Array || typ.Kind() == reflect.Map {
+		typ = typ.Elem()
+	}
+	if typ.Kind() != reflect.Struct || visited[typ] {
+		return
+	}
+	visited[typ] = true
+
+	for _, field := range strictjson.Fields(typ) {
+		compileFields(field.Type, visited)
+	}
+}
+
+// MessageTypes returns the message types registered with [Handle] and [RegisterOutbound], sorted by type.
+// A type that is both sent and received is returned once per direction, the inbound one first.
+func (cm *ClientManager) MessageTypes() []MessageTypeInfo {
+	cm.messageTypesMutex.RLock()
+	defer cm.messageTypesMutex.RUnlock()
+
+	infos := make([]MessageTypeInfo, 0, len(cm.messageTypes))
+	for key, payload := range cm.messageTypes {
+		infos = append(infos, MessageTypeInfo{Type: key.messageType, Direction: key.direction, Payload: payload})
+	}
+	slices.SortFunc(infos, func(a, b MessageTypeInfo) int {
+		if c := strings.Compare(string(a.Type), string(b.Type)); c != 0 {
+			return c
+		}
+		return int(a.Direction) - int(b.Direction)
+	})
+
+	return infos
+}
@@ -0,0 +1,200 @@
+package clients
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"bjoernblessin.de/screenecho/connection"
+	"bjoernblessin.de/screenecho/util/strictjson"
+	"github.com/gorilla/websocket"
+)
+
+type greetMessage struct {
+	Name string `json:"name" validate:"min=1,max=8"`
+}
+
+type greetedMessage struct {
+	Greeting string `json:"greeting"`
+}
+
+func TestHandle(t *testing.T) {
+	cm := newTestClientManager()
+
+	Handle(cm, "greet", func(client *Client, msg greetMessage) error {
+		switch msg.Name {
+		case "error":
+			return errors.New("Name is reserved.")
+		case "invalid":
+			return strictjson.Errors{{Kind: strictjson.InvalidValue, Path: "name", Expected: "a free name", Actual: `"invalid"`}}
+		}
+
+		SendMessage(client, connection.TypedMessage[greetedMessage]{Type: "greeted", Msg: greetedMessage{Greeting: "Hello " + msg.Name}})
+		return nil
+	})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := cm.NewClient(w, r); err != nil {
+			t.Errorf("failed to create client: %v", err)
+		}
+	}))
+	defer server.Close()
+
+	socket, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer socket.Close()
+
+	var clientIDMessage connection.TypedMessage[map[string]string]
+	if err := socket.ReadJSON(&clientIDMessage); err != nil || clientIDMessage.Type != CLIENT_ID_MESSAGE_TYPE {
+		t.Fatalf("expected client-id message, got %v (%v)", clientIDMessage, err)
+	}
+
+	tests := []struct {
+		Name         string
+		Msg          string
+		ExpectedType connection.MessageType
+		Expected     any
+	}{
+		{
+			Name:         "valid payload",
+			Msg:          `{"name": "Ada"}`,
+			ExpectedType: "greeted",
+			Expected:     &greetedMessage{Greeting: "Hello Ada"},
+		},
+		{
+			Name:         "invalid payload",
+			Msg:          `{"name": ""}`,
+			ExpectedType: connection.ERROR_MESSAGE_TYPE,
+			Expected: &connection.ErrorMessage{
+				ErrorMessage: "Message had invalid values. Invalid value at name: expected at least 1 characters, got 0 characters",
+				Expected:     "at least 1 characters",
+				Actual:       "0 characters",
+				Fields:       []connection.FieldError{{Path: "msg.name", Expected: "at least 1 characters", Actual: "0 characters"}},
+			},
+		},
+		{
+			Name:         "unexpected field",
+			Msg:          `{"name": "Ada", "age": 36}`,
+			ExpectedType: connection.ERROR_MESSAGE_TYPE,
+			Expected: &connection.ErrorMessage{
+				ErrorMessage: "Message had invalid JSON format. Unexpected field: age",
+				Fields:       []connection.FieldError{{Path: "msg.age"}},
+			},
+		},
+		{
+			Name:         "handler error",
+			Msg:          `{"name": "error"}`,
+			ExpectedType: connection.ERROR_MESSAGE_TYPE,
+			Expected:     &connection.ErrorMessage{ErrorMessage: "Name is reserved."},
+		},
+		{
+			Name:         "handler field error",
+			Msg:          `{"name": "invalid"}`,
+			ExpectedType: connection.ERROR_MESSAGE_TYPE,
+			Expected: &connection.ErrorMessage{
+				ErrorMessage: `Message had invalid values. Invalid value at name: expected a free name, got "invalid"`,
+				Expected:     "a free name",
+				Actual:       `"invalid"`,
+				Fields:       []connection.FieldError{{Path: "msg.name", Expected: "a free name", Actual: `"invalid"`}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			err := socket.WriteMessage(websocket.TextMessage, []byte(`{"type": "greet", "msg": `+test.Msg+`}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			actual := reflect.New(reflect.TypeOf(test.Expected).Elem()).Interface()
+			response := connection.TypedMessage[any]{Msg: actual}
+			if err := socket.ReadJSON(&response); err != nil {
+				t.Fatal(err)
+			}
+
+			if response.Type != test.ExpectedType || !reflect.DeepEqual(actual, test.Expected) {
+				t.Errorf("expected %s %+v, got %s %+v", test.ExpectedType, test.Expected, response.Type, actual)
+			}
+		})
+	}
+}
+
+func TestMessageTypes(t *testing.T) {
+	cm := newTestClientManager()
+
+	Handle(cm, "b", func(*Client, greetedMessage) error { return nil })
+	Handle(cm, "a", func(*Client, greetMessage) error { return nil })
+	Handle(cm, "a", func(*Client, greetMessage) error { return nil })
+
+	RegisterOutbound[greetedMessage](cm, "a")
+
+	expected := []MessageTypeInfo{
+		{Type: "a", Direction: Inbound, Payload: reflect.TypeFor[greetMessage]()},
+		{Type: "a", Direction: Outbound, Payload: reflect.TypeFor[greetedMessage]()},
+		{Type: "b", Direction: Inbound, Payload: reflect.TypeFor[greetedMessage]()},
+		{Type: CLIENT_ID_MESSAGE_TYPE, Direction: Outbound, Payload: reflect.TypeFor[ClientIDMessage]()},
+	}
+	var types []MessageTypeInfo
+	connectionTypes := []connection.MessageType{connection.ERROR_MESSAGE_TYPE, connection.INTERNAL_ERROR_MESSAGE_TYPE, connection.SERVER_SHUTDOWN_MESSAGE_TYPE}
+	for _, info := range cm.MessageTypes() {
+		// Skip the message types of the connection package
+		if !slices.Contains(connectionTypes, info.Type) {
+			types = append(types, info)
+		}
+	}
+	if !reflect.DeepEqual(types, expected) {
+		t.Errorf("expected %v, got %v", expected, types)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic for a message type with a different payload")
+		}
+	}()
+	Handle(cm, "a", func(*Client, greetedMessage) error { return nil })
+}
+
+func TestHandleInvalidRule(t *testing.T) {
+	type invalidRule struct {
+		Count int `json:"count" validate:"uuid"`
+	}
+	type nestedInvalidRule struct {
+		Items []invalidRule `json:"items"`
+	}
+
+	tests := []struct {
+		Name     string
+		Register func(cm *ClientManager)
+	}{
+		{
+			Name:     "inbound",
+			Register: func(cm *ClientManager) { Handle(cm, "invalid", func(*Client, invalidRule) error { return nil }) },
+		},
+		{
+			Name:     "outbound",
+			Register: func(cm *ClientManager) { RegisterOutbound[invalidRule](cm, "invalid") },
+		},
+		{
+			Name:     "nested",
+			Register: func(cm *ClientManager) { RegisterOutbound[nestedInvalidRule](cm, "invalid") },
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("expected a panic for an invalid rule at registration")
+				}
+			}()
+			test.Register(newTestClientManager())
+		})
+	}
+}
@@ -4,59 +4,202 @@
 package clients
 
 import (
+	"context"
+	"crypto/rand"
 	"encoding/json"
 	"net/http"
+	"reflect"
 	"sync"
+	"time"
 
 	"bjoernblessin.de/screenecho/connection"
+	"bjoernblessin.de/screenecho/events"
 	"bjoernblessin.de/screenecho/util/assert"
+	"bjoernblessin.de/screenecho/util/logger"
 	"github.com/google/uuid"
 )
 
+var log = logger.New("clients")
+
 type ClientManager struct {
-	clients      map[ClientID]*Client
+	clients map[ClientID]*Client
+	// clientsByConn indexes clients by their connection, so that incoming messages are attributed to their client in O(1).
+	// It always holds the same clients as clients, both are guarded by clientsMutex.
+	clientsByConn map[*connection.Conn]*Client
+	// disconnected holds the removed clients whose connections are still running their close handlers.
+	// It's guarded by clientsMutex.
+	disconnected map[*connection.Conn]*Client
 	clientsMutex sync.RWMutex
-	connManager  *connection.ConnectionManager
+	// sessions holds the sessions of the clients that can be resumed, see NewClientWithRole.
+	sessions SessionStorage
+	// resumeTTL is the time a disconnected client can be resumed.
+	resumeTTL   time.Duration
+	connManager *connection.ConnectionManager
+	bus         *events.Bus
+	// messageTypes holds the payload type of every message type registered with Handle or RegisterOutbound.
+	messageTypes      map[messageTypeKey]reflect.Type
+	messageTypesMutex sync.RWMutex
 }
 
 type MessageHandler func(*Client, connection.TypedMessage[json.RawMessage])
 
-func NewClientManager(connManager *connection.ConnectionManager) *ClientManager {
-	return &ClientManager{
-		clients:     make(map[ClientID]*Client),
-		connManager: connManager,
+// defaultResumeTTL is the default of ClientManager.resumeTTL. It covers a page reload, a short network outage
+// or a restart of the server.
+const defaultResumeTTL = 30 * time.Second
+
+// sessionStorageTimeout bounds saving a session of a closed connection.
+const sessionStorageTimeout = 5 * time.Second
+
+// NewClientManager creates a ClientManager that publishes ClientConnected and ClientDisconnected events to bus.
+// The sessions of disconnected clients are kept in sessions, nil keeps them in memory.
+func NewClientManager(connManager *connection.ConnectionManager, sessions SessionStorage, bus *events.Bus) *ClientManager {
+	if sessions == nil {
+		sessions = newMemorySessionStorage()
+	}
+
+	cm := &ClientManager{
+		clients:       make(map[ClientID]*Client),
+		clientsByConn: make(map[*connection.Conn]*Client),
+		disconnected:  make(map[*connection.Conn]*Client),
+		sessions:      sessions,
+		resumeTTL:     defaultResumeTTL,
+		connManager:   connManager,
+		bus:           bus,
+		messageTypes:  make(map[messageTypeKey]reflect.Type),
 	}
+
+	events.Subscribe(bus, cm.saveSession)
+
+	RegisterOutbound[ClientIDMessage](cm, CLIENT_ID_MESSAGE_TYPE)
+	// Sent by the connection package to every connection
+	RegisterOutbound[connection.ErrorMessage](cm, connection.ERROR_MESSAGE_TYPE)
+	RegisterOutbound[connection.InternalErrorMessage](cm, connection.INTERNAL_ERROR_MESSAGE_TYPE)
+	RegisterOutbound[connection.ServerShutdownMessage](cm, connection.SERVER_SHUTDOWN_MESSAGE_TYPE)
+
+	return cm
 }
 
+// NewClient establishes the WebSocket connection of request and creates a participant, see [RoleParticipant].
 func (cm *ClientManager) NewClient(writer http.ResponseWriter, request *http.Request) (*Client, error) {
+	return cm.NewClientWithRole(writer, request, RoleParticipant)
+}
+
+// NewClientWithRole is like NewClient but creates a client with role.
+//
+// A client that lost its connection can resume its ID and display name by passing the resume token it received
+// with its ID in the RESUME_QUERY_PARAMETER, see [ClientIDMessage]. The token can be used once within resumeTTL
+// after the old connection was closed. An unknown or expired token, or a session of another role, is ignored
+// and the client gets a new ID.
+func (cm *ClientManager) NewClientWithRole(writer http.ResponseWriter, request *http.Request, role Role) (*Client, error) {
+	var clientID = ClientID(uuid.New())
+	var displayName = ""
+
+	previous, resumed := cm.takeSession(request, role)
+	if resumed {
+		clientID = previous.ClientID
+		displayName = previous.DisplayName
+	}
+
+	request = request.WithContext(logger.WithClientID(request.Context(), clientID.String()))
+
 	conn, err := cm.connManager.EstablishWebSocket(writer, request)
 	if err != nil {
 		return nil, err
 	}
 
-	var clientID = ClientID(uuid.New())
+	client := &Client{
+		ID:             clientID,
+		DisplayName:    displayName,
+		Role:           role,
+		RemoteAddr:     request.RemoteAddr,
+		ConnectedSince: time.Now(),
+		conn:           conn,
+		resumeToken:    rand.Text(),
+	}
 
-	client := &Client{ID: clientID, DisplayName: "", conn: conn}
+	cm.addClient(client)
 
-	cm.clientsMutex.Lock()
-	defer cm.clientsMutex.Unlock()
-
-	cm.clients[clientID] = client
+	if resumed {
+		log.InfoContext(client.Context(), "Client resumed")
+	}
 
 	client.sendClientID()
 
+	events.Publish(cm.bus, ClientConnected{Client: client})
+
 	conn.AddCloseHandler(func() {
 		cm.removeClient(clientID)
+
+		events.Publish(cm.bus, ClientDisconnected{Client: client})
 	})
 
 	return client, nil
 }
 
+// addClient adds client to the managed clients and the connection index.
+func (cm *ClientManager) addClient(client *Client) {
+	cm.clientsMutex.Lock()
+	defer cm.clientsMutex.Unlock()
+
+	assert.Assert(cm.clients[client.ID] == nil, "client with this ID already exists")
+
+	cm.clients[client.ID] = client
+	cm.clientsByConn[client.conn] = client
+}
+
+// removeClient removes the client with clientID from the managed clients and the connection index.
+// If the client doesn't exist, the method has no effect.
 func (cm *ClientManager) removeClient(clientID ClientID) {
 	cm.clientsMutex.Lock()
 	defer cm.clientsMutex.Unlock()
 
+	client, exists := cm.clients[clientID]
+	if !exists {
+		return
+	}
+
 	delete(cm.clients, clientID)
+	delete(cm.clientsByConn, client.conn)
+	cm.disconnected[client.conn] = client
+}
+
+// saveSession makes the client of a closed connection resumable. ConnectionClosed is published after all close handlers
+// finished, so the client already left its room and resuming its ID can't collide with the old connection.
+func (cm *ClientManager) saveSession(event connection.ConnectionClosed) {
+	cm.clientsMutex.Lock()
+	client, exists := cm.disconnected[event.Conn]
+	delete(cm.disconnected, event.Conn)
+	cm.clientsMutex.Unlock()
+
+	if !exists {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(client.Context(), sessionStorageTimeout)
+	defer cancel()
+
+	session := Session{ClientID: client.ID, DisplayName: client.DisplayName, Role: client.Role}
+	err := cm.sessions.Save(ctx, client.resumeToken, session, cm.resumeTTL)
+	if err != nil {
+		log.WarnContext(ctx, "Failed to save session, the client can't resume it", "error", err)
+	}
+}
+
+// takeSession removes and returns the session of the resume token of request if it has role.
+// The boolean is false if the request has no token or there is no such session.
+func (cm *ClientManager) takeSession(request *http.Request, role Role) (Session, bool) {
+	resumeToken := request.URL.Query().Get(RESUME_QUERY_PARAMETER)
+	if resumeToken == "" {
+		return Session{}, false
+	}
+
+	session, exists, err := cm.sessions.Take(request.Context(), resumeToken)
+	if err != nil {
+		log.WarnContext(request.Context(), "Failed to take session, the client gets a new ID", "error", err)
+		return Session{}, false
+	}
+
+	return session, exists && session.Role == role
 }
 
 // GetClientByWebSocket does exactly that.
@@ -65,12 +208,7 @@ func (cm *ClientManager) GetClientByWebSocket(conn *connection.Conn) *Client {
 	cm.clientsMutex.RLock()
 	defer cm.clientsMutex.RUnlock()
 
-	for _, client := range cm.clients {
-		if client.conn == conn {
-			return client
-		}
-	}
-	return nil
+	return cm.clientsByConn[conn]
 }
 
 // GetClientByID does exactly that.
@@ -82,11 +220,40 @@ func (cm *ClientManager) GetClientByID(id ClientID) *Client {
 	return cm.clients[id]
 }
 
-// SubscribeMessage is a wrapper for [connection.SubscribeMessage].
+// GetClientSnapshot returns a copy of the public information of the client with ID.
+// The boolean is false if the client doesn't exist.
+func (cm *ClientManager) GetClientSnapshot(id ClientID) (ClientSnapshot, bool) {
+	cm.clientsMutex.RLock()
+	defer cm.clientsMutex.RUnlock()
+
+	client, exists := cm.clients[id]
+	if !exists {
+		return ClientSnapshot{}, false
+	}
+
+	return ClientSnapshot{
+		ID:             client.ID,
+		DisplayName:    client.DisplayName,
+		Role:           client.Role,
+		RemoteAddr:     client.RemoteAddr,
+		ConnectedSince: client.ConnectedSince,
+	}, true
+}
+
+// SubscribeMessage is a wrapper for [connection.SubscribeMessage]. Messages of observers are rejected, see [RoleObserver].
+// Prefer [Handle], which decodes and validates the message.
 func (cm *ClientManager) SubscribeMessage(messageType connection.MessageType, handler MessageHandler) connection.MessageHandlerID {
 	return cm.connManager.SubscribeMessage(messageType, func(conn *connection.Conn, tm connection.TypedMessage[json.RawMessage]) {
 		client := cm.GetClientByWebSocket(conn)
-		assert.IsNotNil(client)
+		if client == nil {
+			// Handlers run concurrently, the client may have disconnected since the message was received
+			log.DebugContext(conn.Context(), "Dropped message of disconnected client", "message_type", tm.Type)
+			return
+		}
+		if client.IsObserver() {
+			SendMessage(client, connection.BuildErrorMessage("Observers can't send messages."))
+			return
+		}
 
 		handler(client, tm)
 	})
@@ -0,0 +1,285 @@
+package clients
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"bjoernblessin.de/screenecho/connection"
+	"bjoernblessin.de/screenecho/events"
+	"bjoernblessin.de/screenecho/metrics"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+func newTestClientManager() *ClientManager {
+	return newTestClientManagerWithBus(events.NewBus(events.DefaultAsyncBufferSize))
+}
+
+func newTestClientManagerWithBus(bus *events.Bus) *ClientManager {
+	return NewClientManager(connection.NewConnectionManager(connection.Options{}, metrics.Nop{}, bus), nil, bus)
+}
+
+// newTestClient returns a client with a unique, unconnected Conn.
+func newTestClient() *Client {
+	return &Client{ID: ClientID(uuid.New()), conn: &connection.Conn{}}
+}
+
+// assertConsistent fails if the connection index doesn't hold exactly the managed clients.
+func assertConsistent(t *testing.T, cm *ClientManager) {
+	t.Helper()
+
+	cm.clientsMutex.RLock()
+	defer cm.clientsMutex.RUnlock()
+
+	if len(cm.clients) != len(cm.clientsByConn) {
+		t.Fatalf("index holds %d clients, expected %d", len(cm.clientsByConn), len(cm.clients))
+	}
+	for _, client := range cm.clients {
+		if cm.clientsByConn[client.conn] != client {
+			t.Fatalf("client %s is missing in the index", client.ID)
+		}
+	}
+}
+
+func TestGetClientByWebSocket(t *testing.T) {
+	cm := newTestClientManager()
+
+	first, second := newTestClient(), newTestClient()
+	cm.addClient(first)
+	cm.addClient(second)
+
+	if client := cm.GetClientByWebSocket(first.conn); client != first {
+		t.Errorf("expected first client, got %v", client)
+	}
+	if client := cm.GetClientByWebSocket(&connection.Conn{}); client != nil {
+		t.Errorf("expected nil for an unknown connection, got %v", client)
+	}
+
+	cm.removeClient(first.ID)
+	cm.removeClient(first.ID)
+
+	if client := cm.GetClientByWebSocket(first.conn); client != nil {
+		t.Errorf("expected nil for a removed client, got %v", client)
+	}
+	if client := cm.GetClientByWebSocket(second.conn); client != second {
+		t.Errorf("expected second client, got %v", client)
+	}
+	assertConsistent(t, cm)
+}
+
+func TestClientIndexConcurrent(t *testing.T) {
+	cm := newTestClientManager()
+
+	const workers, clientsPerWorker = 8, 200
+
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for range clientsPerWorker {
+				client := newTestClient()
+				cm.addClient(client)
+
+				if found := cm.GetClientByWebSocket(client.conn); found != client {
+					t.Errorf("client %s not found by its connection", client.ID)
+				}
+
+				cm.removeClient(client.ID)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assertConsistent(t, cm)
+	if len(cm.clients) != 0 {
+		t.Errorf("expected no clients, got %d", len(cm.clients))
+	}
+}
+
+func TestClientRemovedFromIndexOnClose(t *testing.T) {
+	cm := newTestClientManager()
+
+	clientsCh := make(chan *Client, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client, err := cm.NewClient(w, r)
+		if err != nil {
+			t.Errorf("failed to create client: %v", err)
+			return
+		}
+		clientsCh <- client
+	}))
+	defer server.Close()
+
+	socket, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := <-clientsCh
+	if found := cm.GetClientByWebSocket(client.conn); found != client {
+		t.Fatalf("expected the connected client, got %v", found)
+	}
+
+	_ = socket.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for cm.GetClientByWebSocket(client.conn) != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("client was not removed from the index after its connection closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assertConsistent(t, cm)
+}
+
+func TestTakeSession(t *testing.T) {
+	session := Session{ClientID: ClientID(uuid.New()), DisplayName: "Alice", Role: RoleParticipant}
+
+	tests := []struct {
+		Name     string
+		TTL      time.Duration
+		URL      string
+		Role     Role
+		Expected bool
+	}{
+		{Name: "resumed", TTL: time.Minute, URL: "/?resume=token", Role: RoleParticipant, Expected: true},
+		{Name: "no token", TTL: time.Minute, URL: "/", Role: RoleParticipant, Expected: false},
+		{Name: "unknown token", TTL: time.Minute, URL: "/?resume=other", Role: RoleParticipant, Expected: false},
+		{Name: "other role", TTL: time.Minute, URL: "/?resume=token", Role: RoleObserver, Expected: false},
+		{Name: "expired", TTL: time.Millisecond, URL: "/?resume=token", Role: RoleParticipant, Expected: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			cm := newTestClientManager()
+			if err := cm.sessions.Save(context.Background(), "token", session, test.TTL); err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(10 * time.Millisecond)
+
+			resumed, ok := cm.takeSession(httptest.NewRequest(http.MethodGet, test.URL, nil), test.Role)
+			if ok != test.Expected || (ok && resumed != session) {
+				t.Errorf("expected resumed %v, got %v (%+v)", test.Expected, ok, resumed)
+			}
+		})
+	}
+
+	t.Run("used token", func(t *testing.T) {
+		cm := newTestClientManager()
+		_ = cm.sessions.Save(context.Background(), "token", session, time.Minute)
+		request := httptest.NewRequest(http.MethodGet, "/?resume=token", nil)
+
+		if _, ok := cm.takeSession(request, RoleParticipant); !ok {
+			t.Fatal("expected the first use to resume the session")
+		}
+		if _, ok := cm.takeSession(request, RoleParticipant); ok {
+			t.Error("expected the token to be usable once")
+		}
+	})
+}
+
+func TestClientEvents(t *testing.T) {
+	bus := events.NewBus(events.DefaultAsyncBufferSize)
+	cm := newTestClientManagerWithBus(bus)
+
+	var mutex sync.Mutex
+	var received []string
+	record := func(event string) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		received = append(received, event)
+	}
+
+	disconnected := make(chan struct{})
+	events.Subscribe(bus, func(connection.ConnectionOpened) { record("connection opened") })
+	events.Subscribe(bus, func(event ClientConnected) {
+		if cm.GetClientByID(event.Client.ID) != event.Client {
+			t.Error("expected the connected client to be managed")
+		}
+		record("client connected")
+	})
+	events.Subscribe(bus, func(event ClientDisconnected) {
+		if cm.GetClientByID(event.Client.ID) != nil {
+			t.Error("expected the disconnected client to be removed")
+		}
+		record("client disconnected")
+	})
+	events.Subscribe(bus, func(connection.ConnectionClosed) {
+		record("connection closed")
+		close(disconnected)
+	})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := cm.NewClient(w, r); err != nil {
+			t.Errorf("failed to create client: %v", err)
+		}
+	}))
+	defer server.Close()
+
+	socket, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = socket.Close()
+
+	select {
+	case <-disconnected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected ConnectionClosed within 5s")
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	expected := []string{"connection opened", "client connected", "client disconnected", "connection closed"}
+	if !slices.Equal(received, expected) {
+		t.Errorf("expected %v, got %v", expected, received)
+	}
+}
+
+// scanClientByWebSocket is the linear search GetClientByWebSocket used before the index, kept as benchmark baseline.
+func scanClientByWebSocket(cm *ClientManager, conn *connection.Conn) *Client {
+	cm.clientsMutex.RLock()
+	defer cm.clientsMutex.RUnlock()
+
+	for _, client := range cm.clients {
+		if client.conn == conn {
+			return client
+		}
+	}
+	return nil
+}
+
+func BenchmarkGetClientByWebSocket(b *testing.B) {
+	for _, n := range []int{10, 100, 1000, 10000} {
+		cm := newTestClientManager()
+
+		conns := make([]*connection.Conn, n)
+		for i := range n {
+			client := newTestClient()
+			cm.addClient(client)
+			conns[i] = client.conn
+		}
+
+		b.Run(fmt.Sprintf("index/clients=%d", n), func(b *testing.B) {
+			for i := 0; b.Loop(); i++ {
+				_ = cm.GetClientByWebSocket(conns[i%n])
+			}
+		})
+
+		b.Run(fmt.Sprintf("scan/clients=%d", n), func(b *testing.B) {
+			for i := 0; b.Loop(); i++ {
+				_ = scanClientByWebSocket(cm, conns[i%n])
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package clients
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Session is what a reconnecting client resumes, see [ClientManager.NewClientWithRole].
+type Session struct {
+	ClientID    ClientID
+	DisplayName string
+	Role        Role
+}
+
+// SessionStorage keeps the sessions of disconnected clients by their resume token until they are resumed or expire.
+// A storage shared by several servers lets clients resume their ID on another server, e.g. after a restart.
+// It must be safe for concurrent use.
+type SessionStorage interface {
+	// Save stores session for resumeToken until ttl passed.
+	Save(ctx context.Context, resumeToken string, session Session, ttl time.Duration) error
+	// Take removes and returns the session of resumeToken. The boolean is false if there is none or it expired.
+	Take(ctx context.Context, resumeToken string) (Session, bool, error)
+}
+
+// memorySessionStorage is the default SessionStorage, its sessions are lost when the process exits.
+type memorySessionStorage struct {
+	sessions map[string]Session
+	mutex    sync.Mutex
+}
+
+func newMemorySessionStorage() *memorySessionStorage {
+	return &memorySessionStorage{sessions: make(map[string]Session)}
+}
+
+func (storage *memorySessionStorage) Save(_ context.Context, resumeToken string, session Session, ttl time.Duration) error {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+
+	storage.sessions[resumeToken] = session
+
+	// Resume tokens are never reused, so this only deletes the session saved above
+	time.AfterFunc(ttl, func() {
+		storage.mutex.Lock()
+		defer storage.mutex.Unlock()
+
+		delete(storage.sessions, resumeToken)
+	})
+
+	return nil
+}
+
+func (storage *memorySessionStorage) Take(_ context.Context, resumeToken string) (Session, bool, error) {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+
+	session, exists := storage.sessions[resumeToken]
+	delete(storage.sessions, resumeToken)
+
+	return session, exists, nil
+}
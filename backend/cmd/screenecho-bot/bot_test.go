@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"bjoernblessin.de/screenecho/admin"
+	"bjoernblessin.de/screenecho/client"
+	"bjoernblessin.de/screenecho/clients"
+	"bjoernblessin.de/screenecho/connection"
+	"bjoernblessin.de/screenecho/events"
+	"bjoernblessin.de/screenecho/metrics"
+	"bjoernblessin.de/screenecho/rooms"
+	"bjoernblessin.de/screenecho/signaling"
+	"bjoernblessin.de/screenecho/streams"
+)
+
+const testToken = "secret-token"
+
+func startTestServer(t *testing.T) string {
+	t.Helper()
+
+	bus := events.NewBus(events.DefaultAsyncBufferSize)
+	clientManager := clients.NewClientManager(connection.NewConnectionManager(connection.Options{}, metrics.Nop{}, bus), nil, bus)
+	roomManager := rooms.NewRoomManager(clientManager, metrics.Nop{}, bus)
+	streamManager := streams.NewStreamManager(clientManager, roomManager, metrics.Nop{}, bus)
+	signaling.NewSignalingManager(clientManager)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /room/{roomID}/connect", roomManager.HandleConnect)
+	admin.NewAPI(testToken, roomManager, clientManager, streamManager).Register(mux)
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	return server.URL
+}
+
+// startRecording records the room to a file until the test ends, see waitForTypes.
+func startRecording(t *testing.T, serverURL string, roomID string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "recording.jsonl")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := record(ctx, serverURL, roomID, authorization(testToken), false, file)
+		done <- err
+	}()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("recording failed: %v", err)
+		}
+		_ = file.Close()
+	})
+
+	// The observer joined once its streams-available message is recorded
+	waitForTypes(t, path, streams.AVAILABLE_STREAMS_MESSAGE_TYPE)
+
+	return path
+}
+
+func readTypes(t *testing.T, path string) []connection.MessageType {
+	t.Helper()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	entries, err := readRecording(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	types := make([]connection.MessageType, 0, len(entries))
+	for _, e := range entries {
+		types = append(types, e.Type)
+	}
+
+	return types
+}
+
+// waitForTypes waits until the recording at path ends with the message types suffix.
+func waitForTypes(t *testing.T, path string, suffix ...connection.MessageType) []connection.MessageType {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		types := readTypes(t, path)
+		if len(types) >= len(suffix) && slices.Equal(types[len(types)-len(suffix):], suffix) {
+			return types
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the recording to end with %v, got %v", suffix, types)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func dial(t *testing.T, serverURL string, roomID string) *client.Client {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	c, err := client.Dial(ctx, serverURL, roomID, client.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = c.Close() })
+
+	return c
+}
+
+// scenario makes two clients stream, stop and leave and returns the message types the observer receives.
+func scenario(t *testing.T, serverURL string, roomID string, recording string) []connection.MessageType {
+	t.Helper()
+
+	first, second := dial(t, serverURL, roomID), dial(t, serverURL, roomID)
+
+	_ = first.StartStream()
+	waitForTypes(t, recording, streams.STREAM_STARTED_MESSAGE_TYPE)
+	_ = second.StartStream()
+	waitForTypes(t, recording, streams.STREAM_STARTED_MESSAGE_TYPE, streams.STREAM_STARTED_MESSAGE_TYPE)
+	_ = first.StopStream()
+	waitForTypes(t, recording, streams.STREAM_STOPPED_MESSAGE_TYPE)
+	_ = first.Close()
+	waitForTypes(t, recording, rooms.CLIENT_DISCONNECT_MESSAGE_TYPE)
+	_ = second.Close()
+
+	return waitForTypes(t, recording, rooms.CLIENT_DISCONNECT_MESSAGE_TYPE, rooms.CLIENT_DISCONNECT_MESSAGE_TYPE)
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	serverURL := startTestServer(t)
+	recording := startRecording(t, serverURL, "room")
+
+	recorded := scenario(t, serverURL, "room", recording)
+
+	expected := []connection.MessageType{
+		clients.CLIENT_ID_MESSAGE_TYPE,
+		streams.AVAILABLE_STREAMS_MESSAGE_TYPE,
+		streams.STREAM_STARTED_MESSAGE_TYPE,
+		streams.STREAM_STARTED_MESSAGE_TYPE,
+		streams.STREAM_STOPPED_MESSAGE_TYPE,
+		rooms.CLIENT_DISCONNECT_MESSAGE_TYPE,
+		rooms.CLIENT_DISCONNECT_MESSAGE_TYPE,
+	}
+	if !slices.Equal(recorded, expected) {
+		t.Fatalf("expected the recording %v, got %v", expected, recorded)
+	}
+
+	file, err := os.Open(recording)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := readRecording(file)
+	_ = file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries[0].RoomID != "room" || entries[0].Time.IsZero() || entries[6].Time.Before(entries[0].Time) {
+		t.Errorf("expected timestamped entries of the room, got %+v", entries)
+	}
+
+	// Replaying in another room of a fresh server reproduces the sequence
+	replayServerURL := startTestServer(t)
+	replayed := startRecording(t, replayServerURL, "replayed")
+
+	var log bytes.Buffer
+	err = replay(context.Background(), replayOptions{ServerURL: replayServerURL, RoomID: "replayed", Speed: 10}, entries, &log)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waitForTypes(t, replayed, expected[2:]...)
+	if got := readTypes(t, replayed); !slices.Equal(got, expected) {
+		t.Errorf("expected the replay to reproduce %v, got %v", expected, got)
+	}
+	if !strings.Contains(log.String(), "client-id (skipped)") {
+		t.Errorf("expected the observer's own messages to be skipped, got log:\n%s", log.String())
+	}
+}
+
+func TestRecordRequiresToken(t *testing.T) {
+	serverURL := startTestServer(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := record(ctx, serverURL, "room", authorization("wrong"), false, &bytes.Buffer{}); err == nil {
+		t.Error("expected observing with a wrong token to fail")
+	}
+}
+
+func TestReadRecording(t *testing.T) {
+	tests := []struct {
+		Name      string
+		input     string
+		entries   int
+		expectErr bool
+	}{
+		{Name: "Empty", input: ""},
+		{Name: "Entries", input: `{"time":"2026-01-02T03:04:05Z","roomID":"r","type":"stream-started","msg":{"clientID":"x"}}` + "\n\n" +
+			`{"time":"2026-01-02T03:04:06Z","roomID":"r","type":"stream-stopped","msg":{"clientID":"x"}}` + "\n", entries: 2},
+		{Name: "Invalid line", input: "{}\nnope\n", expectErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			entries, err := readRecording(strings.NewReader(test.input))
+			if test.expectErr {
+				if err == nil || !strings.Contains(err.Error(), "line 2") {
+					t.Errorf("expected an error in line 2, got %v", err)
+				}
+				return
+			}
+			if err != nil || len(entries) != test.entries {
+				t.Errorf("expected %d entries, got %d (%v)", test.entries, len(entries), err)
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+// Command screenecho-bot records the control plane of a room and replays recordings, e.g. to reproduce customer reports.
+//
+// The record command joins a room as hidden observer through the admin API and writes every message it receives as
+// one JSON object per line. Observers receive everything broadcast to the room (streams starting and stopping,
+// clients leaving), but they don't appear among the room's clients and aren't part of the signaling between them.
+//
+//	screenecho-bot record -server https://screenecho.example.com -room abc123 -out abc123.jsonl
+//
+// The admin token is read from -token or $ADMIN_TOKEN. Recording runs until Ctrl+C or -duration is over.
+//
+// The replay command reproduces a recording against a (test) server: every recorded client is simulated by a client
+// that starts and stops its stream and leaves at the recorded times.
+//
+//	screenecho-bot replay -server http://localhost:8080 -in abc123.jsonl -speed 2
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"bjoernblessin.de/screenecho/util/env"
+)
+
+const usage = `Usage:
+  screenecho-bot record -room ID [-server URL] [-token TOKEN] [-out FILE] [-duration D] [-reconnect]
+  screenecho-bot replay -in FILE [-server URL] [-room ID] [-speed S]
+
+Run "screenecho-bot record -h" or "screenecho-bot replay -h" for the flags.
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var err error
+	switch os.Args[1] {
+	case "record":
+		err = runRecord(ctx, os.Args[2:])
+	case "replay":
+		err = runReplay(ctx, os.Args[2:])
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "screenecho-bot:", err)
+		os.Exit(1)
+	}
+}
+
+func runRecord(ctx context.Context, args []string) error {
+	defaultToken, _ := env.ReadOptionalEnv("ADMIN_TOKEN")
+
+	flags := flag.NewFlagSet("record", flag.ExitOnError)
+	serverURL := flags.String("server", "http://localhost:8080", "base URL of the server")
+	roomID := flags.String("room", "", "ID of the observed room (required)")
+	token := flags.String("token", defaultToken, "admin token of the server (default $ADMIN_TOKEN)")
+	out := flags.String("out", "", "path of the recording, appended to if it exists (default stdout)")
+	duration := flags.Duration("duration", 0, "stop recording after this duration (default until Ctrl+C)")
+	reconnect := flags.Bool("reconnect", false, "observe again after the connection was lost")
+	_ = flags.Parse(args)
+
+	if *roomID == "" || *token == "" {
+		return fmt.Errorf("-room and -token (or $ADMIN_TOKEN) are required")
+	}
+
+	w := os.Stdout
+	if *out != "" {
+		file, err := os.OpenFile(*out, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+
+	if *duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *duration)
+		defer cancel()
+	}
+
+	fmt.Fprintf(os.Stderr, "Observing room %s on %s\n", *roomID, *serverURL)
+
+	count, err := record(ctx, *serverURL, *roomID, authorization(*token), *reconnect, w)
+	fmt.Fprintf(os.Stderr, "Recorded %d messages\n", count)
+
+	return err
+}
+
+func runReplay(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	serverURL := flags.String("server", "http://localhost:8080", "base URL of the server")
+	in := flags.String("in", "", "path of the recording (required)")
+	roomID := flags.String("room", "", "ID of the room to replay in (default the recorded room)")
+	speed := flags.Float64("speed", 1, "speed factor of the replay, 0 replays without waiting")
+	origin := flags.String("origin", "", "Origin header of the WebSocket handshakes, required if the server checks origins")
+	_ = flags.Parse(args)
+
+	if *in == "" {
+		return fmt.Errorf("-in is required")
+	}
+	if *speed < 0 {
+		return fmt.Errorf("-speed must not be negative")
+	}
+
+	file, err := os.Open(*in)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	entries, err := readRecording(file)
+	if err != nil {
+		return fmt.Errorf("read %s: %w", *in, err)
+	}
+
+	var header http.Header
+	if *origin != "" {
+		header = http.Header{"Origin": []string{*origin}}
+	}
+
+	started := time.Now()
+	err = replay(ctx, replayOptions{ServerURL: *serverURL, RoomID: *roomID, Speed: *speed, Header: header}, entries, os.Stdout)
+	fmt.Fprintf(os.Stderr, "Replayed %d messages in %s\n", len(entries), time.Since(started).Round(time.Millisecond))
+
+	return err
+}
+
+func authorization(token string) http.Header {
+	return http.Header{"Authorization": []string{"Bearer " + token}}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"bjoernblessin.de/screenecho/client"
+	"bjoernblessin.de/screenecho/wire"
+)
+
+// entry is a line of a recording, a message the observer received and when.
+type entry struct {
+	Time   time.Time        `json:"time"`
+	RoomID string           `json:"roomID"`
+	Type   wire.MessageType `json:"type"`
+	Msg    json.RawMessage  `json:"msg"`
+}
+
+// recorder writes the received messages to a recording. Every line is flushed, so the recording can be followed live.
+type recorder struct {
+	mutex   sync.Mutex
+	roomID  string
+	writer  *bufio.Writer
+	encoder *json.Encoder
+	count   int
+	// err is the first write error, later messages are dropped.
+	err error
+}
+
+func newRecorder(w io.Writer, roomID string) *recorder {
+	writer := bufio.NewWriter(w)
+	encoder := json.NewEncoder(writer)
+	encoder.SetEscapeHTML(false)
+
+	return &recorder{roomID: roomID, writer: writer, encoder: encoder}
+}
+
+// record is a [client.MessageHandler] writing message to the recording.
+func (r *recorder) record(_ *client.Client, message wire.TypedMessage[json.RawMessage]) {
+	received := time.Now()
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.err != nil {
+		return
+	}
+
+	r.err = r.encoder.Encode(entry{Time: received, RoomID: r.roomID, Type: message.Type, Msg: message.Msg})
+	if r.err == nil {
+		r.err = r.writer.Flush()
+	}
+	if r.err == nil {
+		r.count++
+	}
+}
+
+func (r *recorder) result() (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.count, r.err
+}
+
+// record observes the room with roomID until ctx is done or the connection is lost and writes every received message to w.
+// header must authenticate the observer, see [client.Options.Observer]. Returns the number of recorded messages.
+func record(ctx context.Context, serverURL string, roomID string, header http.Header, reconnect bool, w io.Writer) (int, error) {
+	rec := newRecorder(w, roomID)
+
+	c, err := client.Dial(ctx, serverURL, roomID, client.Options{
+		Header:    header,
+		Observer:  true,
+		InboxSize: -1,
+		Reconnect: reconnect,
+		OnMessage: rec.record,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	select {
+	case <-ctx.Done():
+		_ = c.Close()
+	case <-c.Done():
+	}
+
+	count, err := rec.result()
+	if err != nil {
+		return count, fmt.Errorf("write recording: %w", err)
+	}
+	if ctx.Err() == nil {
+		// The client stopped on its own, so the connection was lost for good
+		return count, c.Err()
+	}
+
+	return count, nil
+}
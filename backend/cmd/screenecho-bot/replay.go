@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"bjoernblessin.de/screenecho/client"
+	"bjoernblessin.de/screenecho/wire"
+)
+
+const (
+	// minStepGap is the minimum time between two replayed messages. The server handles the messages of a connection
+	// concurrently, so a stream stopped right after it was started could be processed first without it.
+	minStepGap = 20 * time.Millisecond
+	// maxLineSize is the maximum size of a line of a recording.
+	maxLineSize = 1 << 20
+	joinTimeout = 10 * time.Second
+)
+
+// readRecording parses a recording written by record. Empty lines are skipped.
+func readRecording(r io.Reader) ([]entry, error) {
+	var entries []entry
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var e entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, scanner.Err()
+}
+
+type replayOptions struct {
+	ServerURL string
+	// RoomID is the room to replay in, it defaults to the room of the first entry.
+	RoomID string
+	// Speed scales the recorded delays, 2 replays twice as fast. 0 replays without delays, but keeps minStepGap.
+	Speed  float64
+	Header http.Header
+}
+
+// replayer simulates the recorded clients. The clients are created when they appear in the recording for the first time.
+type replayer struct {
+	options replayOptions
+	// participants maps the recorded client IDs to the clients simulating them.
+	participants map[string]*client.Client
+	streaming    map[string]bool
+	log          io.Writer
+}
+
+// replay replays entries against the server of options and writes every step to log.
+// The clients that are still in the room at the end of the recording leave it before replay returns.
+func replay(ctx context.Context, options replayOptions, entries []entry, log io.Writer) error {
+	if len(entries) == 0 {
+		return errors.New("recording is empty")
+	}
+	if options.RoomID == "" {
+		options.RoomID = entries[0].RoomID
+	}
+
+	r := &replayer{
+		options:      options,
+		participants: make(map[string]*client.Client),
+		streaming:    make(map[string]bool),
+		log:          log,
+	}
+	defer r.closeAll()
+
+	started := time.Now()
+	for _, e := range entries {
+		if err := r.wait(ctx, started, e.Time.Sub(entries[0].Time)); err != nil {
+			return err
+		}
+
+		if err := r.step(ctx, e); err != nil {
+			return fmt.Errorf("replay %s of %s: %w", e.Type, e.Time.Format(time.RFC3339Nano), err)
+		}
+	}
+
+	return nil
+}
+
+// wait waits until offset (scaled by the speed) has passed since started, but at least minStepGap.
+func (r *replayer) wait(ctx context.Context, started time.Time, offset time.Duration) error {
+	delay := minStepGap
+	if r.options.Speed > 0 {
+		delay = max(time.Until(started.Add(time.Duration(float64(offset)/r.options.Speed))), minStepGap)
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(delay):
+		return nil
+	}
+}
+
+// step replays a recorded message by making the simulated clients send what caused it.
+func (r *replayer) step(ctx context.Context, e entry) error {
+	switch e.Type {
+	case wire.AVAILABLE_STREAMS_MESSAGE_TYPE:
+		// The streams that were active when the observer joined
+		var msg wire.AvailableStreamsMessage
+		if err := json.Unmarshal(e.Msg, &msg); err != nil {
+			return err
+		}
+		for _, clientID := range msg.ClientIDs {
+			if err := r.startStream(ctx, clientID); err != nil {
+				return err
+			}
+		}
+	case wire.STREAM_STARTED_MESSAGE_TYPE:
+		var msg wire.StreamStartedMessage
+		if err := json.Unmarshal(e.Msg, &msg); err != nil {
+			return err
+		}
+		return r.startStream(ctx, msg.ClientID)
+	case wire.STREAM_STOPPED_MESSAGE_TYPE:
+		var msg wire.StreamStoppedMessage
+		if err := json.Unmarshal(e.Msg, &msg); err != nil {
+			return err
+		}
+		c := r.participants[msg.ClientID]
+		if c == nil || !r.streaming[msg.ClientID] {
+			// Stopped by an administrator or before the recording started
+			r.logStep(e.Type, msg.ClientID, nil, "skipped, no replayed stream")
+			return nil
+		}
+		r.streaming[msg.ClientID] = false
+		r.logStep(e.Type, msg.ClientID, c, "")
+		return c.StopStream()
+	case wire.CLIENT_DISCONNECT_MESSAGE_TYPE:
+		var msg wire.ClientDisconnectMessage
+		if err := json.Unmarshal(e.Msg, &msg); err != nil {
+			return err
+		}
+		// A client that never streamed appears for the first time when it leaves, it joins now to leave right after
+		c, err := r.participant(ctx, msg.ClientID)
+		if err != nil {
+			return err
+		}
+		delete(r.participants, msg.ClientID)
+		delete(r.streaming, msg.ClientID)
+		r.logStep(e.Type, msg.ClientID, c, "")
+		return c.Close()
+	default:
+		// The observer's own messages (client-id, errors, server-shutdown) aren't caused by the room's clients
+		r.logStep(e.Type, "", nil, "skipped")
+	}
+
+	return nil
+}
+
+func (r *replayer) startStream(ctx context.Context, clientID string) error {
+	c, err := r.participant(ctx, clientID)
+	if err != nil {
+		return err
+	}
+	if r.streaming[clientID] {
+		// Recorded again, e.g. in the streams-available message after the observer reconnected
+		r.logStep(wire.STREAM_STARTED_MESSAGE_TYPE, clientID, c, "skipped, already streaming")
+		return nil
+	}
+
+	r.streaming[clientID] = true
+	r.logStep(wire.STREAM_STARTED_MESSAGE_TYPE, clientID, c, "")
+	return c.StartStream()
+}
+
+// participant returns the client simulating the recorded client with clientID and connects it if needed.
+func (r *replayer) participant(ctx context.Context, clientID string) (*client.Client, error) {
+	if c := r.participants[clientID]; c != nil {
+		return c, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, joinTimeout)
+	defer cancel()
+
+	c, err := client.Dial(ctx, r.options.ServerURL, r.options.RoomID, client.Options{Header: r.options.Header, InboxSize: -1})
+	if err != nil {
+		return nil, err
+	}
+	r.participants[clientID] = c
+
+	return c, nil
+}
+
+func (r *replayer) logStep(messageType wire.MessageType, recordedID string, c *client.Client, note string) {
+	line := string(messageType)
+	if recordedID != "" {
+		line += " " + recordedID
+	}
+	if c != nil {
+		line += " as " + c.ID().String()
+	}
+	if note != "" {
+		line += " (" + note + ")"
+	}
+
+	fmt.Fprintf(r.log, "%s %s\n", time.Now().Format(time.TimeOnly+".000"), line)
+}
+
+func (r *replayer) closeAll() {
+	for _, c := range r.participants {
+		_ = c.Close()
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"time"
+
+	"bjoernblessin.de/screenecho/client"
+	"bjoernblessin.de/screenecho/wire"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// inboxSize is the inbox size of the simulated clients.
+const inboxSize = 16
+
+// options configure a load test, see the flags in main.
+type options struct {
+	ServerURL string
+	Origin    string
+	// Header is sent with every WebSocket handshake.
+	Header http.Header
+
+	Clients       int
+	Rooms         int
+	RoomPrefix    string
+	StreamRatio   float64
+	ICECandidates int
+
+	Duration       time.Duration
+	RampUp         time.Duration
+	Session        time.Duration
+	Pause          time.Duration
+	ConnectTimeout time.Duration
+}
+
+func defaultOptions() options {
+	return options{
+		ServerURL:      "http://localhost:8080",
+		Clients:        1000,
+		Rooms:          50,
+		RoomPrefix:     "loadtest-",
+		StreamRatio:    0.2,
+		ICECandidates:  3,
+		Duration:       time.Minute,
+		RampUp:         10 * time.Second,
+		Session:        20 * time.Second,
+		Pause:          2 * time.Second,
+		ConnectTimeout: 10 * time.Second,
+	}
+}
+
+func (o options) validate() error {
+	switch {
+	case o.Clients <= 0:
+		return errors.New("clients must be positive")
+	case o.Rooms <= 0:
+		return errors.New("rooms must be positive")
+	case o.StreamRatio < 0 || o.StreamRatio > 1:
+		return errors.New("stream-ratio must be between 0 and 1")
+	case o.ICECandidates < 0:
+		return errors.New("ice-candidates must not be negative")
+	case o.Duration <= 0 || o.Session <= 0 || o.ConnectTimeout <= 0:
+		return errors.New("duration, session and connect-timeout must be positive")
+	case o.RampUp < 0 || o.Pause < 0:
+		return errors.New("ramp-up and pause must not be negative")
+	}
+
+	return nil
+}
+
+// run runs the load test until options.Duration is over or ctx is canceled and returns the results.
+// Clients that are in a room at the end leave it before run returns.
+func run(ctx context.Context, options options) *results {
+	ctx, cancel := context.WithTimeout(ctx, options.Duration)
+	defer cancel()
+
+	results := newResults()
+	dialer := &websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: options.ConnectTimeout,
+	}
+
+	var wg sync.WaitGroup
+	for i := range options.Clients {
+		s := &simulatedClient{
+			options: options,
+			dialer:  dialer,
+			results: results,
+			roomID:  fmt.Sprintf("%s%d", options.RoomPrefix, i%options.Rooms),
+		}
+
+		// Spread the first joins evenly over the ramp-up
+		delay := time.Duration(int64(options.RampUp) * int64(i) / int64(options.Clients))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if sleep(ctx, delay) {
+				s.run(ctx)
+			}
+		}()
+	}
+	wg.Wait()
+
+	return results
+}
+
+// simulatedClient joins its room, stays for a session and leaves again, until ctx is done.
+type simulatedClient struct {
+	options options
+	dialer  *websocket.Dialer
+	results *results
+	roomID  string
+}
+
+func (s *simulatedClient) run(ctx context.Context) {
+	for ctx.Err() == nil {
+		s.session(ctx)
+
+		if !sleep(ctx, jitter(s.options.Pause)) {
+			return
+		}
+	}
+}
+
+// session joins the room once, takes part in the signaling and leaves when the session is over.
+func (s *simulatedClient) session(ctx context.Context) {
+	dialCtx, cancel := context.WithTimeout(ctx, s.options.ConnectTimeout)
+	defer cancel()
+
+	s.results.connects.Add(1)
+	started := time.Now()
+	c, err := client.Dial(dialCtx, s.options.ServerURL, s.roomID, client.Options{
+		Dialer: s.dialer,
+		Header: s.options.Header,
+		// Only streams-available is taken from the inbox, everything else is handled by the handlers
+		InboxSize: inboxSize,
+	})
+	if err != nil {
+		if !ended(ctx) {
+			s.results.connectFailed(err)
+		} else {
+			// The load test ended while connecting, that's no failure of the server
+			s.results.connects.Add(-1)
+		}
+		return
+	}
+	s.results.joinLatency.add(time.Since(started))
+	defer c.Close()
+
+	s.subscribe(c)
+
+	// Viewers call the streamers that were streaming before they joined, the others once they start
+	available, err := c.ReceiveAvailableStreams(dialCtx)
+	if err != nil {
+		return
+	}
+	for _, streamerID := range available.ClientIDs {
+		s.call(c, streamerID)
+	}
+
+	streaming := rand.Float64() < s.options.StreamRatio
+	if streaming {
+		s.send(c.StartStream())
+	}
+
+	select {
+	case <-ctx.Done():
+	case <-c.Done():
+		s.results.connectionsLost.Add(1)
+		return
+	case <-time.After(jitter(s.options.Session)):
+	}
+
+	if streaming {
+		s.send(c.StopStream())
+	}
+}
+
+// subscribe registers the handlers of the client. They run on the client's receiving goroutine.
+func (s *simulatedClient) subscribe(c *client.Client) {
+	client.Handle(c, wire.STREAM_STARTED_MESSAGE_TYPE, func(c *client.Client, msg wire.StreamStartedMessage) {
+		s.results.received.Add(1)
+		s.call(c, msg.ClientID)
+	})
+
+	client.Handle(c, wire.SDP_OFFER_MESSAGE_TYPE, func(c *client.Client, msg wire.ServerSDPOfferMessage) {
+		s.results.receivedPayload(msg.Offer)
+
+		callerID, err := uuid.Parse(msg.CallerClientID)
+		if err != nil {
+			return
+		}
+		s.send(c.SendSDPAnswer(callerID, fakeSessionDescription("answer")))
+		s.sendICECandidates(c, callerID)
+	})
+	client.Handle(c, wire.SDP_ANSWER_MESSAGE_TYPE, func(_ *client.Client, msg wire.SDPAnswerMessage) {
+		s.results.receivedPayload(msg.Answer)
+	})
+	client.Handle(c, wire.ICE_CANDIDATE_MESSAGE_TYPE, func(_ *client.Client, msg wire.ICEMessage) {
+		s.results.receivedPayload(msg.Candidate)
+	})
+
+	for _, messageType := range []wire.MessageType{wire.STREAM_STOPPED_MESSAGE_TYPE, wire.CLIENT_DISCONNECT_MESSAGE_TYPE} {
+		c.SubscribeMessage(messageType, func(*client.Client, wire.TypedMessage[json.RawMessage]) {
+			s.results.received.Add(1)
+		})
+	}
+
+	client.Handle(c, wire.ERROR_MESSAGE_TYPE, func(_ *client.Client, msg wire.ErrorMessage) {
+		s.results.serverError(wire.ERROR_MESSAGE_TYPE, msg.ErrorMessage)
+	})
+	client.Handle(c, wire.INTERNAL_ERROR_MESSAGE_TYPE, func(_ *client.Client, msg wire.InternalErrorMessage) {
+		s.results.serverError(wire.INTERNAL_ERROR_MESSAGE_TYPE, msg.ErrorMessage)
+	})
+}
+
+// call sends an offer and ICE candidates to the streamer, like a viewer setting up its peer connection.
+func (s *simulatedClient) call(c *client.Client, streamerID string) {
+	parsed, err := uuid.Parse(streamerID)
+	if err != nil || parsed == c.ID() {
+		return
+	}
+
+	s.send(c.SendSDPOffer(parsed, fakeSessionDescription("offer")))
+	s.sendICECandidates(c, parsed)
+}
+
+func (s *simulatedClient) sendICECandidates(c *client.Client, remote uuid.UUID) {
+	for i := range s.options.ICECandidates {
+		s.send(c.SendICECandidate(remote, fakeICECandidate(i)))
+	}
+}
+
+// send counts a sent message. Failures are ignored, they mean that the connection was lost, which is counted anyway.
+func (s *simulatedClient) send(err error) {
+	if err == nil {
+		s.results.sent.Add(1)
+	}
+}
+
+// ended reports whether ctx is done or its deadline passed. A dial timing out at the deadline can notice it before ctx does.
+func ended(ctx context.Context) bool {
+	deadline, ok := ctx.Deadline()
+	return ctx.Err() != nil || ok && !time.Now().Before(deadline)
+}
+
+// sleep waits for d and reports whether ctx is still running.
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// jitter returns a random duration between d/2 and 3d/2, so the clients don't act in lockstep.
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+
+	return d/2 + rand.N(d)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"bjoernblessin.de/screenecho/clients"
+	"bjoernblessin.de/screenecho/connection"
+	"bjoernblessin.de/screenecho/events"
+	"bjoernblessin.de/screenecho/metrics"
+	"bjoernblessin.de/screenecho/rooms"
+	"bjoernblessin.de/screenecho/signaling"
+	"bjoernblessin.de/screenecho/streams"
+)
+
+func startTestServer(t *testing.T) string {
+	t.Helper()
+
+	bus := events.NewBus(events.DefaultAsyncBufferSize)
+	clientManager := clients.NewClientManager(connection.NewConnectionManager(connection.Options{}, metrics.Nop{}, bus), nil, bus)
+	roomManager := rooms.NewRoomManager(clientManager, metrics.Nop{}, bus)
+	streams.NewStreamManager(clientManager, roomManager, metrics.Nop{}, bus)
+	signaling.NewSignalingManager(clientManager)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /room/{roomID}/connect", roomManager.HandleConnect)
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	return server.URL
+}
+
+func TestRun(t *testing.T) {
+	options := defaultOptions()
+	options.ServerURL = startTestServer(t)
+	options.Clients = 20
+	options.Rooms = 3
+	options.StreamRatio = 0.5
+	options.Duration = time.Second
+	options.RampUp = 100 * time.Millisecond
+	options.Session = 300 * time.Millisecond
+	options.Pause = 20 * time.Millisecond
+
+	results := run(context.Background(), options)
+
+	if results.connects.Load() < int64(options.Clients) || results.successRate() != 1 {
+		t.Errorf("expected at least %d successful connects, got %d with %d failures: %v",
+			options.Clients, results.connects.Load(), results.connectFailures.Load(), results.connectErrors)
+	}
+	if results.sent.Load() == 0 || results.received.Load() == 0 {
+		t.Errorf("expected messages, got %d sent and %d received", results.sent.Load(), results.received.Load())
+	}
+	if results.messageLatency.percentiles(50) == nil {
+		t.Error("expected message latencies")
+	}
+
+	var report bytes.Buffer
+	results.report(&report, time.Second)
+	if !strings.Contains(report.String(), "Connections:") || !strings.Contains(report.String(), "Message latency:  p50") {
+		t.Errorf("unexpected report:\n%s", report.String())
+	}
+}
+
+func TestRunUnreachableServer(t *testing.T) {
+	options := defaultOptions()
+	options.ServerURL = "http://127.0.0.1:1"
+	options.Clients = 2
+	options.Duration = 200 * time.Millisecond
+	options.RampUp = 0
+	options.Pause = 50 * time.Millisecond
+
+	results := run(context.Background(), options)
+
+	if results.connectFailures.Load() == 0 || results.successRate() != 0 {
+		t.Errorf("expected only failed connects, got a success rate of %f", results.successRate())
+	}
+	if len(results.connectErrors) == 0 {
+		t.Error("expected the connect errors to be reported")
+	}
+}
+
+func TestPercentiles(t *testing.T) {
+	tests := []struct {
+		Name     string
+		samples  []time.Duration
+		expected []time.Duration
+	}{
+		{Name: "No samples"},
+		{Name: "One sample", samples: []time.Duration{5}, expected: []time.Duration{5, 5, 5, 5, 5}},
+		{
+			Name:     "Unsorted",
+			samples:  []time.Duration{10, 1, 9, 2, 8, 3, 7, 4, 6, 5},
+			expected: []time.Duration{1, 5, 9, 10, 10},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			l := &latencies{}
+			for _, sample := range test.samples {
+				l.add(sample)
+			}
+
+			got := l.percentiles(0, 50, 90, 91, 100)
+			if len(got) != len(test.expected) {
+				t.Fatalf("expected %v, got %v", test.expected, got)
+			}
+			for i := range got {
+				if got[i] != test.expected[i] {
+					t.Errorf("expected %v, got %v", test.expected, got)
+				}
+			}
+		})
+	}
+}
+
+func TestSentAt(t *testing.T) {
+	before := time.Now()
+
+	for _, payload := range [][]byte{fakeSessionDescription("offer"), fakeICECandidate(1)} {
+		sent, ok := sentAt(payload)
+		if !ok || sent.Before(before.Truncate(time.Nanosecond)) || time.Since(sent) < 0 {
+			t.Errorf("expected the send time in %s", payload)
+		}
+	}
+
+	if _, ok := sentAt([]byte(`{"candidate":""}`)); ok {
+		t.Error("expected no send time in a foreign payload")
+	}
+}
@@ -0,0 +1,70 @@
+// Command screenecho-loadtest simulates rooms full of clients against a running server and reports how it coped.
+//
+// Every simulated client joins one of the rooms, streams with probability -stream-ratio, stays for about -session and
+// leaves again, until -duration is over. Viewers call every streamer of their room with a fake SDP offer and ICE
+// candidates, which the streamers answer. The report contains the connection success rate, the latency of joining
+// and of the relayed signaling messages, and the error messages of the server.
+//
+// Usage:
+//
+//	screenecho-loadtest -server http://localhost:8080 -clients 2000 -rooms 100 -duration 2m
+//
+// Every client uses its own connection, so the open file limit (ulimit -n) must allow enough sockets on both sides.
+// The server's MAX_CONNECTIONS must be high enough as well. Latencies are measured between sending and receiving in
+// this process, so they include the time the server needed to relay a message.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+func main() {
+	options := defaultOptions()
+	flag.StringVar(&options.ServerURL, "server", options.ServerURL, "base URL of the server")
+	flag.StringVar(&options.Origin, "origin", "", "Origin header of the WebSocket handshakes, required if the server checks origins")
+	flag.IntVar(&options.Clients, "clients", options.Clients, "number of simulated clients")
+	flag.IntVar(&options.Rooms, "rooms", options.Rooms, "number of rooms the clients are spread across")
+	flag.StringVar(&options.RoomPrefix, "room-prefix", options.RoomPrefix, "prefix of the room IDs")
+	flag.Float64Var(&options.StreamRatio, "stream-ratio", options.StreamRatio, "probability that a client streams during a session")
+	flag.IntVar(&options.ICECandidates, "ice-candidates", options.ICECandidates, "number of ICE candidates sent per offer and answer")
+	flag.DurationVar(&options.Duration, "duration", options.Duration, "duration of the load test")
+	flag.DurationVar(&options.RampUp, "ramp-up", options.RampUp, "time over which the clients connect for the first time")
+	flag.DurationVar(&options.Session, "session", options.Session, "average time a client stays in its room before it leaves")
+	flag.DurationVar(&options.Pause, "pause", options.Pause, "average time a client waits before it joins again")
+	flag.DurationVar(&options.ConnectTimeout, "connect-timeout", options.ConnectTimeout, "time a client has to connect and join")
+	minSuccessRate := flag.Float64("min-success-rate", 0, "exit with status 1 if fewer connections succeed, e.g. 0.99")
+	flag.Parse()
+
+	if options.Origin != "" {
+		options.Header = http.Header{"Origin": []string{options.Origin}}
+	}
+	if err := options.validate(); err != nil {
+		fail(err)
+	}
+
+	// Ctrl+C ends the load test early, the results so far are still reported
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	fmt.Fprintf(os.Stderr, "Simulating %d clients in %d rooms against %s for %s\n", options.Clients, options.Rooms, options.ServerURL, options.Duration)
+
+	started := time.Now()
+	results := run(ctx, options)
+	results.report(os.Stdout, time.Since(started))
+
+	if results.successRate() < *minSuccessRate {
+		os.Exit(1)
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "screenecho-loadtest:", err)
+	os.Exit(2)
+}
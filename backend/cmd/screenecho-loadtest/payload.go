@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// The fake payloads look like the ones of a browser, so the server relays messages of a realistic size.
+// They carry the time they were sent, which the receiver uses to measure the latency.
+
+// sentAtPayload is the part of a fake payload holding the time it was sent in Unix nanoseconds.
+type sentAtPayload struct {
+	SentAt int64 `json:"loadtestSentAt"`
+}
+
+type fakeDescription struct {
+	Type   string `json:"type"`
+	SDP    string `json:"sdp"`
+	SentAt int64  `json:"loadtestSentAt"`
+}
+
+type fakeCandidate struct {
+	Candidate     string `json:"candidate"`
+	SDPMid        string `json:"sdpMid"`
+	SDPMLineIndex int    `json:"sdpMLineIndex"`
+	SentAt        int64  `json:"loadtestSentAt"`
+}
+
+// fakeSDP is a session description with one video track, like the one of a screen share.
+var fakeSDP = strings.Join([]string{
+	"v=0",
+	"o=- 4611731400430051336 2 IN IP4 127.0.0.1",
+	"s=-",
+	"t=0 0",
+	"a=group:BUNDLE 0",
+	"a=msid-semantic: WMS screen",
+	"m=video 9 UDP/TLS/RTP/SAVPF 96 97 102 103",
+	"c=IN IP4 0.0.0.0",
+	"a=rtcp:9 IN IP4 0.0.0.0",
+	"a=ice-ufrag:lOad",
+	"a=ice-pwd:screenecholoadtestpassword",
+	"a=ice-options:trickle",
+	"a=fingerprint:sha-256 6B:8B:F0:65:5F:78:E2:51:3B:AC:6F:F3:3F:46:1B:35:DC:B8:5F:64:1A:24:C2:43:F0:A1:58:D0:A1:2C:19:08",
+	"a=setup:actpass",
+	"a=mid:0",
+	"a=sendonly",
+	"a=rtcp-mux",
+	"a=rtpmap:96 VP8/90000",
+	"a=rtcp-fb:96 nack pli",
+	"a=rtpmap:97 rtx/90000",
+	"a=fmtp:97 apt=96",
+	"a=rtpmap:102 H264/90000",
+	"a=fmtp:102 level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42001f",
+	"a=rtpmap:103 rtx/90000",
+	"a=fmtp:103 apt=102",
+	"",
+}, "\r\n")
+
+func fakeSessionDescription(descriptionType string) json.RawMessage {
+	return mustMarshal(fakeDescription{Type: descriptionType, SDP: fakeSDP, SentAt: time.Now().UnixNano()})
+}
+
+func fakeICECandidate(i int) json.RawMessage {
+	return mustMarshal(fakeCandidate{
+		Candidate: fmt.Sprintf("candidate:%d 1 udp %d 192.168.0.%d %d typ host generation 0", i, 2122260223-i, i%254+1, 50000+i),
+		SentAt:    time.Now().UnixNano(),
+	})
+}
+
+// sentAt returns the time a fake payload was sent, false if it has none.
+func sentAt(payload json.RawMessage) (time.Time, bool) {
+	var fields sentAtPayload
+	if err := json.Unmarshal(payload, &fields); err != nil || fields.SentAt <= 0 {
+		return time.Time{}, false
+	}
+
+	return time.Unix(0, fields.SentAt), true
+}
+
+func mustMarshal(v any) json.RawMessage {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+
+	return data
+}
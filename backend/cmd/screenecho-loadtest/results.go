@@ -0,0 +1,163 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"bjoernblessin.de/screenecho/wire"
+)
+
+// results collects the measurements of all simulated clients. It's safe for concurrent use.
+type results struct {
+	connects        atomic.Int64
+	connectFailures atomic.Int64
+	// connectionsLost counts the connections that ended before the client left.
+	connectionsLost atomic.Int64
+	sent            atomic.Int64
+	received        atomic.Int64
+
+	joinLatency    *latencies
+	messageLatency *latencies
+
+	mutex sync.Mutex
+	// connectErrors counts the errors of failed connects by message.
+	connectErrors map[string]int
+	// serverErrors counts the error and internal-error messages of the server by message type and error message.
+	serverErrors map[string]int
+}
+
+func newResults() *results {
+	return &results{
+		joinLatency:    &latencies{},
+		messageLatency: &latencies{},
+		connectErrors:  make(map[string]int),
+		serverErrors:   make(map[string]int),
+	}
+}
+
+func (r *results) connectFailed(err error) {
+	r.connectFailures.Add(1)
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.connectErrors[err.Error()]++
+}
+
+func (r *results) serverError(messageType wire.MessageType, errorMessage string) {
+	r.received.Add(1)
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.serverErrors[fmt.Sprintf("%s: %s", messageType, errorMessage)]++
+}
+
+// receivedPayload counts a relayed signaling message and measures its latency.
+func (r *results) receivedPayload(payload json.RawMessage) {
+	r.received.Add(1)
+
+	if sent, ok := sentAt(payload); ok {
+		r.messageLatency.add(time.Since(sent))
+	}
+}
+
+// successRate is the share of the connects that succeeded, 1 if there were none.
+func (r *results) successRate() float64 {
+	connects := r.connects.Load()
+	if connects == 0 {
+		return 1
+	}
+
+	return float64(connects-r.connectFailures.Load()) / float64(connects)
+}
+
+func (r *results) report(w io.Writer, elapsed time.Duration) {
+	connects, failures := r.connects.Load(), r.connectFailures.Load()
+
+	fmt.Fprintf(w, "Duration:         %s\n", elapsed.Round(time.Millisecond))
+	fmt.Fprintf(w, "Connections:      %d attempted, %d succeeded (%.2f%%), %d failed, %d lost\n",
+		connects, connects-failures, 100*r.successRate(), failures, r.connectionsLost.Load())
+	fmt.Fprintf(w, "Join latency:     %s\n", r.joinLatency.summary())
+	fmt.Fprintf(w, "Messages:         %d sent, %d received\n", r.sent.Load(), r.received.Load())
+	fmt.Fprintf(w, "Message latency:  %s\n", r.messageLatency.summary())
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	writeCounts(w, "Server errors", r.serverErrors)
+	writeCounts(w, "Connect errors", r.connectErrors)
+}
+
+// writeCounts writes the counts, the most frequent first.
+func writeCounts(w io.Writer, title string, counts map[string]int) {
+	total := 0
+	for _, count := range counts {
+		total += count
+	}
+	fmt.Fprintf(w, "%-18s%d\n", title+":", total)
+
+	keys := slices.SortedFunc(maps.Keys(counts), func(a, b string) int {
+		return cmp.Or(cmp.Compare(counts[b], counts[a]), cmp.Compare(a, b))
+	})
+	for _, key := range keys {
+		fmt.Fprintf(w, "  %8d  %s\n", counts[key], key)
+	}
+}
+
+// latencies collects durations and computes their percentiles.
+type latencies struct {
+	mutex   sync.Mutex
+	samples []time.Duration
+}
+
+func (l *latencies) add(d time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.samples = append(l.samples, d)
+}
+
+// percentiles returns the given percentiles (0-100) with the nearest-rank method, or nil if there are no samples.
+func (l *latencies) percentiles(ps ...float64) []time.Duration {
+	l.mutex.Lock()
+	sorted := slices.Clone(l.samples)
+	l.mutex.Unlock()
+
+	if len(sorted) == 0 {
+		return nil
+	}
+	slices.Sort(sorted)
+
+	result := make([]time.Duration, len(ps))
+	for i, p := range ps {
+		rank := int(math.Ceil(float64(len(sorted))*p/100)) - 1
+		result[i] = sorted[min(max(rank, 0), len(sorted)-1)]
+	}
+
+	return result
+}
+
+func (l *latencies) summary() string {
+	values := l.percentiles(50, 90, 99, 100)
+	if values == nil {
+		return "no samples"
+	}
+
+	l.mutex.Lock()
+	count := len(l.samples)
+	l.mutex.Unlock()
+
+	return fmt.Sprintf("p50 %s, p90 %s, p99 %s, max %s (%d samples)",
+		round(values[0]), round(values[1]), round(values[2]), round(values[3]), count)
+}
+
+func round(d time.Duration) time.Duration {
+	return d.Round(10 * time.Microsecond)
+}
@@ -0,0 +1,54 @@
+// Command screenecho-schema writes the AsyncAPI document and the TypeScript type definitions of the protocol.
+// It creates a server to collect the message types it registers, see package protocol.
+//
+// Usage:
+//
+//	screenecho-schema -asyncapi asyncapi.json -typescript protocol.gen.ts
+//
+// Without flags, the AsyncAPI document is written to stdout.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+
+	"bjoernblessin.de/screenecho/protocol"
+	"bjoernblessin.de/screenecho/server"
+)
+
+func main() {
+	asyncAPIFile := flag.String("asyncapi", "", "path of the AsyncAPI document (default stdout)")
+	typeScriptFile := flag.String("typescript", "", "path of the TypeScript type definitions (default none)")
+	flag.Parse()
+
+	// The server isn't started, creating it registers the message types
+	srv := server.New(server.WithAccessLog("", nil))
+	document := protocol.NewDocument(srv.ClientManager().MessageTypes())
+
+	data, err := document.Marshal()
+	if err != nil {
+		fail(err)
+	}
+	if *asyncAPIFile == "" {
+		_, _ = os.Stdout.Write(data)
+	} else if err := os.WriteFile(*asyncAPIFile, data, 0o644); err != nil {
+		fail(err)
+	}
+
+	if *typeScriptFile != "" {
+		var buf bytes.Buffer
+		if err := protocol.WriteTypeScript(&buf, document); err != nil {
+			fail(err)
+		}
+		if err := os.WriteFile(*typeScriptFile, buf.Bytes(), 0o644); err != nil {
+			fail(err)
+		}
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "screenecho-schema:", err)
+	os.Exit(1)
+}
@@ -0,0 +1,371 @@
+// Package config loads the typed server configuration.
+//
+// Every setting is read from (in increasing priority) its default value, an optional config file and an environment variable.
+// The fields of Config are described by struct tags:
+//
+//	env:"NAME"        name of the environment variable
+//	json:"name"       key in the config file
+//	default:"value"   default value, fields without default are zero
+//	required:"true"   the setting must be set in the config file or environment
+//	valid:"a|b"       the value must be one of the listed values
+//	secret:"true"     the value is redacted when the config is printed
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"bjoernblessin.de/screenecho/tlsconfig"
+	"bjoernblessin.de/screenecho/util/assert"
+	"bjoernblessin.de/screenecho/util/env"
+	"bjoernblessin.de/screenecho/util/logger"
+)
+
+// Config holds all settings of the server.
+type Config struct {
+	Addr              string        `env:"ADDR" json:"addr" default:":8080"`
+	ReadHeaderTimeout time.Duration `env:"READ_HEADER_TIMEOUT" json:"readHeaderTimeout" default:"10s"`
+	IdleTimeout       time.Duration `env:"IDLE_TIMEOUT" json:"idleTimeout" default:"2m"`
+	ShutdownTimeout   time.Duration `env:"SHUTDOWN_TIMEOUT" json:"shutdownTimeout" default:"10s"`
+	// ReconnectAfter is the delay clients are told to wait before reconnecting after a shutdown.
+	ReconnectAfter time.Duration `env:"RECONNECT_AFTER" json:"reconnectAfter" default:"5s"`
+
+	DevMode        bool     `env:"DEV_MODE" json:"devMode" default:"false"`
+	AllowedOrigins []string `env:"ALLOWED_ORIGINS" json:"allowedOrigins"`
+
+	WebSocketReadBufferSize   ByteSize      `env:"WS_READ_BUFFER_SIZE" json:"wsReadBufferSize" default:"4KiB"`
+	WebSocketWriteBufferSize  ByteSize      `env:"WS_WRITE_BUFFER_SIZE" json:"wsWriteBufferSize" default:"4KiB"`
+	WebSocketMaxMessageSize   ByteSize      `env:"WS_MAX_MESSAGE_SIZE" json:"wsMaxMessageSize" default:"64KiB"`
+	WebSocketHandshakeTimeout time.Duration `env:"WS_HANDSHAKE_TIMEOUT" json:"wsHandshakeTimeout" default:"10s"`
+	// WebSocketWriteTimeout is the time a client has to receive a message before it's disconnected.
+	WebSocketWriteTimeout time.Duration `env:"WS_WRITE_TIMEOUT" json:"wsWriteTimeout" default:"10s"`
+	// WebSocketCloseOnPanic closes only the connection whose message handler panicked.
+	WebSocketCloseOnPanic bool `env:"WS_CLOSE_ON_PANIC" json:"wsCloseOnPanic" default:"true"`
+
+	// AccessLogFormat is the format of the HTTP access log.
+	// common and json entries are written to stdout, log entries go through the logger.
+	AccessLogFormat string `env:"ACCESS_LOG_FORMAT" json:"accessLogFormat" default:"log" valid:"log|common|json"`
+
+	// MaxConnections is the connection ceiling for the readiness check. 0 means no ceiling.
+	MaxConnections int `env:"MAX_CONNECTIONS" json:"maxConnections" default:"0"`
+
+	// AdminToken enables the admin API if non-empty.
+	AdminToken string `env:"ADMIN_TOKEN" json:"adminToken" secret:"true"`
+
+	// TLS is enabled if TLSCertFile and TLSKeyFile are set.
+	TLSCertFile       string        `env:"TLS_CERT_FILE" json:"tlsCertFile"`
+	TLSKeyFile        string        `env:"TLS_KEY_FILE" json:"tlsKeyFile"`
+	TLSMinVersion     string        `env:"TLS_MIN_VERSION" json:"tlsMinVersion" default:"1.2" valid:"1.2|1.3"`
+	TLSCipherSuites   []string      `env:"TLS_CIPHER_SUITES" json:"tlsCipherSuites"`
+	TLSReloadInterval time.Duration `env:"TLS_RELOAD_INTERVAL" json:"tlsReloadInterval" default:"1m"`
+	// HTTPRedirectAddr is the address of a plain HTTP listener that redirects to HTTPS. Empty disables the listener.
+	HTTPRedirectAddr string `env:"HTTP_REDIRECT_ADDR" json:"httpRedirectAddr"`
+
+	// LogLevel is the global log level: NONE, ERROR, WARN, INFO, DEBUG or TRACE.
+	LogLevel string `env:"LOG_LEVEL" json:"logLevel" default:"INFO"`
+	// LogLevels holds per package levels, e.g. connection=DEBUG,rooms=WARN.
+	LogLevels []string `env:"LOG_LEVELS" json:"logLevels"`
+	LogFormat string   `env:"LOG_FORMAT" json:"logFormat" default:"text" valid:"text|json"`
+	// LogPayloadMaxLength is the maximum length of logged message payloads in bytes.
+	LogPayloadMaxLength int `env:"LOG_PAYLOAD_MAX_LENGTH" json:"logPayloadMaxLength" default:"512"`
+	// LogRedactKeys are the JSON keys whose values are never logged.
+	LogRedactKeys []string `env:"LOG_REDACT_KEYS" json:"logRedactKeys" default:"offer,answer,description,candidate,sdp"`
+	// LogFile is the path of a log file written in addition to the console. Empty disables file logging.
+	LogFile string `env:"LOG_FILE" json:"logFile"`
+	// LogMaxSizeMB is the size in megabytes after which the log file is rotated. 0 disables size-based rotation.
+	LogMaxSizeMB int `env:"LOG_MAX_SIZE_MB" json:"logMaxSizeMB" default:"100"`
+	// LogRotateInterval is the age after which the log file is rotated. 0 disables time-based rotation.
+	LogRotateInterval time.Duration `env:"LOG_ROTATE_INTERVAL" json:"logRotateInterval" default:"0s"`
+	// LogMaxBackups is the number of rotated log files to keep. 0 keeps all.
+	LogMaxBackups int `env:"LOG_MAX_BACKUPS" json:"logMaxBackups" default:"7"`
+	// LogCompress gzips rotated log files.
+	LogCompress bool `env:"LOG_COMPRESS" json:"logCompress" default:"true"`
+}
+
+// TLSEnabled reports whether the server terminates TLS itself.
+func (cfg *Config) TLSEnabled() bool {
+	return cfg.TLSCertFile != "" && cfg.TLSKeyFile != ""
+}
+
+// LoggerOptions returns the options of the logger, see [logger.Configure]. cfg must be valid.
+func (cfg *Config) LoggerOptions() logger.Options {
+	level, _ := logger.ParseLogLevel(cfg.LogLevel)
+	packageLevels, _ := parsePackageLevels(cfg.LogLevels)
+
+	return logger.Options{
+		Level:            level,
+		PackageLevels:    packageLevels,
+		JSON:             cfg.LogFormat == "json",
+		PayloadMaxLength: cfg.LogPayloadMaxLength,
+		RedactKeys:       cfg.LogRedactKeys,
+		File:             cfg.LogFile,
+		Rotation: logger.RotationOptions{
+			MaxSize:    int64(cfg.LogMaxSizeMB) << 20,
+			Interval:   cfg.LogRotateInterval,
+			MaxBackups: cfg.LogMaxBackups,
+			Compress:   cfg.LogCompress,
+		},
+	}
+}
+
+// parsePackageLevels parses package=LEVEL assignments like connection=DEBUG.
+func parsePackageLevels(assignments []string) (map[string]logger.LogLevel, error) {
+	levels := make(map[string]logger.LogLevel, len(assignments))
+
+	for _, assignment := range assignments {
+		pkg, levelName, found := strings.Cut(assignment, "=")
+		level, ok := logger.ParseLogLevel(levelName)
+		if !found || !ok {
+			return nil, fmt.Errorf("%q is not a package level like connection=DEBUG", assignment)
+		}
+		levels[strings.TrimSpace(pkg)] = level
+	}
+
+	return levels, nil
+}
+
+// ByteSize is a size in bytes. It's parsed with [env.ParseByteSize].
+type ByteSize int64
+
+func (size ByteSize) String() string {
+	switch {
+	case size >= 1<<30 && size%(1<<30) == 0:
+		return fmt.Sprintf("%dGiB", size/(1<<30))
+	case size >= 1<<20 && size%(1<<20) == 0:
+		return fmt.Sprintf("%dMiB", size/(1<<20))
+	case size >= 1<<10 && size%(1<<10) == 0:
+		return fmt.Sprintf("%dKiB", size/(1<<10))
+	default:
+		return strconv.FormatInt(int64(size), 10)
+	}
+}
+
+const redacted = "[REDACTED]"
+
+// Load loads the configuration from the defaults, the config file at configFilePath (may be empty for no file)
+// and the environment.
+//
+// All problems are collected, the returned error lists every invalid, missing or unknown setting at once.
+// The returned Config is never nil but must not be used if the error is non-nil.
+func Load(configFilePath string) (*Config, error) {
+	var errs []error
+
+	fileValues := make(map[string]string)
+	if configFilePath != "" {
+		var err error
+		fileValues, err = readFile(configFilePath)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	cfg, err := load(fileValues, env.ReadOptionalEnv)
+
+	return cfg, errors.Join(append(errs, err)...)
+}
+
+// Defaults returns the configuration of the default values, ignoring the environment, e.g. for hermetic tests.
+// It panics if the defaults are invalid.
+func Defaults() *Config {
+	cfg, err := load(nil, func(string) (string, bool) { return "", false })
+	assert.IsNil(err, "invalid default configuration")
+
+	return cfg
+}
+
+// load builds the configuration from the defaults, fileValues and the environment variables read by lookupEnv.
+func load(fileValues map[string]string, lookupEnv func(key string) (string, bool)) (*Config, error) {
+	cfg := &Config{}
+
+	var errs []error
+
+	configType := reflect.TypeFor[Config]()
+	configValue := reflect.ValueOf(cfg).Elem()
+
+	knownFileKeys := make(map[string]bool)
+
+	for i := range configType.NumField() {
+		field := configType.Field(i)
+		envKey := field.Tag.Get("env")
+		fileKey := field.Tag.Get("json")
+		knownFileKeys[fileKey] = true
+
+		raw, present := field.Tag.Lookup("default")
+		source := "default value of " + envKey
+
+		if value, exists := fileValues[fileKey]; exists {
+			raw, present = value, true
+			source = fmt.Sprintf("config file key %s", fileKey)
+		}
+
+		if value, exists := lookupEnv(envKey); exists {
+			raw, present = value, true
+			source = fmt.Sprintf("environment variable %s", envKey)
+		}
+
+		if !present {
+			if field.Tag.Get("required") == "true" {
+				errs = append(errs, fmt.Errorf("%s is required, set the environment variable %s or the config file key %s", field.Name, envKey, fileKey))
+			}
+			continue
+		}
+
+		if valid, exists := field.Tag.Lookup("valid"); exists {
+			validValues := strings.Split(valid, "|")
+			if !slices.Contains(validValues, raw) {
+				errs = append(errs, fmt.Errorf("%s must be one of %v but was %q", source, validValues, raw))
+				continue
+			}
+		}
+
+		err := setField(configValue.Field(i), raw)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s is invalid. %w", source, err))
+		}
+	}
+
+	for _, key := range sortedKeys(fileValues) {
+		if !knownFileKeys[key] {
+			errs = append(errs, fmt.Errorf("config file contains unknown key %s", key))
+		}
+	}
+
+	errs = append(errs, cfg.validate()...)
+
+	return cfg, errors.Join(errs...)
+}
+
+// setField parses raw according to the type of field and stores the result in field.
+func setField(field reflect.Value, raw string) error {
+	var err error
+
+	switch target := field.Addr().Interface().(type) {
+	case *string:
+		*target = raw
+	case *int:
+		*target, err = env.ParseInt(raw)
+	case *bool:
+		*target, err = env.ParseBool(raw)
+	case *time.Duration:
+		*target, err = env.ParseDuration(raw)
+	case *[]string:
+		*target, err = env.ParseList(raw)
+	case *ByteSize:
+		var size int64
+		size, err = env.ParseByteSize(raw)
+		*target = ByteSize(size)
+	default:
+		panic(fmt.Sprintf("config: unsupported field type %s", field.Type()))
+	}
+
+	return err
+}
+
+// validate checks constraints that can't be expressed with struct tags.
+func (cfg *Config) validate() []error {
+	var errs []error
+
+	if cfg.Addr == "" {
+		errs = append(errs, errors.New("ADDR must not be empty"))
+	}
+
+	if cfg.ShutdownTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("SHUTDOWN_TIMEOUT must be positive but was %s", cfg.ShutdownTimeout))
+	}
+
+	if cfg.ReconnectAfter < 0 {
+		errs = append(errs, fmt.Errorf("RECONNECT_AFTER must not be negative but was %s", cfg.ReconnectAfter))
+	}
+
+	if cfg.MaxConnections < 0 {
+		errs = append(errs, fmt.Errorf("MAX_CONNECTIONS must not be negative but was %d", cfg.MaxConnections))
+	}
+
+	if cfg.WebSocketReadBufferSize <= 0 || cfg.WebSocketWriteBufferSize <= 0 || cfg.WebSocketMaxMessageSize <= 0 {
+		errs = append(errs, errors.New("WS_READ_BUFFER_SIZE, WS_WRITE_BUFFER_SIZE and WS_MAX_MESSAGE_SIZE must be positive"))
+	}
+
+	if cfg.WebSocketWriteTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("WS_WRITE_TIMEOUT must be positive but was %s", cfg.WebSocketWriteTimeout))
+	}
+
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		errs = append(errs, errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together"))
+	}
+
+	if _, err := tlsconfig.ParseCipherSuites(cfg.TLSCipherSuites); err != nil {
+		errs = append(errs, fmt.Errorf("TLS_CIPHER_SUITES is invalid. %w", err))
+	}
+
+	if cfg.TLSReloadInterval <= 0 {
+		errs = append(errs, fmt.Errorf("TLS_RELOAD_INTERVAL must be positive but was %s", cfg.TLSReloadInterval))
+	}
+
+	if cfg.HTTPRedirectAddr != "" && !cfg.TLSEnabled() {
+		errs = append(errs, errors.New("HTTP_REDIRECT_ADDR requires TLS_CERT_FILE and TLS_KEY_FILE"))
+	}
+
+	if _, ok := logger.ParseLogLevel(cfg.LogLevel); !ok {
+		errs = append(errs, fmt.Errorf("LOG_LEVEL must be one of NONE, ERROR, WARN, INFO, DEBUG or TRACE but was %q", cfg.LogLevel))
+	}
+
+	if _, err := parsePackageLevels(cfg.LogLevels); err != nil {
+		errs = append(errs, fmt.Errorf("LOG_LEVELS is invalid. %w", err))
+	}
+
+	if cfg.LogPayloadMaxLength <= 0 {
+		errs = append(errs, fmt.Errorf("LOG_PAYLOAD_MAX_LENGTH must be positive but was %d", cfg.LogPayloadMaxLength))
+	}
+
+	if cfg.LogMaxSizeMB < 0 || cfg.LogRotateInterval < 0 || cfg.LogMaxBackups < 0 {
+		errs = append(errs, errors.New("LOG_MAX_SIZE_MB, LOG_ROTATE_INTERVAL and LOG_MAX_BACKUPS must not be negative"))
+	}
+
+	return errs
+}
+
+// Print writes the configuration as environment variable assignments, one per line, to w.
+// Secrets are redacted.
+func (cfg *Config) Print(w io.Writer) {
+	configType := reflect.TypeFor[Config]()
+	configValue := reflect.ValueOf(cfg).Elem()
+
+	for i := range configType.NumField() {
+		field := configType.Field(i)
+
+		value := formatField(configValue.Field(i))
+		if field.Tag.Get("secret") == "true" && value != "" {
+			value = redacted
+		}
+
+		fmt.Fprintf(w, "%s=%s\n", field.Tag.Get("env"), value)
+	}
+}
+
+// formatField formats field so that it can be parsed again by setField.
+func formatField(field reflect.Value) string {
+	switch value := field.Interface().(type) {
+	case []string:
+		return strings.Join(value, ",")
+	case fmt.Stringer:
+		return value.String()
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	return keys
+}
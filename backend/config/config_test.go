@@ -0,0 +1,160 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"bjoernblessin.de/screenecho/util/logger"
+)
+
+func writeFile(t *testing.T, name string, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestDefaults(t *testing.T) {
+	// The environment must not leak into the defaults
+	t.Setenv("ADDR", ":9000")
+	t.Setenv("DEV_MODE", "true")
+
+	cfg := Defaults()
+
+	if cfg.Addr != ":8080" || cfg.ShutdownTimeout != 10*time.Second || cfg.WebSocketMaxMessageSize != 64*1024 || cfg.DevMode {
+		t.Errorf("unexpected defaults %+v", cfg)
+	}
+
+	options := cfg.LoggerOptions()
+	if len(options.PackageLevels) == 0 {
+		options.PackageLevels = nil
+	}
+	if expected := logger.DefaultOptions(); !reflect.DeepEqual(options, expected) {
+		t.Errorf("expected the logger defaults %+v, got %+v", expected, options)
+	}
+}
+
+func TestLoggerOptions(t *testing.T) {
+	t.Setenv("LOG_LEVEL", "debug")
+	t.Setenv("LOG_LEVELS", "connection=TRACE, rooms = warn")
+	t.Setenv("LOG_FORMAT", "json")
+	t.Setenv("LOG_FILE", "/var/log/screenecho.log")
+	t.Setenv("LOG_MAX_SIZE_MB", "5")
+
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	options := cfg.LoggerOptions()
+	expectedLevels := map[string]logger.LogLevel{"connection": logger.Trace, "rooms": logger.Warn}
+	if options.Level != logger.Debug || !reflect.DeepEqual(options.PackageLevels, expectedLevels) || !options.JSON {
+		t.Errorf("unexpected levels or format %+v", options)
+	}
+	if options.File != "/var/log/screenecho.log" || options.Rotation.MaxSize != 5<<20 || options.Rotation.MaxBackups != 7 {
+		t.Errorf("unexpected file options %+v", options)
+	}
+}
+
+func TestLoad_Precedence(t *testing.T) {
+	path := writeFile(t, "config.json", `{
+		"addr": ":9000",
+		"maxConnections": 100,
+		"devMode": true,
+		"allowedOrigins": ["https://a.example.com", "https://*.example.org"]
+	}`)
+
+	t.Setenv("MAX_CONNECTIONS", "200")
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if cfg.Addr != ":9000" {
+		t.Errorf("expected config file value :9000, got %s", cfg.Addr)
+	}
+	if cfg.MaxConnections != 200 {
+		t.Errorf("expected environment to override config file, got %d", cfg.MaxConnections)
+	}
+	if !cfg.DevMode || len(cfg.AllowedOrigins) != 2 || cfg.AllowedOrigins[1] != "https://*.example.org" {
+		t.Errorf("unexpected config %+v", cfg)
+	}
+}
+
+func TestLoad_TOML(t *testing.T) {
+	path := writeFile(t, "config.toml", `
+# ScreenEcho configuration
+addr = ":9443" # trailing comment
+shutdownTimeout = "30s"
+wsMaxMessageSize = '1MiB'
+maxConnections = 1_000
+allowedOrigins = ["https://a.example.com", "https://#notacomment.example.com"]
+`)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if cfg.Addr != ":9443" || cfg.ShutdownTimeout != 30*time.Second || cfg.WebSocketMaxMessageSize != 1<<20 || cfg.MaxConnections != 1000 {
+		t.Errorf("unexpected config %+v", cfg)
+	}
+	if len(cfg.AllowedOrigins) != 2 || cfg.AllowedOrigins[1] != "https://#notacomment.example.com" {
+		t.Errorf("unexpected origins %q", cfg.AllowedOrigins)
+	}
+}
+
+func TestLoad_ListsEveryProblem(t *testing.T) {
+	path := writeFile(t, "config.json", `{"shutdownTimeout": "soon", "unknownKey": 1}`)
+
+	t.Setenv("MAX_CONNECTIONS", "many")
+	t.Setenv("DEV_MODE", "maybe")
+	t.Setenv("ADDR", "")
+	t.Setenv("TLS_CERT_FILE", "/etc/screenecho/cert.pem")
+	t.Setenv("TLS_MIN_VERSION", "1.0")
+	t.Setenv("LOG_LEVEL", "LOUD")
+	t.Setenv("LOG_LEVELS", "rooms")
+
+	_, err := Load(path)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+
+	for _, expected := range []string{"shutdownTimeout", "unknownKey", "MAX_CONNECTIONS", "DEV_MODE", "ADDR must not be empty", "TLS_KEY_FILE", "TLS_MIN_VERSION", "LOG_LEVEL", "LOG_LEVELS"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected error to mention %s, got:\n%v", expected, err)
+		}
+	}
+}
+
+func TestPrint_RedactsSecrets(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "super-secret")
+	t.Setenv("ALLOWED_ORIGINS", "https://a.example.com,https://b.example.com")
+
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var output strings.Builder
+	cfg.Print(&output)
+
+	if strings.Contains(output.String(), "super-secret") {
+		t.Errorf("secret was printed:\n%s", output.String())
+	}
+
+	for _, expected := range []string{"ADMIN_TOKEN=[REDACTED]", "ALLOWED_ORIGINS=https://a.example.com,https://b.example.com", "WS_MAX_MESSAGE_SIZE=64KiB", "SHUTDOWN_TIMEOUT=10s"} {
+		if !strings.Contains(output.String(), expected+"\n") {
+			t.Errorf("expected line %s in output:\n%s", expected, output.String())
+		}
+	}
+}
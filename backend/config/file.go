@@ -0,0 +1,186 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// readFile reads the config file at path and returns its values as strings that are parsed like environment variables.
+// The format is chosen by the file extension, either .json or .toml.
+func readFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file. %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return parseJSON(data)
+	case ".toml":
+		return parseTOML(data)
+	default:
+		return nil, fmt.Errorf("config file %s must have the extension .json or .toml", path)
+	}
+}
+
+// parseJSON parses a flat JSON object. Values may be strings, numbers, booleans or arrays of strings.
+func parseJSON(data []byte) (map[string]string, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var object map[string]any
+	if err := decoder.Decode(&object); err != nil {
+		return nil, fmt.Errorf("config file is not a valid JSON object. %w", err)
+	}
+
+	values := make(map[string]string, len(object))
+	for key, value := range object {
+		str, err := stringifyValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("config file key %s is invalid. %w", key, err)
+		}
+		values[key] = str
+	}
+
+	return values, nil
+}
+
+func stringifyValue(value any) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case []any:
+		elements := make([]string, 0, len(v))
+		for _, element := range v {
+			str, ok := element.(string)
+			if !ok {
+				return "", fmt.Errorf("arrays may only contain strings")
+			}
+			elements = append(elements, str)
+		}
+		return strings.Join(elements, ","), nil
+	default:
+		return "", fmt.Errorf("unsupported value %v, use a string, number, boolean or array of strings", value)
+	}
+}
+
+// parseTOML parses the flat subset of TOML the configuration needs:
+// comments, and key = value pairs whose values are basic strings, integers, floats, booleans or single-line arrays of strings.
+// Tables are not supported.
+func parseTOML(data []byte) (map[string]string, error) {
+	values := make(map[string]string)
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	lineNumber := 0
+
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(stripTOMLComment(scanner.Text()))
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			return nil, fmt.Errorf("config file line %d: tables are not supported", lineNumber)
+		}
+
+		key, rawValue, found := strings.Cut(line, "=")
+		if !found {
+			return nil, fmt.Errorf("config file line %d: expected key = value", lineNumber)
+		}
+
+		key = strings.Trim(strings.TrimSpace(key), `"`)
+		value, err := parseTOMLValue(strings.TrimSpace(rawValue))
+		if err != nil {
+			return nil, fmt.Errorf("config file line %d: %w", lineNumber, err)
+		}
+
+		if _, exists := values[key]; exists {
+			return nil, fmt.Errorf("config file line %d: duplicate key %s", lineNumber, key)
+		}
+		values[key] = value
+	}
+
+	return values, scanner.Err()
+}
+
+func parseTOMLValue(raw string) (string, error) {
+	switch {
+	case strings.HasPrefix(raw, `"`):
+		value, err := strconv.Unquote(raw)
+		if err != nil {
+			return "", fmt.Errorf("invalid string %s", raw)
+		}
+		return value, nil
+	case strings.HasPrefix(raw, "'") && strings.HasSuffix(raw, "'") && len(raw) >= 2:
+		// Literal string without escapes
+		return raw[1 : len(raw)-1], nil
+	case strings.HasPrefix(raw, "["):
+		if !strings.HasSuffix(raw, "]") {
+			return "", fmt.Errorf("arrays must be written on a single line")
+		}
+
+		inner := strings.TrimSpace(raw[1 : len(raw)-1])
+		elements := make([]string, 0)
+		for element := range strings.SplitSeq(inner, ",") {
+			element = strings.TrimSpace(element)
+			if element == "" {
+				continue
+			}
+
+			isString := strings.HasPrefix(element, `"`) || strings.HasPrefix(element, "'")
+			value, err := parseTOMLValue(element)
+			if err != nil || !isString {
+				return "", fmt.Errorf("arrays may only contain strings")
+			}
+			elements = append(elements, value)
+		}
+		return strings.Join(elements, ","), nil
+	case raw == "true" || raw == "false":
+		return raw, nil
+	default:
+		if _, err := strconv.ParseFloat(strings.ReplaceAll(raw, "_", ""), 64); err != nil {
+			return "", fmt.Errorf("unsupported value %s", raw)
+		}
+		return strings.ReplaceAll(raw, "_", ""), nil
+	}
+}
+
+// stripTOMLComment removes a trailing comment that is not part of a string.
+func stripTOMLComment(line string) string {
+	inBasicString := false
+	inLiteralString := false
+
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			if inBasicString {
+				i++
+			}
+		case '"':
+			if !inLiteralString {
+				inBasicString = !inBasicString
+			}
+		case '\'':
+			if !inBasicString {
+				inLiteralString = !inLiteralString
+			}
+		case '#':
+			if !inBasicString && !inLiteralString {
+				return line[:i]
+			}
+		}
+	}
+
+	return line
+}
@@ -0,0 +1,39 @@
+package connection
+
+import (
+	"encoding/json"
+
+	"bjoernblessin.de/screenecho/util/strictjson"
+	"github.com/gorilla/websocket"
+)
+
+// Codec converts messages into WebSocket frames and back, see [Options.Codec].
+//
+// Only the envelope is up to the codec: payloads are passed to the handlers as JSON and validated with package strictjson.
+// The browser client and the client SDK speak [JSONCodec], other codecs need clients of their own.
+type Codec interface {
+	// Encode encodes msg, a [TypedMessage], into the data of a frame.
+	Encode(msg any) ([]byte, error)
+	// Decode decodes the data of a received frame. Errors are reported to the client, see [BuildInvalidMessageError].
+	Decode(data []byte) (TypedMessage[json.RawMessage], error)
+	// FrameType is the type of the frames of encoded messages, [websocket.TextMessage] or [websocket.BinaryMessage].
+	FrameType() int
+}
+
+// JSONCodec sends messages as JSON in text frames. It's the default codec.
+type JSONCodec struct{}
+
+func (JSONCodec) Encode(msg any) ([]byte, error) {
+	return json.Marshal(msg)
+}
+
+func (JSONCodec) Decode(data []byte) (TypedMessage[json.RawMessage], error) {
+	var message TypedMessage[json.RawMessage]
+	err := strictjson.Unmarshal(data, &message)
+
+	return message, err
+}
+
+func (JSONCodec) FrameType() int {
+	return websocket.TextMessage
+}
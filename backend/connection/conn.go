@@ -1,29 +1,77 @@
 package connection
 
 import (
-	"log"
+	"context"
+	"slices"
 	"sync"
+	"time"
 
+	"bjoernblessin.de/screenecho/metrics"
+	"bjoernblessin.de/screenecho/util/logger"
 	"github.com/gorilla/websocket"
 )
 
 type Conn struct {
 	socket             *websocket.Conn
-	closeHandlers      []func()
+	closeHandlers      []*closeHandler
 	closeHandlersMutex sync.RWMutex
+	// closed is set once the close handlers were notified. Guarded by closeHandlersMutex.
+	closed bool
 	// From https://pkg.go.dev/github.com/gorilla/websocket#hdr-Concurrency: Connections support one concurrent reader and one concurrent writer.
 	writeMutex sync.Mutex
+	// writeTimeout bounds every write, see [Options.WriteTimeout].
+	writeTimeout time.Duration
+	codec        Codec
+	metrics      metrics.Metrics
+	openedAt     time.Time
+	// ctx holds the log fields of the connection (request ID, room ID, client ID). It's never canceled.
+	ctx context.Context
+}
+
+// closeHandler wraps a handler, so that it can be removed by identity.
+type closeHandler struct {
+	handler func()
+}
+
+// Context returns the context holding the log fields of the connection, see [logger.WithRoomID] and friends.
+func (conn *Conn) Context() context.Context {
+	return conn.ctx
 }
 
 // AddCloseHandler registers a function to be called when the WebSocket connection is closed.
 //
 // Multiple close handlers can be added, and they will all be executed after the connection was closed.
-// However, there is no RemoveCloseHandler function, so once a handler is registered, it cannot be removed.
+// Calling the returned function removes the handler again, it's safe to call it multiple times.
+// If the close handlers were already notified, handler is executed immediately, so no cleanup is missed.
+// Handlers of all connections are notified with [ConnectionClosed] on the bus instead.
 //
 // When the WebSocket connection is closed, no more messages will be read or written.
 // The connection is considered invalid, and any pointers to Conn should be freed to avoid invalid state.
-func (conn *Conn) AddCloseHandler(handler func()) {
-	conn.closeHandlers = append(conn.closeHandlers, handler)
+func (conn *Conn) AddCloseHandler(handler func()) (remove func()) {
+	conn.closeHandlersMutex.Lock()
+
+	if conn.closed {
+		conn.closeHandlersMutex.Unlock()
+
+		func() {
+			defer conn.recoverCloseHandler()
+			handler()
+		}()
+		return func() {}
+	}
+
+	wrapper := &closeHandler{handler: handler}
+	conn.closeHandlers = append(conn.closeHandlers, wrapper)
+	conn.closeHandlersMutex.Unlock()
+
+	return func() {
+		conn.closeHandlersMutex.Lock()
+		defer conn.closeHandlersMutex.Unlock()
+
+		conn.closeHandlers = slices.DeleteFunc(conn.closeHandlers, func(other *closeHandler) bool {
+			return other == wrapper
+		})
+	}
 }
 
 // SendMessage sends a typed message over a WebSocket connection.
@@ -52,30 +100,76 @@ func (conn *Conn) AddCloseHandler(handler func()) {
 //	    log.Println("Message sent successfully")
 //	}
 func SendMessage[T any](conn *Conn, msg TypedMessage[T]) error {
+	start := time.Now()
+
+	data, err := conn.codec.Encode(msg)
+	if err != nil {
+		return err
+	}
+
 	conn.writeMutex.Lock()
 	defer conn.writeMutex.Unlock()
 
-	log.Printf("msg sent: %v", msg)
+	if conn.writeTimeout > 0 {
+		_ = conn.socket.SetWriteDeadline(time.Now().Add(conn.writeTimeout))
+	}
+
+	err = conn.socket.WriteMessage(conn.codec.FrameType(), data)
+	if err != nil {
+		log.DebugContext(logger.WithMessageType(conn.ctx, string(msg.Type)), "Failed to send message", "error", err)
+		// A failed write leaves the socket unusable, closing it ends the read loop and runs the close handlers
+		_ = conn.socket.Close()
+		return err
+	}
+
+	log.DebugContext(logger.WithMessageType(conn.ctx, string(msg.Type)), "Message sent", "payload", logger.Payload(data))
+
+	conn.metrics.SendLatency(time.Since(start))
+	conn.metrics.MessageSent(string(msg.Type))
+	if msg.Type == ERROR_MESSAGE_TYPE {
+		conn.metrics.ErrorMessageSent()
+	}
+
+	return nil
+}
+
+// closeGracePeriod is the time the remote endpoint has to answer a close message before the socket is closed forcefully.
+const closeGracePeriod = 5 * time.Second
+
+// Close starts the WebSocket closing handshake with the given close code (see [CloseNormalClosure] and following) and reason.
+//
+// Close doesn't wait for the handshake to complete. Close handlers are executed as soon as the remote endpoint answered
+// or closeGracePeriod passed, whatever happens first.
+func (conn *Conn) Close(code int, reason string) {
+	deadline := time.Now().Add(closeGracePeriod)
+
+	err := conn.socket.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), deadline)
+	if err != nil {
+		_ = conn.socket.Close()
+		return
+	}
 
-	err := conn.socket.WriteJSON(msg)
-	return err
+	_ = conn.socket.SetReadDeadline(deadline)
 }
 
 // notifyCloseHandlers executes all registered close handlers in parallel,
-// waiting for all of them to finish before returning. It temporarily acquires
-// a read lock to safely access the list of handlers and uses a wait group
+// waiting for all of them to finish before returning. It marks the connection as closed
+// so that handlers added later are executed right away by AddCloseHandler, and uses a wait group
 // to track individual handler completion.
 func (conn *Conn) notifyCloseHandlers() {
-	conn.closeHandlersMutex.RLock()
-	defer conn.closeHandlersMutex.RUnlock()
+	conn.closeHandlersMutex.Lock()
+	conn.closed = true
+	closeHandlers := conn.closeHandlers
+	conn.closeHandlersMutex.Unlock()
 
 	var waitgroup sync.WaitGroup
 
-	for _, closeHandler := range conn.closeHandlers {
+	for _, closeHandler := range closeHandlers {
 		waitgroup.Add(1)
 		go func() {
 			defer waitgroup.Done()
-			closeHandler()
+			defer conn.recoverCloseHandler()
+			closeHandler.handler()
 		}()
 	}
 
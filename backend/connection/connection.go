@@ -3,7 +3,34 @@
 // is to enable the exchange of strongly-typed messages between endpoints.
 package connection
 
-const ERROR_MESSAGE_TYPE = "error"
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"bjoernblessin.de/screenecho/util/logger"
+	"bjoernblessin.de/screenecho/util/strictjson"
+	"bjoernblessin.de/screenecho/wire"
+	"github.com/gorilla/websocket"
+)
+
+var log = logger.New("connection")
+
+// Close codes that can be passed to [Conn.Close].
+const (
+	CloseNormalClosure   = websocket.CloseNormalClosure
+	ClosePolicyViolation = websocket.ClosePolicyViolation
+	CloseServiceRestart  = websocket.CloseServiceRestart
+	// CloseInternalServerErr is used if a connection is closed because a handler panicked.
+	CloseInternalServerErr = websocket.CloseInternalServerErr
+)
+
+const ERROR_MESSAGE_TYPE = wire.ERROR_MESSAGE_TYPE
+
+// SERVER_SHUTDOWN_MESSAGE_TYPE is sent to all clients right before the server closes their connections during a shutdown.
+const SERVER_SHUTDOWN_MESSAGE_TYPE = wire.SERVER_SHUTDOWN_MESSAGE_TYPE
+
+type ServerShutdownMessage = wire.ServerShutdownMessage
 
 // BuildErrorMessage is a helper function that returns an error message with only ErrorMessage set.
 func BuildErrorMessage(msg string) TypedMessage[ErrorMessage] {
@@ -14,3 +41,41 @@ func BuildErrorMessage(msg string) TypedMessage[ErrorMessage] {
 		},
 	}
 }
+
+// BuildInvalidMessageError returns the error message for an error of [strictjson.Unmarshal] that a handler got while
+// unmarshalling the msg field of a received message. The invalid fields are listed in Fields, their paths start with "msg".
+// If there is only one invalid field, Expected and Actual are set as well.
+func BuildInvalidMessageError(err error) TypedMessage[ErrorMessage] {
+	var fieldErrors strictjson.Errors
+	var fieldError *strictjson.Error
+
+	switch {
+	case errors.As(err, &fieldErrors):
+		message := BuildErrorMessage(fmt.Sprintf("Message had invalid values. %v", err))
+		for _, fieldError := range fieldErrors {
+			message.Msg.Fields = append(message.Msg.Fields, buildFieldError(fieldError))
+		}
+		if len(fieldErrors) == 1 {
+			message.Msg.Expected, message.Msg.Actual = fieldErrors[0].Expected, fieldErrors[0].Actual
+		}
+		return message
+	case errors.As(err, &fieldError):
+		message := BuildErrorMessage(fmt.Sprintf("Message had invalid JSON format. %v", err))
+		message.Msg.Fields = []FieldError{buildFieldError(fieldError)}
+		message.Msg.Expected, message.Msg.Actual = fieldError.Expected, fieldError.Actual
+		return message
+	default:
+		// Malformed JSON
+		return BuildErrorMessage(fmt.Sprintf("Message had invalid JSON format. %v", err))
+	}
+}
+
+func buildFieldError(err *strictjson.Error) FieldError {
+	path := "msg"
+	if err.Path != "" && !strings.HasPrefix(err.Path, "[") {
+		path += "."
+	}
+	path += err.Path
+
+	return FieldError{Path: path, Expected: err.Expected, Actual: err.Actual}
+}
@@ -0,0 +1,205 @@
+package connection
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"bjoernblessin.de/screenecho/events"
+	"bjoernblessin.de/screenecho/metrics"
+	"bjoernblessin.de/screenecho/util/strictjson"
+)
+
+func TestBuildInvalidMessageError(t *testing.T) {
+	tests := []struct {
+		Name     string
+		Err      error
+		Expected ErrorMessage
+	}{
+		{
+			Name: "rule violations",
+			Err: strictjson.Errors{
+				{Kind: strictjson.InvalidValue, Path: "remoteClientID", Expected: "a UUID", Actual: `"x"`},
+				{Kind: strictjson.InvalidValue, Path: "description.type", Expected: "one of offer, answer", Actual: `"y"`},
+			},
+			Expected: ErrorMessage{
+				ErrorMessage: `Message had invalid values. Invalid value at remoteClientID: expected a UUID, got "x"; Invalid value at description.type: expected one of offer, answer, got "y"`,
+				Fields: []FieldError{
+					{Path: "msg.remoteClientID", Expected: "a UUID", Actual: `"x"`},
+					{Path: "msg.description.type", Expected: "one of offer, answer", Actual: `"y"`},
+				},
+			},
+		},
+		{
+			Name: "single rule violation",
+			Err:  strictjson.Errors{{Kind: strictjson.InvalidValue, Path: "clientID", Expected: "a UUID", Actual: `"x"`}},
+			Expected: ErrorMessage{
+				ErrorMessage: `Message had invalid values. Invalid value at clientID: expected a UUID, got "x"`,
+				Expected:     "a UUID",
+				Actual:       `"x"`,
+				Fields:       []FieldError{{Path: "msg.clientID", Expected: "a UUID", Actual: `"x"`}},
+			},
+		},
+		{
+			Name: "type mismatch",
+			Err:  &strictjson.Error{Kind: strictjson.TypeMismatch, Path: "meta.width", Expected: "integer", Actual: "string"},
+			Expected: ErrorMessage{
+				ErrorMessage: "Message had invalid JSON format. Type mismatch at meta.width: expected integer, got string",
+				Expected:     "integer",
+				Actual:       "string",
+				Fields:       []FieldError{{Path: "msg.meta.width", Expected: "integer", Actual: "string"}},
+			},
+		},
+		{
+			Name: "root type mismatch",
+			Err:  &strictjson.Error{Kind: strictjson.TypeMismatch, Expected: "object", Actual: "array"},
+			Expected: ErrorMessage{
+				ErrorMessage: "Message had invalid JSON format. Type mismatch: expected object, got array",
+				Expected:     "object",
+				Actual:       "array",
+				Fields:       []FieldError{{Path: "msg", Expected: "object", Actual: "array"}},
+			},
+		},
+		{
+			Name:     "malformed JSON",
+			Err:      errors.New("unexpected EOF"),
+			Expected: ErrorMessage{ErrorMessage: "Message had invalid JSON format. unexpected EOF"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			message := BuildInvalidMessageError(test.Err)
+
+			if message.Type != ERROR_MESSAGE_TYPE {
+				t.Errorf("expected type %s, got %s", ERROR_MESSAGE_TYPE, message.Type)
+			}
+			if !reflect.DeepEqual(message.Msg, test.Expected) {
+				t.Errorf("expected %+v, got %+v", test.Expected, message.Msg)
+			}
+		})
+	}
+}
+
+func TestWriteTimeout(t *testing.T) {
+	cm := NewConnectionManager(Options{WriteTimeout: 100 * time.Millisecond}, metrics.Nop{}, events.NewBus(events.DefaultAsyncBufferSize))
+
+	conns := make(chan *Conn, 1)
+	// The client never reads, so its receive window fills up
+	startServer(t, cm, func(conn *Conn) { conns <- conn })
+	conn := <-conns
+
+	closed := make(chan struct{})
+	conn.AddCloseHandler(func() { close(closed) })
+
+	message := TypedMessage[string]{Type: "flood", Msg: strings.Repeat("x", 1<<20)}
+
+	start := time.Now()
+	for {
+		if err := SendMessage(conn, message); err != nil {
+			break
+		}
+		if time.Since(start) > 10*time.Second {
+			t.Fatal("expected sending to a client that doesn't read to fail")
+		}
+	}
+
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the connection to be closed after the write timed out")
+	}
+}
+
+func TestRemoveCloseHandler(t *testing.T) {
+	cm := NewConnectionManager(Options{}, metrics.Nop{}, events.NewBus(events.DefaultAsyncBufferSize))
+
+	removedRan := make(chan struct{}, 1)
+	registered := make(chan struct{})
+	closed := make(chan struct{})
+	socket := startServer(t, cm, func(conn *Conn) {
+		remove := conn.AddCloseHandler(func() { removedRan <- struct{}{} })
+		conn.AddCloseHandler(func() { close(closed) })
+		remove()
+		remove()
+		close(registered)
+	})
+
+	// Handlers added after the connection closed would run immediately
+	<-registered
+	_ = socket.Close()
+
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the remaining close handler to run")
+	}
+
+	select {
+	case <-removedRan:
+		t.Error("expected the removed close handler not to run")
+	default:
+	}
+}
+
+func TestUnsubscribeMessage(t *testing.T) {
+	cm := NewConnectionManager(Options{}, metrics.Nop{}, events.NewBus(events.DefaultAsyncBufferSize))
+
+	var removedCalls atomic.Int32
+	received := make(chan struct{}, 2)
+	removedID := cm.SubscribeMessage("ping", func(*Conn, TypedMessage[json.RawMessage]) { removedCalls.Add(1) })
+	cm.SubscribeMessage("ping", func(*Conn, TypedMessage[json.RawMessage]) { received <- struct{}{} })
+	cm.UnsubscribeMessage("ping", removedID)
+	cm.UnsubscribeMessage("ping", removedID)
+
+	socket := startServer(t, cm, nil)
+	for range 2 {
+		if err := socket.WriteJSON(TypedMessage[string]{Type: "ping"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for range 2 {
+		select {
+		case <-received:
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected the remaining handler to receive the message")
+		}
+	}
+	if calls := removedCalls.Load(); calls != 0 {
+		t.Errorf("expected the removed handler not to be called, got %d calls", calls)
+	}
+}
+
+func TestShutdownStalledClient(t *testing.T) {
+	cm := NewConnectionManager(Options{WriteTimeout: time.Minute}, metrics.Nop{}, events.NewBus(events.DefaultAsyncBufferSize))
+
+	conns := make(chan *Conn, 1)
+	// The client never reads, so the flood fills its receive window and the writes block
+	startServer(t, cm, func(conn *Conn) { conns <- conn })
+	conn := <-conns
+
+	go func() {
+		message := TypedMessage[string]{Type: "flood", Msg: strings.Repeat("x", 1<<20)}
+		for SendMessage(conn, message) == nil {
+		}
+	}()
+	time.Sleep(200 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := cm.Shutdown(ctx, time.Second)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the deadline to be exceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("expected Shutdown to return once ctx expired, took %v", elapsed)
+	}
+}
@@ -0,0 +1,19 @@
+package connection
+
+import "time"
+
+// Events published by the ConnectionManager, see package events.
+// ConnectionOpened is published before the connection's reading goroutine starts, which publishes ConnectionClosed,
+// so synchronous subscribers receive them in this order.
+
+// ConnectionOpened is published when a WebSocket connection was established, before its first message is read.
+type ConnectionOpened struct {
+	Conn *Conn
+}
+
+// ConnectionClosed is published when a WebSocket connection was closed, after its close handlers finished.
+// Shutdown waits for synchronous subscribers, like it waits for close handlers.
+type ConnectionClosed struct {
+	Conn     *Conn
+	Lifetime time.Duration
+}
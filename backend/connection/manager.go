@@ -1,30 +1,31 @@
 package connection
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
 	"net/http"
 	"slices"
 	"sync"
+	"time"
 
-	"bjoernblessin.de/screenecho/util/strictjson"
+	"bjoernblessin.de/screenecho/events"
+	"bjoernblessin.de/screenecho/metrics"
+	"bjoernblessin.de/screenecho/util/logger"
+	"bjoernblessin.de/screenecho/wire"
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 )
 
-type MessageType string
-
-type TypedMessage[T any] struct {
-	Type MessageType `json:"type"`
-	Msg  T           `json:"msg"`
-}
-
-type ErrorMessage struct {
-	ErrorMessage string `json:"errorMessage"`
-	Expected     string `json:"expected,omitempty"`
-	Actual       string `json:"actual,omitempty"`
-}
+// The messages are defined in package wire, which the Go client shares.
+type (
+	MessageType         = wire.MessageType
+	TypedMessage[T any] = wire.TypedMessage[T]
+	// ErrorMessage lists the invalid fields of a received message in Fields, see BuildInvalidMessageError.
+	ErrorMessage = wire.ErrorMessage
+	FieldError   = wire.FieldError
+)
 
 type MessageHandlerID uuid.UUID
 
@@ -39,40 +40,220 @@ type ConnectionManager struct {
 	messageHandlers      map[MessageType][]messageHandlerWrapper
 	messageHandlersMutex sync.RWMutex
 	// closeMutex is a mutex used to ensure only one connection close-routine is executed at a time.
-	closeMutex sync.Mutex
+	closeMutex     sync.Mutex
+	upgrader       websocket.Upgrader
+	maxMessageSize int64
+	writeTimeout   time.Duration
+	closeOnPanic   bool
+	codec          Codec
+	// conns holds all open connections. connsMutex also guards draining and additions to activeConns.
+	conns      map[*Conn]struct{}
+	connsMutex sync.Mutex
+	// draining is set once Shutdown was called. No new connections are accepted afterwards.
+	draining bool
+	// activeConns counts connections whose close handlers have not finished yet.
+	activeConns sync.WaitGroup
+	metrics     metrics.Metrics
+	bus         *events.Bus
+}
+
+// ErrShuttingDown is returned by EstablishWebSocket after Shutdown was called.
+var ErrShuttingDown = errors.New("server is shutting down")
+
+// Options configures the WebSocket connections of a ConnectionManager.
+type Options struct {
+	// CheckOrigin decides whether a WebSocket upgrade request is accepted based on its Origin header, see [websocket.Upgrader.CheckOrigin].
+	CheckOrigin func(*http.Request) bool
+	// ReadBufferSize and WriteBufferSize are the I/O buffer sizes in bytes. 0 uses the buffers allocated by the HTTP server.
+	ReadBufferSize  int
+	WriteBufferSize int
+	// HandshakeTimeout is the maximum duration of the upgrade handshake. 0 means no timeout.
+	HandshakeTimeout time.Duration
+	// WriteTimeout is the maximum duration of sending a message. Connections whose peer doesn't receive in time are closed,
+	// so a stalled client can't block the sender, e.g. a room broadcasting to all its clients. 0 means no timeout.
+	WriteTimeout time.Duration
+	// MaxMessageSize is the maximum size in bytes of an inbound message. Connections sending larger messages are closed. 0 means no limit.
+	MaxMessageSize int64
+	// CloseOnPanic closes a connection with close code 1011 (internal error) after one of its message handlers panicked.
+	// Otherwise the connection stays open and the client only receives an internal-error message.
+	CloseOnPanic bool
+	// Codec encodes and decodes the messages. nil uses [JSONCodec].
+	Codec Codec
 }
 
-func NewConnectionManager() *ConnectionManager {
+// NewConnectionManager creates a ConnectionManager whose connections are configured by options.
+// Connection lifetimes, messages and handler durations are recorded in m, ConnectionOpened and ConnectionClosed are published to bus.
+func NewConnectionManager(options Options, m metrics.Metrics, bus *events.Bus) *ConnectionManager {
+	codec := options.Codec
+	if codec == nil {
+		codec = JSONCodec{}
+	}
+
 	return &ConnectionManager{
 		messageHandlers: make(map[MessageType][]messageHandlerWrapper),
+		upgrader: websocket.Upgrader{
+			CheckOrigin:      options.CheckOrigin,
+			ReadBufferSize:   options.ReadBufferSize,
+			WriteBufferSize:  options.WriteBufferSize,
+			HandshakeTimeout: options.HandshakeTimeout,
+		},
+		maxMessageSize: options.MaxMessageSize,
+		writeTimeout:   options.WriteTimeout,
+		closeOnPanic:   options.CloseOnPanic,
+		codec:          codec,
+		conns:          make(map[*Conn]struct{}),
+		metrics:        m,
+		bus:            bus,
 	}
 }
 
-// TODO CheckOrigin
-
-var upgrader = websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}
-
 // EstablishWebSocket establishes the WebSocket connection between client and server and listens to send messages.
 // It handles incoming messages by forwarding them according to their TypedMessage type.
+//
+// After Shutdown was called, the request is answered with 503 Service Unavailable and ErrShuttingDown is returned.
 func (cm *ConnectionManager) EstablishWebSocket(writer http.ResponseWriter, request *http.Request) (*Conn, error) {
-	socket, err := upgrader.Upgrade(writer, request, nil)
+	cm.connsMutex.Lock()
+	if cm.draining {
+		cm.connsMutex.Unlock()
+		http.Error(writer, "Server is shutting down, please reconnect later.", http.StatusServiceUnavailable)
+		return nil, ErrShuttingDown
+	}
+	cm.activeConns.Add(1)
+	cm.connsMutex.Unlock()
+
+	socket, err := cm.upgrader.Upgrade(writer, request, nil)
 	if err != nil {
+		cm.activeConns.Done()
 		return nil, err
 	}
 
-	conn := &Conn{socket: socket, closeHandlers: make([]func(), 0)}
+	if cm.maxMessageSize > 0 {
+		socket.SetReadLimit(cm.maxMessageSize)
+	}
+
+	conn := &Conn{
+		socket:        socket,
+		closeHandlers: make([]*closeHandler, 0),
+		writeTimeout:  cm.writeTimeout,
+		codec:         cm.codec,
+		metrics:       cm.metrics,
+		openedAt:      time.Now(),
+		// The request context is canceled when the handler returns, but its values hold the log fields of the connection
+		ctx: context.WithoutCancel(request.Context()),
+	}
+	cm.metrics.ConnectionOpened()
+
+	log.InfoContext(conn.ctx, "Connection established", "remote_addr", request.RemoteAddr)
+
+	cm.connsMutex.Lock()
+	cm.conns[conn] = struct{}{}
+	draining := cm.draining
+	cm.connsMutex.Unlock()
+
+	events.Publish(cm.bus, ConnectionOpened{Conn: conn})
 
 	go cm.listenToMessages(conn)
 
+	if draining {
+		// Shutdown started while upgrading and didn't see this connection
+		conn.Close(CloseServiceRestart, SERVER_SHUTDOWN_MESSAGE_TYPE)
+	}
+
 	return conn, nil
 }
 
+// Shutdown gracefully closes all connections.
+//
+// New connections are rejected from now on. Every connected client receives a server-shutdown message
+// that hints the client to reconnect after reconnectAfter. Then all WebSockets are closed with close code 1012 (service restart).
+//
+// The messages are sent concurrently, so a client that doesn't receive delays only its own connection.
+// Shutdown waits until the close handlers of all connections finished.
+// If ctx expires first, the remaining sockets are closed forcefully, which also ends pending writes, and ctx's error is returned.
+func (cm *ConnectionManager) Shutdown(ctx context.Context, reconnectAfter time.Duration) error {
+	cm.connsMutex.Lock()
+	cm.draining = true
+	conns := make([]*Conn, 0, len(cm.conns))
+	for conn := range cm.conns {
+		conns = append(conns, conn)
+	}
+	cm.connsMutex.Unlock()
+
+	shutdownMessage := TypedMessage[ServerShutdownMessage]{
+		Type: SERVER_SHUTDOWN_MESSAGE_TYPE,
+		Msg: ServerShutdownMessage{
+			Reason:           "The server is restarting.",
+			ReconnectAfterMs: reconnectAfter.Milliseconds(),
+		},
+	}
+
+	for _, conn := range conns {
+		go func() {
+			_ = SendMessage(conn, shutdownMessage)
+			conn.Close(CloseServiceRestart, SERVER_SHUTDOWN_MESSAGE_TYPE)
+		}()
+	}
+
+	closeHandlersDone := make(chan struct{})
+	go func() {
+		cm.activeConns.Wait()
+		close(closeHandlersDone)
+	}()
+
+	select {
+	case <-closeHandlersDone:
+		return nil
+	case <-ctx.Done():
+		cm.connsMutex.Lock()
+		for conn := range cm.conns {
+			_ = conn.socket.Close()
+		}
+		cm.connsMutex.Unlock()
+
+		return ctx.Err()
+	}
+}
+
+// IsDraining reports whether Shutdown was called.
+func (cm *ConnectionManager) IsDraining() bool {
+	cm.connsMutex.Lock()
+	defer cm.connsMutex.Unlock()
+
+	return cm.draining
+}
+
+// ConnectionCount returns the number of open connections.
+func (cm *ConnectionManager) ConnectionCount() int {
+	cm.connsMutex.Lock()
+	defer cm.connsMutex.Unlock()
+
+	return len(cm.conns)
+}
+
+// publishClosed publishes ConnectionClosed for conn. Panics of subscribers are recovered like those of close handlers,
+// so that the connection is still released.
+func (cm *ConnectionManager) publishClosed(conn *Conn) {
+	defer conn.recoverCloseHandler()
+
+	events.Publish(cm.bus, ConnectionClosed{Conn: conn, Lifetime: time.Since(conn.openedAt)})
+}
+
+// removeConn forgets conn after its close handlers finished.
+func (cm *ConnectionManager) removeConn(conn *Conn) {
+	cm.connsMutex.Lock()
+	defer cm.connsMutex.Unlock()
+
+	delete(cm.conns, conn)
+	cm.activeConns.Done()
+
+	cm.metrics.ConnectionClosed(time.Since(conn.openedAt))
+}
+
 // listenToMessages listens for incoming messages on a WebSocket connection.
 // It continuously reads messages from the provided WebSocket connection and processes them.
 // If an error occurs while reading a message (e.g., the WebSocket is closed), the function exits.
 func (cm *ConnectionManager) listenToMessages(conn *Conn) {
 	defer func() {
-		// log.Printf("listenToMessages exited, closing socket")
 		_ = conn.socket.Close()
 	}()
 
@@ -80,19 +261,24 @@ func (cm *ConnectionManager) listenToMessages(conn *Conn) {
 		_, msg, err := conn.socket.ReadMessage()
 		if err != nil {
 			// WebSocket is closed
+			log.InfoContext(conn.ctx, "Connection closed", "reason", err, "lifetime", time.Since(conn.openedAt))
+
 			cm.closeMutex.Lock()
 			defer cm.closeMutex.Unlock()
 
 			conn.notifyCloseHandlers()
+			cm.publishClosed(conn)
+			cm.removeConn(conn)
 
 			return
 		}
 
-		log.Printf("msg received: %s", msg)
+		logger.TraceContext(conn.ctx, log, "Message received", "payload", logger.Payload(msg))
 
-		var typedMessage TypedMessage[json.RawMessage]
-		err = strictjson.Unmarshal(msg, &typedMessage)
+		typedMessage, err := cm.codec.Decode(msg)
 		if err != nil {
+			cm.metrics.MessageReceived(invalidMessageLabel)
+
 			expectedJSON, _ := json.Marshal(TypedMessage[any]{
 				Type: "",
 				Msg:  nil,
@@ -140,8 +326,6 @@ func (cm *ConnectionManager) SubscribeMessage(messageType MessageType, handler M
 	return id
 }
 
-// TODO test
-
 // UnsubscribeMessage removes a specific message handler for a given message type.
 //
 // The provided handler will be called whenever a message with the specified message type is received.
@@ -164,12 +348,38 @@ func (cm *ConnectionManager) UnsubscribeMessage(messageType MessageType, handler
 	}
 }
 
+// Label values used for received messages that can't be attributed to a subscribed message type.
+// Message types are chosen by clients, so unknown types are not used as label values to keep the number of metric series bounded.
+const (
+	invalidMessageLabel   = "invalid"
+	unhandledMessageLabel = "unhandled"
+)
+
 // forwardMessage forwards a typed message to all handlers subscribed to its type.
+// Panics of handlers are recovered, see [ConnectionManager.recoverMessageHandler].
 func (cm *ConnectionManager) forwardMessage(conn *Conn, typedMessage TypedMessage[json.RawMessage]) {
 	cm.messageHandlersMutex.RLock()
 	defer cm.messageHandlersMutex.RUnlock()
 
-	for _, wrapper := range cm.messageHandlers[typedMessage.Type] {
-		go wrapper.messageHandler(conn, typedMessage)
+	ctx := logger.WithMessageType(conn.ctx, string(typedMessage.Type))
+
+	wrappers := cm.messageHandlers[typedMessage.Type]
+	if len(wrappers) == 0 {
+		log.DebugContext(ctx, "No handler subscribed to message type")
+		cm.metrics.MessageReceived(unhandledMessageLabel)
+		return
+	}
+
+	log.DebugContext(ctx, "Message received", "payload", logger.Payload(typedMessage.Msg))
+	cm.metrics.MessageReceived(string(typedMessage.Type))
+
+	for _, wrapper := range wrappers {
+		go func() {
+			defer cm.recoverMessageHandler(ctx, conn, typedMessage.Type)
+
+			start := time.Now()
+			wrapper.messageHandler(conn, typedMessage)
+			cm.metrics.HandlerDuration(string(typedMessage.Type), time.Since(start))
+		}()
 	}
 }
@@ -0,0 +1,61 @@
+package connection
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+
+	"bjoernblessin.de/screenecho/wire"
+)
+
+// INTERNAL_ERROR_MESSAGE_TYPE is sent to a client after a handler panicked while processing one of its messages.
+const INTERNAL_ERROR_MESSAGE_TYPE = wire.INTERNAL_ERROR_MESSAGE_TYPE
+
+type InternalErrorMessage = wire.InternalErrorMessage
+
+// Sources of recovered panics as passed to [metrics.Metrics.PanicRecovered].
+const (
+	messageHandlerPanicSource = "message_handler"
+	closeHandlerPanicSource   = "close_handler"
+)
+
+// recoverMessageHandler recovers a panic of a message handler of conn.
+// The panic is logged with its stack and counted, the client receives an internal-error message and,
+// if enabled, only its connection is closed. The server and all other connections keep running.
+//
+// It must be deferred directly in the goroutine running the handler.
+func (cm *ConnectionManager) recoverMessageHandler(ctx context.Context, conn *Conn, messageType MessageType) {
+	recovered := recover()
+	if recovered == nil {
+		return
+	}
+
+	log.ErrorContext(ctx, "Message handler panicked", "panic", fmt.Sprint(recovered), "stack", string(debug.Stack()))
+	cm.metrics.PanicRecovered(messageHandlerPanicSource)
+
+	_ = SendMessage(conn, TypedMessage[InternalErrorMessage]{
+		Type: INTERNAL_ERROR_MESSAGE_TYPE,
+		Msg: InternalErrorMessage{
+			ErrorMessage: "The server failed to process the message.",
+			MessageType:  messageType,
+		},
+	})
+
+	if cm.closeOnPanic {
+		conn.Close(CloseInternalServerErr, INTERNAL_ERROR_MESSAGE_TYPE)
+	}
+}
+
+// recoverCloseHandler recovers a panic of a close handler of conn, so that the remaining close handlers
+// still finish and the connection is released.
+//
+// It must be deferred directly in the goroutine running the handler.
+func (conn *Conn) recoverCloseHandler() {
+	recovered := recover()
+	if recovered == nil {
+		return
+	}
+
+	log.ErrorContext(conn.ctx, "Close handler panicked", "panic", fmt.Sprint(recovered), "stack", string(debug.Stack()))
+	conn.metrics.PanicRecovered(closeHandlerPanicSource)
+}
@@ -0,0 +1,140 @@
+package connection
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"bjoernblessin.de/screenecho/events"
+	"bjoernblessin.de/screenecho/metrics"
+	"github.com/gorilla/websocket"
+)
+
+// startServer serves WebSockets of cm and returns a connected client socket.
+// Every server-side connection is passed to onConnect.
+func startServer(t *testing.T, cm *ConnectionManager, onConnect func(*Conn)) *websocket.Conn {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := cm.EstablishWebSocket(w, r)
+		if err != nil {
+			t.Errorf("failed to establish WebSocket: %v", err)
+			return
+		}
+		if onConnect != nil {
+			onConnect(conn)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	socket, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = socket.Close() })
+
+	return socket
+}
+
+func readTypedMessage(t *testing.T, socket *websocket.Conn) TypedMessage[json.RawMessage] {
+	t.Helper()
+
+	_ = socket.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	var message TypedMessage[json.RawMessage]
+	err := socket.ReadJSON(&message)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return message
+}
+
+func TestMessageHandlerPanic(t *testing.T) {
+	tests := []struct {
+		Name         string
+		CloseOnPanic bool
+	}{
+		{Name: "connection stays open", CloseOnPanic: false},
+		{Name: "connection is closed", CloseOnPanic: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			registry := metrics.NewRegistry()
+			cm := NewConnectionManager(Options{CloseOnPanic: test.CloseOnPanic}, registry, events.NewBus(events.DefaultAsyncBufferSize))
+
+			cm.SubscribeMessage("boom", func(*Conn, TypedMessage[json.RawMessage]) {
+				panic("invariant violated")
+			})
+			cm.SubscribeMessage("ping", func(conn *Conn, _ TypedMessage[json.RawMessage]) {
+				_ = SendMessage(conn, TypedMessage[any]{Type: "pong"})
+			})
+
+			socket := startServer(t, cm, nil)
+
+			_ = socket.WriteJSON(TypedMessage[any]{Type: "boom"})
+
+			message := readTypedMessage(t, socket)
+			if message.Type != INTERNAL_ERROR_MESSAGE_TYPE {
+				t.Fatalf("expected %s message, got %s", INTERNAL_ERROR_MESSAGE_TYPE, message.Type)
+			}
+
+			var internalError InternalErrorMessage
+			_ = json.Unmarshal(message.Msg, &internalError)
+			if internalError.MessageType != "boom" {
+				t.Errorf("expected message type boom, got %q", internalError.MessageType)
+			}
+
+			if value := registry.Counter(metrics.PanicsRecoveredName, messageHandlerPanicSource); value != 1 {
+				t.Errorf("expected 1 recovered panic, got %v", value)
+			}
+
+			_ = socket.WriteJSON(TypedMessage[any]{Type: "ping"})
+
+			_ = socket.SetReadDeadline(time.Now().Add(5 * time.Second))
+			var reply TypedMessage[json.RawMessage]
+			err := socket.ReadJSON(&reply)
+
+			if test.CloseOnPanic {
+				if !websocket.IsCloseError(err, CloseInternalServerErr) {
+					t.Errorf("expected close code %d, got %v", CloseInternalServerErr, err)
+				}
+			} else if err != nil || reply.Type != "pong" {
+				t.Errorf("expected pong on the open connection, got %v (%v)", reply.Type, err)
+			}
+		})
+	}
+}
+
+func TestCloseHandlerPanic(t *testing.T) {
+	registry := metrics.NewRegistry()
+	cm := NewConnectionManager(Options{}, registry, events.NewBus(events.DefaultAsyncBufferSize))
+
+	var otherHandlerRan atomic.Bool
+	socket := startServer(t, cm, func(conn *Conn) {
+		conn.AddCloseHandler(func() { panic("invariant violated") })
+		conn.AddCloseHandler(func() { otherHandlerRan.Store(true) })
+	})
+
+	_ = socket.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for cm.ConnectionCount() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("connection was not released after a close handler panicked")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if !otherHandlerRan.Load() {
+		t.Error("expected the other close handler to run")
+	}
+	if value := registry.Counter(metrics.PanicsRecoveredName, closeHandlerPanicSource); value != 1 {
+		t.Errorf("expected 1 recovered panic, got %v", value)
+	}
+}
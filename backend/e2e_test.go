@@ -0,0 +1,486 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"bjoernblessin.de/screenecho/clients"
+	"bjoernblessin.de/screenecho/config"
+	"bjoernblessin.de/screenecho/connection"
+	"bjoernblessin.de/screenecho/metrics"
+	"bjoernblessin.de/screenecho/rooms"
+	"bjoernblessin.de/screenecho/signaling"
+	"bjoernblessin.de/screenecho/streams"
+	"github.com/google/uuid"
+)
+
+func TestJoin(t *testing.T) {
+	h := newHarness(t)
+
+	first := h.dial("room")
+	idMessage := expectMessage[clients.ClientIDMessage](first, clients.CLIENT_ID_MESSAGE_TYPE)
+	if idMessage.ClientID != first.ID().String() {
+		t.Errorf("expected client ID %s, got %s", first.ID(), idMessage.ClientID)
+	}
+	available := expectMessage[streams.AvailableStreamsMessage](first, streams.AVAILABLE_STREAMS_MESSAGE_TYPE)
+	if len(available.ClientIDs) != 0 {
+		t.Errorf("expected no streams in a new room, got %v", available.ClientIDs)
+	}
+
+	second := h.connect("room")
+
+	// Joins aren't announced, the other clients learn about a client once it streams or calls them
+	first.expectNoMessage()
+
+	h.waitFor("two clients in the room", func() bool { return h.app.Metrics().Gauge(metrics.ClientsName) == 2 })
+	if room := h.app.RoomManager().GetUsersRoom(clients.ClientID(second.ID())); room == nil || room.RoomID != "room" {
+		t.Errorf("expected %s in room, got %v", second.ID(), room)
+	}
+}
+
+func TestJoinSeesActiveStreams(t *testing.T) {
+	h := newHarness(t)
+
+	streamers := h.connectN("room", 2)
+	for _, streamer := range streamers {
+		if err := streamer.StartStream(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h.waitFor("two streams", func() bool { return len(h.app.StreamManager().GetStreamingClients("room")) == 2 })
+
+	viewer := h.dial("room")
+	viewer.expectSequence(clients.CLIENT_ID_MESSAGE_TYPE)
+	available := expectMessage[streams.AvailableStreamsMessage](viewer, streams.AVAILABLE_STREAMS_MESSAGE_TYPE)
+
+	expected := map[string]bool{streamers[0].ID().String(): true, streamers[1].ID().String(): true}
+	if len(available.ClientIDs) != 2 || !expected[available.ClientIDs[0]] || !expected[available.ClientIDs[1]] {
+		t.Errorf("expected streams of %v, got %v", expected, available.ClientIDs)
+	}
+}
+
+func TestStreamStartStop(t *testing.T) {
+	h := newHarness(t)
+
+	testClients := h.connectN("room", 3)
+	streamer, viewers := testClients[0], testClients[1:]
+
+	if err := streamer.StartStream(); err != nil {
+		t.Fatal(err)
+	}
+	for _, viewer := range viewers {
+		started := expectMessage[streams.StreamStartedMessage](viewer, streams.STREAM_STARTED_MESSAGE_TYPE)
+		if started.ClientID != streamer.ID().String() {
+			t.Errorf("expected stream of %s, got %s", streamer.ID(), started.ClientID)
+		}
+	}
+	h.waitFor("active stream gauge", func() bool { return h.app.Metrics().Gauge(metrics.ActiveStreamsName) == 1 })
+
+	if err := streamer.StopStream(); err != nil {
+		t.Fatal(err)
+	}
+	for _, viewer := range viewers {
+		stopped := expectMessage[streams.StreamStoppedMessage](viewer, streams.STREAM_STOPPED_MESSAGE_TYPE)
+		if stopped.ClientID != streamer.ID().String() {
+			t.Errorf("expected stopped stream of %s, got %s", streamer.ID(), stopped.ClientID)
+		}
+	}
+	h.waitFor("no active stream", func() bool { return h.app.Metrics().Gauge(metrics.ActiveStreamsName) == 0 })
+
+	// The streamer isn't told about its own stream
+	streamer.expectNoMessage()
+}
+
+func TestRoomsAreIsolated(t *testing.T) {
+	h := newHarness(t)
+
+	streamer := h.connect("first")
+	other := h.connect("second")
+
+	if err := streamer.StartStream(); err != nil {
+		t.Fatal(err)
+	}
+	h.waitFor("stream", func() bool { return len(h.app.StreamManager().GetStreamingClients("first")) == 1 })
+
+	if err := streamer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	other.expectNoMessage()
+}
+
+func TestLeave(t *testing.T) {
+	h := newHarness(t)
+
+	testClients := h.connectN("room", 3)
+	leaving := testClients[0]
+	leavingID := leaving.ID()
+
+	if err := leaving.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, remaining := range testClients[1:] {
+		disconnect := expectMessage[rooms.ClientDisconnectMessage](remaining, rooms.CLIENT_DISCONNECT_MESSAGE_TYPE)
+		if disconnect.ClientID != leavingID.String() {
+			t.Errorf("expected disconnect of %s, got %s", leavingID, disconnect.ClientID)
+		}
+	}
+}
+
+func TestLeaveWhileStreaming(t *testing.T) {
+	h := newHarness(t)
+
+	streamer, viewer := h.connect("room"), h.connect("room")
+
+	if err := streamer.StartStream(); err != nil {
+		t.Fatal(err)
+	}
+	viewer.expectSequence(streams.STREAM_STARTED_MESSAGE_TYPE)
+
+	if err := streamer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The stream ends with the client, client-disconnect tells the viewer about both
+	viewer.expectSequence(rooms.CLIENT_DISCONNECT_MESSAGE_TYPE)
+	viewer.expectNoMessage()
+	h.waitFor("no active stream", func() bool { return len(h.app.StreamManager().GetStreamingClients("room")) == 0 })
+}
+
+func TestSignalingRelay(t *testing.T) {
+	h := newHarness(t)
+
+	caller, callee := h.connect("room"), h.connect("room")
+	payload := json.RawMessage(`{"type":"offer","sdp":"v=0"}`)
+
+	tests := []struct {
+		Name     string
+		send     func() error
+		receive  connection.MessageType
+		senderID func(msg json.RawMessage) string
+	}{
+		{
+			Name:    "SDP offer",
+			send:    func() error { return caller.SendSDPOffer(callee.ID(), payload) },
+			receive: signaling.SDP_OFFER_MESSAGE_TYPE,
+			senderID: func(msg json.RawMessage) string {
+				var offer signaling.ServerSDPOfferMessage
+				_ = json.Unmarshal(msg, &offer)
+				return offer.CallerClientID
+			},
+		},
+		{
+			Name: "ICE candidate",
+			send: func() error {
+				return caller.SendICECandidate(callee.ID(), json.RawMessage(`{"candidate":"candidate:1"}`))
+			},
+			receive: signaling.ICE_CANDIDATE_MESSAGE_TYPE,
+			senderID: func(msg json.RawMessage) string {
+				var candidate signaling.ICEMessage
+				_ = json.Unmarshal(msg, &candidate)
+				return candidate.RemoteClientID
+			},
+		},
+		{
+			Name: "SDP message",
+			send: func() error {
+				return caller.SendSDPMessage(callee.ID(), signaling.SessionDescription{Type: "offer", SDP: "v=0"})
+			},
+			receive: signaling.SDP_MESSAGE_TYPE,
+			senderID: func(msg json.RawMessage) string {
+				var sdp signaling.SDPMessage
+				_ = json.Unmarshal(msg, &sdp)
+				return sdp.RemoteClientID
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			if err := test.send(); err != nil {
+				t.Fatal(err)
+			}
+
+			message := callee.expectSequence(test.receive)[0]
+			if senderID := test.senderID(message.Msg); senderID != caller.ID().String() {
+				t.Errorf("expected the caller's ID %s, got %s", caller.ID(), senderID)
+			}
+			caller.expectNoMessage()
+		})
+	}
+
+	t.Run("SDP answer", func(t *testing.T) {
+		if err := callee.SendSDPAnswer(caller.ID(), payload); err != nil {
+			t.Fatal(err)
+		}
+
+		// The answer is forwarded unchanged, it names the caller
+		answer := expectMessage[signaling.SDPAnswerMessage](caller, signaling.SDP_ANSWER_MESSAGE_TYPE)
+		if answer.CallerClientID != caller.ID().String() || string(answer.Answer) != string(payload) {
+			t.Errorf("expected the answer for %s, got %v", caller.ID(), answer)
+		}
+	})
+}
+
+func TestErrors(t *testing.T) {
+	h := newHarness(t)
+
+	sender := h.connect("room")
+	unknownID := uuid.NewString()
+
+	tests := []struct {
+		Name string
+		// send sends the offending message
+		send func()
+		// check checks the error message
+		check func(t *testing.T, msg connection.ErrorMessage)
+	}{
+		{
+			Name: "Invalid JSON",
+			send: func() { _ = sender.SendRaw([]byte(`{"type": "sdp-offer"`)) },
+			check: func(t *testing.T, msg connection.ErrorMessage) {
+				if !strings.HasPrefix(msg.ErrorMessage, "Message had invalid JSON format.") {
+					t.Errorf("expected an invalid JSON error, got %q", msg.ErrorMessage)
+				}
+			},
+		},
+		{
+			Name: "Invalid payload",
+			send: func() {
+				send(sender, signaling.SDP_MESSAGE_TYPE, map[string]any{
+					"remoteClientID": "nope",
+					"description":    map[string]string{"type": "bogus", "sdp": ""},
+				})
+			},
+			check: func(t *testing.T, msg connection.ErrorMessage) {
+				paths := make([]string, len(msg.Fields))
+				for i, field := range msg.Fields {
+					paths[i] = field.Path
+				}
+				slices.Sort(paths)
+				if !slices.Equal(paths, []string{"msg.description.type", "msg.remoteClientID"}) {
+					t.Errorf("expected errors of remoteClientID and description.type, got %+v", msg.Fields)
+				}
+			},
+		},
+		{
+			Name: "Missing field",
+			send: func() { send(sender, signaling.SDP_OFFER_MESSAGE_TYPE, map[string]string{"calleeClientID": unknownID}) },
+			check: func(t *testing.T, msg connection.ErrorMessage) {
+				if len(msg.Fields) != 1 || msg.Fields[0].Path != "msg.offer" {
+					t.Errorf("expected a missing offer, got %+v", msg.Fields)
+				}
+			},
+		},
+		{
+			Name: "Unknown callee",
+			send: func() {
+				send(sender, signaling.SDP_OFFER_MESSAGE_TYPE, signaling.ClientSDPOfferMessage{CalleeClientID: unknownID, Offer: json.RawMessage(`{}`)})
+			},
+			check: func(t *testing.T, msg connection.ErrorMessage) {
+				if msg.ErrorMessage != "Callee client not found." {
+					t.Errorf("expected an unknown callee, got %q", msg.ErrorMessage)
+				}
+			},
+		},
+		{
+			Name: "Unknown remote client",
+			send: func() {
+				_ = sender.SendICECandidate(uuid.MustParse(unknownID), json.RawMessage(`{}`))
+			},
+			check: func(t *testing.T, msg connection.ErrorMessage) {
+				if msg.ErrorMessage != "Remote client not found." {
+					t.Errorf("expected an unknown remote client, got %q", msg.ErrorMessage)
+				}
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			test.send()
+			test.check(t, expectMessage[connection.ErrorMessage](sender, connection.ERROR_MESSAGE_TYPE))
+		})
+	}
+
+	t.Run("Second stream", func(t *testing.T) {
+		_ = sender.StartStream()
+		h.waitFor("stream", func() bool { return len(h.app.StreamManager().GetStreamingClients("room")) == 1 })
+		_ = sender.StartStream()
+
+		msg := expectMessage[connection.ErrorMessage](sender, connection.ERROR_MESSAGE_TYPE)
+		if !strings.HasPrefix(msg.ErrorMessage, "You already have an active stream.") {
+			t.Errorf("expected an active stream error, got %q", msg.ErrorMessage)
+		}
+	})
+
+	t.Run("Unknown message type", func(t *testing.T) {
+		send(sender, "bogus", struct{}{})
+		sender.expectNoMessage()
+	})
+}
+
+func TestRoomCleanup(t *testing.T) {
+	h := newHarness(t)
+
+	testClients := h.connectN("room", 3)
+	_ = testClients[0].StartStream()
+	h.waitFor("stream", func() bool { return h.app.Metrics().Gauge(metrics.ActiveStreamsName) == 1 })
+
+	for _, c := range testClients {
+		if err := c.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	h.waitFor("room deletion", func() bool { return h.app.RoomManager().GetRoom("room") == nil })
+	for _, name := range []string{metrics.RoomsName, metrics.ClientsName, metrics.ActiveStreamsName, metrics.ConnectionsName} {
+		h.waitFor(name+" to be 0", func() bool { return h.app.Metrics().Gauge(name) == 0 })
+	}
+
+	// The room ID can be used again and starts empty
+	rejoined := h.dial("room")
+	rejoined.expectSequence(clients.CLIENT_ID_MESSAGE_TYPE)
+	if available := expectMessage[streams.AvailableStreamsMessage](rejoined, streams.AVAILABLE_STREAMS_MESSAGE_TYPE); len(available.ClientIDs) != 0 {
+		t.Errorf("expected no streams in the new room, got %v", available.ClientIDs)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	h := newHarness(t)
+
+	testClients := h.connectN("room", 2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), messageTimeout)
+	defer cancel()
+	if err := h.app.ConnectionManager().Shutdown(ctx, 3*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range testClients {
+		shutdown := expectMessage[connection.ServerShutdownMessage](c, connection.SERVER_SHUTDOWN_MESSAGE_TYPE)
+		if shutdown.ReconnectAfterMs != 3000 {
+			t.Errorf("expected a reconnect hint of 3000ms, got %d", shutdown.ReconnectAfterMs)
+		}
+		<-c.Done()
+	}
+
+	response, err := http.Get(h.server.URL + "/readyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(response.Body)
+	_ = response.Body.Close()
+	if response.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected a draining server to be unready, got %d: %s", response.StatusCode, body)
+	}
+}
+
+func TestHTTPEndpoints(t *testing.T) {
+	h := newHarness(t)
+
+	tests := []struct {
+		Name   string
+		path   string
+		status int
+		// contains is a part of the expected body
+		contains string
+	}{
+		{Name: "Liveness", path: "/healthz", status: http.StatusOK, contains: `"status":"ok"`},
+		{Name: "Generate ID", path: "/room/generate-id", status: http.StatusOK},
+		{Name: "Metrics", path: "/metrics", status: http.StatusOK, contains: metrics.RoomsName},
+		{Name: "Protocol", path: "/protocol/asyncapi.json", status: http.StatusOK, contains: `"asyncapi": "2.6.0"`},
+		{Name: "Admin API disabled", path: "/admin/rooms", status: http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			response, err := http.Get(h.server.URL + test.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer response.Body.Close()
+
+			body, _ := io.ReadAll(response.Body)
+			if response.StatusCode != test.status || !strings.Contains(string(body), test.contains) {
+				t.Errorf("expected status %d with %q, got %d: %s", test.status, test.contains, response.StatusCode, body)
+			}
+		})
+	}
+}
+
+func TestObserver(t *testing.T) {
+	const token = "admin-token"
+	h := newHarness(t, func(cfg *config.Config) { cfg.AdminToken = token })
+
+	streamer, viewer := h.connect("room"), h.connect("room")
+	if err := streamer.StartStream(); err != nil {
+		t.Fatal(err)
+	}
+	viewer.expectSequence(streams.STREAM_STARTED_MESSAGE_TYPE)
+
+	if _, err := h.observe("room", "wrong"); err == nil {
+		t.Fatal("expected observing with a wrong token to fail")
+	}
+
+	observer, err := h.observe("room", token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	observer.expectSequence(clients.CLIENT_ID_MESSAGE_TYPE)
+	available := expectMessage[streams.AvailableStreamsMessage](observer, streams.AVAILABLE_STREAMS_MESSAGE_TYPE)
+	if len(available.ClientIDs) != 1 || available.ClientIDs[0] != streamer.ID().String() {
+		t.Errorf("expected the stream of %s, got %v", streamer.ID(), available.ClientIDs)
+	}
+
+	// The observer isn't part of the roster
+	if ids := h.app.RoomManager().GetRoom("room").ClientIDs(); len(ids) != 2 || slices.Contains(ids, clients.ClientID(observer.ID())) {
+		t.Errorf("expected only the two participants in the room, got %v", ids)
+	}
+
+	t.Run("Receives broadcasts", func(t *testing.T) {
+		if err := viewer.StartStream(); err != nil {
+			t.Fatal(err)
+		}
+		started := expectMessage[streams.StreamStartedMessage](observer, streams.STREAM_STARTED_MESSAGE_TYPE)
+		if started.ClientID != viewer.ID().String() {
+			t.Errorf("expected the stream of %s, got %s", viewer.ID(), started.ClientID)
+		}
+		streamer.expectSequence(streams.STREAM_STARTED_MESSAGE_TYPE)
+	})
+
+	t.Run("Hidden from signaling", func(t *testing.T) {
+		if err := streamer.SendSDPOffer(observer.ID(), json.RawMessage(`{}`)); err != nil {
+			t.Fatal(err)
+		}
+		if msg := expectMessage[connection.ErrorMessage](streamer, connection.ERROR_MESSAGE_TYPE); msg.ErrorMessage != "Callee client not found." {
+			t.Errorf("expected the observer to be unknown, got %q", msg.ErrorMessage)
+		}
+		observer.expectNoMessage()
+	})
+
+	t.Run("Can't send", func(t *testing.T) {
+		if err := observer.StartStream(); err != nil {
+			t.Fatal(err)
+		}
+		if msg := expectMessage[connection.ErrorMessage](observer, connection.ERROR_MESSAGE_TYPE); msg.ErrorMessage != "Observers can't send messages." {
+			t.Errorf("expected the message to be rejected, got %q", msg.ErrorMessage)
+		}
+		streamer.expectNoMessage()
+	})
+
+	t.Run("Leaves silently", func(t *testing.T) {
+		if err := observer.Close(); err != nil {
+			t.Fatal(err)
+		}
+		h.waitFor("observer to leave", func() bool { return h.app.RoomManager().GetUsersRoom(clients.ClientID(observer.ID())) == nil })
+		streamer.expectNoMessage()
+		viewer.expectNoMessage()
+	})
+}
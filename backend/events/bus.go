@@ -0,0 +1,124 @@
+// Package events provides a typed in-process event bus.
+//
+// Events are plain structs defined by the package that publishes them, e.g. rooms.ClientJoined or streams.StreamStarted.
+// Every event type has its own [observer.Observable], so subscribers only receive the type they subscribed to.
+//
+// Ordering guarantees:
+//   - Synchronous subscribers are called before Publish returns, one after another in the order they subscribed.
+//     So they receive the events of one goroutine in the order they were published, across all event types.
+//     Managers publish all events of a room from the room's goroutine, so e.g. a synchronous subscriber always
+//     receives a client's ClientJoined before its ClientLeft.
+//   - Asynchronous subscribers receive the events of one type in the order they were published, on their own goroutine.
+//     There is no order between different event types or different asynchronous subscribers.
+//
+// Events that reference state owned by the publishing goroutine implement [Synchronous], they can't be subscribed asynchronously.
+package events
+
+import (
+	"reflect"
+	"sync"
+
+	"bjoernblessin.de/screenecho/util/assert"
+	"bjoernblessin.de/screenecho/util/observer"
+)
+
+// DefaultAsyncBufferSize is the number of events an asynchronous subscriber can fall behind before events are dropped.
+const DefaultAsyncBufferSize = 256
+
+// Bus delivers published events to the subscribers of their type.
+type Bus struct {
+	// topics holds an *observer.Observable[T] per event type T.
+	topics          map[reflect.Type]any
+	topicsMutex     sync.Mutex
+	asyncBufferSize int
+}
+
+// NewBus creates an event bus. Asynchronous subscribers can fall behind by asyncBufferSize events,
+// further events are dropped for them until they caught up.
+func NewBus(asyncBufferSize int) *Bus {
+	return &Bus{
+		topics:          make(map[reflect.Type]any),
+		asyncBufferSize: asyncBufferSize,
+	}
+}
+
+// Synchronous is implemented by events that are only valid while they are published, e.g. rooms.ClientJoined holding the
+// room's state. SubscribeAsync panics for them, since an asynchronous handler would read the state while it's modified.
+type Synchronous interface {
+	Synchronous()
+}
+
+// Subscription is returned by Subscribe and SubscribeAsync.
+type Subscription struct {
+	unsubscribe func()
+	once        sync.Once
+	// dropped is nil for synchronous subscriptions, they never drop events.
+	dropped func() uint64
+}
+
+// Unsubscribe removes the subscription. It's safe to call it multiple times and from within the handler.
+//
+// A synchronous handler whose call already started finishes. An asynchronous handler still receives the events
+// that were published before Unsubscribe, but no events published afterwards.
+func (subscription *Subscription) Unsubscribe() {
+	subscription.once.Do(subscription.unsubscribe)
+}
+
+// Dropped returns the number of events an asynchronous subscriber missed because it fell behind.
+// It's always 0 for synchronous subscribers and after Unsubscribe.
+func (subscription *Subscription) Dropped() uint64 {
+	if subscription.dropped == nil {
+		return 0
+	}
+
+	return subscription.dropped()
+}
+
+// Subscribe calls handler synchronously for every published event of type T, in the publishing goroutine.
+// Publish doesn't return before handler returned, so handler should be fast and must not block on the publisher.
+func Subscribe[T any](bus *Bus, handler func(T)) *Subscription {
+	return &Subscription{unsubscribe: topic[T](bus).SubscribeFunc(handler)}
+}
+
+// SubscribeAsync calls handler for every published event of type T on a goroutine of its own, one event after another.
+// Publish never waits for handler. If handler falls behind by more than the bus's buffer size, events are dropped.
+// T must not implement [Synchronous].
+func SubscribeAsync[T any](bus *Bus, handler func(T)) *Subscription {
+	assert.Assert(!reflect.TypeFor[T]().Implements(reflect.TypeFor[Synchronous]()), "synchronous events can't be subscribed asynchronously")
+
+	observable := topic[T](bus)
+
+	events := observable.Subscribe()
+	go func() {
+		for event := range events {
+			handler(event)
+		}
+	}()
+
+	return &Subscription{
+		unsubscribe: func() { observable.Unsubscribe(events) },
+		dropped:     func() uint64 { return observable.Dropped(events) },
+	}
+}
+
+// Publish delivers event to all subscribers of its type.
+// Asynchronous subscribers are notified first, then the synchronous handlers are called.
+func Publish[T any](bus *Bus, event T) {
+	topic[T](bus).NotifyObservers(event)
+}
+
+// topic returns the observable of event type T, it's created on first use.
+func topic[T any](bus *Bus) *observer.Observable[T] {
+	eventType := reflect.TypeFor[T]()
+
+	bus.topicsMutex.Lock()
+	defer bus.topicsMutex.Unlock()
+
+	observable, exists := bus.topics[eventType]
+	if !exists {
+		observable = observer.NewObservable[T](bus.asyncBufferSize)
+		bus.topics[eventType] = observable
+	}
+
+	return observable.(*observer.Observable[T])
+}
@@ -0,0 +1,195 @@
+package events
+
+import (
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+type first struct{ N int }
+type second struct{ N int }
+type synchronous struct{}
+
+func (synchronous) Synchronous() {}
+
+func TestSubscribeSyncOrder(t *testing.T) {
+	bus := NewBus(DefaultAsyncBufferSize)
+
+	// Synchronous handlers run in the publishing goroutine, so no synchronization is needed
+	var received []string
+	Subscribe(bus, func(event first) { received = append(received, "first") })
+	Subscribe(bus, func(event second) { received = append(received, "second") })
+	Subscribe(bus, func(event first) { received = append(received, "first again") })
+
+	Publish(bus, first{})
+	Publish(bus, second{})
+	Publish(bus, first{})
+
+	expected := []string{"first", "first again", "second", "first", "first again"}
+	if !slices.Equal(received, expected) {
+		t.Errorf("expected %v, got %v", expected, received)
+	}
+}
+
+func TestSubscribeAsyncOrder(t *testing.T) {
+	bus := NewBus(DefaultAsyncBufferSize)
+
+	const count = 100
+	received := make(chan int, count)
+	subscription := SubscribeAsync(bus, func(event first) { received <- event.N })
+	defer subscription.Unsubscribe()
+
+	for i := range count {
+		Publish(bus, first{N: i})
+	}
+
+	for i := range count {
+		select {
+		case n := <-received:
+			if n != i {
+				t.Fatalf("expected event %d, got %d", i, n)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("event %d not received", i)
+		}
+	}
+}
+
+func TestSubscribeAsyncSynchronous(t *testing.T) {
+	bus := NewBus(DefaultAsyncBufferSize)
+
+	Subscribe(bus, func(synchronous) {}).Unsubscribe()
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected subscribing a synchronous event asynchronously to panic")
+		}
+	}()
+	SubscribeAsync(bus, func(synchronous) {})
+}
+
+func TestUnsubscribe(t *testing.T) {
+	tests := []struct {
+		Name      string
+		Subscribe func(bus *Bus, handler func(first)) *Subscription
+	}{
+		{Name: "sync", Subscribe: Subscribe[first]},
+		{Name: "async", Subscribe: SubscribeAsync[first]},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			bus := NewBus(DefaultAsyncBufferSize)
+
+			received := make(chan int, 10)
+			subscription := test.Subscribe(bus, func(event first) { received <- event.N })
+
+			Publish(bus, first{N: 1})
+			select {
+			case <-received:
+			case <-time.After(5 * time.Second):
+				t.Fatal("event not received")
+			}
+
+			subscription.Unsubscribe()
+			subscription.Unsubscribe()
+
+			Publish(bus, first{N: 2})
+			select {
+			case n := <-received:
+				t.Errorf("received event %d after unsubscribe", n)
+			case <-time.After(50 * time.Millisecond):
+			}
+		})
+	}
+}
+
+func TestUnsubscribeFromHandler(t *testing.T) {
+	bus := NewBus(DefaultAsyncBufferSize)
+
+	calls := 0
+	var subscription *Subscription
+	subscription = Subscribe(bus, func(first) {
+		calls++
+		subscription.Unsubscribe()
+	})
+
+	Publish(bus, first{})
+	Publish(bus, first{})
+
+	if calls != 1 {
+		t.Errorf("expected 1 call, got %d", calls)
+	}
+}
+
+func TestPublishFromHandler(t *testing.T) {
+	bus := NewBus(DefaultAsyncBufferSize)
+
+	var received []int
+	Subscribe(bus, func(event first) {
+		received = append(received, event.N)
+		Publish(bus, second{N: event.N})
+	})
+	Subscribe(bus, func(event second) { received = append(received, -event.N) })
+
+	Publish(bus, first{N: 1})
+
+	if expected := []int{1, -1}; !slices.Equal(received, expected) {
+		t.Errorf("expected %v, got %v", expected, received)
+	}
+}
+
+func TestConcurrentSubscribeAndPublish(t *testing.T) {
+	bus := NewBus(DefaultAsyncBufferSize)
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for range 100 {
+				Subscribe(bus, func(first) {}).Unsubscribe()
+				SubscribeAsync(bus, func(second) {}).Unsubscribe()
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for i := range 100 {
+				Publish(bus, first{N: i})
+				Publish(bus, second{N: i})
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestDropped(t *testing.T) {
+	bus := NewBus(2)
+
+	started, block := make(chan struct{}, 1), make(chan struct{})
+	slow := SubscribeAsync(bus, func(first) {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-block
+	})
+	defer slow.Unsubscribe()
+	synchronous := Subscribe(bus, func(first) {})
+
+	// The first event blocks the handler, two fill the buffer, the rest is dropped
+	Publish(bus, first{N: 0})
+	<-started
+	for i := range 5 {
+		Publish(bus, first{N: i + 1})
+	}
+	close(block)
+
+	if dropped := slow.Dropped(); dropped != 3 {
+		t.Errorf("expected 3 dropped events, got %d", dropped)
+	}
+	if dropped := synchronous.Dropped(); dropped != 0 {
+		t.Errorf("expected synchronous subscribers to never drop, got %d", dropped)
+	}
+}
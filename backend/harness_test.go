@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"bjoernblessin.de/screenecho/client"
+	"bjoernblessin.de/screenecho/clients"
+	"bjoernblessin.de/screenecho/config"
+	"bjoernblessin.de/screenecho/connection"
+	"bjoernblessin.de/screenecho/server"
+	"bjoernblessin.de/screenecho/streams"
+)
+
+// The end-to-end tests run the server as main does, see package server, on an httptest.Server and talk to it with
+// simulated clients of package client. Each client asserts on the sequence of messages it received.
+
+const (
+	// messageTimeout is the time a client waits for an expected message.
+	messageTimeout = 5 * time.Second
+	// quietPeriod is the time a client waits to be sure that no message arrives.
+	quietPeriod = 100 * time.Millisecond
+)
+
+type harness struct {
+	t      *testing.T
+	app    *server.Server
+	server *httptest.Server
+}
+
+// newHarness starts a server with the default configuration, changed by configure. The environment is ignored.
+// The server and all clients are closed when the test ends.
+func newHarness(t *testing.T, configure ...func(cfg *config.Config)) *harness {
+	t.Helper()
+
+	cfg := config.Defaults()
+	for _, apply := range configure {
+		apply(cfg)
+	}
+
+	app := server.New(server.WithConfig(cfg))
+	testServer := httptest.NewServer(app)
+	t.Cleanup(testServer.Close)
+
+	return &harness{t: t, app: app, server: testServer}
+}
+
+type testClient struct {
+	*client.Client
+	t *testing.T
+}
+
+// dial connects a client to the room. The messages of the join are left in its inbox.
+func (h *harness) dial(roomID string) *testClient {
+	h.t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), messageTimeout)
+	defer cancel()
+
+	c, err := client.Dial(ctx, h.server.URL, roomID, client.Options{})
+	if err != nil {
+		h.t.Fatalf("failed to connect to room %s: %v", roomID, err)
+	}
+	h.t.Cleanup(func() { _ = c.Close() })
+
+	return &testClient{Client: c, t: h.t}
+}
+
+// observe connects a hidden observer to the room with the admin token. The messages of the join are left in its inbox.
+func (h *harness) observe(roomID string, token string) (*testClient, error) {
+	h.t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), messageTimeout)
+	defer cancel()
+
+	c, err := client.Dial(ctx, h.server.URL, roomID, client.Options{
+		Observer: true,
+		Header:   http.Header{"Authorization": []string{"Bearer " + token}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	h.t.Cleanup(func() { _ = c.Close() })
+
+	return &testClient{Client: c, t: h.t}, nil
+}
+
+// connect connects a client to the room and takes the messages of the join.
+func (h *harness) connect(roomID string) *testClient {
+	h.t.Helper()
+
+	c := h.dial(roomID)
+	c.expectSequence(clients.CLIENT_ID_MESSAGE_TYPE, streams.AVAILABLE_STREAMS_MESSAGE_TYPE)
+
+	return c
+}
+
+// connectN connects n clients to the room one after another.
+func (h *harness) connectN(roomID string, n int) []*testClient {
+	h.t.Helper()
+
+	testClients := make([]*testClient, n)
+	for i := range testClients {
+		testClients[i] = h.connect(roomID)
+	}
+
+	return testClients
+}
+
+// waitFor polls condition until it's true and fails the test after messageTimeout.
+func (h *harness) waitFor(description string, condition func() bool) {
+	h.t.Helper()
+
+	deadline := time.Now().Add(messageTimeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			h.t.Fatalf("timed out waiting for %s", description)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// next takes the next message of the client and fails the test if none arrives in time.
+func (c *testClient) next() connection.TypedMessage[json.RawMessage] {
+	c.t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), messageTimeout)
+	defer cancel()
+
+	message, err := c.Next(ctx)
+	if err != nil {
+		c.t.Fatalf("client %s didn't receive a message: %v", c.ID(), err)
+	}
+
+	return message
+}
+
+// expectSequence takes the next messages of the client and fails the test unless they have the given types in order.
+func (c *testClient) expectSequence(types ...connection.MessageType) []connection.TypedMessage[json.RawMessage] {
+	c.t.Helper()
+
+	messages := make([]connection.TypedMessage[json.RawMessage], len(types))
+	received := make([]connection.MessageType, 0, len(types))
+	for i := range types {
+		messages[i] = c.next()
+		received = append(received, messages[i].Type)
+
+		if !slices.Equal(received, types[:i+1]) {
+			c.t.Fatalf("client %s expected messages %v, got %v (last: %s)", c.ID(), types, received, messages[i].Msg)
+		}
+	}
+
+	return messages
+}
+
+// expectNoMessage fails the test if the client receives a message within quietPeriod.
+func (c *testClient) expectNoMessage() {
+	c.t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), quietPeriod)
+	defer cancel()
+
+	if message, err := c.Next(ctx); err == nil {
+		c.t.Fatalf("client %s expected no message, got %s: %s", c.ID(), message.Type, message.Msg)
+	}
+}
+
+// expectMessage takes the next message of c, fails the test unless it's of messageType and returns its decoded payload.
+func expectMessage[T any](c *testClient, messageType connection.MessageType) T {
+	c.t.Helper()
+
+	message := c.expectSequence(messageType)[0]
+
+	var msg T
+	if err := json.Unmarshal(message.Msg, &msg); err != nil {
+		c.t.Fatalf("client %s received an invalid %s message: %v", c.ID(), messageType, err)
+	}
+
+	return msg
+}
+
+// send sends a message and fails the test if that's not possible.
+func send[T any](c *testClient, messageType connection.MessageType, msg T) {
+	c.t.Helper()
+
+	if err := client.Send(c.Client, messageType, msg); err != nil {
+		c.t.Fatalf("client %s failed to send %s: %v", c.ID(), messageType, err)
+	}
+}
@@ -0,0 +1,153 @@
+// Package health provides the liveness (/healthz) and readiness (/readyz) endpoints used by orchestrators.
+//
+// Liveness fails if the server is wedged and should be restarted.
+// Readiness fails if the server should temporarily not receive new clients, e.g. while draining or when it's full.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// checkTimeout is the time every single check has before it's considered failed.
+const checkTimeout = 2 * time.Second
+
+// Server is the part of the server whose state is checked.
+// It's implemented by [connection.ConnectionManager].
+type Server interface {
+	// IsDraining reports whether the server is shutting down.
+	IsDraining() bool
+	// ConnectionCount returns the number of open connections.
+	ConnectionCount() int
+}
+
+// Check checks a single dependency and returns an error if it's not reachable.
+// Check should return early when ctx expires.
+type Check func(ctx context.Context) error
+
+type dependency struct {
+	name  string
+	check Check
+}
+
+// Checker serves the liveness and readiness endpoints.
+type Checker struct {
+	server Server
+	// rooms returns an error if the goroutines of the rooms don't make progress.
+	rooms Check
+	// maxConnections is the connection ceiling above which the server is not ready. 0 means no ceiling.
+	maxConnections    int
+	dependencies      []dependency
+	dependenciesMutex sync.RWMutex
+}
+
+// Response is the JSON body of both endpoints.
+// Checks maps each check name to "ok" or to the reason it failed.
+type Response struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+const (
+	statusOK          = "ok"
+	statusUnavailable = "unavailable"
+)
+
+// NewChecker creates a Checker for server. The server is not alive while rooms fails.
+// If maxConnections is greater than 0, the server is not ready while it has maxConnections or more open connections.
+func NewChecker(server Server, rooms Check, maxConnections int) *Checker {
+	return &Checker{
+		server:         server,
+		rooms:          rooms,
+		maxConnections: maxConnections,
+	}
+}
+
+// AddDependency registers a dependency (e.g. a pub/sub backend or a storage) that must be reachable for the server to be ready.
+// name is used as key in the readiness response.
+func (c *Checker) AddDependency(name string, check Check) {
+	c.dependenciesMutex.Lock()
+	defer c.dependenciesMutex.Unlock()
+
+	c.dependencies = append(c.dependencies, dependency{name: name, check: check})
+}
+
+// HandleLiveness answers with 200 OK as long as the rooms make progress, otherwise with 503 Service Unavailable.
+func (c *Checker) HandleLiveness(writer http.ResponseWriter, request *http.Request) {
+	response := Response{Status: statusOK, Checks: make(map[string]string)}
+
+	response.record("rooms", runCheck(request.Context(), c.rooms))
+
+	writeResponse(writer, response)
+}
+
+// HandleReadiness answers with 200 OK if the server is not draining, all dependencies are reachable
+// and the connection ceiling is not reached, otherwise with 503 Service Unavailable.
+func (c *Checker) HandleReadiness(writer http.ResponseWriter, request *http.Request) {
+	response := Response{Status: statusOK, Checks: make(map[string]string)}
+
+	if c.server.IsDraining() {
+		response.record("draining", fmt.Errorf("server is shutting down"))
+	} else {
+		response.record("draining", nil)
+	}
+
+	connectionCount := c.server.ConnectionCount()
+	if c.maxConnections > 0 && connectionCount >= c.maxConnections {
+		response.record("connections", fmt.Errorf("%d of %d connections in use", connectionCount, c.maxConnections))
+	} else {
+		response.record("connections", nil)
+	}
+
+	c.dependenciesMutex.RLock()
+	dependencies := c.dependencies
+	c.dependenciesMutex.RUnlock()
+
+	for _, dependency := range dependencies {
+		response.record(dependency.name, runCheck(request.Context(), dependency.check))
+	}
+
+	writeResponse(writer, response)
+}
+
+// runCheck runs check with a timeout of checkTimeout.
+func runCheck(ctx context.Context, check Check) error {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	return check(ctx)
+}
+
+// record stores the result of the check name. A non-nil err marks the whole response as unavailable.
+func (response *Response) record(name string, err error) {
+	if err == nil {
+		response.Checks[name] = statusOK
+		return
+	}
+
+	response.Status = statusUnavailable
+	response.Checks[name] = err.Error()
+}
+
+func writeResponse(writer http.ResponseWriter, response Response) {
+	body, err := json.Marshal(response)
+	if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Header().Set("Cache-Control", "no-store")
+
+	if response.Status == statusOK {
+		writer.WriteHeader(http.StatusOK)
+	} else {
+		writer.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	writer.Write(body)
+}
@@ -0,0 +1,84 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type fakeServer struct {
+	draining        bool
+	connectionCount int
+}
+
+func (s *fakeServer) IsDraining() bool     { return s.draining }
+func (s *fakeServer) ConnectionCount() int { return s.connectionCount }
+
+func serve(t *testing.T, handler http.HandlerFunc) (int, Response) {
+	t.Helper()
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest("GET", "/", nil))
+
+	var response Response
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatalf("invalid response body %q: %v", recorder.Body.String(), err)
+	}
+
+	return recorder.Code, response
+}
+
+func TestReadiness(t *testing.T) {
+	tests := []struct {
+		Name           string
+		server         fakeServer
+		maxConnections int
+		dependencyErr  error
+		expectedCode   int
+		failedCheck    string
+	}{
+		{Name: "Ready", server: fakeServer{connectionCount: 5}, maxConnections: 10, expectedCode: http.StatusOK},
+		{Name: "No ceiling", server: fakeServer{connectionCount: 5000}, expectedCode: http.StatusOK},
+		{Name: "Draining", server: fakeServer{draining: true}, expectedCode: http.StatusServiceUnavailable, failedCheck: "draining"},
+		{Name: "Ceiling reached", server: fakeServer{connectionCount: 10}, maxConnections: 10, expectedCode: http.StatusServiceUnavailable, failedCheck: "connections"},
+		{Name: "Dependency down", dependencyErr: errors.New("connection refused"), expectedCode: http.StatusServiceUnavailable, failedCheck: "pubsub"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			checker := NewChecker(&tt.server, func(context.Context) error { return nil }, tt.maxConnections)
+			checker.AddDependency("pubsub", func(context.Context) error { return tt.dependencyErr })
+
+			code, response := serve(t, checker.HandleReadiness)
+
+			if code != tt.expectedCode {
+				t.Errorf("expected status code %d, got %d", tt.expectedCode, code)
+			}
+			if tt.failedCheck != "" && response.Checks[tt.failedCheck] == statusOK {
+				t.Errorf("expected check %s to fail, got %+v", tt.failedCheck, response.Checks)
+			}
+		})
+	}
+}
+
+func TestLiveness(t *testing.T) {
+	var roomsErr error
+	checker := NewChecker(&fakeServer{}, func(context.Context) error { return roomsErr }, 0)
+
+	if code, _ := serve(t, checker.HandleLiveness); code != http.StatusOK {
+		t.Errorf("expected status code 200, got %d", code)
+	}
+
+	roomsErr = errors.New("room is stuck")
+
+	code, response := serve(t, checker.HandleLiveness)
+	if code != http.StatusServiceUnavailable {
+		t.Errorf("expected status code 503, got %d", code)
+	}
+	if response.Checks["rooms"] != "room is stuck" {
+		t.Errorf("unexpected rooms check result %q", response.Checks["rooms"])
+	}
+}
@@ -1,39 +1,47 @@
 package main
 
 import (
-	"log"
-	"net/http"
-
-	"bjoernblessin.de/screenecho/clients"
-	"bjoernblessin.de/screenecho/connection"
-	"bjoernblessin.de/screenecho/middleware"
-	"bjoernblessin.de/screenecho/rooms"
-	"bjoernblessin.de/screenecho/signaling"
-	"bjoernblessin.de/screenecho/streams"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"bjoernblessin.de/screenecho/config"
+	"bjoernblessin.de/screenecho/server"
+	"bjoernblessin.de/screenecho/util/env"
+	"bjoernblessin.de/screenecho/util/logger"
 )
 
-func main() {
-	log.Println("Running...")
-
-	connManager := connection.NewConnectionManager()
-
-	clientManager := clients.NewClientManager(connManager)
-
-	roomManager := rooms.NewRoomManager(clientManager)
+var log = logger.New("main")
 
-	streams.NewStreamManager(clientManager, roomManager)
+func main() {
+	defaultConfigFile, _ := env.ReadOptionalEnv("CONFIG_FILE")
+	configFile := flag.String("config", defaultConfigFile, "path to a JSON or TOML config file (default $CONFIG_FILE)")
+	printConfig := flag.Bool("print-config", false, "print the effective configuration with secrets redacted and exit")
+	flag.Parse()
+
+	cfg, err := config.Load(*configFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration:\n%v\n", err)
+		os.Exit(2)
+	}
 
-	signaling.NewSignalingManager(clientManager)
+	if *printConfig {
+		cfg.Print(os.Stdout)
+		return
+	}
 
-	mux := http.NewServeMux()
+	logger.Configure(cfg.LoggerOptions())
 
-	mux.HandleFunc("GET /room/{roomID}/connect", roomManager.HandleConnect)
-	mux.HandleFunc("GET /room/generate-id", roomManager.GenerateIDHandler)
+	signalCtx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
 
-	server := &http.Server{
-		Addr:    ":8080",
-		Handler: middleware.Logging(middleware.CORS(mux)),
+	err = server.New(server.WithConfig(cfg)).Run(signalCtx)
+	if err != nil {
+		logger.Fatal(log, "Server failed", "error", err)
 	}
 
-	log.Fatal(server.ListenAndServe())
+	_ = logger.CloseFile()
 }
@@ -0,0 +1,200 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// collector is a metric family that can write itself in the text exposition format.
+type collector interface {
+	write(w io.Writer)
+}
+
+type gauge struct {
+	name    string
+	help    string
+	current float64
+	mu      sync.Mutex
+}
+
+func newGauge(name string, help string) *gauge {
+	return &gauge{name: name, help: help}
+}
+
+func (g *gauge) add(delta float64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.current += delta
+}
+
+func (g *gauge) value() float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.current
+}
+
+func (g *gauge) write(w io.Writer) {
+	writeHeader(w, g.name, g.help, "gauge")
+	fmt.Fprintf(w, "%s %s\n", g.name, formatFloat(g.value()))
+}
+
+// counterVec is a counter with at most one label.
+// If labelName is empty, the counter has no label and all values are stored under the label value "".
+type counterVec struct {
+	name      string
+	help      string
+	labelName string
+	values    map[string]float64
+	mu        sync.Mutex
+}
+
+func newCounterVec(name string, help string, labelName string) *counterVec {
+	return &counterVec{name: name, help: help, labelName: labelName, values: make(map[string]float64)}
+}
+
+func (c *counterVec) inc(labelValue string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.values[labelValue]++
+}
+
+func (c *counterVec) value(labelValue string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.values[labelValue]
+}
+
+func (c *counterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	writeHeader(w, c.name, c.help, "counter")
+
+	if c.labelName == "" {
+		fmt.Fprintf(w, "%s %s\n", c.name, formatFloat(c.values[""]))
+		return
+	}
+
+	for _, labelValue := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s{%s} %s\n", c.name, formatLabel(c.labelName, labelValue), formatFloat(c.values[labelValue]))
+	}
+}
+
+// histogramVec is a histogram with at most one label, see counterVec.
+type histogramVec struct {
+	name      string
+	help      string
+	labelName string
+	buckets   []float64 // upper bounds in increasing order, without +Inf
+	values    map[string]*histogramData
+	mu        sync.Mutex
+}
+
+type histogramData struct {
+	bucketCounts []uint64 // non-cumulative, bucketCounts[len(buckets)] holds observations above the largest bucket
+	sum          float64
+	count        uint64
+}
+
+func newHistogramVec(name string, help string, labelName string, buckets []float64) *histogramVec {
+	return &histogramVec{name: name, help: help, labelName: labelName, buckets: buckets, values: make(map[string]*histogramData)}
+}
+
+func (h *histogramVec) observe(labelValue string, value float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	data, exists := h.values[labelValue]
+	if !exists {
+		data = &histogramData{bucketCounts: make([]uint64, len(h.buckets)+1)}
+		h.values[labelValue] = data
+	}
+
+	bucketIndex, _ := slices.BinarySearch(h.buckets, value)
+	data.bucketCounts[bucketIndex]++
+	data.sum += value
+	data.count++
+}
+
+func (h *histogramVec) count(labelValue string) uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	data, exists := h.values[labelValue]
+	if !exists {
+		return 0
+	}
+
+	return data.count
+}
+
+func (h *histogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	writeHeader(w, h.name, h.help, "histogram")
+
+	for _, labelValue := range sortedKeys(h.values) {
+		data := h.values[labelValue]
+
+		labels := ""
+		if h.labelName != "" {
+			labels = formatLabel(h.labelName, labelValue) + ","
+		}
+
+		var cumulative uint64
+		for i, upperBound := range h.buckets {
+			cumulative += data.bucketCounts[i]
+			fmt.Fprintf(w, "%s_bucket{%sle=\"%s\"} %d\n", h.name, labels, formatFloat(upperBound), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket{%sle=\"+Inf\"} %d\n", h.name, labels, data.count)
+
+		labels = strings.TrimSuffix(labels, ",")
+		if labels != "" {
+			labels = "{" + labels + "}"
+		}
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, labels, formatFloat(data.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, labels, data.count)
+	}
+}
+
+func writeHeader(w io.Writer, name string, help string, metricType string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, metricType)
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabel(name string, value string) string {
+	return fmt.Sprintf(`%s="%s"`, name, labelValueReplacer.Replace(value))
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	return keys
+}
@@ -0,0 +1,52 @@
+// Package metrics records what happens on the server (connections, rooms, streams, messages) and exposes it
+// in the Prometheus text exposition format.
+//
+// Managers only depend on the small Metrics interface. Use [NewRegistry] in production and [Nop] if metrics are not needed.
+package metrics
+
+import "time"
+
+// Metrics is implemented by everything that records server metrics.
+// All methods must be safe for concurrent use.
+type Metrics interface {
+	// ConnectionOpened is called after a WebSocket connection was established.
+	ConnectionOpened()
+	// ConnectionClosed is called after all close handlers of a connection finished.
+	ConnectionClosed(lifetime time.Duration)
+	// MessageReceived is called for every inbound message.
+	MessageReceived(messageType string)
+	// MessageSent is called for every outbound message that was written successfully.
+	MessageSent(messageType string)
+	// ErrorMessageSent is called for every outbound error message in addition to MessageSent.
+	ErrorMessageSent()
+	// SendLatency is called with the time a message waited for and spent writing to the connection.
+	SendLatency(latency time.Duration)
+	// HandlerDuration is called after a message handler returned.
+	HandlerDuration(messageType string, duration time.Duration)
+	RoomCreated()
+	RoomDeleted()
+	ClientJoined()
+	ClientLeft()
+	StreamStarted()
+	StreamStopped()
+	// PanicRecovered is called after a panic was recovered. source names where it happened, e.g. "message_handler" or "http".
+	PanicRecovered(source string)
+}
+
+// Nop is a Metrics implementation that discards everything.
+type Nop struct{}
+
+func (Nop) ConnectionOpened()                     {}
+func (Nop) ConnectionClosed(time.Duration)        {}
+func (Nop) MessageReceived(string)                {}
+func (Nop) MessageSent(string)                    {}
+func (Nop) ErrorMessageSent()                     {}
+func (Nop) SendLatency(time.Duration)             {}
+func (Nop) HandlerDuration(string, time.Duration) {}
+func (Nop) RoomCreated()                          {}
+func (Nop) RoomDeleted()                          {}
+func (Nop) ClientJoined()                         {}
+func (Nop) ClientLeft()                           {}
+func (Nop) StreamStarted()                        {}
+func (Nop) StreamStopped()                        {}
+func (Nop) PanicRecovered(string)                 {}
@@ -0,0 +1,154 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http"
+	"time"
+)
+
+// Metric names as exposed on the /metrics endpoint.
+const (
+	ConnectionsName        = "screenecho_connections"
+	RoomsName              = "screenecho_rooms"
+	ClientsName            = "screenecho_clients"
+	ActiveStreamsName      = "screenecho_active_streams"
+	MessagesReceivedName   = "screenecho_messages_received_total"
+	MessagesSentName       = "screenecho_messages_sent_total"
+	ErrorMessagesSentName  = "screenecho_error_messages_sent_total"
+	SendLatencyName        = "screenecho_send_latency_seconds"
+	HandlerDurationName    = "screenecho_handler_duration_seconds"
+	ConnectionLifetimeName = "screenecho_connection_lifetime_seconds"
+	PanicsRecoveredName    = "screenecho_panics_recovered_total"
+)
+
+var latencyBuckets = []float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1}
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+var lifetimeBuckets = []float64{1, 10, 60, 300, 900, 1800, 3600, 7200, 14400, 28800}
+
+// Registry is the Prometheus implementation of Metrics.
+// It implements [http.Handler] and serves all metrics in the text exposition format.
+type Registry struct {
+	connections        *gauge
+	rooms              *gauge
+	clients            *gauge
+	activeStreams      *gauge
+	messagesReceived   *counterVec
+	messagesSent       *counterVec
+	errorMessagesSent  *counterVec
+	sendLatency        *histogramVec
+	handlerDuration    *histogramVec
+	connectionLifetime *histogramVec
+	panicsRecovered    *counterVec
+	collectors         []collector
+}
+
+func NewRegistry() *Registry {
+	r := &Registry{
+		connections:        newGauge(ConnectionsName, "Number of open WebSocket connections."),
+		rooms:              newGauge(RoomsName, "Number of rooms."),
+		clients:            newGauge(ClientsName, "Number of clients joined to a room."),
+		activeStreams:      newGauge(ActiveStreamsName, "Number of active streams across all rooms."),
+		messagesReceived:   newCounterVec(MessagesReceivedName, "Number of received messages by message type.", "type"),
+		messagesSent:       newCounterVec(MessagesSentName, "Number of sent messages by message type.", "type"),
+		errorMessagesSent:  newCounterVec(ErrorMessagesSentName, "Number of sent error messages.", ""),
+		sendLatency:        newHistogramVec(SendLatencyName, "Time a message waited for and spent writing to the connection.", "", latencyBuckets),
+		handlerDuration:    newHistogramVec(HandlerDurationName, "Duration of message handlers by message type.", "type", durationBuckets),
+		connectionLifetime: newHistogramVec(ConnectionLifetimeName, "Lifetime of closed WebSocket connections.", "", lifetimeBuckets),
+		panicsRecovered:    newCounterVec(PanicsRecoveredName, "Number of recovered panics by source.", "source"),
+	}
+
+	r.collectors = []collector{
+		r.connections, r.rooms, r.clients, r.activeStreams,
+		r.messagesReceived, r.messagesSent, r.errorMessagesSent,
+		r.sendLatency, r.handlerDuration, r.connectionLifetime,
+		r.panicsRecovered,
+	}
+
+	return r
+}
+
+func (r *Registry) ConnectionOpened() {
+	r.connections.add(1)
+}
+
+func (r *Registry) ConnectionClosed(lifetime time.Duration) {
+	r.connections.add(-1)
+	r.connectionLifetime.observe("", lifetime.Seconds())
+}
+
+func (r *Registry) MessageReceived(messageType string) {
+	r.messagesReceived.inc(messageType)
+}
+
+func (r *Registry) MessageSent(messageType string) {
+	r.messagesSent.inc(messageType)
+}
+
+func (r *Registry) ErrorMessageSent() {
+	r.errorMessagesSent.inc("")
+}
+
+func (r *Registry) SendLatency(latency time.Duration) {
+	r.sendLatency.observe("", latency.Seconds())
+}
+
+func (r *Registry) HandlerDuration(messageType string, duration time.Duration) {
+	r.handlerDuration.observe(messageType, duration.Seconds())
+}
+
+func (r *Registry) RoomCreated()   { r.rooms.add(1) }
+func (r *Registry) RoomDeleted()   { r.rooms.add(-1) }
+func (r *Registry) ClientJoined()  { r.clients.add(1) }
+func (r *Registry) ClientLeft()    { r.clients.add(-1) }
+func (r *Registry) StreamStarted() { r.activeStreams.add(1) }
+func (r *Registry) StreamStopped() { r.activeStreams.add(-1) }
+
+func (r *Registry) PanicRecovered(source string) {
+	r.panicsRecovered.inc(source)
+}
+
+// Counter returns the current value of the counter name with the given label value.
+// labelValue is ignored for counters without label. Unknown counters have the value 0.
+func (r *Registry) Counter(name string, labelValue string) float64 {
+	for _, c := range r.collectors {
+		if counter, ok := c.(*counterVec); ok && counter.name == name {
+			return counter.value(labelValue)
+		}
+	}
+
+	return 0
+}
+
+// Gauge returns the current value of the gauge name. Unknown gauges have the value 0.
+func (r *Registry) Gauge(name string) float64 {
+	for _, c := range r.collectors {
+		if gauge, ok := c.(*gauge); ok && gauge.name == name {
+			return gauge.value()
+		}
+	}
+
+	return 0
+}
+
+// HistogramCount returns the number of observations of the histogram name with the given label value.
+func (r *Registry) HistogramCount(name string, labelValue string) uint64 {
+	for _, c := range r.collectors {
+		if histogram, ok := c.(*histogramVec); ok && histogram.name == name {
+			return histogram.count(labelValue)
+		}
+	}
+
+	return 0
+}
+
+// ServeHTTP writes all metrics in the Prometheus text exposition format (version 0.0.4).
+func (r *Registry) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	var buffer bytes.Buffer
+	for _, c := range r.collectors {
+		c.write(&buffer)
+	}
+
+	writer.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	writer.WriteHeader(http.StatusOK)
+	writer.Write(buffer.Bytes())
+}
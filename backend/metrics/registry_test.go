@@ -0,0 +1,103 @@
+package metrics
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRegistry_Counters(t *testing.T) {
+	registry := NewRegistry()
+
+	registry.MessageReceived("sdp-offer")
+	registry.MessageReceived("sdp-offer")
+	registry.MessageReceived("stream-started")
+	registry.MessageSent("error")
+	registry.ErrorMessageSent()
+
+	if value := registry.Counter(MessagesReceivedName, "sdp-offer"); value != 2 {
+		t.Errorf("expected 2 received sdp-offer messages, got %v", value)
+	}
+	if value := registry.Counter(MessagesReceivedName, "stream-started"); value != 1 {
+		t.Errorf("expected 1 received stream-started message, got %v", value)
+	}
+	if value := registry.Counter(MessagesReceivedName, "unknown"); value != 0 {
+		t.Errorf("expected 0 received unknown messages, got %v", value)
+	}
+	if value := registry.Counter(ErrorMessagesSentName, ""); value != 1 {
+		t.Errorf("expected 1 sent error message, got %v", value)
+	}
+}
+
+func TestRegistry_Gauges(t *testing.T) {
+	registry := NewRegistry()
+
+	registry.RoomCreated()
+	registry.RoomCreated()
+	registry.RoomDeleted()
+	registry.StreamStarted()
+	registry.ConnectionOpened()
+	registry.ConnectionOpened()
+	registry.ConnectionClosed(time.Minute)
+
+	if value := registry.Gauge(RoomsName); value != 1 {
+		t.Errorf("expected 1 room, got %v", value)
+	}
+	if value := registry.Gauge(ActiveStreamsName); value != 1 {
+		t.Errorf("expected 1 active stream, got %v", value)
+	}
+	if value := registry.Gauge(ConnectionsName); value != 1 {
+		t.Errorf("expected 1 connection, got %v", value)
+	}
+	if count := registry.HistogramCount(ConnectionLifetimeName, ""); count != 1 {
+		t.Errorf("expected 1 connection lifetime observation, got %v", count)
+	}
+}
+
+func TestRegistry_ServeHTTP(t *testing.T) {
+	registry := NewRegistry()
+
+	registry.MessageReceived(`quote"type`)
+	registry.HandlerDuration("sdp-offer", 30*time.Millisecond)
+	registry.HandlerDuration("sdp-offer", 2*time.Second)
+
+	recorder := httptest.NewRecorder()
+	registry.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+	if contentType := recorder.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/plain; version=0.0.4") {
+		t.Errorf("unexpected content type %q", contentType)
+	}
+
+	body, _ := io.ReadAll(recorder.Body)
+
+	expectedLines := []string{
+		"# TYPE screenecho_rooms gauge",
+		"screenecho_rooms 0",
+		"# TYPE screenecho_messages_received_total counter",
+		`screenecho_messages_received_total{type="quote\"type"} 1`,
+		"screenecho_error_messages_sent_total 0",
+		"# TYPE screenecho_handler_duration_seconds histogram",
+		`screenecho_handler_duration_seconds_bucket{type="sdp-offer",le="0.025"} 0`,
+		`screenecho_handler_duration_seconds_bucket{type="sdp-offer",le="0.05"} 1`,
+		`screenecho_handler_duration_seconds_bucket{type="sdp-offer",le="2.5"} 2`,
+		`screenecho_handler_duration_seconds_bucket{type="sdp-offer",le="+Inf"} 2`,
+		`screenecho_handler_duration_seconds_sum{type="sdp-offer"} 2.03`,
+		`screenecho_handler_duration_seconds_count{type="sdp-offer"} 2`,
+	}
+
+	lines := strings.Split(string(body), "\n")
+	for _, expected := range expectedLines {
+		found := false
+		for _, line := range lines {
+			if line == expected {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("expected line %q in output:\n%s", expected, body)
+		}
+	}
+}
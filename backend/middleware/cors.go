@@ -2,15 +2,50 @@ package middleware
 
 import (
 	"net/http"
+
+	"bjoernblessin.de/screenecho/util/origin"
 )
 
-// CORS adds CORS headers to all responses.
-func CORS(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		// w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		// w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+// CORS adds CORS headers to responses of requests from allowed origins.
+// Preflight requests are answered directly and never reach next.
+// Requests from origins not in allowlist are passed on without CORS headers, so the browser blocks the response.
+func CORS(allowlist *origin.Allowlist) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requestOrigin := r.Header.Get("Origin")
+			if requestOrigin == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Add("Vary", "Origin")
+
+			isPreflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+			allowed := allowlist.IsAllowed(requestOrigin)
+
+			if isPreflight {
+				w.Header().Add("Vary", "Access-Control-Request-Method")
+				w.Header().Add("Vary", "Access-Control-Request-Headers")
+
+				if !allowed {
+					w.WriteHeader(http.StatusForbidden)
+					return
+				}
+
+				w.Header().Set("Access-Control-Allow-Origin", requestOrigin)
+				w.Header().Set("Access-Control-Allow-Methods", "GET, DELETE, OPTIONS")
+				w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, "+RequestIDHeader)
+				w.Header().Set("Access-Control-Max-Age", "600")
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			if allowed {
+				w.Header().Set("Access-Control-Allow-Origin", requestOrigin)
+				w.Header().Set("Access-Control-Expose-Headers", RequestIDHeader)
+			}
 
-		next.ServeHTTP(w, r)
-	})
+			next.ServeHTTP(w, r)
+		})
+	}
 }
@@ -1,17 +1,113 @@
 package middleware
 
 import (
-	"log"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"sync"
 	"time"
+
+	"bjoernblessin.de/screenecho/util/logger"
 )
 
-func Logging(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		start := time.Now()
+var log = logger.New("http")
+
+// AccessLogFormat selects how Logging writes access log entries.
+type AccessLogFormat string
+
+const (
+	// AccessLogDefault logs every request as an Info record of the http logger, formatted like all other log records.
+	AccessLogDefault AccessLogFormat = "log"
+	// AccessLogCommon writes one line per request in the Common Log Format known from Apache and nginx.
+	AccessLogCommon AccessLogFormat = "common"
+	// AccessLogJSON writes one JSON object per request and line.
+	AccessLogJSON AccessLogFormat = "json"
+)
+
+// commonLogTimeFormat is the timestamp format of the Common Log Format.
+const commonLogTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+// accessLogEntry holds everything logged about a request.
+type accessLogEntry struct {
+	Time       time.Time `json:"time"`
+	RequestID  string    `json:"request_id,omitempty"`
+	RemoteAddr string    `json:"remote_addr"`
+	Method     string    `json:"method"`
+	URI        string    `json:"uri"`
+	Proto      string    `json:"proto"`
+	Status     int       `json:"status"`
+	Size       int64     `json:"size"`
+	DurationMs float64   `json:"duration_ms"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+}
+
+// Logging writes an access log entry for every request after it was handled, including the response status and body size.
+// WebSocket upgrades are logged as soon as the upgrade finished with status 101.
+//
+// AccessLogCommon and AccessLogJSON entries are written to out, AccessLogDefault ignores out.
+// Request IDs are taken from the request context, so [RequestID] must run before Logging.
+func Logging(format AccessLogFormat, out io.Writer) func(http.Handler) http.Handler {
+	// Entries of concurrent requests must not interleave
+	var outMutex sync.Mutex
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			recorder := newResponseRecorder(w)
+
+			next.ServeHTTP(recorder, r)
+
+			entry := accessLogEntry{
+				Time:       start,
+				RequestID:  logger.RequestID(r.Context()),
+				RemoteAddr: r.RemoteAddr,
+				Method:     r.Method,
+				URI:        r.RequestURI,
+				Proto:      r.Proto,
+				Status:     recorder.Status(),
+				Size:       recorder.size,
+				DurationMs: float64(time.Since(start).Microseconds()) / 1000,
+				UserAgent:  r.UserAgent(),
+			}
+
+			switch format {
+			case AccessLogCommon:
+				outMutex.Lock()
+				_, _ = io.WriteString(out, entry.common())
+				outMutex.Unlock()
+			case AccessLogJSON:
+				line, _ := json.Marshal(entry)
+				outMutex.Lock()
+				_, _ = out.Write(append(line, '\n'))
+				outMutex.Unlock()
+			default:
+				log.InfoContext(r.Context(), "Request handled",
+					"remote_addr", entry.RemoteAddr,
+					"method", entry.Method,
+					"uri", entry.URI,
+					"status", entry.Status,
+					"size", entry.Size,
+					"duration", time.Since(start),
+				)
+			}
+		})
+	}
+}
+
+// common formats entry as a line in the Common Log Format: host ident authuser [date] "request" status bytes
+func (entry accessLogEntry) common() string {
+	host, _, err := net.SplitHostPort(entry.RemoteAddr)
+	if err != nil {
+		host = entry.RemoteAddr
+	}
 
-		next.ServeHTTP(w, r)
+	size := "-"
+	if entry.Size > 0 {
+		size = fmt.Sprint(entry.Size)
+	}
 
-		log.Printf("%s %s %s %s", r.RemoteAddr, r.Method, r.RequestURI, time.Since(start))
-	})
+	return fmt.Sprintf("%s - - [%s] \"%s %s %s\" %d %s\n",
+		host, entry.Time.Format(commonLogTimeFormat), entry.Method, entry.URI, entry.Proto, entry.Status, size)
 }
@@ -0,0 +1,202 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"bjoernblessin.de/screenecho/metrics"
+	"bjoernblessin.de/screenecho/util/logger"
+	"github.com/gorilla/websocket"
+)
+
+func TestLogging_CommonFormat(t *testing.T) {
+	var out bytes.Buffer
+
+	handler := Logging(AccessLogCommon, &out)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte("hello"))
+	}))
+
+	request := httptest.NewRequest(http.MethodGet, "/room/generate-id?x=1", nil)
+	request.RemoteAddr = "192.0.2.1:51234"
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+
+	pattern := regexp.MustCompile(`^192\.0\.2\.1 - - \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}\] "GET /room/generate-id\?x=1 HTTP/1\.1" 201 5\n$`)
+	if !pattern.MatchString(out.String()) {
+		t.Errorf("unexpected common log line %q", out.String())
+	}
+}
+
+func TestLogging_JSONFormat(t *testing.T) {
+	tests := []struct {
+		Name         string
+		Handler      http.HandlerFunc
+		ExpectedCode int
+		ExpectedSize int64
+	}{
+		{
+			Name:         "implicit status",
+			Handler:      func(w http.ResponseWriter, r *http.Request) { _, _ = w.Write([]byte("{}")) },
+			ExpectedCode: http.StatusOK,
+			ExpectedSize: 2,
+		},
+		{
+			Name:         "no body",
+			Handler:      func(w http.ResponseWriter, r *http.Request) {},
+			ExpectedCode: http.StatusOK,
+			ExpectedSize: 0,
+		},
+		{
+			Name:         "error",
+			Handler:      func(w http.ResponseWriter, r *http.Request) { http.Error(w, "not found", http.StatusNotFound) },
+			ExpectedCode: http.StatusNotFound,
+			ExpectedSize: int64(len("not found\n")),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			var out bytes.Buffer
+
+			handler := RequestID(Logging(AccessLogJSON, &out)(test.Handler))
+
+			request := httptest.NewRequest(http.MethodDelete, "/admin/rooms/abc", nil)
+			request.Header.Set(RequestIDHeader, "proxy-id-1")
+			handler.ServeHTTP(httptest.NewRecorder(), request)
+
+			var entry accessLogEntry
+			err := json.Unmarshal(out.Bytes(), &entry)
+			if err != nil {
+				t.Fatalf("invalid JSON log line %q: %v", out.String(), err)
+			}
+
+			if entry.Status != test.ExpectedCode || entry.Size != test.ExpectedSize {
+				t.Errorf("expected status %d and size %d, got %d and %d", test.ExpectedCode, test.ExpectedSize, entry.Status, entry.Size)
+			}
+			if entry.Method != http.MethodDelete || entry.URI != "/admin/rooms/abc" || entry.RequestID != "proxy-id-1" {
+				t.Errorf("unexpected entry %+v", entry)
+			}
+		})
+	}
+}
+
+// lineWriter passes every write to a channel, so a test can wait for log lines written by server goroutines.
+type lineWriter chan []byte
+
+func (w lineWriter) Write(p []byte) (int, error) {
+	w <- bytes.Clone(p)
+	return len(p), nil
+}
+
+func TestLogging_WebSocketUpgrade(t *testing.T) {
+	out := make(lineWriter, 1)
+	upgrader := websocket.Upgrader{}
+
+	server := httptest.NewServer(Logging(AccessLogJSON, out)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		socket, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade failed: %v", err)
+			return
+		}
+		_ = socket.Close()
+	})))
+	defer server.Close()
+
+	socket, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = socket.Close()
+
+	line := <-out
+
+	var entry accessLogEntry
+	err = json.Unmarshal(line, &entry)
+	if err != nil {
+		t.Fatalf("invalid JSON log line %q: %v", line, err)
+	}
+	if entry.Status != http.StatusSwitchingProtocols {
+		t.Errorf("expected status 101, got %d", entry.Status)
+	}
+}
+
+func TestLogging_PanicAfterUpgrade(t *testing.T) {
+	out := make(lineWriter, 1)
+	upgrader := websocket.Upgrader{}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		socket, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade failed: %v", err)
+			return
+		}
+		defer socket.Close()
+
+		if _, err := w.Write([]byte("too late")); err != http.ErrHijacked {
+			t.Errorf("expected http.ErrHijacked writing after the upgrade, got %v", err)
+		}
+		panic("after upgrade")
+	})
+
+	server := httptest.NewServer(Logging(AccessLogJSON, out)(Recover(metrics.Nop{})(handler)))
+	defer server.Close()
+
+	socket, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = socket.Close()
+
+	line := <-out
+
+	var entry accessLogEntry
+	err = json.Unmarshal(line, &entry)
+	if err != nil {
+		t.Fatalf("invalid JSON log line %q: %v", line, err)
+	}
+	if entry.Status != http.StatusSwitchingProtocols || entry.Size != 0 {
+		t.Errorf("expected status 101 without body, got %d with %d bytes", entry.Status, entry.Size)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	tests := []struct {
+		Name      string
+		Header    string
+		Propagate bool
+	}{
+		{Name: "missing", Header: "", Propagate: false},
+		{Name: "valid", Header: "3f2a-17:ab.c_d", Propagate: true},
+		{Name: "invalid characters", Header: "id\"with quotes", Propagate: false},
+		{Name: "too long", Header: strings.Repeat("a", maxRequestIDLength+1), Propagate: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			var contextID string
+			handler := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				contextID = logger.RequestID(r.Context())
+			}))
+
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			if test.Header != "" {
+				request.Header.Set(RequestIDHeader, test.Header)
+			}
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+
+			responseID := recorder.Header().Get(RequestIDHeader)
+			if contextID == "" || contextID != responseID {
+				t.Errorf("expected the same request ID in context and response, got %q and %q", contextID, responseID)
+			}
+			if (contextID == test.Header) != test.Propagate {
+				t.Errorf("expected propagation %t, got request ID %q for header %q", test.Propagate, contextID, test.Header)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+
+	"bjoernblessin.de/screenecho/metrics"
+)
+
+// httpPanicSource is the source passed to [metrics.Metrics.PanicRecovered] for panics of HTTP handlers.
+const httpPanicSource = "http"
+
+// Recover recovers panics of next, logs them with their stack and answers the request with 500 Internal Server Error.
+// Every recovered panic is counted in m.
+//
+// [http.ErrAbortHandler] is passed on, so that the server still aborts the response as intended.
+func Recover(m metrics.Metrics) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer func() {
+				recovered := recover()
+				if recovered == nil {
+					return
+				}
+				if recovered == http.ErrAbortHandler {
+					panic(recovered)
+				}
+
+				log.ErrorContext(r.Context(), "HTTP handler panicked",
+					"method", r.Method,
+					"uri", r.RequestURI,
+					"panic", fmt.Sprint(recovered),
+					"stack", string(debug.Stack()),
+				)
+				m.PanicRecovered(httpPanicSource)
+
+				// Fails silently if the handler already wrote the header or hijacked the connection, see responseRecorder
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			}()
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
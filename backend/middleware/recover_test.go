@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"bjoernblessin.de/screenecho/metrics"
+)
+
+func TestRecover(t *testing.T) {
+	registry := metrics.NewRegistry()
+
+	handler := Recover(registry)(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		panic("invariant violated")
+	}))
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/room/generate-id", nil))
+
+	if recorder.Code != http.StatusInternalServerError {
+		t.Errorf("expected status 500, got %d", recorder.Code)
+	}
+	if value := registry.Counter(metrics.PanicsRecoveredName, httpPanicSource); value != 1 {
+		t.Errorf("expected 1 recovered panic, got %v", value)
+	}
+}
+
+func TestRecover_AbortHandler(t *testing.T) {
+	handler := Recover(metrics.Nop{})(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+
+	defer func() {
+		if recovered := recover(); recovered != http.ErrAbortHandler {
+			t.Errorf("expected http.ErrAbortHandler to be passed on, got %v", recovered)
+		}
+	}()
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+}
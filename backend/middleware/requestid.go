@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"net/http"
+
+	"bjoernblessin.de/screenecho/util/logger"
+	"github.com/google/uuid"
+)
+
+// RequestIDHeader is the header a request ID is read from and returned in.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength limits the length of propagated request IDs, longer IDs are replaced.
+const maxRequestIDLength = 128
+
+// RequestID stores a request ID in the request context (see [logger.WithRequestID]) and returns it in the X-Request-ID response header.
+//
+// A valid X-Request-ID of the request (e.g. set by a reverse proxy) is propagated, otherwise a new random ID is generated.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(RequestIDHeader)
+		if !isValidRequestID(requestID) {
+			requestID = uuid.NewString()
+		}
+
+		w.Header().Set(RequestIDHeader, requestID)
+
+		next.ServeHTTP(w, r.WithContext(logger.WithRequestID(r.Context(), requestID)))
+	})
+}
+
+// isValidRequestID reports whether id is short and only contains characters that are safe to log and to echo in a header.
+func isValidRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+
+	for _, char := range id {
+		isAlphanumeric := (char >= 'a' && char <= 'z') || (char >= 'A' && char <= 'Z') || (char >= '0' && char <= '9')
+		if !isAlphanumeric && char != '-' && char != '_' && char != '.' && char != ':' {
+			return false
+		}
+	}
+
+	return true
+}
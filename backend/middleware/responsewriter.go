@@ -0,0 +1,97 @@
+package middleware
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+)
+
+// responseRecorder wraps an [http.ResponseWriter] and records the status code and the number of body bytes written.
+//
+// It implements [http.Hijacker] and [http.Flusher] if the wrapped writer does, so WebSocket upgrades and streaming
+// responses keep working. Other optional interfaces are reachable through Unwrap, see [http.ResponseController].
+// After a hijack, writes fail with [http.ErrHijacked] without reaching the wrapped writer, e.g. the 500 response of
+// [Recover] for a panic after a WebSocket upgrade.
+type responseRecorder struct {
+	http.ResponseWriter
+	status   int
+	size     int64
+	hijacked bool
+}
+
+func newResponseRecorder(w http.ResponseWriter) *responseRecorder {
+	return &responseRecorder{ResponseWriter: w}
+}
+
+func (rr *responseRecorder) WriteHeader(status int) {
+	if rr.hijacked {
+		return
+	}
+
+	// Informational responses (1xx) may be followed by the final status
+	if rr.status == 0 && status >= 200 {
+		rr.status = status
+	}
+
+	rr.ResponseWriter.WriteHeader(status)
+}
+
+func (rr *responseRecorder) Write(p []byte) (int, error) {
+	if rr.hijacked {
+		return 0, http.ErrHijacked
+	}
+
+	if rr.status == 0 {
+		rr.status = http.StatusOK
+	}
+
+	n, err := rr.ResponseWriter.Write(p)
+	rr.size += int64(n)
+
+	return n, err
+}
+
+// Hijack hijacks the underlying connection. The response is recorded as 101 Switching Protocols,
+// since the handler writes the upgrade response to the connection directly.
+func (rr *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := rr.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("middleware: response writer doesn't support hijacking")
+	}
+
+	conn, readWriter, err := hijacker.Hijack()
+	if err == nil {
+		rr.hijacked = true
+		rr.status = http.StatusSwitchingProtocols
+	}
+
+	return conn, readWriter, err
+}
+
+func (rr *responseRecorder) Flush() {
+	if rr.hijacked {
+		return
+	}
+
+	if rr.status == 0 {
+		rr.status = http.StatusOK
+	}
+
+	if flusher, ok := rr.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (rr *responseRecorder) Unwrap() http.ResponseWriter {
+	return rr.ResponseWriter
+}
+
+// Status returns the recorded status code. Handlers that never wrote anything implicitly responded with 200 OK.
+func (rr *responseRecorder) Status() int {
+	if rr.status == 0 {
+		return http.StatusOK
+	}
+
+	return rr.status
+}
@@ -0,0 +1,196 @@
+// Package protocol describes the WebSocket protocol between clients and the server.
+//
+// The description is generated from the message types registered at a [clients.ClientManager] with [clients.Handle]
+// and [clients.RegisterOutbound], so it can't drift apart from the Go structs. It's available as AsyncAPI document
+// with a JSON Schema per payload type, see [NewDocument], and as TypeScript type definitions for the frontend,
+// see [WriteTypeScript]. Both are checked in and regenerated with go generate, the document is also served by [Handler].
+package protocol
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"slices"
+
+	"bjoernblessin.de/screenecho/clients"
+	"bjoernblessin.de/screenecho/connection"
+)
+
+//go:generate go run ../cmd/screenecho-schema -asyncapi asyncapi.json -typescript ../../frontend/src/services/protocol.gen.ts
+
+// Version is the version of the protocol. Increase it on changes that break existing clients.
+const Version = "1.0.0"
+
+// ConnectPath is the path of the WebSocket endpoint all messages are exchanged over.
+const ConnectPath = "/room/{roomID}/connect"
+
+// Document is an AsyncAPI 2.6 document.
+type Document struct {
+	AsyncAPI           string             `json:"asyncapi"`
+	Info               Info               `json:"info"`
+	DefaultContentType string             `json:"defaultContentType"`
+	Channels           map[string]Channel `json:"channels"`
+	Components         Components         `json:"components"`
+}
+
+type Info struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description"`
+}
+
+type Channel struct {
+	Description string               `json:"description"`
+	Parameters  map[string]Parameter `json:"parameters"`
+	// Publish are the messages clients send to the server.
+	Publish Operation `json:"publish"`
+	// Subscribe are the messages the server sends to clients.
+	Subscribe Operation `json:"subscribe"`
+}
+
+type Parameter struct {
+	Description string  `json:"description"`
+	Schema      *Schema `json:"schema"`
+}
+
+type Operation struct {
+	OperationID string       `json:"operationId"`
+	Summary     string       `json:"summary"`
+	Message     OneOfMessage `json:"message"`
+}
+
+type OneOfMessage struct {
+	OneOf []Reference `json:"oneOf"`
+}
+
+type Reference struct {
+	Ref string `json:"$ref"`
+}
+
+type Components struct {
+	Messages map[string]Message `json:"messages"`
+	Schemas  map[string]*Schema `json:"schemas"`
+}
+
+// Message is a message of the protocol. Its payload is the envelope of [connection.TypedMessage].
+type Message struct {
+	Name    string  `json:"name"`
+	Title   string  `json:"title"`
+	Payload *Schema `json:"payload"`
+}
+
+// NewDocument returns the AsyncAPI document of the message types, see [clients.ClientManager.MessageTypes].
+//
+// Messages are named "<direction>.<type>" in the components, e.g. "inbound.sdp-offer", because some message types
+// are sent in both directions with different payloads. Payload types are named after their Go type.
+func NewDocument(types []clients.MessageTypeInfo) *Document {
+	payloads := make([]reflect.Type, len(types))
+	for i, info := range types {
+		payloads[i] = info.Payload
+	}
+	builder := newSchemaBuilder(payloads)
+
+	document := &Document{
+		AsyncAPI: "2.6.0",
+		Info: Info{
+			Title:       "ScreenEcho",
+			Version:     Version,
+			Description: "Signaling and room protocol of ScreenEcho. Every message is a JSON object with the message type and its payload.",
+		},
+		DefaultContentType: "application/json",
+		Channels: map[string]Channel{
+			ConnectPath: {
+				Description: "WebSocket connection of a client in a room.",
+				Parameters: map[string]Parameter{
+					"roomID": {Description: "ID of the room to join.", Schema: &Schema{Type: "string"}},
+				},
+				Publish: Operation{
+					OperationID: "receiveClientMessage",
+					Summary:     "Messages clients send to the server.",
+					Message:     OneOfMessage{OneOf: []Reference{}},
+				},
+				Subscribe: Operation{
+					OperationID: "sendServerMessage",
+					Summary:     "Messages the server sends to clients.",
+					Message:     OneOfMessage{OneOf: []Reference{}},
+				},
+			},
+		},
+		Components: Components{
+			Messages: make(map[string]Message),
+			Schemas:  builder.schemas,
+		},
+	}
+
+	channel := document.Channels[ConnectPath]
+	for _, info := range types {
+		key := messageKey(info)
+		document.Components.Messages[key] = Message{
+			Name:    string(info.Type),
+			Title:   info.Direction.String() + " " + string(info.Type),
+			Payload: envelopeSchema(info.Type, builder.schema(info.Payload)),
+		}
+
+		reference := Reference{Ref: "#/components/messages/" + key}
+		if info.Direction == clients.Inbound {
+			channel.Publish.Message.OneOf = append(channel.Publish.Message.OneOf, reference)
+		} else {
+			channel.Subscribe.Message.OneOf = append(channel.Subscribe.Message.OneOf, reference)
+		}
+	}
+	document.Channels[ConnectPath] = channel
+
+	return document
+}
+
+func messageKey(info clients.MessageTypeInfo) string {
+	return info.Direction.String() + "." + string(info.Type)
+}
+
+// envelopeSchema returns the schema of a [connection.TypedMessage] of messageType with the payload schema msg.
+func envelopeSchema(messageType connection.MessageType, msg *Schema) *Schema {
+	return &Schema{
+		Type: "object",
+		Properties: Properties{
+			{Name: "type", Schema: &Schema{Type: "string", Const: string(messageType)}},
+			{Name: "msg", Schema: msg},
+		},
+		Required: []string{"type", "msg"},
+	}
+}
+
+// Marshal returns the indented JSON of the document, as served by [Handler] and written by go generate.
+func (document *Document) Marshal() ([]byte, error) {
+	data, err := json.MarshalIndent(document, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append(data, '\n'), nil
+}
+
+// Handler serves the AsyncAPI document of the message types registered at clientManager.
+// The document is built per request, so it includes message types registered after the handler was created.
+func Handler(clientManager *clients.ClientManager) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		data, err := NewDocument(clientManager.MessageTypes()).Marshal()
+		if err != nil {
+			http.Error(writer, "Failed to build protocol document", http.StatusInternalServerError)
+			return
+		}
+
+		writer.Header().Set("Content-Type", "application/json")
+		_, _ = writer.Write(data)
+	})
+}
+
+// sortedSchemaNames returns the names of the component schemas in alphabetical order.
+func sortedSchemaNames(schemas map[string]*Schema) []string {
+	names := make([]string, 0, len(schemas))
+	for name := range schemas {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	return names
+}
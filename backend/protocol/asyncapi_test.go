@@ -0,0 +1,124 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+
+	"bjoernblessin.de/screenecho/clients"
+	"bjoernblessin.de/screenecho/connection"
+	"bjoernblessin.de/screenecho/events"
+	"bjoernblessin.de/screenecho/metrics"
+	"bjoernblessin.de/screenecho/rooms"
+	"bjoernblessin.de/screenecho/signaling"
+	"bjoernblessin.de/screenecho/streams"
+)
+
+type ErrorMessage struct {
+	Code int `json:"code"`
+}
+
+func TestNewDocument(t *testing.T) {
+	types := []clients.MessageTypeInfo{
+		{Type: "error", Direction: clients.Outbound, Payload: reflect.TypeFor[connection.ErrorMessage]()},
+		{Type: "ping", Direction: clients.Inbound, Payload: reflect.TypeFor[samplePosition]()},
+		{Type: "ping", Direction: clients.Outbound, Payload: reflect.TypeFor[ErrorMessage]()},
+	}
+
+	document := NewDocument(types)
+	channel := document.Channels[ConnectPath]
+
+	expectedPublish := []Reference{{Ref: "#/components/messages/inbound.ping"}}
+	if !reflect.DeepEqual(channel.Publish.Message.OneOf, expectedPublish) {
+		t.Errorf("expected publish messages %v, got %v", expectedPublish, channel.Publish.Message.OneOf)
+	}
+	expectedSubscribe := []Reference{{Ref: "#/components/messages/outbound.error"}, {Ref: "#/components/messages/outbound.ping"}}
+	if !reflect.DeepEqual(channel.Subscribe.Message.OneOf, expectedSubscribe) {
+		t.Errorf("expected subscribe messages %v, got %v", expectedSubscribe, channel.Subscribe.Message.OneOf)
+	}
+
+	// The two ErrorMessage types are told apart by their package
+	msg := document.Components.Messages["outbound.ping"].Payload.Properties[1].Schema
+	if msg.Ref != "#/components/schemas/ProtocolErrorMessage" {
+		t.Errorf("expected the payload of outbound.ping to reference ProtocolErrorMessage, got %+v", msg)
+	}
+	for _, name := range []string{"WireErrorMessage", "ProtocolErrorMessage", "FieldError", "SamplePosition"} {
+		if document.Components.Schemas[name] == nil {
+			t.Errorf("expected schema %s, got %v", name, sortedSchemaNames(document.Components.Schemas))
+		}
+	}
+
+	envelope, err := json.Marshal(document.Components.Messages["inbound.ping"].Payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedEnvelope := `{"type":"object","properties":{"type":{"type":"string","const":"ping"},"msg":{"$ref":"#/components/schemas/SamplePosition"}},"required":["type","msg"]}`
+	if string(envelope) != expectedEnvelope {
+		t.Errorf("expected envelope %s, got %s", expectedEnvelope, envelope)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	bus := events.NewBus(events.DefaultAsyncBufferSize)
+	clientManager := clients.NewClientManager(connection.NewConnectionManager(connection.Options{}, metrics.Nop{}, bus), nil, bus)
+	handler := Handler(clientManager)
+
+	// Registered after the handler was created
+	clients.Handle(clientManager, "ping", func(*clients.Client, samplePosition) error { return nil })
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/protocol/asyncapi.json", nil))
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, recorder.Code)
+	}
+	if contentType := recorder.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("expected content type application/json, got %q", contentType)
+	}
+
+	var document Document
+	if err := json.Unmarshal(recorder.Body.Bytes(), &document); err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := document.Components.Messages["inbound.ping"]; !exists {
+		t.Errorf("expected message inbound.ping, got %v", document.Components.Messages)
+	}
+}
+
+// TestGeneratedFiles fails if the checked-in files are outdated. Run go generate ./protocol to update them.
+func TestGeneratedFiles(t *testing.T) {
+	bus := events.NewBus(events.DefaultAsyncBufferSize)
+	clientManager := clients.NewClientManager(connection.NewConnectionManager(connection.Options{}, metrics.Nop{}, bus), nil, bus)
+	roomManager := rooms.NewRoomManager(clientManager, metrics.Nop{}, bus)
+	streams.NewStreamManager(clientManager, roomManager, metrics.Nop{}, bus)
+	signaling.NewSignalingManager(clientManager)
+
+	document := NewDocument(clientManager.MessageTypes())
+
+	asyncAPI, err := document.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var typeScript bytes.Buffer
+	if err := WriteTypeScript(&typeScript, document); err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string][]byte{
+		"asyncapi.json": asyncAPI,
+		"../../frontend/src/services/protocol.gen.ts": typeScript.Bytes(),
+	}
+	for file, expected := range files {
+		actual, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(actual, expected) {
+			t.Errorf("%s is outdated, run go generate ./protocol", file)
+		}
+	}
+}
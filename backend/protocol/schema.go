@@ -0,0 +1,351 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"bjoernblessin.de/screenecho/util/strictjson"
+)
+
+// Schema is a JSON Schema (draft 7, as used by AsyncAPI 2) restricted to what message payloads need.
+type Schema struct {
+	Ref    string `json:"$ref,omitempty"`
+	Type   string `json:"type,omitempty"`
+	Format string `json:"format,omitempty"`
+	Const  any    `json:"const,omitempty"`
+	Enum   []any  `json:"enum,omitempty"`
+
+	Pattern   string   `json:"pattern,omitempty"`
+	MinLength *int     `json:"minLength,omitempty"`
+	MaxLength *int     `json:"maxLength,omitempty"`
+	Minimum   *float64 `json:"minimum,omitempty"`
+	Maximum   *float64 `json:"maximum,omitempty"`
+
+	Items    *Schema `json:"items,omitempty"`
+	MinItems *int    `json:"minItems,omitempty"`
+	MaxItems *int    `json:"maxItems,omitempty"`
+
+	Properties Properties `json:"properties,omitempty"`
+	Required   []string   `json:"required,omitempty"`
+	// AdditionalProperties is false for structs and the *Schema of the values for maps.
+	AdditionalProperties any  `json:"additionalProperties,omitempty"`
+	MinProperties        *int `json:"minProperties,omitempty"`
+	MaxProperties        *int `json:"maxProperties,omitempty"`
+
+	// AnyOf is used for pointers, which are the schema of their element or null.
+	AnyOf []*Schema `json:"anyOf,omitempty"`
+}
+
+// Property is a property of an object schema.
+type Property struct {
+	Name   string
+	Schema *Schema
+}
+
+// Properties are the properties of an object schema in the order of the struct's fields.
+// They are encoded as JSON object, the order is kept in both directions.
+type Properties []Property
+
+func (properties Properties) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+
+	buf.WriteByte('{')
+	for i, property := range properties {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+
+		name, err := json.Marshal(property.Name)
+		if err != nil {
+			return nil, err
+		}
+		schema, err := json.Marshal(property.Schema)
+		if err != nil {
+			return nil, err
+		}
+
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(schema)
+	}
+	buf.WriteByte('}')
+
+	return buf.Bytes(), nil
+}
+
+func (properties *Properties) UnmarshalJSON(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok != json.Delim('{') {
+		return &json.UnmarshalTypeError{Value: fmt.Sprint(tok), Type: reflect.TypeFor[Properties]()}
+	}
+
+	*properties = nil
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		// Keys of an object are always strings
+		property := Property{Name: tok.(string)}
+		if err := dec.Decode(&property.Schema); err != nil {
+			return err
+		}
+		*properties = append(*properties, property)
+	}
+
+	return nil
+}
+
+const schemaRefPrefix = "#/components/schemas/"
+
+var (
+	jsonUnmarshalerType = reflect.TypeFor[json.Unmarshaler]()
+	textUnmarshalerType = reflect.TypeFor[encoding.TextUnmarshaler]()
+	numberType          = reflect.TypeFor[json.Number]()
+)
+
+// schemaBuilder builds the schemas of payload types. Named struct types become components referenced by $ref,
+// all other types are inlined.
+type schemaBuilder struct {
+	// names holds the component name of every named struct type.
+	names   map[reflect.Type]string
+	schemas map[string]*Schema
+}
+
+// newSchemaBuilder names the named struct types reachable from types. Unexported names are capitalized,
+// names used by types of different packages are prefixed with their package name.
+func newSchemaBuilder(types []reflect.Type) *schemaBuilder {
+	b := &schemaBuilder{
+		names:   make(map[reflect.Type]string),
+		schemas: make(map[string]*Schema),
+	}
+
+	var structs []reflect.Type
+	visited := make(map[reflect.Type]bool)
+	for _, typ := range types {
+		collectStructs(typ, visited, &structs)
+	}
+
+	count := make(map[string]int)
+	for _, typ := range structs {
+		count[exportedName(typ.Name())]++
+	}
+	for _, typ := range structs {
+		name := exportedName(typ.Name())
+		if count[name] > 1 {
+			name = exportedName(path.Base(typ.PkgPath())) + name
+		}
+		b.names[typ] = name
+	}
+
+	return b
+}
+
+// collectStructs appends the named struct types reachable from typ to structs.
+func collectStructs(typ reflect.Type, visited map[reflect.Type]bool, structs *[]reflect.Type) {
+	if visited[typ] || isOpaque(typ) || isText(typ) {
+		return
+	}
+	visited[typ] = true
+
+	switch typ.Kind() {
+	case reflect.Pointer, reflect.Slice, reflect.Array:
+		collectStructs(typ.Elem(), visited, structs)
+	case reflect.Map:
+		collectStructs(typ.Elem(), visited, structs)
+	case reflect.Struct:
+		if typ.Name() != "" {
+			*structs = append(*structs, typ)
+		}
+		for _, field := range strictjson.Fields(typ) {
+			collectStructs(field.Type, visited, structs)
+		}
+	}
+}
+
+// schema returns the schema of typ. The schemas of named struct types are added to the components.
+func (b *schemaBuilder) schema(typ reflect.Type) *Schema {
+	if isOpaque(typ) {
+		return &Schema{}
+	}
+	if isText(typ) {
+		return &Schema{Type: "string"}
+	}
+
+	switch typ.Kind() {
+	case reflect.Pointer:
+		return &Schema{AnyOf: []*Schema{b.schema(typ.Elem()), {Type: "null"}}}
+	case reflect.Struct:
+		name, named := b.names[typ]
+		if !named {
+			return b.structSchema(typ)
+		}
+		if _, exists := b.schemas[name]; !exists {
+			// Added before the fields are built, so recursive types reference themselves
+			b.schemas[name] = &Schema{}
+			*b.schemas[name] = *b.structSchema(typ)
+		}
+		return &Schema{Ref: schemaRefPrefix + name}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: b.schema(typ.Elem())}
+	case reflect.Slice:
+		if typ.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: b.schema(typ.Elem())}
+	case reflect.Array:
+		return &Schema{Type: "array", Items: b.schema(typ.Elem()), MinItems: ptr(typ.Len()), MaxItems: ptr(typ.Len())}
+	case reflect.String:
+		if typ == numberType {
+			return &Schema{Type: "number"}
+		}
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	default:
+		return &Schema{}
+	}
+}
+
+// structSchema returns the object schema of the struct type typ with the fields as [strictjson.Unmarshal] decodes them.
+func (b *schemaBuilder) structSchema(typ reflect.Type) *Schema {
+	schema := &Schema{Type: "object", AdditionalProperties: false}
+
+	for _, field := range strictjson.Fields(typ) {
+		var fieldSchema *Schema
+		if field.Quoted {
+			fieldSchema = &Schema{Type: "string"}
+		} else {
+			fieldSchema = b.schema(field.Type)
+		}
+		applyRules(fieldSchema, field.Rules, field.Type)
+
+		schema.Properties = append(schema.Properties, Property{Name: field.Name, Schema: fieldSchema})
+		if !field.Optional {
+			schema.Required = append(schema.Required, field.Name)
+		}
+	}
+
+	return schema
+}
+
+// applyRules adds the constraints of the validate rules of a field of type typ to its schema.
+// The rules of pointer fields constrain the element.
+func applyRules(schema *Schema, rules []strictjson.Rule, typ reflect.Type) {
+	if len(rules) == 0 {
+		return
+	}
+
+	for typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+		if len(schema.AnyOf) > 0 {
+			schema = schema.AnyOf[0]
+		}
+	}
+
+	for _, rule := range rules {
+		switch rule.Name {
+		case "uuid":
+			schema.Format = "uuid"
+		case "oneof":
+			for _, value := range strings.Fields(rule.Argument) {
+				schema.Enum = append(schema.Enum, enumValue(value, typ))
+			}
+		case "regex":
+			schema.Pattern = rule.Argument
+		case "min", "max":
+			applyBound(schema, rule, typ)
+		}
+	}
+}
+
+func applyBound(schema *Schema, rule strictjson.Rule, typ reflect.Type) {
+	isMin := rule.Name == "min"
+
+	var minimum, maximum **int
+	switch typ.Kind() {
+	case reflect.String:
+		minimum, maximum = &schema.MinLength, &schema.MaxLength
+	case reflect.Slice, reflect.Array:
+		minimum, maximum = &schema.MinItems, &schema.MaxItems
+	case reflect.Map:
+		minimum, maximum = &schema.MinProperties, &schema.MaxProperties
+	default:
+		bound, _ := strconv.ParseFloat(rule.Argument, 64)
+		if isMin {
+			schema.Minimum = &bound
+		} else {
+			schema.Maximum = &bound
+		}
+		return
+	}
+
+	bound, _ := strconv.Atoi(rule.Argument)
+	if isMin {
+		*minimum = &bound
+	} else {
+		*maximum = &bound
+	}
+}
+
+// enumValue returns value of a oneof rule as the JSON value of a field of type typ.
+func enumValue(value string, typ reflect.Type) any {
+	if typ.Kind() != reflect.String {
+		if number, err := strconv.ParseFloat(value, 64); err == nil {
+			return number
+		}
+	}
+	return value
+}
+
+// isOpaque reports whether typ is decoded by itself, e.g. [json.RawMessage], or can hold any value.
+// Its schema accepts every value.
+func isOpaque(typ reflect.Type) bool {
+	if typ.Kind() == reflect.Interface {
+		return true
+	}
+	return typ.Implements(jsonUnmarshalerType) || reflect.PointerTo(typ).Implements(jsonUnmarshalerType)
+}
+
+// isText reports whether typ is decoded from a JSON string by [encoding.TextUnmarshaler].
+func isText(typ reflect.Type) bool {
+	return typ.Implements(textUnmarshalerType) || reflect.PointerTo(typ).Implements(textUnmarshalerType)
+}
+
+// exportedName turns name into an exported identifier, e.g. "clientIDMessage" into "ClientIDMessage".
+// Characters that aren't valid in identifiers, like the brackets of generic types, are dropped.
+func exportedName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' {
+			return r
+		}
+		return -1
+	}, name)
+
+	runes := []rune(name)
+	if len(runes) > 0 {
+		runes[0] = unicode.ToUpper(runes[0])
+	}
+
+	return string(runes)
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
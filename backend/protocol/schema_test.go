@@ -0,0 +1,123 @@
+package protocol
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type sampleMessage struct {
+	ID       string            `json:"id" validate:"uuid"`
+	Name     string            `json:"name" validate:"min=1,max=8"`
+	Kind     string            `json:"kind" validate:"oneof=offer answer"`
+	Level    int               `json:"level,omitempty" validate:"oneof=1 2"`
+	Volume   *float64          `json:"volume" validate:"min=0,max=1"`
+	Tags     []string          `json:"tags" validate:"max=2"`
+	Code     string            `json:"code" validate:"regex=^[a-z]+$"`
+	Payload  json.RawMessage   `json:"payload"`
+	Scores   map[string]int    `json:"scores"`
+	Count    int64             `json:"count,string"`
+	Children []sampleMessage   `json:"children"`
+	Inline   struct{ A bool }  `json:"inline"`
+	Pair     [2]samplePosition `json:"pair"`
+}
+
+type samplePosition struct {
+	X float64 `json:"x"`
+}
+
+func TestSchema(t *testing.T) {
+	builder := newSchemaBuilder([]reflect.Type{reflect.TypeFor[sampleMessage]()})
+
+	schema := builder.schema(reflect.TypeFor[sampleMessage]())
+	if schema.Ref != "#/components/schemas/SampleMessage" {
+		t.Fatalf("expected a reference to SampleMessage, got %+v", schema)
+	}
+
+	data, err := json.Marshal(builder.schemas)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{"SampleMessage":{"type":"object","properties":{` +
+		`"id":{"type":"string","format":"uuid"},` +
+		`"name":{"type":"string","minLength":1,"maxLength":8},` +
+		`"kind":{"type":"string","enum":["offer","answer"]},` +
+		`"level":{"type":"integer","enum":[1,2]},` +
+		`"volume":{"anyOf":[{"type":"number","minimum":0,"maximum":1},{"type":"null"}]},` +
+		`"tags":{"type":"array","items":{"type":"string"},"maxItems":2},` +
+		`"code":{"type":"string","pattern":"^[a-z]+$"},` +
+		`"payload":{},` +
+		`"scores":{"type":"object","additionalProperties":{"type":"integer"}},` +
+		`"count":{"type":"string"},` +
+		`"children":{"type":"array","items":{"$ref":"#/components/schemas/SampleMessage"}},` +
+		`"inline":{"type":"object","properties":{"A":{"type":"boolean"}},"required":["A"],"additionalProperties":false},` +
+		`"pair":{"type":"array","items":{"$ref":"#/components/schemas/SamplePosition"},"minItems":2,"maxItems":2}},` +
+		`"required":["id","name","kind","tags","code","payload","scores","count","children","inline","pair"],"additionalProperties":false},` +
+		`"SamplePosition":{"type":"object","properties":{"x":{"type":"number"}},"required":["x"],"additionalProperties":false}}`
+	if string(data) != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, data)
+	}
+}
+
+func TestTypeScript(t *testing.T) {
+	document := NewDocument(nil)
+	builder := newSchemaBuilder([]reflect.Type{reflect.TypeFor[sampleMessage]()})
+	builder.schema(reflect.TypeFor[sampleMessage]())
+	document.Components.Schemas = builder.schemas
+
+	var b strings.Builder
+	if err := WriteTypeScript(&b, document); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `export type SampleMessage = {
+    id: string;
+    name: string;
+    kind: "offer" | "answer";
+    level?: 1 | 2;
+    volume?: number | null;
+    tags: string[];
+    code: string;
+    payload: unknown;
+    scores: Record<string, number>;
+    count: string;
+    children: SampleMessage[];
+    inline: {
+        A: boolean;
+    };
+    pair: SamplePosition[];
+};
+
+export type SamplePosition = {
+    x: number;
+};
+
+/** Messages clients send to the server. */
+export type ClientMessage = never;
+`
+	if !strings.Contains(b.String(), expected) {
+		t.Errorf("expected output to contain\n%s\ngot\n%s", expected, b.String())
+	}
+}
+
+func TestExportedName(t *testing.T) {
+	tests := []struct {
+		Name     string
+		input    string
+		expected string
+	}{
+		{Name: "Exported", input: "SDPMessage", expected: "SDPMessage"},
+		{Name: "Unexported", input: "clientIDMessage", expected: "ClientIDMessage"},
+		{Name: "Generic", input: "pair[int,string]", expected: "Pairintstring"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			if name := exportedName(test.input); name != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, name)
+			}
+		})
+	}
+}
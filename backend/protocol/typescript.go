@@ -0,0 +1,131 @@
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+const typeScriptIndent = "    "
+
+var identifierPattern = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*$`)
+
+// WriteTypeScript writes TypeScript type definitions of the document to w: a type per component schema and the unions
+// ClientMessage and ServerMessage of all messages clients and the server send, discriminated by their type.
+// The output follows the formatting of the frontend.
+func WriteTypeScript(w io.Writer, document *Document) error {
+	var b strings.Builder
+
+	b.WriteString("// Code generated by screenecho-schema. DO NOT EDIT.\n")
+	fmt.Fprintf(&b, "// Protocol version %s, see backend/protocol.\n", document.Info.Version)
+
+	for _, name := range sortedSchemaNames(document.Components.Schemas) {
+		fmt.Fprintf(&b, "\nexport type %s = %s;\n", name, typeScriptType(document.Components.Schemas[name], 0))
+	}
+
+	channel := document.Channels[ConnectPath]
+	writeMessageUnion(&b, "ClientMessage", channel.Publish, document.Components.Messages)
+	writeMessageUnion(&b, "ServerMessage", channel.Subscribe, document.Components.Messages)
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeMessageUnion writes the union of the envelopes of the messages of operation and the union of their types.
+func writeMessageUnion(b *strings.Builder, name string, operation Operation, messages map[string]Message) {
+	fmt.Fprintf(b, "\n/** %s */\n", operation.Summary)
+	fmt.Fprintf(b, "export type %s =", name)
+
+	if len(operation.Message.OneOf) == 0 {
+		b.WriteString(" never;\n")
+	}
+	for i, reference := range operation.Message.OneOf {
+		message := messages[strings.TrimPrefix(reference.Ref, "#/components/messages/")]
+		// The payload is the envelope, see envelopeSchema
+		msg := message.Payload.Properties[1].Schema
+
+		fmt.Fprintf(b, "\n%s| { type: %s; msg: %s }", typeScriptIndent, quote(message.Name), typeScriptType(msg, 1))
+		if i == len(operation.Message.OneOf)-1 {
+			b.WriteString(";\n")
+		}
+	}
+
+	fmt.Fprintf(b, "\nexport type %sType = %s[\"type\"];\n", name, name)
+}
+
+// typeScriptType returns the TypeScript type of schema. depth is the indentation level of the type's first line.
+func typeScriptType(schema *Schema, depth int) string {
+	switch {
+	case schema.Ref != "":
+		return strings.TrimPrefix(schema.Ref, schemaRefPrefix)
+	case len(schema.AnyOf) > 0:
+		members := make([]string, len(schema.AnyOf))
+		for i, member := range schema.AnyOf {
+			members[i] = typeScriptType(member, depth)
+		}
+		return strings.Join(members, " | ")
+	case schema.Const != nil:
+		return quote(schema.Const)
+	case len(schema.Enum) > 0:
+		members := make([]string, len(schema.Enum))
+		for i, value := range schema.Enum {
+			members[i] = quote(value)
+		}
+		return strings.Join(members, " | ")
+	}
+
+	switch schema.Type {
+	case "string", "boolean", "null":
+		return schema.Type
+	case "integer", "number":
+		return "number"
+	case "array":
+		items := typeScriptType(schema.Items, depth)
+		if strings.Contains(items, " | ") {
+			items = "(" + items + ")"
+		}
+		return items + "[]"
+	case "object":
+		if values, ok := schema.AdditionalProperties.(*Schema); ok {
+			return "Record<string, " + typeScriptType(values, depth) + ">"
+		}
+		return typeScriptObject(schema, depth)
+	default:
+		return "unknown"
+	}
+}
+
+func typeScriptObject(schema *Schema, depth int) string {
+	if len(schema.Properties) == 0 {
+		return "Record<string, never>"
+	}
+
+	indent := strings.Repeat(typeScriptIndent, depth)
+
+	var b strings.Builder
+	b.WriteString("{\n")
+	for _, property := range schema.Properties {
+		name := property.Name
+		if !identifierPattern.MatchString(name) {
+			name = quote(name)
+		}
+
+		optional := ""
+		if !slices.Contains(schema.Required, property.Name) {
+			optional = "?"
+		}
+
+		fmt.Fprintf(&b, "%s%s%s%s: %s;\n", indent, typeScriptIndent, name, optional, typeScriptType(property.Schema, depth+1))
+	}
+	b.WriteString(indent + "}")
+
+	return b.String()
+}
+
+func quote(value any) string {
+	data, _ := json.Marshal(value)
+	return string(data)
+}
@@ -0,0 +1,42 @@
+package rooms
+
+import "bjoernblessin.de/screenecho/clients"
+
+// Events published by the RoomManager, see package events.
+// All events of a room are published from the room's goroutine, so they are delivered in the order they happened.
+
+// RoomCreated is published when a room was created, before any client joined it.
+type RoomCreated struct {
+	RoomID RoomID
+}
+
+// RoomDeleted is published when a room was deleted, after all clients left it.
+type RoomDeleted struct {
+	RoomID RoomID
+}
+
+// ClientJoined is published when a client joined a room. Observers are published as well, see [clients.Client.IsObserver].
+// It's [events.Synchronous] because of State.
+type ClientJoined struct {
+	Room   *Room
+	Client *clients.Client
+	// State is the state of the room. It's only valid while the subscriber runs.
+	// Subscribers run on the room's goroutine and must not call [Room.Do].
+	State *RoomState
+}
+
+func (ClientJoined) Synchronous() {}
+
+// ClientLeft is published when a client left a room, after it was removed from the room.
+// It's [events.Synchronous] because of State.
+type ClientLeft struct {
+	Room     *Room
+	ClientID clients.ClientID
+	// Observer is true if the client was a hidden observer, see [clients.RoleObserver].
+	Observer bool
+	// State is the state of the room. It's only valid while the subscriber runs.
+	// Subscribers run on the room's goroutine and must not call [Room.Do].
+	State *RoomState
+}
+
+func (ClientLeft) Synchronous() {}
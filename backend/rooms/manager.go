@@ -5,27 +5,53 @@
 package rooms
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"math/rand"
 	"net/http"
 	"sync"
+	"time"
 
 	"bjoernblessin.de/screenecho/clients"
+	"bjoernblessin.de/screenecho/events"
+	"bjoernblessin.de/screenecho/metrics"
 	"bjoernblessin.de/screenecho/util/assert"
+	"bjoernblessin.de/screenecho/util/logger"
 )
 
+var log = logger.New("rooms")
+
 type RoomManager struct {
-	rooms              map[RoomID]*Room // Mapping RoomID <-> Room is redundant here because it's already done in Room struct, but most efficient
-	roomsMutex         sync.RWMutex
-	clientManager      *clients.ClientManager
-	clientJoinHandlers []func(*Room, *clients.Client)
-	clientJoinMutex    sync.RWMutex
+	rooms map[RoomID]*Room // Mapping RoomID <-> Room is redundant here because it's already done in Room struct, but most efficient
+	// roomsByClient indexes the room of every joined client. It's kept consistent with the clients of all rooms
+	// by join and leave, both maps are guarded by roomsMutex.
+	// roomsMutex must never be held while waiting for a room's goroutine, the goroutine itself locks it on join and leave.
+	roomsByClient map[clients.ClientID]*Room
+	roomsMutex    sync.RWMutex
+	clientManager *clients.ClientManager
+	metrics       metrics.Metrics
+	bus           *events.Bus
+	// unusedRoomTTL is the time a room created by GenerateIDHandler is kept without a client joining it.
+	unusedRoomTTL time.Duration
 }
 
-func NewRoomManager(clientManager *clients.ClientManager) *RoomManager {
+// defaultUnusedRoomTTL is the default of RoomManager.unusedRoomTTL. A client joining afterwards creates the room again,
+// so it only bounds how long a generated ID is reserved.
+const defaultUnusedRoomTTL = 5 * time.Minute
+
+// NewRoomManager creates a RoomManager that publishes RoomCreated, RoomDeleted, ClientJoined and ClientLeft events to bus.
+func NewRoomManager(clientManager *clients.ClientManager, m metrics.Metrics, bus *events.Bus) *RoomManager {
+	clients.RegisterOutbound[ClientDisconnectMessage](clientManager, CLIENT_DISCONNECT_MESSAGE_TYPE)
+
 	return &RoomManager{
 		rooms:         make(map[RoomID]*Room),
+		roomsByClient: make(map[clients.ClientID]*Room),
 		clientManager: clientManager,
+		metrics:       m,
+		bus:           bus,
+		unusedRoomTTL: defaultUnusedRoomTTL,
 	}
 }
 
@@ -42,6 +68,14 @@ func (rm *RoomManager) createEmptyRoom(roomID RoomID) *Room {
 	newRoom := NewRoom(roomID, rm.clientManager)
 
 	rm.rooms[roomID] = newRoom
+	rm.metrics.RoomCreated()
+
+	log.DebugContext(logger.WithRoomID(context.Background(), string(roomID)), "Room created")
+
+	// Published as the room's first event, so it's delivered before all other events of the room
+	newRoom.post(func(*RoomState) {
+		events.Publish(rm.bus, RoomCreated{RoomID: roomID})
+	})
 
 	return newRoom
 }
@@ -52,95 +86,301 @@ func (rm *RoomManager) GetUsersRoom(clientID clients.ClientID) *Room {
 	rm.roomsMutex.RLock()
 	defer rm.roomsMutex.RUnlock()
 
-	for _, room := range rm.rooms {
-		for id, _ := range room.clientIDs {
-			if id == clientID {
-				return room
+	return rm.roomsByClient[clientID]
+}
+
+// join adds client to the room with roomID, the room is created if it doesn't exist (anymore).
+// ClientJoined is published on the room's goroutine as part of the same event, for observers as well.
+func (rm *RoomManager) join(roomID RoomID, client *clients.Client) *Room {
+	for {
+		room := rm.getOrCreateRoom(roomID)
+
+		err := room.Do(func(state *RoomState) {
+			rm.addClient(state, client)
+
+			if client.IsObserver() {
+				log.InfoContext(client.Context(), "Observer joined room")
+			} else {
+				rm.metrics.ClientJoined()
+				log.InfoContext(client.Context(), "Client joined room")
 			}
+
+			events.Publish(rm.bus, ClientJoined{Room: room, Client: client, State: state})
+		})
+		if err == nil {
+			return room
 		}
+
+		// The last client left the room since it was looked up, the next attempt creates a new room
 	}
+}
 
-	return nil
+// getOrCreateRoom returns the room with roomID, it's created if it doesn't exist.
+func (rm *RoomManager) getOrCreateRoom(roomID RoomID) *Room {
+	rm.roomsMutex.Lock()
+	defer rm.roomsMutex.Unlock()
+
+	if room, exists := rm.rooms[roomID]; exists {
+		return room
+	}
+
+	return rm.createEmptyRoom(roomID)
+}
+
+// addClient adds client to the room of state and to the index. Must be called on the room's goroutine.
+// The lock is released by defer, so a failed assertion doesn't leave it locked while the room's goroutine recovers.
+func (rm *RoomManager) addClient(state *RoomState, client *clients.Client) {
+	rm.roomsMutex.Lock()
+	defer rm.roomsMutex.Unlock()
+
+	assert.Assert(rm.roomsByClient[client.ID] == nil, "client already joined a room")
+
+	if client.IsObserver() {
+		state.addObserver(client.ID)
+	} else {
+		state.addClient(client.ID)
+	}
+	rm.roomsByClient[client.ID] = state.room
+}
+
+// removeClient removes the client with clientID from the room of state and from the index.
+// The room is deleted and closed if it's empty afterwards. Must be called on the room's goroutine.
+func (rm *RoomManager) removeClient(state *RoomState, clientID clients.ClientID) (empty bool) {
+	rm.roomsMutex.Lock()
+	defer rm.roomsMutex.Unlock()
+
+	state.removeClient(clientID)
+	delete(rm.roomsByClient, clientID)
+
+	if !state.isEmpty() {
+		return false
+	}
+
+	rm.deleteRoom(state.room)
+	state.close()
+
+	return true
+}
+
+// leave removes the client with clientID from room and publishes ClientLeft. The room is deleted if it's empty afterwards,
+// otherwise the remaining clients are informed unless an observer left.
+func (rm *RoomManager) leave(room *Room, clientID clients.ClientID) {
+	err := room.Do(func(state *RoomState) {
+		observer := state.observerIDs[clientID]
+		empty := rm.removeClient(state, clientID)
+
+		if !observer {
+			rm.metrics.ClientLeft()
+		}
+		events.Publish(rm.bus, ClientLeft{Room: room, ClientID: clientID, Observer: observer, State: state})
+
+		if empty {
+			events.Publish(rm.bus, RoomDeleted{RoomID: room.RoomID})
+		} else if !observer {
+			state.sendDisconnectMessageToRemainingClients(clientID)
+		}
+	})
+
+	// Rooms are only closed once they are empty, so a client's room is open until the client left
+	assert.IsNil(err, "room of a joined client must not be closed")
+}
+
+// GetRoom returns the room with roomID or nil if it doesn't exist.
+func (rm *RoomManager) GetRoom(roomID RoomID) *Room {
+	rm.roomsMutex.RLock()
+	defer rm.roomsMutex.RUnlock()
+
+	return rm.rooms[roomID]
+}
+
+// RoomSnapshot is a copy of a room's state at one point in time.
+type RoomSnapshot struct {
+	RoomID      RoomID
+	ClientIDs   []clients.ClientID
+	ObserverIDs []clients.ClientID
+}
+
+// Snapshot returns a copy of all rooms and their clients.
+func (rm *RoomManager) Snapshot() []RoomSnapshot {
+	rm.roomsMutex.RLock()
+	allRooms := make([]*Room, 0, len(rm.rooms))
+	for _, room := range rm.rooms {
+		allRooms = append(allRooms, room)
+	}
+	rm.roomsMutex.RUnlock()
+
+	snapshots := make([]RoomSnapshot, 0, len(allRooms))
+	for _, room := range allRooms {
+		err := room.Do(func(state *RoomState) {
+			snapshots = append(snapshots, RoomSnapshot{RoomID: room.RoomID, ClientIDs: state.ClientIDs(), ObserverIDs: state.ObserverIDs()})
+		})
+		if err != nil {
+			// Deleted in the meantime
+			continue
+		}
+	}
+
+	return snapshots
+}
+
+// CloseRoom disconnects all clients and observers of the room with roomID.
+// The room is deleted as soon as the last client's disconnect handlers ran, an empty room is deleted immediately.
+// Returns false if the room doesn't exist.
+func (rm *RoomManager) CloseRoom(roomID RoomID, reason string) bool {
+	room := rm.GetRoom(roomID)
+	if room == nil {
+		return false
+	}
+
+	var clientIDs []clients.ClientID
+	err := room.Do(func(state *RoomState) {
+		if rm.deleteIfEmpty(state) {
+			return
+		}
+
+		clientIDs = append(state.ClientIDs(), state.ObserverIDs()...)
+	})
+	if err != nil {
+		return false
+	}
+
+	for _, clientID := range clientIDs {
+		client := rm.clientManager.GetClientByID(clientID)
+		if client != nil {
+			client.Disconnect(reason)
+		}
+	}
+
+	return true
 }
 
 // HandleConnect handles an HTTP request to establish a connection to a room.
 // The HTTP request is send to the connection package to establish a connection.
 // It is the main entry point for connecting clients.
 func (rm *RoomManager) HandleConnect(writer http.ResponseWriter, request *http.Request) {
+	rm.connect(writer, request, clients.RoleParticipant)
+}
+
+// HandleObserve is like HandleConnect but connects a hidden observer, see [clients.RoleObserver].
+// Observers receive everything broadcast to the room, but the room's clients don't learn about them.
+// The handler doesn't authenticate the request, it must only be reachable for operators, see package admin.
+func (rm *RoomManager) HandleObserve(writer http.ResponseWriter, request *http.Request) {
+	rm.connect(writer, request, clients.RoleObserver)
+}
+
+func (rm *RoomManager) connect(writer http.ResponseWriter, request *http.Request, role clients.Role) {
 	roomIDString := request.PathValue("roomID")
 	if roomIDString == "" {
 		return
 	}
 	roomID := RoomID(roomIDString)
 
-	rm.roomsMutex.Lock()
+	request = request.WithContext(logger.WithRoomID(request.Context(), string(roomID)))
 
-	room, exists := rm.rooms[roomID]
-	if !exists {
-		room = rm.createEmptyRoom(roomID)
+	client, err := rm.clientManager.NewClientWithRole(writer, request, role)
+	if err != nil {
+		log.InfoContext(request.Context(), "Failed to connect client", "error", err)
+		return
 	}
-	assert.Assert(room != nil)
 
-	rm.roomsMutex.Unlock()
+	room := rm.join(roomID, client)
 
-	client, err := rm.clientManager.NewClient(writer, request)
-	if err != nil {
-		return
+	client.RegisterDisconnectHandler(func() {
+		rm.leave(room, client.ID)
+
+		log.InfoContext(client.Context(), "Client left room")
+	})
+}
+
+// deleteIfEmpty deletes and closes the room of state if no client or observer is in it. Must be called on the room's goroutine.
+func (rm *RoomManager) deleteIfEmpty(state *RoomState) bool {
+	if !state.isEmpty() {
+		return false
 	}
 
-	room.addClient(client.ID)
+	rm.lockedDeleteRoom(state.room)
 
-	rm.notifyClientJoinHandlers(room, client)
+	state.close()
+	events.Publish(rm.bus, RoomDeleted{RoomID: state.room.RoomID})
 
-	client.RegisterDisconnectHandler(func() {
-		room.removeClient(client.ID)
-		if room.isEmpty() {
-			rm.deleteRoom(room)
-		} else {
-			room.sendDisconnectMessageToRemainingClients(client.ID)
-		}
+	return true
+}
+
+// deleteIfUnused deletes room if still no client joined it, see unusedRoomTTL.
+func (rm *RoomManager) deleteIfUnused(room *Room) {
+	// A room that was joined and left in the meantime is closed already
+	_ = room.Do(func(state *RoomState) {
+		rm.deleteIfEmpty(state)
 	})
 }
 
-// deleteRoom removes room from the list of managed rooms.
-// room must exist in the list of managed rooms.
-func (rm *RoomManager) deleteRoom(room *Room) {
+// lockedDeleteRoom is deleteRoom with the roomsMutex locked.
+func (rm *RoomManager) lockedDeleteRoom(room *Room) {
 	rm.roomsMutex.Lock()
 	defer rm.roomsMutex.Unlock()
 
+	rm.deleteRoom(room)
+}
+
+// deleteRoom removes room from the list of managed rooms.
+// room must exist in the list of managed rooms.
+// The function is not synchronized, so it must be called with the roomsMutex locked.
+func (rm *RoomManager) deleteRoom(room *Room) {
 	assert.Assert(rm.rooms[room.RoomID] != nil, "room must exist in the list of managed rooms")
 
 	delete(rm.rooms, room.RoomID)
-}
+	rm.metrics.RoomDeleted()
 
-// RegisterClientJoinHandler registers a handler function that is called when a client's connection is establisheds.
-// There is no RemoveConnectHandler function, so once a handler is registered, it cannot be removed.
-func (rm *RoomManager) RegisterClientJoinHandler(handler func(*Room, *clients.Client)) {
-	rm.clientJoinMutex.Lock()
-	defer rm.clientJoinMutex.Unlock()
+	log.DebugContext(logger.WithRoomID(context.Background(), string(room.RoomID)), "Room deleted")
+}
 
-	rm.clientJoinHandlers = append(rm.clientJoinHandlers, handler)
+type GenerateIDResponse struct {
+	RoomID RoomID `json:"roomID"`
 }
 
-func (rm *RoomManager) notifyClientJoinHandlers(room *Room, client *clients.Client) {
-	rm.clientJoinMutex.RLock()
-	defer rm.clientJoinMutex.RUnlock()
+// ErrRoomStuck is returned by CheckRooms if the goroutine of a room doesn't make progress.
+var ErrRoomStuck = errors.New("room is stuck")
+
+// CheckRooms probes the goroutines of all rooms with an empty event and returns ErrRoomStuck
+// if a room didn't process its probe within stuckAfter.
+//
+// The goroutine of a room can legitimately be busy for a while, e.g. while a broadcast waits for a slow receiver,
+// so a probe that isn't processed before ctx expires is not a failure by itself. It keeps waiting and the room is
+// only reported once its probe is older than stuckAfter, by this or a later check. stuckAfter should therefore be
+// well above the write timeout of the connections.
+func (rm *RoomManager) CheckRooms(ctx context.Context, stuckAfter time.Duration) error {
+	rm.roomsMutex.RLock()
+	rooms := make([]*Room, 0, len(rm.rooms))
+	for _, room := range rm.rooms {
+		rooms = append(rooms, room)
+	}
+	rm.roomsMutex.RUnlock()
+
+	var wg sync.WaitGroup
+	for _, room := range rooms {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			room.probe(ctx)
+		}()
+	}
+	wg.Wait()
 
-	for _, joinHandler := range rm.clientJoinHandlers {
-		joinHandler(room, client)
+	now := time.Now()
+	for _, room := range rooms {
+		if age := room.probeAge(now); age > stuckAfter {
+			return fmt.Errorf("%w: room %s didn't respond for %s", ErrRoomStuck, room.RoomID, age.Round(time.Second))
+		}
 	}
-}
 
-type GenerateIDResponse struct {
-	RoomID RoomID `json:"roomID"`
+	return nil
 }
 
+// GenerateIDHandler reserves a new room ID. The room is deleted again if no client joins it within unusedRoomTTL.
 func (rm *RoomManager) GenerateIDHandler(writer http.ResponseWriter, request *http.Request) {
 	rm.roomsMutex.Lock()
 	defer rm.roomsMutex.Unlock()
 
-	writer.Header().Set("Access-Control-Allow-Origin", "*")
-
 	maxAttempts := 10
 	for range maxAttempts {
 		roomID := GenerateRoomID()
@@ -149,7 +389,8 @@ func (rm *RoomManager) GenerateIDHandler(writer http.ResponseWriter, request *ht
 			continue
 		}
 
-		rm.createEmptyRoom(roomID)
+		room := rm.createEmptyRoom(roomID)
+		time.AfterFunc(rm.unusedRoomTTL, func() { rm.deleteIfUnused(room) })
 
 		response, err := json.Marshal(GenerateIDResponse{RoomID: roomID})
 		assert.IsNil(err, "failed to marshal response")
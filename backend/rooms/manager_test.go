@@ -0,0 +1,590 @@
+package rooms
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"bjoernblessin.de/screenecho/clients"
+	"bjoernblessin.de/screenecho/connection"
+	"bjoernblessin.de/screenecho/events"
+	"bjoernblessin.de/screenecho/metrics"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+func newTestRoomManager() *RoomManager {
+	return newTestRoomManagerWithBus(events.NewBus(events.DefaultAsyncBufferSize))
+}
+
+func newTestRoomManagerWithBus(bus *events.Bus) *RoomManager {
+	connManager := connection.NewConnectionManager(connection.Options{}, metrics.Nop{}, bus)
+	return NewRoomManager(clients.NewClientManager(connManager, nil, bus), metrics.Nop{}, bus)
+}
+
+// startServer serves the connect endpoint of rm and returns its WebSocket URL prefix.
+func startServer(t testing.TB, rm *RoomManager) string {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /room/{roomID}/connect", rm.HandleConnect)
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+// connect joins the room with roomID and waits until the join is complete.
+func connect(t testing.TB, rm *RoomManager, serverURL string, roomID RoomID) (*websocket.Conn, clients.ClientID) {
+	t.Helper()
+
+	socket, clientID, err := tryConnect(rm, serverURL, roomID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = socket.Close() })
+
+	return socket, clientID
+}
+
+// tryConnect is connect for goroutines other than the test's goroutine.
+func tryConnect(rm *RoomManager, serverURL string, roomID RoomID) (*websocket.Conn, clients.ClientID, error) {
+	socket, clientID, _, err := tryResume(rm, serverURL, roomID, "")
+	return socket, clientID, err
+}
+
+// tryResume is tryConnect with a resume token, it also returns the new resume token of the client.
+func tryResume(rm *RoomManager, serverURL string, roomID RoomID, resumeToken string) (*websocket.Conn, clients.ClientID, string, error) {
+	connectURL := fmt.Sprintf("%s/room/%s/connect?%s=%s", serverURL, roomID, clients.RESUME_QUERY_PARAMETER, resumeToken)
+	socket, _, err := websocket.DefaultDialer.Dial(connectURL, nil)
+	if err != nil {
+		return nil, clients.ClientID{}, "", err
+	}
+
+	var message connection.TypedMessage[clients.ClientIDMessage]
+	err = socket.ReadJSON(&message)
+	if err != nil || message.Type != clients.CLIENT_ID_MESSAGE_TYPE {
+		_ = socket.Close()
+		return nil, clients.ClientID{}, "", fmt.Errorf("expected client-id message, got %v (%v)", message, err)
+	}
+	clientID := clients.ClientID(uuid.MustParse(message.Msg.ClientID))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for rm.GetUsersRoom(clientID) == nil {
+		if time.Now().After(deadline) {
+			_ = socket.Close()
+			return nil, clients.ClientID{}, "", fmt.Errorf("client %s didn't join within 5s", clientID)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	return socket, clientID, message.Msg.ResumeToken, nil
+}
+
+func waitFor(t testing.TB, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met within 5s")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// assertConsistent fails if the client index doesn't match the clients of all rooms or if an empty room exists.
+func assertConsistent(t *testing.T, rm *RoomManager) {
+	t.Helper()
+
+	rm.roomsMutex.RLock()
+	index := make(map[clients.ClientID]*Room, len(rm.roomsByClient))
+	for clientID, room := range rm.roomsByClient {
+		index[clientID] = room
+	}
+	rm.roomsMutex.RUnlock()
+
+	joinedClients := 0
+	for _, snapshot := range rm.Snapshot() {
+		joined := append(snapshot.ClientIDs, snapshot.ObserverIDs...)
+		if len(joined) == 0 {
+			t.Errorf("room %s is empty but wasn't deleted", snapshot.RoomID)
+		}
+
+		for _, clientID := range joined {
+			if room := index[clientID]; room == nil || room.RoomID != snapshot.RoomID {
+				t.Errorf("client %s of room %s is not indexed", clientID, snapshot.RoomID)
+			}
+		}
+		joinedClients += len(joined)
+	}
+
+	if len(index) != joinedClients {
+		t.Errorf("index holds %d clients, rooms hold %d", len(index), joinedClients)
+	}
+}
+
+func TestGetUsersRoom(t *testing.T) {
+	rm := newTestRoomManager()
+	serverURL := startServer(t, rm)
+
+	aliceSocket, alice := connect(t, rm, serverURL, "first")
+	bobSocket, bob := connect(t, rm, serverURL, "first")
+	_, carol := connect(t, rm, serverURL, "second")
+
+	first, second := rm.GetRoom("first"), rm.GetRoom("second")
+
+	tests := []struct {
+		Name     string
+		ClientID clients.ClientID
+		Expected *Room
+	}{
+		{Name: "first room", ClientID: alice, Expected: first},
+		{Name: "same room", ClientID: bob, Expected: first},
+		{Name: "other room", ClientID: carol, Expected: second},
+		{Name: "unknown client", ClientID: clients.ClientID(uuid.New()), Expected: nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			if room := rm.GetUsersRoom(test.ClientID); room != test.Expected {
+				t.Errorf("expected room %v, got %v", test.Expected, room)
+			}
+		})
+	}
+
+	_ = aliceSocket.Close()
+	waitFor(t, func() bool { return rm.GetUsersRoom(alice) == nil })
+
+	var disconnect connection.TypedMessage[ClientDisconnectMessage]
+	err := bobSocket.ReadJSON(&disconnect)
+	if err != nil || disconnect.Type != CLIENT_DISCONNECT_MESSAGE_TYPE || disconnect.Msg.ClientID != alice.String() {
+		t.Errorf("expected client-disconnect of alice, got %v (%v)", disconnect, err)
+	}
+
+	_ = bobSocket.Close()
+	waitFor(t, func() bool { return rm.GetRoom("first") == nil })
+
+	if err := first.Do(func(*RoomState) {}); err != ErrRoomClosed {
+		t.Errorf("expected the deleted room to be closed, got %v", err)
+	}
+
+	assertConsistent(t, rm)
+}
+
+func TestRejoinAfterRoomDeleted(t *testing.T) {
+	rm := newTestRoomManager()
+	serverURL := startServer(t, rm)
+
+	aliceSocket, _ := connect(t, rm, serverURL, "room")
+	oldRoom := rm.GetRoom("room")
+
+	_ = aliceSocket.Close()
+	waitFor(t, func() bool { return rm.GetRoom("room") == nil })
+
+	_, bob := connect(t, rm, serverURL, "room")
+
+	newRoom := rm.GetUsersRoom(bob)
+	if newRoom == oldRoom || newRoom != rm.GetRoom("room") {
+		t.Fatal("expected bob in a new room after the old one was deleted")
+	}
+
+	assertConsistent(t, rm)
+}
+
+func TestCloseRoom(t *testing.T) {
+	rm := newTestRoomManager()
+	serverURL := startServer(t, rm)
+
+	socket, _ := connect(t, rm, serverURL, "room")
+
+	if !rm.CloseRoom("room", "closed by test") {
+		t.Fatal("expected the room to exist")
+	}
+
+	_, _, err := socket.ReadMessage()
+	if !websocket.IsCloseError(err, connection.ClosePolicyViolation) {
+		t.Errorf("expected policy violation close, got %v", err)
+	}
+
+	waitFor(t, func() bool { return rm.GetRoom("room") == nil })
+
+	if rm.CloseRoom("room", "closed by test") {
+		t.Error("expected the deleted room not to exist")
+	}
+}
+
+func TestGenerateIDUnusedRoom(t *testing.T) {
+	tests := []struct {
+		Name        string
+		join        bool
+		expectExist bool
+	}{
+		{Name: "Unused room is deleted", join: false, expectExist: false},
+		{Name: "Joined room is kept", join: true, expectExist: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			bus := events.NewBus(events.DefaultAsyncBufferSize)
+			rm := newTestRoomManagerWithBus(bus)
+			rm.unusedRoomTTL = 100 * time.Millisecond
+			serverURL := startServer(t, rm)
+
+			// RoomCreated is published by the room's goroutine once it runs
+			started := make(chan struct{}, 1)
+			events.Subscribe(bus, func(RoomCreated) { started <- struct{}{} })
+
+			recorder := httptest.NewRecorder()
+			rm.GenerateIDHandler(recorder, httptest.NewRequest("GET", "/room/generate-id", nil))
+
+			var response GenerateIDResponse
+			if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+
+			room := rm.GetRoom(response.RoomID)
+			if room == nil {
+				t.Fatal("expected the generated room to exist")
+			}
+			select {
+			case <-started:
+				t.Error("expected the goroutine of the unused room not to be started")
+			default:
+			}
+
+			if test.join {
+				connect(t, rm, serverURL, response.RoomID)
+			}
+
+			time.Sleep(2 * rm.unusedRoomTTL)
+			if test.expectExist {
+				if rm.GetRoom(response.RoomID) != room {
+					t.Error("expected the joined room to be kept")
+				}
+			} else {
+				waitFor(t, func() bool { return rm.GetRoom(response.RoomID) == nil })
+			}
+		})
+	}
+}
+
+func TestRoomIndexConcurrent(t *testing.T) {
+	rm := newTestRoomManager()
+	serverURL := startServer(t, rm)
+
+	const workers, joinsPerWorker = 8, 15
+	roomIDs := []RoomID{"a", "b", "c"}
+
+	var wg sync.WaitGroup
+	for w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for i := range joinsPerWorker {
+				socket, clientID, err := tryConnect(rm, serverURL, roomIDs[(w+i)%len(roomIDs)])
+				if err != nil {
+					t.Error(err)
+					return
+				}
+
+				if room := rm.GetUsersRoom(clientID); room == nil || !slices.Contains(room.ClientIDs(), clientID) {
+					t.Errorf("client %s not found in its room", clientID)
+				}
+				_ = rm.Snapshot()
+
+				_ = socket.Close()
+			}
+		}()
+	}
+	wg.Wait()
+
+	waitFor(t, func() bool { return len(rm.Snapshot()) == 0 })
+	assertConsistent(t, rm)
+}
+
+func TestRoomEvents(t *testing.T) {
+	bus := events.NewBus(events.DefaultAsyncBufferSize)
+	rm := newTestRoomManagerWithBus(bus)
+	serverURL := startServer(t, rm)
+
+	var mutex sync.Mutex
+	var received []string
+	record := func(event string) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		received = append(received, event)
+	}
+
+	events.Subscribe(bus, func(event RoomCreated) { record("created " + string(event.RoomID)) })
+	events.Subscribe(bus, func(event RoomDeleted) { record("deleted " + string(event.RoomID)) })
+	events.Subscribe(bus, func(event ClientJoined) {
+		if !event.State.Contains(event.Client.ID) {
+			t.Error("expected the joined client in the room's state")
+		}
+		record("joined")
+	})
+	events.Subscribe(bus, func(event ClientLeft) {
+		if event.State.Contains(event.ClientID) {
+			t.Error("expected the left client not in the room's state")
+		}
+		record("left")
+	})
+
+	aliceSocket, _ := connect(t, rm, serverURL, "room")
+	bobSocket, _ := connect(t, rm, serverURL, "room")
+
+	_ = aliceSocket.Close()
+	_ = bobSocket.Close()
+	waitFor(t, func() bool { return rm.GetRoom("room") == nil })
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	expected := []string{"created room", "joined", "joined", "left", "left", "deleted room"}
+	if !slices.Equal(received, expected) {
+		t.Errorf("expected %v, got %v", expected, received)
+	}
+}
+
+func TestJoinPanicReleasesLock(t *testing.T) {
+	rm := newTestRoomManager()
+	serverURL := startServer(t, rm)
+
+	socket, clientID := connect(t, rm, serverURL, "first")
+	client := rm.clientManager.GetClientByID(clientID)
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("expected a panic for a client joining a second room")
+			}
+		}()
+		rm.join("second", client)
+	}()
+
+	looked := make(chan *Room)
+	go func() { looked <- rm.GetUsersRoom(client.ID) }()
+
+	select {
+	case room := <-looked:
+		if room == nil || room.RoomID != "first" {
+			t.Errorf("expected the client in room first, got %v", room)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the rooms are still locked after the panic")
+	}
+
+	_ = socket.Close()
+	waitFor(t, func() bool { return rm.GetRoom("first") == nil && rm.GetUsersRoom(clientID) == nil })
+}
+
+func TestRoomDo(t *testing.T) {
+	room := NewRoom("room", nil)
+
+	// counter is only accessed on the room's goroutine, the race detector complains if events overlap
+	counter := 0
+
+	var wg sync.WaitGroup
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = room.Do(func(*RoomState) { counter++ })
+		}()
+	}
+	wg.Wait()
+
+	_ = room.Do(func(*RoomState) {
+		if counter != 50 {
+			t.Errorf("expected 50 events, got %d", counter)
+		}
+	})
+
+	func() {
+		defer func() {
+			if recovered := recover(); recovered == nil {
+				t.Error("expected the panic of the event to be re-raised")
+			}
+		}()
+		_ = room.Do(func(*RoomState) { panic("invariant violated") })
+	}()
+
+	if err := room.Do(func(state *RoomState) { state.close() }); err != nil {
+		t.Errorf("expected the closing event to run, got %v", err)
+	}
+	if err := room.Do(func(*RoomState) { t.Error("event ran after the room was closed") }); err != ErrRoomClosed {
+		t.Errorf("expected ErrRoomClosed, got %v", err)
+	}
+}
+
+func TestCheckRooms(t *testing.T) {
+	rm := newTestRoomManager()
+	rm.roomsMutex.Lock()
+	room := rm.createEmptyRoom("busy")
+	rm.createEmptyRoom("idle")
+	rm.roomsMutex.Unlock()
+
+	check := func(stuckAfter time.Duration) error {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		return rm.CheckRooms(ctx, stuckAfter)
+	}
+
+	if err := check(time.Hour); err != nil {
+		t.Fatalf("expected idle rooms to pass, got %v", err)
+	}
+
+	// The room's goroutine is busy until release is closed, e.g. like a broadcast waiting for a slow receiver
+	release := make(chan struct{})
+	go func() { _ = room.Do(func(*RoomState) { <-release }) }()
+	time.Sleep(10 * time.Millisecond)
+
+	if err := check(time.Hour); err != nil {
+		t.Errorf("expected a room busy for less than stuckAfter to pass, got %v", err)
+	}
+	if err := check(20 * time.Millisecond); !errors.Is(err, ErrRoomStuck) {
+		t.Errorf("expected ErrRoomStuck once the probe is older than stuckAfter, got %v", err)
+	}
+
+	close(release)
+	waitFor(t, func() bool { return check(20*time.Millisecond) == nil })
+}
+
+func TestResumeKeepsIndexConsistent(t *testing.T) {
+	bus := events.NewBus(events.DefaultAsyncBufferSize)
+	rm := newTestRoomManagerWithBus(bus)
+	serverURL := startServer(t, rm)
+
+	// Subscribed after the ClientManager, so the closed client is resumable once this is called
+	closed := make(chan struct{}, 10)
+	events.Subscribe(bus, func(connection.ConnectionClosed) { closed <- struct{}{} })
+
+	connect(t, rm, serverURL, "first")
+
+	socket, clientID, resumeToken, err := tryResume(rm, serverURL, "first", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = socket.Close()
+	<-closed
+
+	tests := []struct {
+		Name   string
+		RoomID RoomID
+	}{
+		{Name: "same room", RoomID: "first"},
+		{Name: "other room", RoomID: "second"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			socket, resumedID, newResumeToken, err := tryResume(rm, serverURL, test.RoomID, resumeToken)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				_ = socket.Close()
+				<-closed
+			}()
+
+			if resumedID != clientID {
+				t.Fatalf("expected the client to resume ID %s, got %s", clientID, resumedID)
+			}
+			if newResumeToken == resumeToken {
+				t.Error("expected a new resume token")
+			}
+			if room := rm.GetUsersRoom(clientID); room == nil || room.RoomID != test.RoomID {
+				t.Errorf("expected the client to be indexed in room %s, got %v", test.RoomID, room)
+			}
+			assertConsistent(t, rm)
+
+			resumeToken = newResumeToken
+		})
+	}
+
+	t.Run("used token", func(t *testing.T) {
+		first, firstID, _, err := tryResume(rm, serverURL, "first", resumeToken)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer first.Close()
+		second, secondID, _, err := tryResume(rm, serverURL, "first", resumeToken)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer second.Close()
+
+		if firstID != clientID || secondID == clientID {
+			t.Errorf("expected only the first connection to resume ID %s, got %s and %s", clientID, firstID, secondID)
+		}
+		assertConsistent(t, rm)
+	})
+
+	waitFor(t, func() bool { return rm.GetRoom("second") == nil })
+	assertConsistent(t, rm)
+}
+
+// scanUsersRoom finds the room of a client by asking every room for its clients, which is what GetUsersRoom
+// would have to do without the index. It's the benchmark baseline.
+func scanUsersRoom(rm *RoomManager, clientID clients.ClientID) *Room {
+	rm.roomsMutex.RLock()
+	allRooms := make([]*Room, 0, len(rm.rooms))
+	for _, room := range rm.rooms {
+		allRooms = append(allRooms, room)
+	}
+	rm.roomsMutex.RUnlock()
+
+	for _, room := range allRooms {
+		if slices.Contains(room.ClientIDs(), clientID) {
+			return room
+		}
+	}
+
+	return nil
+}
+
+func BenchmarkGetUsersRoom(b *testing.B) {
+	const clientsPerRoom = 4
+
+	for _, roomCount := range []int{10, 100, 1000} {
+		rm := newTestRoomManager()
+
+		// Clients are added without connections, only the lookup is measured
+		clientIDs := make([]clients.ClientID, 0, roomCount*clientsPerRoom)
+		for r := range roomCount {
+			room := NewRoom(RoomID(fmt.Sprint(r)), nil)
+			rm.rooms[room.RoomID] = room
+
+			for range clientsPerRoom {
+				clientID := clients.ClientID(uuid.New())
+				_ = room.Do(func(state *RoomState) { state.addClient(clientID) })
+				rm.roomsByClient[clientID] = room
+				clientIDs = append(clientIDs, clientID)
+			}
+		}
+
+		b.Run(fmt.Sprintf("index/rooms=%d", roomCount), func(b *testing.B) {
+			for i := 0; b.Loop(); i++ {
+				_ = rm.GetUsersRoom(clientIDs[i%len(clientIDs)])
+			}
+		})
+
+		b.Run(fmt.Sprintf("scan/rooms=%d", roomCount), func(b *testing.B) {
+			for i := 0; b.Loop(); i++ {
+				_ = scanUsersRoom(rm, clientIDs[i%len(clientIDs)])
+			}
+		})
+	}
+}
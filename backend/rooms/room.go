@@ -1,88 +1,320 @@
 package rooms
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"bjoernblessin.de/screenecho/clients"
 	"bjoernblessin.de/screenecho/connection"
 	"bjoernblessin.de/screenecho/util/assert"
+	"bjoernblessin.de/screenecho/wire"
 )
 
 type RoomID string
 
 // Room holds a collection of joined clients.
+//
+// The state of a room (its clients and the values other packages attach to it, e.g. active streams) is owned by
+// a single goroutine that processes one event after another. Events are submitted with [Room.Do], so everything
+// that happens in a room is serialized: a client that joins sees either all or nothing of a concurrent broadcast.
 type Room struct {
-	RoomID         RoomID
-	clientIDs      map[clients.ClientID]bool
-	clientIDsMutex sync.RWMutex
-	clientManager  *clients.ClientManager
+	RoomID        RoomID
+	clientManager *clients.ClientManager
+	// startOnce starts the room's goroutine with the first Do, so rooms that are never used cost no goroutine.
+	startOnce sync.Once
+	// pending holds the events posted before the goroutine started, they are processed first.
+	pending []*roomEvent
+	// events is the queue of the room's goroutine, it's created when the goroutine starts.
+	// It's never closed, done is closed instead.
+	events chan *roomEvent
+	// done is closed after the room's goroutine stopped. Events submitted afterwards are rejected.
+	done chan struct{}
+	// state must only be accessed by the room's goroutine.
+	state *RoomState
+	// probeStarted is the time in Unix nanoseconds the in-flight probe was submitted, 0 if there is none, see [Room.probe].
+	probeStarted atomic.Int64
 }
 
-const CLIENT_DISCONNECT_MESSAGE_TYPE = "client-disconnect"
+// RoomState is the state of a room. It's only valid inside functions passed to [Room.Do].
+type RoomState struct {
+	room      *Room
+	clientIDs map[clients.ClientID]bool
+	// observerIDs holds the hidden observers of the room, see [clients.RoleObserver]. They only receive broadcasts.
+	observerIDs map[clients.ClientID]bool
+	values      map[any]any
+	// closed stops the room's goroutine after the current event.
+	closed bool
+}
 
-type clientDisconnectMessage struct {
-	ClientID string `json:"clientID"`
+type roomEvent struct {
+	fn   func(*RoomState)
+	done chan struct{}
+	// panicked holds the description of a panic of fn, it's re-raised by Do in the submitting goroutine.
+	panicked any
 }
 
+// roomQueueSize is the number of events that can be queued before Do blocks.
+const roomQueueSize = 64
+
+// ErrRoomClosed is returned by Do if the room was deleted.
+var ErrRoomClosed = errors.New("room is closed")
+
+const CLIENT_DISCONNECT_MESSAGE_TYPE = wire.CLIENT_DISCONNECT_MESSAGE_TYPE
+
+// ClientDisconnectMessage is sent to the remaining clients of a room after a client left.
+type ClientDisconnectMessage = wire.ClientDisconnectMessage
+
+// NewRoom creates a room. Its goroutine is started by the first [Room.Do] and stops once the room is closed, see [RoomState.close].
 func NewRoom(roomID RoomID, clientManager *clients.ClientManager) *Room {
-	return &Room{
+	room := &Room{
 		RoomID:        roomID,
-		clientIDs:     make(map[clients.ClientID]bool),
 		clientManager: clientManager,
+		done:          make(chan struct{}),
 	}
+	room.state = &RoomState{
+		room:        room,
+		clientIDs:   make(map[clients.ClientID]bool),
+		observerIDs: make(map[clients.ClientID]bool),
+		values:      make(map[any]any),
+	}
+
+	return room
 }
 
-func (room *Room) addClient(clientID clients.ClientID) {
-	room.clientIDsMutex.Lock()
-	defer room.clientIDsMutex.Unlock()
+// Do runs fn on the room's goroutine and waits until it returned.
+// Functions passed to Do from different goroutines run one after another in the order they were queued.
+//
+// If the room is closed, fn is not run and ErrRoomClosed is returned.
+// A panic of fn is re-raised in the calling goroutine, the room keeps running.
+//
+// Do must not be called from the room's own goroutine, i.e. from inside fn or a synchronous subscriber of the room's events,
+// as it would wait for itself.
+func (room *Room) Do(fn func(*RoomState)) error {
+	room.startOnce.Do(room.start)
+
+	event := &roomEvent{fn: fn, done: make(chan struct{})}
+
+	select {
+	case room.events <- event:
+	case <-room.done:
+		return ErrRoomClosed
+	}
 
-	assert.Assert(room.clientIDs[clientID] == false, "couldn't add client because client already joined the room")
+	select {
+	case <-event.done:
+	case <-room.done:
+		// The room closed while the event was queued, it was processed only if it closed the room itself
+		select {
+		case <-event.done:
+		default:
+			return ErrRoomClosed
+		}
+	}
+
+	if event.panicked != nil {
+		panic(event.panicked)
+	}
 
-	room.clientIDs[clientID] = true
+	return nil
 }
 
-// removeClient removes the client with clientID from the room.
-// If the client is not part of the room, the method has no effect.
-func (room *Room) removeClient(clientID clients.ClientID) {
-	room.clientIDsMutex.Lock()
-	defer room.clientIDsMutex.Unlock()
+// post queues fn without waiting for it and without starting the room's goroutine.
+// It's only used right after the room was created, before the room is shared with other goroutines.
+func (room *Room) post(fn func(*RoomState)) {
+	room.pending = append(room.pending, &roomEvent{fn: fn, done: make(chan struct{})})
+}
 
-	delete(room.clientIDs, clientID)
+func (room *Room) start() {
+	room.events = make(chan *roomEvent, roomQueueSize)
+	go room.run()
 }
 
-// Broadcast sends a websocket message to all clients in the room except for the sender.
-// The sender can be nil, effectively broadcasting to all clients.
-// See also [connection.SendMessage].
-func Broadcast[T any](room *Room, msg connection.TypedMessage[T], senderClientID clients.ClientID) {
-	room.clientIDsMutex.Lock()
-	defer room.clientIDsMutex.Unlock()
+// run processes the pending events and then the queued events of the room until the room is closed.
+func (room *Room) run() {
+	defer close(room.done)
+
+	for _, event := range room.pending {
+		room.process(event)
+
+		if room.state.closed {
+			return
+		}
+	}
+	room.pending = nil
+
+	for event := range room.events {
+		room.process(event)
 
-	for clientID := range room.clientIDs {
-		if clientID == senderClientID {
-			continue
+		if room.state.closed {
+			return
 		}
+	}
+}
+
+func (room *Room) process(event *roomEvent) {
+	defer close(event.done)
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			event.panicked = fmt.Sprintf("%v (recovered on the goroutine of room %s)\n%s", recovered, room.RoomID, debug.Stack())
+		}
+	}()
+
+	event.fn(room.state)
+}
+
+// probe submits an empty event, unless a previous probe is still in flight, and waits until it's processed or ctx expires.
+// A probe that isn't processed in time keeps waiting, see [Room.probeAge].
+func (room *Room) probe(ctx context.Context) {
+	if !room.probeStarted.CompareAndSwap(0, time.Now().UnixNano()) {
+		return
+	}
+
+	processed := make(chan struct{})
+	go func() {
+		defer close(processed)
+
+		// A closed room rejects the probe right away, it has no goroutine that could be stuck
+		_ = room.Do(func(*RoomState) {})
+		room.probeStarted.Store(0)
+	}()
+
+	select {
+	case <-processed:
+	case <-ctx.Done():
+	}
+}
+
+// probeAge returns how long the in-flight probe has been waiting at now, 0 if there is none.
+func (room *Room) probeAge(now time.Time) time.Duration {
+	started := room.probeStarted.Load()
+	if started == 0 {
+		return 0
+	}
 
-		receiver := room.clientManager.GetClientByID(clientID)
-		assert.IsNotNil(receiver)
+	return now.Sub(time.Unix(0, started))
+}
+
+// ClientIDs returns a copy of the IDs of all clients in the room.
+// A closed room has no clients.
+func (room *Room) ClientIDs() []clients.ClientID {
+	var clientIDs []clients.ClientID
+
+	_ = room.Do(func(state *RoomState) {
+		clientIDs = state.ClientIDs()
+	})
+
+	return clientIDs
+}
+
+// Room returns the room the state belongs to.
+func (state *RoomState) Room() *Room {
+	return state.room
+}
+
+// ClientIDs returns a copy of the IDs of all clients in the room. Observers are not included.
+func (state *RoomState) ClientIDs() []clients.ClientID {
+	return mapKeys(state.clientIDs)
+}
+
+// ObserverIDs returns a copy of the IDs of all observers of the room.
+func (state *RoomState) ObserverIDs() []clients.ClientID {
+	return mapKeys(state.observerIDs)
+}
+
+func mapKeys(ids map[clients.ClientID]bool) []clients.ClientID {
+	keys := make([]clients.ClientID, 0, len(ids))
+	for id := range ids {
+		keys = append(keys, id)
+	}
+
+	return keys
+}
+
+// Contains reports whether the client with clientID joined the room. Observers are not included.
+func (state *RoomState) Contains(clientID clients.ClientID) bool {
+	return state.clientIDs[clientID]
+}
+
+// Value returns the value stored for key or nil.
+// Other packages use values to keep their per-room state in the room, so that it's serialized with joins and leaves.
+// Use an unexported key type to avoid collisions, like with [context.Context].
+func (state *RoomState) Value(key any) any {
+	return state.values[key]
+}
 
-		clients.SendMessage(receiver, msg)
+// SetValue stores value for key. A nil value deletes the key.
+func (state *RoomState) SetValue(key any, value any) {
+	if value == nil {
+		delete(state.values, key)
+		return
 	}
+
+	state.values[key] = value
+}
+
+func (state *RoomState) addClient(clientID clients.ClientID) {
+	assert.Assert(state.clientIDs[clientID] == false, "couldn't add client because client already joined the room")
+
+	state.clientIDs[clientID] = true
+}
+
+func (state *RoomState) addObserver(clientID clients.ClientID) {
+	assert.Assert(state.observerIDs[clientID] == false, "couldn't add observer because observer already joined the room")
+
+	state.observerIDs[clientID] = true
+}
+
+// removeClient removes the client or observer with clientID from the room.
+// If the client is not part of the room, the method has no effect.
+func (state *RoomState) removeClient(clientID clients.ClientID) {
+	delete(state.clientIDs, clientID)
+	delete(state.observerIDs, clientID)
 }
 
-func (room *Room) isEmpty() bool {
-	room.clientIDsMutex.RLock()
-	defer room.clientIDsMutex.RUnlock()
+// isEmpty reports whether neither clients nor observers are in the room. Observers keep a room alive.
+func (state *RoomState) isEmpty() bool {
+	return len(state.clientIDs) == 0 && len(state.observerIDs) == 0
+}
+
+// close stops the room's goroutine after the current event. Later calls of Do return ErrRoomClosed.
+func (state *RoomState) close() {
+	state.closed = true
+}
 
-	return len(room.clientIDs) == 0
+// Broadcast sends a websocket message to all clients and observers in the room except for the sender.
+// The sender can be the zero ClientID, effectively broadcasting to all clients.
+// A receiver that doesn't receive within the write timeout is disconnected, see [connection.Options.WriteTimeout],
+// so a stalled client holds up the room's goroutine at most once.
+func Broadcast[T any](state *RoomState, msg connection.TypedMessage[T], senderClientID clients.ClientID) {
+	for _, ids := range []map[clients.ClientID]bool{state.clientIDs, state.observerIDs} {
+		for clientID := range ids {
+			if clientID == senderClientID {
+				continue
+			}
+
+			receiver := state.room.clientManager.GetClientByID(clientID)
+			if receiver == nil {
+				// The client's connection is closed, it leaves the room with one of the next events
+				continue
+			}
+
+			clients.SendMessage(receiver, msg)
+		}
+	}
 }
 
-func (room *Room) sendDisconnectMessageToRemainingClients(clientID clients.ClientID) {
-	msg := connection.TypedMessage[clientDisconnectMessage]{
+func (state *RoomState) sendDisconnectMessageToRemainingClients(clientID clients.ClientID) {
+	msg := connection.TypedMessage[ClientDisconnectMessage]{
 		Type: CLIENT_DISCONNECT_MESSAGE_TYPE,
-		Msg: clientDisconnectMessage{
+		Msg: ClientDisconnectMessage{
 			ClientID: clientID.String(),
 		},
 	}
 
-	Broadcast(room, msg, clientID)
+	Broadcast(state, msg, clientID)
 }
@@ -0,0 +1,200 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"bjoernblessin.de/screenecho/clients"
+	"bjoernblessin.de/screenecho/config"
+	"bjoernblessin.de/screenecho/connection"
+	"bjoernblessin.de/screenecho/health"
+	"bjoernblessin.de/screenecho/middleware"
+)
+
+// Option configures a [Server], see [New].
+type Option func(*options)
+
+type options struct {
+	addr              string
+	readHeaderTimeout time.Duration
+	idleTimeout       time.Duration
+	shutdownTimeout   time.Duration
+	reconnectAfter    time.Duration
+
+	limits Limits
+	tls    *TLS
+	codec  connection.Codec
+
+	sessions clients.SessionStorage
+
+	adminToken     string
+	allowedOrigins []string
+	devMode        bool
+
+	accessLogFormat middleware.AccessLogFormat
+	accessLogOut    io.Writer
+	middlewares     []func(http.Handler) http.Handler
+	dependencies    []dependency
+}
+
+type dependency struct {
+	name  string
+	check health.Check
+}
+
+// Limits bound the resources of the WebSocket connections.
+type Limits struct {
+	// MaxConnections is the connection ceiling above which the server reports that it's not ready. 0 means no ceiling.
+	MaxConnections int
+	// MaxMessageSize is the maximum size in bytes of an inbound message, larger messages close the connection. 0 means no limit.
+	MaxMessageSize int64
+	// ReadBufferSize and WriteBufferSize are the I/O buffer sizes in bytes of a connection.
+	ReadBufferSize  int
+	WriteBufferSize int
+	// HandshakeTimeout is the maximum duration of the WebSocket handshake. 0 means no timeout.
+	HandshakeTimeout time.Duration
+	// WriteTimeout is the time a client has to receive a message before it's disconnected. 0 means no timeout.
+	WriteTimeout time.Duration
+	// CloseOnPanic closes only the connection whose message handler panicked, see [connection.Options.CloseOnPanic].
+	CloseOnPanic bool
+}
+
+// TLS configures the server to terminate TLS itself.
+type TLS struct {
+	CertFile string
+	KeyFile  string
+	// MinVersion is "1.2" or "1.3", CipherSuites are the names of the allowed TLS 1.2 cipher suites, see package tlsconfig.
+	MinVersion   string
+	CipherSuites []string
+	// ReloadInterval is the interval in which the certificate files are checked for changes.
+	ReloadInterval time.Duration
+	// RedirectAddr is the address of a plain HTTP listener that redirects to HTTPS. Empty disables the listener.
+	RedirectAddr string
+}
+
+// defaultOptions match the defaults of package config.
+func defaultOptions() options {
+	return options{
+		addr:              ":8080",
+		readHeaderTimeout: 10 * time.Second,
+		idleTimeout:       2 * time.Minute,
+		shutdownTimeout:   10 * time.Second,
+		reconnectAfter:    5 * time.Second,
+		limits: Limits{
+			MaxMessageSize:   64 << 10,
+			ReadBufferSize:   4 << 10,
+			WriteBufferSize:  4 << 10,
+			HandshakeTimeout: 10 * time.Second,
+			WriteTimeout:     10 * time.Second,
+			CloseOnPanic:     true,
+		},
+		accessLogFormat: middleware.AccessLogDefault,
+		accessLogOut:    os.Stdout,
+	}
+}
+
+// WithConfig applies all settings of cfg. Options after it override single settings.
+func WithConfig(cfg *config.Config) Option {
+	return func(o *options) {
+		o.addr = cfg.Addr
+		o.readHeaderTimeout = cfg.ReadHeaderTimeout
+		o.idleTimeout = cfg.IdleTimeout
+		o.shutdownTimeout = cfg.ShutdownTimeout
+		o.reconnectAfter = cfg.ReconnectAfter
+		o.limits = Limits{
+			MaxConnections:   cfg.MaxConnections,
+			MaxMessageSize:   int64(cfg.WebSocketMaxMessageSize),
+			ReadBufferSize:   int(cfg.WebSocketReadBufferSize),
+			WriteBufferSize:  int(cfg.WebSocketWriteBufferSize),
+			HandshakeTimeout: cfg.WebSocketHandshakeTimeout,
+			WriteTimeout:     cfg.WebSocketWriteTimeout,
+			CloseOnPanic:     cfg.WebSocketCloseOnPanic,
+		}
+		o.adminToken = cfg.AdminToken
+		o.allowedOrigins = cfg.AllowedOrigins
+		o.devMode = cfg.DevMode
+		o.accessLogFormat = middleware.AccessLogFormat(cfg.AccessLogFormat)
+
+		o.tls = nil
+		if cfg.TLSEnabled() {
+			o.tls = &TLS{
+				CertFile:       cfg.TLSCertFile,
+				KeyFile:        cfg.TLSKeyFile,
+				MinVersion:     cfg.TLSMinVersion,
+				CipherSuites:   cfg.TLSCipherSuites,
+				ReloadInterval: cfg.TLSReloadInterval,
+				RedirectAddr:   cfg.HTTPRedirectAddr,
+			}
+		}
+	}
+}
+
+// WithAddr sets the address [Server.Run] listens on, ":8080" by default.
+func WithAddr(addr string) Option {
+	return func(o *options) { o.addr = addr }
+}
+
+// WithTimeouts sets the timeouts of the HTTP server and the time [Server.Run] has to shut down.
+func WithTimeouts(readHeader time.Duration, idle time.Duration, shutdown time.Duration) Option {
+	return func(o *options) {
+		o.readHeaderTimeout, o.idleTimeout, o.shutdownTimeout = readHeader, idle, shutdown
+	}
+}
+
+// WithReconnectAfter sets the delay clients are told to wait before reconnecting after a shutdown, 5s by default.
+func WithReconnectAfter(d time.Duration) Option {
+	return func(o *options) { o.reconnectAfter = d }
+}
+
+// WithLimits replaces the limits of the WebSocket connections.
+func WithLimits(limits Limits) Option {
+	return func(o *options) { o.limits = limits }
+}
+
+// WithCodec sets the codec of the WebSocket messages, [connection.JSONCodec] by default.
+// The clients must use the same encoding.
+func WithCodec(codec connection.Codec) Option {
+	return func(o *options) { o.codec = codec }
+}
+
+// WithStorage sets where the sessions of disconnected clients are kept until they resume, in memory by default.
+// A shared storage lets clients resume on another instance or after a restart, see [clients.SessionStorage].
+func WithStorage(storage clients.SessionStorage) Option {
+	return func(o *options) { o.sessions = storage }
+}
+
+// WithTLS makes [Server.Run] terminate TLS itself.
+func WithTLS(tls TLS) Option {
+	return func(o *options) { o.tls = &tls }
+}
+
+// WithAdminToken enables the admin API and observers, see package admin. An empty token disables them.
+func WithAdminToken(token string) Option {
+	return func(o *options) { o.adminToken = token }
+}
+
+// WithAllowedOrigins sets the origins allowed for CORS and WebSocket handshakes, see [origin.NewAllowlist].
+// allowLocalhost allows every localhost origin additionally, like the dev mode.
+func WithAllowedOrigins(patterns []string, allowLocalhost bool) Option {
+	return func(o *options) { o.allowedOrigins, o.devMode = patterns, allowLocalhost }
+}
+
+// WithAccessLog sets the format of the access log and where common and json entries are written, see [middleware.Logging].
+// An empty format disables the access log.
+func WithAccessLog(format middleware.AccessLogFormat, out io.Writer) Option {
+	return func(o *options) { o.accessLogFormat, o.accessLogOut = format, out }
+}
+
+// WithMiddleware adds middlewares around the routes of the server. They run after the built-in middlewares
+// (request ID, access log, panic recovery and CORS), the first one outermost. The health probes bypass them.
+func WithMiddleware(middlewares ...func(http.Handler) http.Handler) Option {
+	return func(o *options) { o.middlewares = append(o.middlewares, middlewares...) }
+}
+
+// WithDependency adds a readiness check of an external dependency, e.g. the storage or pub/sub backend of an
+// embedding service, see [health.Checker.AddDependency].
+func WithDependency(name string, check health.Check) Option {
+	return func(o *options) { o.dependencies = append(o.dependencies, dependency{name: name, check: check}) }
+}
@@ -0,0 +1,282 @@
+// Package server assembles the managers and routes of ScreenEcho into a [Server].
+//
+// A Server is an [http.Handler], so it can be mounted into another Go service or an [httptest.Server].
+// [Server.Run] runs it standalone on its own address, like the screenecho command does:
+//
+//	srv := server.New(server.WithConfig(cfg))
+//	err := srv.Run(ctx) // until ctx is canceled, then shuts down gracefully
+//
+// Embedding services configure it with options instead:
+//
+//	srv := server.New(
+//	    server.WithAdminToken(token),
+//	    server.WithMiddleware(authenticate),
+//	    server.WithStorage(sessions),
+//	    server.WithDependency("database", db.PingContext),
+//	)
+//	mux.Handle("/screenecho/", http.StripPrefix("/screenecho", srv))
+//	...
+//	srv.Shutdown(ctx)
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"bjoernblessin.de/screenecho/admin"
+	"bjoernblessin.de/screenecho/clients"
+	"bjoernblessin.de/screenecho/connection"
+	"bjoernblessin.de/screenecho/events"
+	"bjoernblessin.de/screenecho/health"
+	"bjoernblessin.de/screenecho/metrics"
+	"bjoernblessin.de/screenecho/middleware"
+	"bjoernblessin.de/screenecho/protocol"
+	"bjoernblessin.de/screenecho/rooms"
+	"bjoernblessin.de/screenecho/signaling"
+	"bjoernblessin.de/screenecho/streams"
+	"bjoernblessin.de/screenecho/tlsconfig"
+	"bjoernblessin.de/screenecho/util/logger"
+	"bjoernblessin.de/screenecho/util/origin"
+)
+
+var log = logger.New("server")
+
+// minRoomStuckAfter is the least time a room may not respond before the liveness probe fails.
+// A room's goroutine waits up to the write timeout for every stalled receiver of a broadcast, so the bound grows with it.
+const minRoomStuckAfter = time.Minute
+
+// Server is a ScreenEcho server. It serves the rooms, the admin API, metrics and health probes.
+type Server struct {
+	options options
+
+	metrics       *metrics.Registry
+	connManager   *connection.ConnectionManager
+	clientManager *clients.ClientManager
+	roomManager   *rooms.RoomManager
+	streamManager *streams.StreamManager
+	handler       http.Handler
+
+	// httpServers holds the servers started by Serve, they are shut down by Shutdown.
+	httpServers      []*http.Server
+	httpServersMutex sync.Mutex
+}
+
+// New creates a server configured by opts. It doesn't listen until [Server.Run] or [Server.Serve] is called.
+func New(opts ...Option) *Server {
+	o := defaultOptions()
+	for _, apply := range opts {
+		apply(&o)
+	}
+
+	s := &Server{options: o}
+
+	allowlist := origin.NewAllowlist(o.allowedOrigins, o.devMode)
+
+	s.metrics = metrics.NewRegistry()
+
+	bus := events.NewBus(events.DefaultAsyncBufferSize)
+
+	s.connManager = connection.NewConnectionManager(connection.Options{
+		CheckOrigin:      allowlist.CheckOrigin,
+		ReadBufferSize:   o.limits.ReadBufferSize,
+		WriteBufferSize:  o.limits.WriteBufferSize,
+		HandshakeTimeout: o.limits.HandshakeTimeout,
+		WriteTimeout:     o.limits.WriteTimeout,
+		MaxMessageSize:   o.limits.MaxMessageSize,
+		CloseOnPanic:     o.limits.CloseOnPanic,
+		Codec:            o.codec,
+	}, s.metrics, bus)
+
+	s.clientManager = clients.NewClientManager(s.connManager, o.sessions, bus)
+
+	s.roomManager = rooms.NewRoomManager(s.clientManager, s.metrics, bus)
+
+	s.streamManager = streams.NewStreamManager(s.clientManager, s.roomManager, s.metrics, bus)
+
+	signaling.NewSignalingManager(s.clientManager)
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /room/{roomID}/connect", s.roomManager.HandleConnect)
+	mux.HandleFunc("GET /room/generate-id", s.roomManager.GenerateIDHandler)
+	mux.Handle("GET /metrics", s.metrics)
+	mux.Handle("GET /protocol/asyncapi.json", protocol.Handler(s.clientManager))
+
+	if o.adminToken != "" {
+		admin.NewAPI(o.adminToken, s.roomManager, s.clientManager, s.streamManager).Register(mux)
+	} else {
+		log.Info("Admin token not set, admin API is disabled")
+	}
+
+	roomStuckAfter := max(minRoomStuckAfter, 6*o.limits.WriteTimeout)
+	checkRooms := func(ctx context.Context) error { return s.roomManager.CheckRooms(ctx, roomStuckAfter) }
+	healthChecker := health.NewChecker(s.connManager, checkRooms, o.limits.MaxConnections)
+	for _, dependency := range o.dependencies {
+		healthChecker.AddDependency(dependency.name, dependency.check)
+	}
+
+	// The first middleware is the outermost
+	chain := []func(http.Handler) http.Handler{}
+	if o.accessLogFormat != "" {
+		chain = append(chain, middleware.Logging(o.accessLogFormat, o.accessLogOut))
+	}
+	chain = append(chain, middleware.Recover(s.metrics), middleware.CORS(allowlist))
+	chain = append(chain, o.middlewares...)
+
+	var routes http.Handler = mux
+	for i := len(chain) - 1; i >= 0; i-- {
+		routes = chain[i](routes)
+	}
+
+	// Probes are polled every few seconds, so they bypass CORS and request logging
+	rootMux := http.NewServeMux()
+	rootMux.HandleFunc("GET /healthz", healthChecker.HandleLiveness)
+	rootMux.HandleFunc("GET /readyz", healthChecker.HandleReadiness)
+	rootMux.Handle("/", middleware.RequestID(routes))
+
+	s.handler = rootMux
+
+	return s
+}
+
+// ServeHTTP serves the routes of the server.
+func (s *Server) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	s.handler.ServeHTTP(writer, request)
+}
+
+// Run listens on the configured address and serves until ctx is done, then shuts down gracefully within the
+// configured shutdown timeout. It returns the error that stopped the server early, nil after a shutdown.
+// TLS is set up by Run as well, so invalid certificates are reported here.
+func (s *Server) Run(ctx context.Context) error {
+	listener, err := net.Listen("tcp", s.options.addr)
+	if err != nil {
+		return err
+	}
+
+	return s.Serve(ctx, listener)
+}
+
+// Serve is like Run but accepts the connections of listener.
+func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
+	log.Info("Running...", "addr", listener.Addr().String(), "tls", s.options.tls != nil)
+
+	server := &http.Server{
+		Handler:           s,
+		ReadHeaderTimeout: s.options.readHeaderTimeout,
+		IdleTimeout:       s.options.idleTimeout,
+	}
+
+	serveErrs := make(chan error, 2)
+	serve := func() error { return server.Serve(listener) }
+
+	if s.options.tls != nil {
+		reloader, err := tlsconfig.NewCertReloader(s.options.tls.CertFile, s.options.tls.KeyFile)
+		if err != nil {
+			_ = listener.Close()
+			return fmt.Errorf("load TLS certificate: %w", err)
+		}
+		go reloader.Watch(ctx, s.options.tls.ReloadInterval)
+
+		server.TLSConfig, err = tlsconfig.New(reloader, s.options.tls.MinVersion, s.options.tls.CipherSuites)
+		if err != nil {
+			_ = listener.Close()
+			return fmt.Errorf("invalid TLS configuration: %w", err)
+		}
+
+		if s.options.tls.RedirectAddr != "" {
+			_, httpsPort, _ := net.SplitHostPort(listener.Addr().String())
+
+			redirectServer := &http.Server{
+				Addr:              s.options.tls.RedirectAddr,
+				Handler:           tlsconfig.RedirectHandler(httpsPort),
+				ReadHeaderTimeout: s.options.readHeaderTimeout,
+			}
+			s.track(redirectServer)
+			go func() { serveErrs <- redirectServer.ListenAndServe() }()
+		}
+
+		// Certificates are provided by TLSConfig.GetCertificate
+		serve = func() error { return server.ServeTLS(listener, "", "") }
+	}
+
+	s.track(server)
+	go func() { serveErrs <- serve() }()
+
+	var err error
+	select {
+	case <-ctx.Done():
+	case err = <-serveErrs:
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), s.options.shutdownTimeout)
+	defer cancel()
+	// Failures are logged, the server stops either way
+	_ = s.Shutdown(shutdownCtx)
+
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+
+	return err
+}
+
+// Shutdown drains the WebSocket connections, telling the clients when to reconnect, and stops the HTTP servers
+// started by Serve. Afterwards new connections are rejected. It returns once everything stopped or ctx expired.
+func (s *Server) Shutdown(ctx context.Context) error {
+	log.Info("Shutting down...")
+
+	err := s.connManager.Shutdown(ctx, s.options.reconnectAfter)
+	if err != nil {
+		log.Warn("Connections didn't drain in time", "error", err)
+	}
+
+	s.httpServersMutex.Lock()
+	servers := s.httpServers
+	s.httpServers = nil
+	s.httpServersMutex.Unlock()
+
+	errs := []error{err}
+	for _, server := range servers {
+		if err := server.Shutdown(ctx); err != nil {
+			log.Warn("HTTP server shutdown failed", "error", err)
+			errs = append(errs, err)
+		}
+	}
+
+	log.Info("Shutdown complete")
+
+	return errors.Join(errs...)
+}
+
+func (s *Server) track(server *http.Server) {
+	s.httpServersMutex.Lock()
+	defer s.httpServersMutex.Unlock()
+
+	s.httpServers = append(s.httpServers, server)
+}
+
+// Metrics returns the metrics registry of the server, it's served at /metrics.
+func (s *Server) Metrics() *metrics.Registry {
+	return s.metrics
+}
+
+func (s *Server) ConnectionManager() *connection.ConnectionManager {
+	return s.connManager
+}
+
+func (s *Server) ClientManager() *clients.ClientManager {
+	return s.clientManager
+}
+
+func (s *Server) RoomManager() *rooms.RoomManager {
+	return s.roomManager
+}
+
+func (s *Server) StreamManager() *streams.StreamManager {
+	return s.streamManager
+}
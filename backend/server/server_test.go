@@ -0,0 +1,182 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"bjoernblessin.de/screenecho/clients"
+	"bjoernblessin.de/screenecho/connection"
+	"github.com/gorilla/websocket"
+)
+
+func serve(s *Server, method string, path string, header http.Header) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, path, nil)
+	for name, values := range header {
+		request.Header[name] = values
+	}
+
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, request)
+
+	return recorder
+}
+
+func TestRoutes(t *testing.T) {
+	authorized := http.Header{"Authorization": {"Bearer secret"}}
+
+	tests := []struct {
+		Name         string
+		options      []Option
+		path         string
+		header       http.Header
+		expectedCode int
+	}{
+		{Name: "Liveness", path: "/healthz", expectedCode: http.StatusOK},
+		{Name: "Readiness", path: "/readyz", expectedCode: http.StatusOK},
+		{Name: "Metrics", path: "/metrics", expectedCode: http.StatusOK},
+		{Name: "Room ID", path: "/room/generate-id", expectedCode: http.StatusOK},
+		{Name: "Admin API disabled", path: "/admin/rooms", header: authorized, expectedCode: http.StatusNotFound},
+		{Name: "Admin API", options: []Option{WithAdminToken("secret")}, path: "/admin/rooms", header: authorized, expectedCode: http.StatusOK},
+		{Name: "Admin API unauthorized", options: []Option{WithAdminToken("secret")}, path: "/admin/rooms", expectedCode: http.StatusUnauthorized},
+		{Name: "Dependency down", options: []Option{WithDependency("storage", func(context.Context) error { return errors.New("down") })},
+			path: "/readyz", expectedCode: http.StatusServiceUnavailable},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			s := New(append([]Option{WithAccessLog("", nil)}, test.options...)...)
+
+			recorder := serve(s, "GET", test.path, test.header)
+
+			if recorder.Code != test.expectedCode {
+				t.Errorf("expected status code %d, got %d: %s", test.expectedCode, recorder.Code, recorder.Body.String())
+			}
+		})
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	var calls []string
+	record := func(name string) func(http.Handler) http.Handler {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+				calls = append(calls, name+" "+request.URL.Path)
+				next.ServeHTTP(writer, request)
+			})
+		}
+	}
+
+	s := New(WithAccessLog("", nil), WithMiddleware(record("first"), record("second")))
+
+	serve(s, "GET", "/metrics", nil)
+	serve(s, "GET", "/healthz", nil)
+
+	expected := []string{"first /metrics", "second /metrics"}
+	if len(calls) != len(expected) || calls[0] != expected[0] || calls[1] != expected[1] {
+		t.Errorf("expected the middlewares to run in order for routes only %v, got %v", expected, calls)
+	}
+}
+
+func TestServe(t *testing.T) {
+	s := New(WithAccessLog("", nil), WithTimeouts(time.Second, time.Second, time.Second))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := "http://" + listener.Addr().String()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.Serve(ctx, listener) }()
+
+	response, err := http.Get(url + "/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("expected status code %d, got %d", http.StatusOK, response.StatusCode)
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("expected a graceful shutdown, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected Serve to return after ctx is canceled")
+	}
+
+	if !s.ConnectionManager().IsDraining() {
+		t.Error("expected the connections to be drained")
+	}
+	if _, err := http.Get(url + "/healthz"); err == nil {
+		t.Error("expected the listener to be closed")
+	}
+}
+
+// binaryCodec is the JSON codec with binary frames.
+type binaryCodec struct{ connection.JSONCodec }
+
+func (binaryCodec) FrameType() int {
+	return websocket.BinaryMessage
+}
+
+type recordingStorage struct {
+	saved chan clients.Session
+}
+
+func (storage *recordingStorage) Save(_ context.Context, _ string, session clients.Session, _ time.Duration) error {
+	storage.saved <- session
+	return nil
+}
+
+func (storage *recordingStorage) Take(context.Context, string) (clients.Session, bool, error) {
+	return clients.Session{}, false, nil
+}
+
+func TestCodecAndStorage(t *testing.T) {
+	storage := &recordingStorage{saved: make(chan clients.Session, 1)}
+	s := New(WithAccessLog("", nil), WithCodec(binaryCodec{}), WithStorage(storage))
+
+	httpServer := httptest.NewServer(s)
+	defer httpServer.Close()
+
+	socket, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(httpServer.URL, "http")+"/room/room/connect", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	frameType, data, err := socket.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frameType != websocket.BinaryMessage {
+		t.Errorf("expected the codec's binary frames, got frame type %d", frameType)
+	}
+
+	var message connection.TypedMessage[clients.ClientIDMessage]
+	if err := json.Unmarshal(data, &message); err != nil || message.Type != clients.CLIENT_ID_MESSAGE_TYPE {
+		t.Fatalf("expected the client ID message, got %s (%v)", data, err)
+	}
+
+	_ = socket.Close()
+
+	select {
+	case session := <-storage.saved:
+		if session.ClientID.String() != message.Msg.ClientID {
+			t.Errorf("expected the session of client %s, got %s", message.Msg.ClientID, session.ClientID)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the session to be saved in the storage")
+	}
+}
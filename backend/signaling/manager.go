@@ -6,19 +6,18 @@
 package signaling
 
 import (
-	"encoding/json"
-	"fmt"
+	"errors"
 
 	"bjoernblessin.de/screenecho/clients"
 	"bjoernblessin.de/screenecho/connection"
-	"bjoernblessin.de/screenecho/util/strictjson"
+	"bjoernblessin.de/screenecho/wire"
 	"github.com/google/uuid"
 )
 
-const SDP_OFFER_MESSAGE_TYPE = "sdp-offer"
-const SDP_ANSWER_MESSAGE_TYPE = "sdp-answer"
-const ICE_CANDIDATE_MESSAGE_TYPE = "new-ice-candidate"
-const SDP_MESSAGE_TYPE = "sdp-message"
+const SDP_OFFER_MESSAGE_TYPE = wire.SDP_OFFER_MESSAGE_TYPE
+const SDP_ANSWER_MESSAGE_TYPE = wire.SDP_ANSWER_MESSAGE_TYPE
+const ICE_CANDIDATE_MESSAGE_TYPE = wire.ICE_CANDIDATE_MESSAGE_TYPE
+const SDP_MESSAGE_TYPE = wire.SDP_MESSAGE_TYPE
 
 type SignalingManager struct {
 	clientManager *clients.ClientManager
@@ -29,40 +28,32 @@ func NewSignalingManager(clientManager *clients.ClientManager) *SignalingManager
 		clientManager: clientManager,
 	}
 
-	clientManager.SubscribeMessage(SDP_OFFER_MESSAGE_TYPE, sm.handleSDPOffer)
-	clientManager.SubscribeMessage(SDP_ANSWER_MESSAGE_TYPE, sm.handleSDPAnswer)
-	clientManager.SubscribeMessage(ICE_CANDIDATE_MESSAGE_TYPE, sm.handleICECandidate)
-	clientManager.SubscribeMessage(SDP_MESSAGE_TYPE, sm.handleSDPMessage)
+	clients.Handle(clientManager, SDP_OFFER_MESSAGE_TYPE, sm.handleSDPOffer)
+	clients.Handle(clientManager, SDP_ANSWER_MESSAGE_TYPE, sm.handleSDPAnswer)
+	clients.Handle(clientManager, ICE_CANDIDATE_MESSAGE_TYPE, sm.handleICECandidate)
+	clients.Handle(clientManager, SDP_MESSAGE_TYPE, sm.handleSDPMessage)
+	clients.RegisterOutbound[ServerSDPOfferMessage](clientManager, SDP_OFFER_MESSAGE_TYPE)
+	clients.RegisterOutbound[SDPAnswerMessage](clientManager, SDP_ANSWER_MESSAGE_TYPE)
+	clients.RegisterOutbound[ICEMessage](clientManager, ICE_CANDIDATE_MESSAGE_TYPE)
+	clients.RegisterOutbound[SDPMessage](clientManager, SDP_MESSAGE_TYPE)
 
 	return sm
 }
 
-func (sm *SignalingManager) handleSDPMessage(client *clients.Client, typedMessage connection.TypedMessage[json.RawMessage]) {
-	type SDPMessage struct {
-		RemoteClientID string          `json:"remoteClientID"`
-		Description    json.RawMessage `json:"description"`
-	}
-
-	var msg SDPMessage
-	err := strictjson.Unmarshal(typedMessage.Msg, &msg)
-	if err != nil {
-		errorMsg := connection.BuildErrorMessage(fmt.Sprintf("Message had invalid JSON format. %v", err))
-		clients.SendMessage(client, errorMsg)
-		return
-	}
-
-	remoteClientID, err := uuid.Parse(msg.RemoteClientID)
-	if err != nil {
-		errorMsg := connection.BuildErrorMessage(fmt.Sprintf("remoteClientID is not a valid UUID. %v", err))
-		clients.SendMessage(client, errorMsg)
-		return
-	}
+// The messages are defined in package wire, which the Go client shares.
+type (
+	SessionDescription    = wire.SessionDescription
+	SDPMessage            = wire.SDPMessage
+	ClientSDPOfferMessage = wire.ClientSDPOfferMessage
+	ServerSDPOfferMessage = wire.ServerSDPOfferMessage
+	SDPAnswerMessage      = wire.SDPAnswerMessage
+	ICEMessage            = wire.ICEMessage
+)
 
-	receiverClient := sm.clientManager.GetClientByID(clients.ClientID(remoteClientID))
+func (sm *SignalingManager) handleSDPMessage(client *clients.Client, msg SDPMessage) error {
+	receiverClient := sm.getPeer(clients.ClientID(uuid.MustParse(msg.RemoteClientID)))
 	if receiverClient == nil {
-		errorMsg := connection.BuildErrorMessage("Remote client not found.")
-		clients.SendMessage(client, errorMsg)
-		return
+		return errors.New("Remote client not found.")
 	}
 
 	clients.SendMessage(receiverClient, connection.TypedMessage[SDPMessage]{
@@ -73,43 +64,16 @@ func (sm *SignalingManager) handleSDPMessage(client *clients.Client, typedMessag
 			Description:    msg.Description,
 		},
 	})
+
+	return nil
 }
 
 // handleSPDOffer handles the SDP offer of a client offering a WebRTC connection.
 // The server forwards the offer to the requested remote peer.
-func (sm *SignalingManager) handleSDPOffer(client *clients.Client, typedMessage connection.TypedMessage[json.RawMessage]) {
-	type ClientSDPOfferMessage struct {
-		CalleeClientID string          `json:"calleeClientID"`
-		Offer          json.RawMessage `json:"offer"`
-	}
-
-	var msg ClientSDPOfferMessage
-	err := strictjson.Unmarshal(typedMessage.Msg, &msg)
-	if err != nil {
-		errorMsg := connection.BuildErrorMessage(fmt.Sprintf("Message had invalid JSON format. %v", err))
-		clients.SendMessage(client, errorMsg)
-		return
-	}
-
-	calleeClientID, err := uuid.Parse(msg.CalleeClientID)
-	if err != nil {
-		errorMsg := connection.BuildErrorMessage(fmt.Sprintf("calleeClientID is not a valid UUID. %v", err))
-		clients.SendMessage(client, errorMsg)
-		return
-	}
-
-	calleeClient := sm.clientManager.GetClientByID(clients.ClientID(calleeClientID))
+func (sm *SignalingManager) handleSDPOffer(client *clients.Client, msg ClientSDPOfferMessage) error {
+	calleeClient := sm.getPeer(clients.ClientID(uuid.MustParse(msg.CalleeClientID)))
 	if calleeClient == nil {
-		errorMsg := connection.BuildErrorMessage("Callee client not found.")
-		clients.SendMessage(client, errorMsg)
-		return
-	}
-
-	// SDP offer is valid
-
-	type ServerSDPOfferMessage struct {
-		CallerClientID string          `json:"callerClientID"`
-		Offer          json.RawMessage `json:"offer"`
+		return errors.New("Callee client not found.")
 	}
 
 	clients.SendMessage(calleeClient, connection.TypedMessage[ServerSDPOfferMessage]{
@@ -119,73 +83,35 @@ func (sm *SignalingManager) handleSDPOffer(client *clients.Client, typedMessage
 			Offer:          msg.Offer,
 		},
 	})
+
+	return nil
 }
 
 // handleSDPAnswer handles SDP answer of a user answering an SDP offer.
 // The server forwards the answer to the caller.
-func (sm *SignalingManager) handleSDPAnswer(client *clients.Client, typedMessage connection.TypedMessage[json.RawMessage]) {
-	type SDPAnswerMessage struct {
-		CallerClientID string          `json:"callerClientID"`
-		Answer         json.RawMessage `json:"answer"`
-	}
-
-	var msg SDPAnswerMessage
-	err := strictjson.Unmarshal(typedMessage.Msg, &msg)
-	if err != nil {
-		errorMsg := connection.BuildErrorMessage(fmt.Sprintf("Message had invalid JSON format. %v", err))
-		clients.SendMessage(client, errorMsg)
-		return
-	}
-
-	callerClientID, err := uuid.Parse(msg.CallerClientID)
-	if err != nil {
-		errorMsg := connection.BuildErrorMessage(fmt.Sprintf("callerClientID is not a valid UUID. %v", err))
-		clients.SendMessage(client, errorMsg)
-		return
-	}
-
-	callerClient := sm.clientManager.GetClientByID(clients.ClientID(callerClientID))
+func (sm *SignalingManager) handleSDPAnswer(client *clients.Client, msg SDPAnswerMessage) error {
+	callerClient := sm.getPeer(clients.ClientID(uuid.MustParse(msg.CallerClientID)))
 	if callerClient == nil {
-		errorMsg := connection.BuildErrorMessage("Caller client not found.")
-		clients.SendMessage(client, errorMsg)
-		return
+		return errors.New("Caller client not found.")
 	}
 
-	clients.SendMessage(callerClient, typedMessage)
+	clients.SendMessage(callerClient, connection.TypedMessage[SDPAnswerMessage]{
+		Type: SDP_ANSWER_MESSAGE_TYPE,
+		Msg:  msg,
+	})
+
+	return nil
 }
 
 // handleICECandidate processes an ICE candidate message from a client and forwards it to the intended remote client.
-// The functions validates the incoming message and sends a modified message to the remote client.
-func (sm *SignalingManager) handleICECandidate(client *clients.Client, typedMessage connection.TypedMessage[json.RawMessage]) {
-	type ICEMessage struct {
-		RemoteClientID string          `json:"remoteClientID"`
-		Candidate      json.RawMessage `json:"candidate"`
-	}
-
-	var msg ICEMessage
-	err := strictjson.Unmarshal(typedMessage.Msg, &msg)
-	if err != nil {
-		errorMsg := connection.BuildErrorMessage(fmt.Sprintf("Message had invalid JSON format. %v", err))
-		clients.SendMessage(client, errorMsg)
-		return
-	}
-
-	remoteClientID, err := uuid.Parse(msg.RemoteClientID)
-	if err != nil {
-		errorMsg := connection.BuildErrorMessage(fmt.Sprintf("remoteClientID is not a valid UUID. %v", err))
-		clients.SendMessage(client, errorMsg)
-		return
-	}
-
-	receiverClient := sm.clientManager.GetClientByID(clients.ClientID(remoteClientID))
+// The functions sends a modified message to the remote client.
+func (sm *SignalingManager) handleICECandidate(client *clients.Client, msg ICEMessage) error {
+	receiverClient := sm.getPeer(clients.ClientID(uuid.MustParse(msg.RemoteClientID)))
 	if receiverClient == nil {
-		errorMsg := connection.BuildErrorMessage("Remote client not found.")
-		clients.SendMessage(client, errorMsg)
-		return
+		return errors.New("Remote client not found.")
 	}
 
 	clients.SendMessage(receiverClient, connection.TypedMessage[ICEMessage]{
-		// TODO Type: SDP_OFFER_MESSAGE_TYPE,
 		Type: ICE_CANDIDATE_MESSAGE_TYPE,
 		Msg: ICEMessage{
 			// Change remoteClientID to sender client's ID
@@ -193,4 +119,17 @@ func (sm *SignalingManager) handleICECandidate(client *clients.Client, typedMess
 			Candidate:      msg.Candidate,
 		},
 	})
+
+	return nil
+}
+
+// getPeer returns the client with clientID or nil if it doesn't exist.
+// Observers are hidden from signaling, so they are reported as not found.
+func (sm *SignalingManager) getPeer(clientID clients.ClientID) *clients.Client {
+	client := sm.clientManager.GetClientByID(clientID)
+	if client == nil || client.IsObserver() {
+		return nil
+	}
+
+	return client
 }
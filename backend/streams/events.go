@@ -0,0 +1,21 @@
+package streams
+
+import (
+	"bjoernblessin.de/screenecho/clients"
+	"bjoernblessin.de/screenecho/rooms"
+)
+
+// Events published by the StreamManager, see package events.
+// They are published from the room's goroutine, in order with the room's other events.
+
+// StreamStarted is published when a client started a stream in a room.
+type StreamStarted struct {
+	RoomID   rooms.RoomID
+	ClientID clients.ClientID
+}
+
+// StreamStopped is published when a client's stream ended, because it was stopped or the client left the room.
+type StreamStopped struct {
+	RoomID   rooms.RoomID
+	ClientID clients.ClientID
+}
@@ -3,57 +3,77 @@
 package streams
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
-	"sync"
-
 	"slices"
 
 	"bjoernblessin.de/screenecho/clients"
 	"bjoernblessin.de/screenecho/connection"
+	"bjoernblessin.de/screenecho/events"
+	"bjoernblessin.de/screenecho/metrics"
 	"bjoernblessin.de/screenecho/rooms"
-	"bjoernblessin.de/screenecho/util/assert"
-	"bjoernblessin.de/screenecho/util/strictjson"
+	"bjoernblessin.de/screenecho/util/logger"
+	"bjoernblessin.de/screenecho/wire"
 )
 
+var log = logger.New("streams")
+
 type StreamInfo struct {
 	clientID     clients.ClientID
 	previewImage []byte
 }
 
+// streamsKey is the key of the active streams of a room, see [rooms.RoomState.Value].
+// The value is a []*StreamInfo and not set if there are no active streams in the room.
+type streamsKey struct{}
+
 type StreamManager struct {
-	// Holds the actives streams per room.
-	// activeStreams[roomID] is not set if there are no active streams in the room.
-	activeStreams      map[rooms.RoomID][]*StreamInfo
-	activeStreamsMutex sync.RWMutex
-	clientMananger     *clients.ClientManager
-	roomManager        *rooms.RoomManager
+	clientMananger *clients.ClientManager
+	roomManager    *rooms.RoomManager
+	metrics        metrics.Metrics
+	bus            *events.Bus
 }
 
-const AVAILABLE_STREAMS_MESSAGE_TYPE = "streams-available"
+const STREAM_STARTED_MESSAGE_TYPE = wire.STREAM_STARTED_MESSAGE_TYPE
+const AVAILABLE_STREAMS_MESSAGE_TYPE = wire.AVAILABLE_STREAMS_MESSAGE_TYPE
+const STREAM_STOPPED_MESSAGE_TYPE = wire.STREAM_STOPPED_MESSAGE_TYPE
 
-type AvailableStreamsMessage struct {
-	ClientIDs []string `json:"clientIDs"`
-}
+type (
+	StreamStartedMessage    = wire.StreamStartedMessage
+	StreamStoppedMessage    = wire.StreamStoppedMessage
+	AvailableStreamsMessage = wire.AvailableStreamsMessage
+)
 
-func NewStreamManager(clientManager *clients.ClientManager, roomManager *rooms.RoomManager) *StreamManager {
+// NewStreamManager creates a StreamManager. The active streams of a room are part of the room's state,
+// so starting and stopping streams is serialized with clients joining and leaving the room.
+// The StreamManager subscribes to the room events of bus and publishes StreamStarted and StreamStopped to it.
+func NewStreamManager(clientManager *clients.ClientManager, roomManager *rooms.RoomManager, m metrics.Metrics, bus *events.Bus) *StreamManager {
 	sm := &StreamManager{
-		activeStreams:  make(map[rooms.RoomID][]*StreamInfo),
 		clientMananger: clientManager,
 		roomManager:    roomManager,
+		metrics:        m,
+		bus:            bus,
 	}
 
-	clientManager.SubscribeMessage("stream-started", sm.handleStreamStarted)
-	clientManager.SubscribeMessage("stream-stopped", sm.handleStreamStopped)
-	roomManager.RegisterClientJoinHandler(sm.handleClientJoined)
+	clients.Handle(clientManager, STREAM_STARTED_MESSAGE_TYPE, sm.handleStreamStarted)
+	clients.Handle(clientManager, STREAM_STOPPED_MESSAGE_TYPE, sm.handleStreamStopped)
+	clients.RegisterOutbound[StreamStartedMessage](clientManager, STREAM_STARTED_MESSAGE_TYPE)
+	clients.RegisterOutbound[StreamStoppedMessage](clientManager, STREAM_STOPPED_MESSAGE_TYPE)
+	clients.RegisterOutbound[AvailableStreamsMessage](clientManager, AVAILABLE_STREAMS_MESSAGE_TYPE)
+	// Synchronous, so the handlers run on the room's goroutine as part of the join or leave
+	events.Subscribe(bus, func(event rooms.ClientJoined) { sm.handleClientJoined(event.State, event.Client) })
+	events.Subscribe(bus, func(event rooms.ClientLeft) { sm.handleClientLeft(event.State, event.ClientID) })
 
 	return sm
 }
 
-func (sm *StreamManager) handleClientJoined(room *rooms.Room, client *clients.Client) {
-	streamingClientIDs := make([]string, 0, len(sm.activeStreams[room.RoomID]))
+// handleClientJoined informs the joined client about the active streams in the room.
+// It runs on the room's goroutine.
+func (sm *StreamManager) handleClientJoined(state *rooms.RoomState, client *clients.Client) {
+	activeStreams := getActiveStreams(state)
+	streamingClientIDs := make([]string, 0, len(activeStreams))
 
-	for _, streamInfo := range sm.activeStreams[room.RoomID] {
+	for _, streamInfo := range activeStreams {
 		streamingClientIDs = append(streamingClientIDs, streamInfo.clientID.String())
 	}
 
@@ -67,89 +87,153 @@ func (sm *StreamManager) handleClientJoined(room *rooms.Room, client *clients.Cl
 	clients.SendMessage(client, streamsAvailableMsg)
 }
 
-func (sm *StreamManager) handleStreamStarted(client *clients.Client, typedMessage connection.TypedMessage[json.RawMessage]) {
-	type StreamStartedMessage struct {
-		ClientID string `json:"clientID"`
+// handleClientLeft ends the stream of a leaving client. The remaining clients are informed by the client-disconnect message.
+// It runs on the room's goroutine.
+func (sm *StreamManager) handleClientLeft(state *rooms.RoomState, clientID clients.ClientID) {
+	sm.deleteClientsStream(state, clientID)
+}
+
+func (sm *StreamManager) handleStreamStarted(client *clients.Client, message StreamStartedMessage) error {
+	room := sm.roomManager.GetUsersRoom(client.ID)
+	if room == nil {
+		return errors.New("You are not in a room.")
 	}
 
-	var message StreamStartedMessage
-	err := strictjson.Unmarshal(typedMessage.Msg, &message)
+	var addErr error
+	err := room.Do(func(state *rooms.RoomState) {
+		if !state.Contains(client.ID) {
+			// The client left the room after it was looked up
+			return
+		}
+
+		addErr = sm.addClientsStream(state, client.ID)
+		if addErr != nil {
+			return
+		}
+
+		log.InfoContext(client.Context(), "Stream started")
+
+		rooms.Broadcast(state, connection.TypedMessage[StreamStartedMessage]{Type: STREAM_STARTED_MESSAGE_TYPE, Msg: message}, client.ID)
+	})
 	if err != nil {
-		errorMsg := connection.BuildErrorMessage(fmt.Sprintf("Message had invalid JSON format. %v", err))
-		clients.SendMessage(client, errorMsg)
-		return
+		// The room was closed after it was looked up, so the client is leaving
+		return nil
 	}
 
-	room := sm.roomManager.GetUsersRoom(client.ID)
-	assert.IsNotNil(room)
+	if addErr != nil {
+		return fmt.Errorf("You already have an active stream. %v", addErr)
+	}
 
-	err = sm.addClientsStream(client.ID, room)
-	if err != nil {
-		errorMsg := connection.BuildErrorMessage(fmt.Sprintf("You already have an active stream. %v", err))
-		clients.SendMessage(client, errorMsg)
-		return
+	return nil
+}
+
+// handleStreamStopped ends the stream of the client. The payload is only validated, the stream is identified by the sender.
+func (sm *StreamManager) handleStreamStopped(client *clients.Client, _ StreamStoppedMessage) error {
+	room := sm.roomManager.GetUsersRoom(client.ID)
+	if room == nil {
+		return errors.New("You are not in a room.")
 	}
 
-	client.RegisterDisconnectHandler(func() {
-		sm.deleteClientsStream(client.ID, room)
+	_ = room.Do(func(state *rooms.RoomState) {
+		if !sm.deleteClientsStream(state, client.ID) {
+			return
+		}
+
+		log.InfoContext(client.Context(), "Stream stopped")
+
+		rooms.Broadcast(state, buildStreamStoppedMessage(client.ID), client.ID)
 	})
 
-	rooms.Broadcast(room, typedMessage, client.ID)
+	return nil
 }
 
-func (sm *StreamManager) handleStreamStopped(client *clients.Client, typedMessage connection.TypedMessage[json.RawMessage]) {
-	// TODO check if stream info was present / remove was successful (before broadcasting)
+// getActiveStreams returns the active streams of the room. Must be called on the room's goroutine.
+func getActiveStreams(state *rooms.RoomState) []*StreamInfo {
+	activeStreams, _ := state.Value(streamsKey{}).([]*StreamInfo)
+	return activeStreams
+}
 
-	room := sm.roomManager.GetUsersRoom(client.ID)
-	assert.IsNotNil(room)
+// addClientsStream adds a new stream for the given client to the room.
+// If the client already has an active stream in the room, an error is returned.
+// Must be called on the room's goroutine.
+func (sm *StreamManager) addClientsStream(state *rooms.RoomState, clientID clients.ClientID) error {
+	activeStreams := getActiveStreams(state)
 
-	sm.deleteClientsStream(client.ID, room)
+	if slices.ContainsFunc(activeStreams, func(streamInfo *StreamInfo) bool { return streamInfo.clientID == clientID }) {
+		return fmt.Errorf("Client with ID %v already has an active stream in room %v", clientID, state.Room().RoomID)
+	}
 
-	rooms.Broadcast(room, typedMessage, client.ID)
+	state.SetValue(streamsKey{}, append(activeStreams, &StreamInfo{clientID: clientID, previewImage: nil}))
+	sm.metrics.StreamStarted()
+	events.Publish(sm.bus, StreamStarted{RoomID: state.Room().RoomID, ClientID: clientID})
+
+	return nil
 }
 
-// addClientsStream adds a new stream for the given client in the specified room.
-// If the client already has an active stream in the room, an error is returned.
-func (sm *StreamManager) addClientsStream(clientID clients.ClientID, room *rooms.Room) error {
-	if sm.activeStreamsContainsClientsStream(room.RoomID, clientID) {
-		return fmt.Errorf("Client with ID %v already has an active stream in room %v", clientID, room)
+// deleteClientsStream removes the stream associated with the given clientID from the room.
+// If the client does not have an active stream in the room, the function has no effect and returns false.
+// Must be called on the room's goroutine.
+func (sm *StreamManager) deleteClientsStream(state *rooms.RoomState, clientID clients.ClientID) bool {
+	activeStreams := getActiveStreams(state)
+
+	i := slices.IndexFunc(activeStreams, func(streamInfo *StreamInfo) bool { return streamInfo.clientID == clientID })
+	if i == -1 {
+		return false
 	}
 
-	sm.activeStreamsMutex.Lock()
-	defer sm.activeStreamsMutex.Unlock()
+	activeStreams = slices.Delete(activeStreams, i, i+1)
+	sm.metrics.StreamStopped()
+	events.Publish(sm.bus, StreamStopped{RoomID: state.Room().RoomID, ClientID: clientID})
 
-	sm.activeStreams[room.RoomID] = append(sm.activeStreams[room.RoomID], &StreamInfo{clientID: clientID, previewImage: nil})
+	// Delete the value if no more active streams in the room
+	if len(activeStreams) == 0 {
+		state.SetValue(streamsKey{}, nil)
+	} else {
+		state.SetValue(streamsKey{}, activeStreams)
+	}
 
-	return nil
+	return true
 }
 
-// activeStreamsContainsClientsStream checks if a client already has an active stream in the given room.
-// Returns true if the client has an active stream, otherwise false.
-func (sm *StreamManager) activeStreamsContainsClientsStream(roomID rooms.RoomID, clientID clients.ClientID) bool {
-	sm.activeStreamsMutex.RLock()
-	defer sm.activeStreamsMutex.RUnlock()
+// GetStreamingClients returns a copy of the IDs of all clients with an active stream in the room with roomID.
+func (sm *StreamManager) GetStreamingClients(roomID rooms.RoomID) []clients.ClientID {
+	clientIDs := make([]clients.ClientID, 0)
+
+	room := sm.roomManager.GetRoom(roomID)
+	if room == nil {
+		return clientIDs
+	}
 
-	return slices.ContainsFunc(sm.activeStreams[roomID], func(streamInfo *StreamInfo) bool {
-		return streamInfo.clientID == clientID
+	_ = room.Do(func(state *rooms.RoomState) {
+		for _, streamInfo := range getActiveStreams(state) {
+			clientIDs = append(clientIDs, streamInfo.clientID)
+		}
 	})
+
+	return clientIDs
 }
 
-// deleteClientsStream removes the stream associated with the given clientID from the specified room.
-// If the client does not have an active stream in the room, the function has no effect.
-func (sm *StreamManager) deleteClientsStream(clientID clients.ClientID, room *rooms.Room) {
-	sm.activeStreamsMutex.Lock()
-	defer sm.activeStreamsMutex.Unlock()
-
-	streamsInRoom := sm.activeStreams[room.RoomID]
-	for i, stream := range streamsInRoom {
-		if stream.clientID == clientID {
-			sm.activeStreams[room.RoomID] = slices.Delete(streamsInRoom, i, i+1)
-			break
+// StopStream ends the stream of the client with clientID in room and informs all clients in the room, including the streaming client.
+// Returns false if the client has no active stream in the room.
+func (sm *StreamManager) StopStream(room *rooms.Room, clientID clients.ClientID) bool {
+	stopped := false
+
+	_ = room.Do(func(state *rooms.RoomState) {
+		stopped = sm.deleteClientsStream(state, clientID)
+		if stopped {
+			// The zero ClientID is never a sender, so all clients receive the message
+			rooms.Broadcast(state, buildStreamStoppedMessage(clientID), clients.ClientID{})
 		}
-	}
+	})
 
-	// Delete room entry in activeStreams map if no more active streams in the room
-	if len(sm.activeStreams[room.RoomID]) == 0 {
-		delete(sm.activeStreams, room.RoomID)
+	return stopped
+}
+
+func buildStreamStoppedMessage(clientID clients.ClientID) connection.TypedMessage[StreamStoppedMessage] {
+	return connection.TypedMessage[StreamStoppedMessage]{
+		Type: STREAM_STOPPED_MESSAGE_TYPE,
+		Msg: StreamStoppedMessage{
+			ClientID: clientID.String(),
+		},
 	}
 }
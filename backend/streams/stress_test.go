@@ -0,0 +1,338 @@
+package streams
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"bjoernblessin.de/screenecho/clients"
+	"bjoernblessin.de/screenecho/connection"
+	"bjoernblessin.de/screenecho/events"
+	"bjoernblessin.de/screenecho/metrics"
+	"bjoernblessin.de/screenecho/rooms"
+	"github.com/gorilla/websocket"
+)
+
+// The stress tests are meant to be run with -race. They connect many clients that join and leave rooms and start
+// and stop streams concurrently, then check that every client's view of the active streams, built only from the
+// messages it received, matches the server's state.
+
+type stressServer struct {
+	url           string
+	metrics       *metrics.Registry
+	roomManager   *rooms.RoomManager
+	streamManager *StreamManager
+}
+
+func startStressServer(t *testing.T) *stressServer {
+	t.Helper()
+
+	registry := metrics.NewRegistry()
+	bus := events.NewBus(events.DefaultAsyncBufferSize)
+	connManager := connection.NewConnectionManager(connection.Options{}, registry, bus)
+	clientManager := clients.NewClientManager(connManager, nil, bus)
+	roomManager := rooms.NewRoomManager(clientManager, registry, bus)
+	streamManager := NewStreamManager(clientManager, roomManager, registry, bus)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /room/{roomID}/connect", roomManager.HandleConnect)
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	return &stressServer{
+		url:           "ws" + strings.TrimPrefix(server.URL, "http"),
+		metrics:       registry,
+		roomManager:   roomManager,
+		streamManager: streamManager,
+	}
+}
+
+// stressClient is a client whose view of the room is built from the messages it receives.
+type stressClient struct {
+	roomID rooms.RoomID
+	socket *websocket.Conn
+	id     string
+	// joined is closed once the streams-available message was received.
+	joined chan struct{}
+	// readerDone is closed once the socket was closed.
+	readerDone chan struct{}
+
+	mutex sync.Mutex
+	// streams holds the IDs of the other clients the client believes to be streaming.
+	streams map[string]bool
+	errors  []string
+}
+
+type clientIDMessage struct {
+	ClientID string `json:"clientID"`
+}
+
+func dialStressClient(server *stressServer, roomID rooms.RoomID) (*stressClient, error) {
+	socket, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("%s/room/%s/connect", server.url, roomID), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &stressClient{
+		roomID:     roomID,
+		socket:     socket,
+		joined:     make(chan struct{}),
+		readerDone: make(chan struct{}),
+		streams:    make(map[string]bool),
+	}
+
+	var idMessage connection.TypedMessage[clientIDMessage]
+	err = socket.ReadJSON(&idMessage)
+	if err != nil {
+		_ = socket.Close()
+		return nil, err
+	}
+	client.id = idMessage.Msg.ClientID
+
+	go client.read()
+
+	select {
+	case <-client.joined:
+		return client, nil
+	case <-time.After(5 * time.Second):
+		_ = socket.Close()
+		return nil, fmt.Errorf("client %s didn't receive %s", client.id, AVAILABLE_STREAMS_MESSAGE_TYPE)
+	}
+}
+
+func (client *stressClient) read() {
+	defer close(client.readerDone)
+
+	for {
+		var message connection.TypedMessage[json.RawMessage]
+		err := client.socket.ReadJSON(&message)
+		if err != nil {
+			return
+		}
+
+		client.mutex.Lock()
+		switch message.Type {
+		case AVAILABLE_STREAMS_MESSAGE_TYPE:
+			var available AvailableStreamsMessage
+			_ = json.Unmarshal(message.Msg, &available)
+			for _, clientID := range available.ClientIDs {
+				client.streams[clientID] = true
+			}
+			close(client.joined)
+		case STREAM_STARTED_MESSAGE_TYPE:
+			var started clientIDMessage
+			_ = json.Unmarshal(message.Msg, &started)
+			client.streams[started.ClientID] = true
+		case STREAM_STOPPED_MESSAGE_TYPE, rooms.CLIENT_DISCONNECT_MESSAGE_TYPE:
+			var stopped clientIDMessage
+			_ = json.Unmarshal(message.Msg, &stopped)
+			delete(client.streams, stopped.ClientID)
+		default:
+			client.errors = append(client.errors, fmt.Sprintf("%s: %s", message.Type, message.Msg))
+		}
+		client.mutex.Unlock()
+	}
+}
+
+func (client *stressClient) send(messageType connection.MessageType) error {
+	return client.socket.WriteJSON(connection.TypedMessage[clientIDMessage]{
+		Type: messageType,
+		Msg:  clientIDMessage{ClientID: client.id},
+	})
+}
+
+func (client *stressClient) view() []string {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	return slices.Sorted(maps.Keys(client.streams))
+}
+
+// expectedView returns the streaming clients of the client's room as the server sees them, without the client itself.
+func (server *stressServer) expectedView(client *stressClient) []string {
+	var expected []string
+	for _, clientID := range server.streamManager.GetStreamingClients(client.roomID) {
+		if clientID.String() != client.id {
+			expected = append(expected, clientID.String())
+		}
+	}
+	slices.Sort(expected)
+
+	return expected
+}
+
+// waitForViews waits until the view of every client matches the server's state.
+func waitForViews(t *testing.T, server *stressServer, stressClients []*stressClient) {
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for _, client := range stressClients {
+		for {
+			view, expected := client.view(), server.expectedView(client)
+			if slices.Equal(view, expected) {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("client %s in room %s sees streams %v, server has %v", client.id, client.roomID, view, expected)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+func waitForGauge(t *testing.T, server *stressServer, name string, expected float64) {
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for server.metrics.Gauge(name) != expected {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %s to be %v, got %v", name, expected, server.metrics.Gauge(name))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestStress_JoinLeaveAndStreams(t *testing.T) {
+	server := startStressServer(t)
+
+	const clientCount, operationsPerClient = 32, 20
+	roomIDs := []rooms.RoomID{"alpha", "beta", "gamma", "delta"}
+
+	stressClients := make([]*stressClient, clientCount)
+
+	var wg sync.WaitGroup
+	for i := range clientCount {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			client, err := dialStressClient(server, roomIDs[i%len(roomIDs)])
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			stressClients[i] = client
+
+			for range operationsPerClient {
+				messageType := connection.MessageType(STREAM_STARTED_MESSAGE_TYPE)
+				if rand.IntN(2) == 0 {
+					messageType = STREAM_STOPPED_MESSAGE_TYPE
+				}
+
+				err := client.send(messageType)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+
+			// Every second client leaves while the others are still streaming
+			if i%2 == 1 {
+				_ = client.socket.Close()
+				<-client.readerDone
+			}
+		}()
+	}
+	wg.Wait()
+
+	if t.Failed() {
+		return
+	}
+
+	var remaining []*stressClient
+	for i, client := range stressClients {
+		if i%2 == 0 {
+			remaining = append(remaining, client)
+		}
+	}
+
+	waitForGauge(t, server, metrics.ClientsName, float64(len(remaining)))
+	waitForViews(t, server, remaining)
+
+	streamCount := 0
+	for _, roomID := range roomIDs {
+		streamCount += len(server.streamManager.GetStreamingClients(roomID))
+	}
+	if gauge := server.metrics.Gauge(metrics.ActiveStreamsName); gauge != float64(streamCount) {
+		t.Errorf("active streams gauge is %v, rooms hold %d streams", gauge, streamCount)
+	}
+
+	for _, client := range remaining {
+		client.mutex.Lock()
+		// "You already have an active stream" errors are expected, everything else is not
+		for _, message := range client.errors {
+			if !strings.Contains(message, "already has an active stream") {
+				t.Errorf("client %s received unexpected message %s", client.id, message)
+			}
+		}
+		client.mutex.Unlock()
+
+		_ = client.socket.Close()
+	}
+
+	waitForGauge(t, server, metrics.ClientsName, 0)
+	waitForGauge(t, server, metrics.RoomsName, 0)
+	waitForGauge(t, server, metrics.ActiveStreamsName, 0)
+}
+
+func TestStress_JoinDuringBroadcast(t *testing.T) {
+	server := startStressServer(t)
+
+	const streamerCount, joinerCount = 8, 24
+	const roomID = rooms.RoomID("room")
+
+	streamers := make([]*stressClient, streamerCount)
+	for i := range streamerCount {
+		client, err := dialStressClient(server, roomID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		streamers[i] = client
+	}
+
+	joiners := make([]*stressClient, joinerCount)
+
+	var wg sync.WaitGroup
+	for _, streamer := range streamers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for range 10 {
+				_ = streamer.send(STREAM_STARTED_MESSAGE_TYPE)
+				_ = streamer.send(STREAM_STOPPED_MESSAGE_TYPE)
+			}
+			_ = streamer.send(STREAM_STARTED_MESSAGE_TYPE)
+		}()
+	}
+	for i := range joinerCount {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			client, err := dialStressClient(server, roomID)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			joiners[i] = client
+		}()
+	}
+	wg.Wait()
+
+	if t.Failed() {
+		return
+	}
+
+	// Messages of one client are handled concurrently, so the final streams are not known, only that everybody agrees on them
+	waitForViews(t, server, append(streamers, joiners...))
+}
@@ -0,0 +1,125 @@
+package tlsconfig
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"bjoernblessin.de/screenecho/util/logger"
+)
+
+var log = logger.New("tlsconfig")
+
+// CertReloader holds a certificate loaded from a certificate and a key file and reloads it when one of the files changes.
+type CertReloader struct {
+	certFile string
+	keyFile  string
+
+	certificate *tls.Certificate
+	// certVersion identifies the file state certificate was loaded from.
+	certVersion fileVersion
+	mutex       sync.RWMutex
+}
+
+// fileVersion is used to detect changes of the certificate and key file without reading them.
+type fileVersion struct {
+	certModTime time.Time
+	certSize    int64
+	keyModTime  time.Time
+	keySize     int64
+}
+
+// NewCertReloader loads the PEM encoded certificate (chain) and private key from certFile and keyFile.
+func NewCertReloader(certFile string, keyFile string) (*CertReloader, error) {
+	reloader := &CertReloader{certFile: certFile, keyFile: keyFile}
+
+	_, err := reloader.Reload()
+	if err != nil {
+		return nil, err
+	}
+
+	return reloader, nil
+}
+
+// GetCertificate returns the current certificate. It can be used as [tls.Config.GetCertificate].
+func (reloader *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	reloader.mutex.RLock()
+	defer reloader.mutex.RUnlock()
+
+	return reloader.certificate, nil
+}
+
+// Reload reloads the certificate if the certificate or key file changed since the last load.
+// It returns true if a new certificate was loaded.
+//
+// If the files can't be loaded, e.g. because only one of them was replaced yet, the previous certificate is kept.
+func (reloader *CertReloader) Reload() (bool, error) {
+	version, err := reloader.currentVersion()
+	if err != nil {
+		return false, err
+	}
+
+	reloader.mutex.RLock()
+	unchanged := reloader.certificate != nil && version == reloader.certVersion
+	reloader.mutex.RUnlock()
+
+	if unchanged {
+		return false, nil
+	}
+
+	certificate, err := tls.LoadX509KeyPair(reloader.certFile, reloader.keyFile)
+	if err != nil {
+		return false, fmt.Errorf("failed to load TLS certificate. %w", err)
+	}
+
+	reloader.mutex.Lock()
+	defer reloader.mutex.Unlock()
+
+	reloader.certificate = &certificate
+	reloader.certVersion = version
+
+	return true, nil
+}
+
+// Watch checks the files for changes every interval and reloads the certificate until ctx is done.
+// Failed reloads are logged and retried with the next check.
+func (reloader *CertReloader) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reloaded, err := reloader.Reload()
+			if err != nil {
+				log.Warn("Keeping previous TLS certificate", "error", err)
+			} else if reloaded {
+				log.Info("Reloaded TLS certificate", "file", reloader.certFile)
+			}
+		}
+	}
+}
+
+func (reloader *CertReloader) currentVersion() (fileVersion, error) {
+	certInfo, err := os.Stat(reloader.certFile)
+	if err != nil {
+		return fileVersion{}, fmt.Errorf("failed to read TLS certificate file. %w", err)
+	}
+
+	keyInfo, err := os.Stat(reloader.keyFile)
+	if err != nil {
+		return fileVersion{}, fmt.Errorf("failed to read TLS key file. %w", err)
+	}
+
+	return fileVersion{
+		certModTime: certInfo.ModTime(),
+		certSize:    certInfo.Size(),
+		keyModTime:  keyInfo.ModTime(),
+		keySize:     keyInfo.Size(),
+	}, nil
+}
@@ -0,0 +1,98 @@
+// Package tlsconfig provides native TLS termination for the server.
+// Certificates are reloaded from disk when they change, which only affects new TLS handshakes.
+// Established connections, including WebSockets, keep using the certificate they were opened with.
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+var versions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ParseMinVersion parses a TLS version like "1.2" or "1.3". Older versions are not supported.
+func ParseMinVersion(version string) (uint16, error) {
+	parsed, exists := versions[version]
+	if !exists {
+		return 0, fmt.Errorf("unsupported TLS version %q, use 1.2 or 1.3", version)
+	}
+
+	return parsed, nil
+}
+
+// ParseCipherSuites parses cipher suite names like "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256" as listed by [tls.CipherSuites].
+// Insecure cipher suites are rejected. All unknown names are reported in the error.
+func ParseCipherSuites(names []string) ([]uint16, error) {
+	ids := make([]uint16, 0, len(names))
+	var unknown []string
+
+	for _, name := range names {
+		index := slices.IndexFunc(tls.CipherSuites(), func(suite *tls.CipherSuite) bool {
+			return suite.Name == name
+		})
+		if index == -1 {
+			unknown = append(unknown, name)
+			continue
+		}
+
+		ids = append(ids, tls.CipherSuites()[index].ID)
+	}
+
+	if len(unknown) > 0 {
+		return nil, fmt.Errorf("unknown or insecure cipher suites %s", strings.Join(unknown, ", "))
+	}
+
+	return ids, nil
+}
+
+// New creates a TLS configuration that serves the certificates of reloader.
+// cipherSuites only apply to TLS 1.2, an empty list uses Go's defaults.
+func New(reloader *CertReloader, minVersion string, cipherSuites []string) (*tls.Config, error) {
+	parsedMinVersion, err := ParseMinVersion(minVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	var parsedCipherSuites []uint16
+	if len(cipherSuites) > 0 {
+		parsedCipherSuites, err = ParseCipherSuites(cipherSuites)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &tls.Config{
+		MinVersion:     parsedMinVersion,
+		CipherSuites:   parsedCipherSuites,
+		GetCertificate: reloader.GetCertificate,
+	}, nil
+}
+
+// RedirectHandler redirects every request permanently to the same URL on HTTPS.
+// httpsPort is the port of the TLS listener, it's omitted from the URL if it's 443.
+func RedirectHandler(httpsPort string) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		host := request.Host
+		if hostWithoutPort, _, err := net.SplitHostPort(host); err == nil {
+			host = hostWithoutPort
+		}
+
+		if httpsPort != "" && httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		} else if strings.Contains(host, ":") {
+			// IPv6 literal without port
+			host = "[" + host + "]"
+		}
+
+		target := "https://" + host + request.URL.RequestURI()
+
+		http.Redirect(writer, request, target, http.StatusPermanentRedirect)
+	})
+}
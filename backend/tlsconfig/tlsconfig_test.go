@@ -0,0 +1,216 @@
+package tlsconfig
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeSelfSignedCert generates a self-signed certificate for 127.0.0.1 with the given serial number,
+// writes it to certFile and keyFile and sets their modification time to modTime.
+func writeSelfSignedCert(t *testing.T, certFile string, keyFile string, serial int64, modTime time.Time) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "screenecho test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	for file, content := range map[string][]byte{certFile: certPEM, keyFile: keyPEM} {
+		if err := os.WriteFile(file, content, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(file, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// servedSerial opens a new TLS connection to addr and returns the serial number of the served certificate.
+func servedSerial(t *testing.T, addr string) int64 {
+	t.Helper()
+
+	conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64()
+}
+
+func TestCertReloader_ReloadKeepsExistingConnections(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	start := time.Now().Add(-time.Minute)
+
+	writeSelfSignedCert(t, certFile, keyFile, 1, start)
+
+	reloader, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tlsConfig, err := New(reloader, "1.2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// httptest.Server adds its own certificate, which would take precedence over GetCertificate
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", tlsConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})}
+	go server.Serve(listener)
+	defer server.Close()
+
+	addr := listener.Addr().String()
+
+	if serial := servedSerial(t, addr); serial != 1 {
+		t.Fatalf("expected certificate 1, got %d", serial)
+	}
+
+	// Long-lived connection opened before the reload
+	existing, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer existing.Close()
+
+	if reloaded, err := reloader.Reload(); reloaded || err != nil {
+		t.Fatalf("expected no reload for unchanged files, got reloaded=%v err=%v", reloaded, err)
+	}
+
+	writeSelfSignedCert(t, certFile, keyFile, 2, start.Add(30*time.Second))
+
+	if reloaded, err := reloader.Reload(); !reloaded || err != nil {
+		t.Fatalf("expected reload after files changed, got reloaded=%v err=%v", reloaded, err)
+	}
+
+	if serial := servedSerial(t, addr); serial != 2 {
+		t.Errorf("expected new connections to get certificate 2, got %d", serial)
+	}
+
+	// The existing connection still works and still uses the old certificate
+	if _, err := existing.Write([]byte("GET / HTTP/1.1\r\nHost: test\r\n\r\n")); err != nil {
+		t.Fatalf("existing connection broke: %v", err)
+	}
+	response, err := http.ReadResponse(bufio.NewReader(existing), nil)
+	if err != nil || response.StatusCode != http.StatusOK {
+		t.Fatalf("existing connection broke: %v", err)
+	}
+	if serial := existing.ConnectionState().PeerCertificates[0].SerialNumber.Int64(); serial != 1 {
+		t.Errorf("expected existing connection to keep certificate 1, got %d", serial)
+	}
+}
+
+func TestCertReloader_KeepsCertificateOnInvalidFiles(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+
+	writeSelfSignedCert(t, certFile, keyFile, 1, time.Now().Add(-time.Minute))
+
+	reloader, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate a half-finished deployment: the key file was truncated
+	if err := os.WriteFile(keyFile, []byte("garbage"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := reloader.Reload(); err == nil {
+		t.Fatal("expected error for invalid key file")
+	}
+
+	certificate, _ := reloader.GetCertificate(nil)
+	if certificate == nil || certificate.Leaf.SerialNumber.Int64() != 1 {
+		t.Errorf("expected previous certificate to be kept")
+	}
+}
+
+func TestNew_InvalidSettings(t *testing.T) {
+	if _, err := ParseMinVersion("1.0"); err == nil {
+		t.Errorf("expected TLS 1.0 to be rejected")
+	}
+
+	if _, err := ParseCipherSuites([]string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", "TLS_RSA_WITH_RC4_128_SHA"}); err == nil {
+		t.Errorf("expected insecure cipher suite to be rejected")
+	}
+
+	suites, err := ParseCipherSuites([]string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"})
+	if err != nil || len(suites) != 1 || suites[0] != tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 {
+		t.Errorf("unexpected cipher suites %v (error: %v)", suites, err)
+	}
+}
+
+func TestRedirectHandler(t *testing.T) {
+	tests := []struct {
+		Name      string
+		httpsPort string
+		host      string
+		target    string
+		expected  string
+	}{
+		{Name: "Default port", httpsPort: "443", host: "example.com", target: "/room/abc?x=1", expected: "https://example.com/room/abc?x=1"},
+		{Name: "Custom port", httpsPort: "8443", host: "example.com:8080", target: "/", expected: "https://example.com:8443/"},
+		{Name: "IPv6", httpsPort: "8443", host: "[::1]:8080", target: "/", expected: "https://[::1]:8443/"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			request := httptest.NewRequest("GET", tt.target, nil)
+			request.Host = tt.host
+
+			recorder := httptest.NewRecorder()
+			RedirectHandler(tt.httpsPort).ServeHTTP(recorder, request)
+
+			if recorder.Code != http.StatusPermanentRedirect || recorder.Header().Get("Location") != tt.expected {
+				t.Errorf("expected redirect to %s, got %d %s", tt.expected, recorder.Code, recorder.Header().Get("Location"))
+			}
+		})
+	}
+}
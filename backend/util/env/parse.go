@@ -0,0 +1,97 @@
+package env
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseInt parses a base 10 integer like "42" or "-1".
+func ParseInt(value string) (int, error) {
+	i, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("%q is not an integer", value)
+	}
+
+	return i, nil
+}
+
+// ParseBool parses a boolean as accepted by [strconv.ParseBool], e.g. "true", "false", "1" or "0".
+func ParseBool(value string) (bool, error) {
+	b, err := strconv.ParseBool(strings.TrimSpace(value))
+	if err != nil {
+		return false, fmt.Errorf("%q is not a boolean (true or false)", value)
+	}
+
+	return b, nil
+}
+
+// ParseDuration parses a duration as accepted by [time.ParseDuration], e.g. "10s" or "1m30s".
+func ParseDuration(value string) (time.Duration, error) {
+	d, err := time.ParseDuration(strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a duration (like 10s or 1m30s)", value)
+	}
+
+	return d, nil
+}
+
+// ParseList parses a comma-separated list. Elements are trimmed and empty elements are dropped,
+// so the empty string results in an empty list.
+func ParseList(value string) ([]string, error) {
+	list := make([]string, 0)
+	for element := range strings.SplitSeq(value, ",") {
+		element = strings.TrimSpace(element)
+		if element != "" {
+			list = append(list, element)
+		}
+	}
+
+	return list, nil
+}
+
+var byteSizeUnits = map[string]float64{
+	"":    1,
+	"b":   1,
+	"kb":  1e3,
+	"mb":  1e6,
+	"gb":  1e9,
+	"k":   1 << 10,
+	"m":   1 << 20,
+	"g":   1 << 30,
+	"kib": 1 << 10,
+	"mib": 1 << 20,
+	"gib": 1 << 30,
+}
+
+// ParseByteSize parses a size in bytes with an optional case-insensitive unit, e.g. "512", "64KiB", "1.5MB".
+// KB, MB and GB are powers of 1000, K, M, G, KiB, MiB and GiB are powers of 1024.
+func ParseByteSize(value string) (int64, error) {
+	trimmed := strings.TrimSpace(value)
+
+	numberEnd := strings.IndexFunc(trimmed, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if numberEnd == -1 {
+		numberEnd = len(trimmed)
+	}
+
+	number, err := strconv.ParseFloat(trimmed[:numberEnd], 64)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a byte size (like 512, 64KiB or 10MB)", value)
+	}
+
+	multiplier, exists := byteSizeUnits[strings.ToLower(strings.TrimSpace(trimmed[numberEnd:]))]
+	if !exists {
+		return 0, fmt.Errorf("%q has an unknown byte size unit (use B, KB, MB, GB, KiB, MiB or GiB)", value)
+	}
+
+	size := number * multiplier
+	if size > math.MaxInt64 {
+		return 0, fmt.Errorf("%q is too large", value)
+	}
+
+	return int64(size), nil
+}
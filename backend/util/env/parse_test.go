@@ -0,0 +1,51 @@
+package env
+
+import (
+	"testing"
+)
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		input       string
+		expected    int64
+		expectError bool
+	}{
+		{input: "512", expected: 512},
+		{input: "512B", expected: 512},
+		{input: "64KiB", expected: 64 * 1024},
+		{input: "64 kib", expected: 64 * 1024},
+		{input: "10MB", expected: 10_000_000},
+		{input: "1.5GiB", expected: 1536 * 1024 * 1024},
+		{input: "1M", expected: 1024 * 1024},
+		{input: "", expectError: true},
+		{input: "ten", expectError: true},
+		{input: "10XB", expectError: true},
+		{input: "-1", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			size, err := ParseByteSize(tt.input)
+
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("expected error but got %d", size)
+				}
+			} else if err != nil || size != tt.expected {
+				t.Errorf("expected %d, got %d (error: %v)", tt.expected, size, err)
+			}
+		})
+	}
+}
+
+func TestParseList(t *testing.T) {
+	list, _ := ParseList(" https://a.example.com, ,https://b.example.com ")
+
+	if len(list) != 2 || list[0] != "https://a.example.com" || list[1] != "https://b.example.com" {
+		t.Errorf("unexpected list %q", list)
+	}
+
+	if list, _ := ParseList(""); len(list) != 0 {
+		t.Errorf("expected empty list, got %q", list)
+	}
+}
@@ -0,0 +1,75 @@
+package logger
+
+import (
+	"context"
+	"log/slog"
+)
+
+type contextKey int
+
+const (
+	roomIDKey contextKey = iota
+	clientIDKey
+	messageTypeKey
+	requestIDKey
+)
+
+// Field names of the values stored in the context.
+const (
+	RoomIDField      = "room_id"
+	ClientIDField    = "client_id"
+	MessageTypeField = "message_type"
+	RequestIDField   = "request_id"
+)
+
+// contextFields lists the context keys in the order they appear in log records.
+var contextFields = []struct {
+	key  contextKey
+	name string
+}{
+	{requestIDKey, RequestIDField},
+	{roomIDKey, RoomIDField},
+	{clientIDKey, ClientIDField},
+	{messageTypeKey, MessageTypeField},
+}
+
+// WithRoomID returns a copy of ctx whose log records contain the room ID.
+func WithRoomID(ctx context.Context, roomID string) context.Context {
+	return context.WithValue(ctx, roomIDKey, roomID)
+}
+
+// WithClientID returns a copy of ctx whose log records contain the client ID.
+func WithClientID(ctx context.Context, clientID string) context.Context {
+	return context.WithValue(ctx, clientIDKey, clientID)
+}
+
+// WithMessageType returns a copy of ctx whose log records contain the message type.
+func WithMessageType(ctx context.Context, messageType string) context.Context {
+	return context.WithValue(ctx, messageTypeKey, messageType)
+}
+
+// WithRequestID returns a copy of ctx whose log records contain the request ID.
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey, requestID)
+}
+
+// RequestID returns the request ID stored in ctx or the empty string.
+func RequestID(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey).(string)
+	return requestID
+}
+
+func contextAttrs(ctx context.Context) []slog.Attr {
+	if ctx == nil {
+		return nil
+	}
+
+	var attrs []slog.Attr
+	for _, field := range contextFields {
+		if value, ok := ctx.Value(field.key).(string); ok && value != "" {
+			attrs = append(attrs, slog.String(field.name, value))
+		}
+	}
+
+	return attrs
+}
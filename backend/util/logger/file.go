@@ -0,0 +1,21 @@
+package logger
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// logFilePath is the path of the log file, see [Options.File].
+var logFilePath string
+
+// rotationOptions are the options of the log file, see [Options.Rotation].
+var rotationOptions RotationOptions
+
+// defaultFilePath returns logFilePath or, if it's not set, screenecho.log in the temp directory.
+func defaultFilePath() string {
+	if logFilePath != "" {
+		return logFilePath
+	}
+
+	return filepath.Join(os.TempDir(), "screenecho.log")
+}
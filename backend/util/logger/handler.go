@@ -0,0 +1,74 @@
+package logger
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+)
+
+// packageHandler filters records by the level of its package and adds the fields stored in the context.
+type packageHandler struct {
+	pkg  string
+	next slog.Handler
+}
+
+func (h *packageHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	if !enabled.Load() && level < slog.LevelError {
+		return false
+	}
+
+	return level >= levelFor(h.pkg)
+}
+
+func (h *packageHandler) Handle(ctx context.Context, record slog.Record) error {
+	record.AddAttrs(contextAttrs(ctx)...)
+
+	return h.next.Handle(ctx, record)
+}
+
+func (h *packageHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &packageHandler{pkg: h.pkg, next: h.next.WithAttrs(attrs)}
+}
+
+func (h *packageHandler) WithGroup(name string) slog.Handler {
+	return &packageHandler{pkg: h.pkg, next: h.next.WithGroup(name)}
+}
+
+// levelFor returns the level of pkg, which is the global level if pkg has no own level.
+func levelFor(pkg string) slog.Level {
+	if level, exists := packageLevels.Load(pkg); exists {
+		return level.(slog.Level)
+	}
+
+	return slog.Level(globalLevel.Load())
+}
+
+// jsonFormat is set if records are written as JSON instead of text, see [Options.JSON].
+var jsonFormat atomic.Bool
+
+// formatHandler writes records with its text or its JSON handler, depending on jsonFormat.
+// Loggers are created before the format is configured, so both handlers are kept.
+type formatHandler struct {
+	text slog.Handler
+	json slog.Handler
+}
+
+func (h *formatHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return true
+}
+
+func (h *formatHandler) Handle(ctx context.Context, record slog.Record) error {
+	if jsonFormat.Load() {
+		return h.json.Handle(ctx, record)
+	}
+
+	return h.text.Handle(ctx, record)
+}
+
+func (h *formatHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &formatHandler{text: h.text.WithAttrs(attrs), json: h.json.WithAttrs(attrs)}
+}
+
+func (h *formatHandler) WithGroup(name string) slog.Handler {
+	return &formatHandler{text: h.text.WithGroup(name), json: h.json.WithGroup(name)}
+}
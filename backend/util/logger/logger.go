@@ -1,9 +1,22 @@
+// Package logger provides structured, leveled logging built on [log/slog].
+//
+// Each package creates its own logger with [New]. The level can be set globally and per package.
+// Log records automatically contain the room ID, client ID, message type and request ID stored in the context
+// passed to the *Context logging methods, see [WithRoomID] and friends.
+//
+// The logger starts with [DefaultOptions]. The screenecho command applies the LOG_* settings of package config
+// with [Configure].
 package logger
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
 
 	"bjoernblessin.de/screenecho/util/assert"
 )
@@ -12,79 +25,97 @@ type LogLevel int
 
 const (
 	None LogLevel = iota
+	Error
 	Warn
 	Info
 	Debug
 	Trace
 )
 
-const logLevelEnv = "LOG_LEVEL"
+// LevelTrace is the slog level used for [TraceContext], it's more verbose than [slog.LevelDebug].
+const LevelTrace = slog.Level(-8)
 
-var logLevel LogLevel
-var fileLogger *log.Logger
-var consoleLogger *log.Logger
-var logFilePath string
-var enabled bool = true
-var fileEnabled bool = false
+// levelNone is above every level that is ever logged.
+const levelNone = slog.Level(1 << 20)
+
+var globalLevel atomic.Int64
+var packageLevels sync.Map // package name -> slog.Level
+var enabled atomic.Bool
+var output = &switchWriter{console: os.Stderr}
+var baseHandler slog.Handler
+var defaultLogger *slog.Logger
 
 func init() {
-	initLogger()
+	enabled.Store(true)
+	globalLevel.Store(int64(Info.slogLevel()))
 
-	envvar, present := os.LookupEnv(logLevelEnv)
-	if !present {
-		logLevel = Info
-		return
+	handlerOptions := &slog.HandlerOptions{
+		// Filtering is done by packageHandler, the base handler must accept everything
+		Level:       slog.Level(-1 << 20),
+		ReplaceAttr: replaceLevelName,
 	}
 
-	switch envvar {
-	case "NONE":
-		logLevel = None
-	case "WARN":
-		logLevel = Warn
-	case "INFO":
-		logLevel = Info
-	case "DEBUG":
-		logLevel = Debug
-	case "TRACE":
-		logLevel = Trace
-	default:
-		logLevel = Info
-		Warnf("Unknown log level '%s', defaulting to INFO", envvar)
+	baseHandler = &formatHandler{
+		text: slog.NewTextHandler(output, handlerOptions),
+		json: slog.NewJSONHandler(output, handlerOptions),
 	}
-}
 
-// initLogger initializes the logger, creating a temporary log file and setting up console and file loggers.
-func initLogger() {
-	consoleLogger = log.New(os.Stderr, "", log.LstdFlags)
+	defaultLogger = New("")
 
-	if !fileEnabled {
-		return
-	}
+	Configure(DefaultOptions())
+}
 
-	tempFile, err := os.CreateTemp("", "app-*.log")
-	if err != nil {
-		consoleLogger.Printf("Failed to create temp log file: %v", err)
-		return
+// New returns a logger for the package pkg. Every record is tagged with pkg and filtered by the level of pkg,
+// which is the global level unless set otherwise with [SetPackageLogLevel].
+func New(pkg string) *slog.Logger {
+	next := baseHandler
+	if pkg != "" {
+		next = next.WithAttrs([]slog.Attr{slog.String("pkg", pkg)})
 	}
 
-	logFilePath = tempFile.Name()
-	consoleLogger.Printf("Logging to file: %s", logFilePath)
+	return slog.New(&packageHandler{pkg: pkg, next: next})
+}
 
-	fileLogger = log.New(tempFile, "", log.LstdFlags|log.Lmicroseconds)
+// ParseLogLevel parses a level name like "INFO" (case-insensitive).
+func ParseLogLevel(name string) (LogLevel, bool) {
+	switch strings.ToUpper(strings.TrimSpace(name)) {
+	case "NONE":
+		return None, true
+	case "ERROR":
+		return Error, true
+	case "WARN":
+		return Warn, true
+	case "INFO":
+		return Info, true
+	case "DEBUG":
+		return Debug, true
+	case "TRACE":
+		return Trace, true
+	default:
+		return None, false
+	}
 }
 
+// SetLogLevel sets the global level used by all packages without an own level.
 func SetLogLevel(level LogLevel) {
-	logLevel = level
+	globalLevel.Store(int64(level.slogLevel()))
 }
 
 func GetLogLevel() LogLevel {
-	return logLevel
+	return fromSlogLevel(slog.Level(globalLevel.Load()))
+}
+
+// SetPackageLogLevel sets the level of the package pkg, overriding the global level.
+func SetPackageLogLevel(pkg string, level LogLevel) {
+	packageLevels.Store(pkg, level.slogLevel())
 }
 
 func (l LogLevel) String() string {
 	switch l {
 	case None:
 		return "NONE"
+	case Error:
+		return "ERROR"
 	case Warn:
 		return "WARN"
 	case Info:
@@ -98,116 +129,203 @@ func (l LogLevel) String() string {
 	}
 }
 
-// Errorf prints an error message prefixed with "[ERROR] " and stops execution.
+func (l LogLevel) slogLevel() slog.Level {
+	switch l {
+	case Error:
+		return slog.LevelError
+	case Warn:
+		return slog.LevelWarn
+	case Info:
+		return slog.LevelInfo
+	case Debug:
+		return slog.LevelDebug
+	case Trace:
+		return LevelTrace
+	default:
+		return levelNone
+	}
+}
+
+func fromSlogLevel(level slog.Level) LogLevel {
+	switch {
+	case level <= LevelTrace:
+		return Trace
+	case level <= slog.LevelDebug:
+		return Debug
+	case level <= slog.LevelInfo:
+		return Info
+	case level <= slog.LevelWarn:
+		return Warn
+	case level <= slog.LevelError:
+		return Error
+	default:
+		return None
+	}
+}
+
+// replaceLevelName prints LevelTrace as "TRACE" instead of "DEBUG-4".
+func replaceLevelName(groups []string, attr slog.Attr) slog.Attr {
+	if attr.Key == slog.LevelKey && len(groups) == 0 {
+		if level, ok := attr.Value.Any().(slog.Level); ok && level <= LevelTrace {
+			attr.Value = slog.StringValue("TRACE")
+		}
+	}
+
+	return attr
+}
+
+// Fatal logs msg at error level with log and stops execution.
+func Fatal(log *slog.Logger, msg string, args ...any) {
+	log.Error(msg, args...)
+	os.Exit(1)
+}
+
+// TraceContext logs msg at [LevelTrace] with log, like [slog.Logger.DebugContext] does for debug level.
+func TraceContext(ctx context.Context, log *slog.Logger, msg string, args ...any) {
+	log.Log(ctx, LevelTrace, msg, args...)
+}
+
+// Errorf logs an error message and stops execution.
 // After Errorf nothing will be executed anymore.
-// A newline is added to the end of the message.
 func Errorf(format string, v ...any) {
-	logFormat := fmt.Sprintf("[ERROR] %s", format)
-	if fileEnabled {
-		fileLogger.Printf(logFormat, v...)
-	}
-	consoleLogger.Fatalf(logFormat, v...)
+	Fatal(defaultLogger, fmt.Sprintf(format, v...))
 	assert.Never()
 }
 
-// Warnf prints a message prefixed with "[WARN] ".
-// A newline is added to the end of the message.
+// Warnf logs a formatted message at warn level.
 func Warnf(format string, v ...any) {
-	logFormat := fmt.Sprintf("[WARN] %s", format)
-	if fileEnabled {
-		fileLogger.Printf(logFormat, v...)
-	}
-	if logLevel >= Warn {
-		consoleLogger.Printf(logFormat, v...)
-	}
+	defaultLogger.Warn(fmt.Sprintf(format, v...))
 }
 
 // Panicf acts similar to [Errorf] but panics.
 // All deferred functions will execute and a stack trace is printed.
 // Technically you can recover from the panic, but that's not intended use.
 func Panicf(format string, v ...any) {
-	logFormat := fmt.Sprintf("[ERROR] %s", format)
-	if fileEnabled {
-		fileLogger.Printf(logFormat, v...)
-	}
-	consoleLogger.Panicf(logFormat, v...)
-	assert.Never()
+	msg := fmt.Sprintf(format, v...)
+	defaultLogger.Error(msg)
+	panic(msg)
 }
 
-// Infof prints an informational message prefixed with "[INFO] ".
-// A newline is added to the end of the message.
+// Infof logs a formatted message at info level.
 func Infof(format string, v ...any) {
-	if !enabled {
-		return
-	}
-
-	logFormat := fmt.Sprintf("[INFO] %s", format)
-	if fileEnabled {
-		fileLogger.Printf(logFormat, v...)
-	}
-	if logLevel >= Info {
-		consoleLogger.Printf(logFormat, v...)
-	}
+	defaultLogger.Info(fmt.Sprintf(format, v...))
 }
 
-// Debugf prints a debug message prefixed with "[DEBUG] ".
-// A newline is added to the end of the message.
+// Debugf logs a formatted message at debug level.
 func Debugf(format string, v ...any) {
-	if !enabled {
-		return
-	}
-
-	logFormat := fmt.Sprintf("[DEBUG] %s", format)
-	if fileEnabled {
-		fileLogger.Printf(logFormat, v...)
-	}
-	if logLevel >= Debug {
-		consoleLogger.Printf(logFormat, v...)
-	}
+	defaultLogger.Debug(fmt.Sprintf(format, v...))
 }
 
-// Tracef prints a trace message prefixed with "[TRACE] ".
-// A newline is added to the end of the message.
+// Tracef logs a formatted message at trace level.
 func Tracef(format string, v ...any) {
-	if !enabled {
-		return
-	}
-
-	logFormat := fmt.Sprintf("[TRACE] %s", format)
-	if fileEnabled {
-		fileLogger.Printf(logFormat, v...)
-	}
-	if logLevel >= Trace {
-		consoleLogger.Printf(logFormat, v...)
-	}
-}
-
-// GetLogFilePath returns the path to the current log file
-func GetLogFilePath() string {
-	return logFilePath
+	TraceContext(context.Background(), defaultLogger, fmt.Sprintf(format, v...))
 }
 
 // SetEnable sets whether logging (console and file) is enabled or not.
-// Errors and panics will still be logged, but other log levels will not output anything if disabled.
+// Errors will still be logged, but other log levels will not output anything if disabled.
 func SetEnable(enable bool) {
 	if enable {
-		enabled = true
+		enabled.Store(true)
 		Infof("--- LOGGING ENABLED ---")
 	} else {
 		Infof("--- LOGGING DISABLED ---")
-		enabled = false
+		enabled.Store(false)
 	}
 }
 
 // SetFileEnable sets whether file logging is enabled or not.
 // General logging (SetEnable) must also be enabled for file logging to work.
-// File logging must be initialized/enabled at the start of the application. Otherwise, no log file will be created.
+// If no file is configured, see [Options.File], the log file is created in the temp directory the first time file logging is enabled, see [GetLogFilePath].
 func SetFileEnable(enable bool) {
 	if enable {
-		fileEnabled = true
+		err := output.enableFile(defaultFilePath(), rotationOptions)
+		if err != nil {
+			Warnf("Failed to open log file: %v", err)
+			return
+		}
 		Infof("--- FILE LOGGING ENABLED ---")
 	} else {
 		Infof("--- FILE LOGGING DISABLED ---")
-		fileEnabled = false
+		output.disableFile()
 	}
 }
+
+// GetLogFilePath returns the path to the current log file
+func GetLogFilePath() string {
+	return output.filePath()
+}
+
+// CloseFile closes the log file, e.g. before the program exits. File logging is disabled afterwards.
+func CloseFile() error {
+	return output.closeFile()
+}
+
+// switchWriter writes to the console and, if enabled, additionally to a log file.
+// Writes are serialized, so records of concurrent loggers never interleave.
+type switchWriter struct {
+	console io.Writer
+	file    *RotatingFile
+	fileOn  bool
+	mutex   sync.Mutex
+}
+
+func (w *switchWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.fileOn {
+		_, _ = w.file.Write(p)
+	}
+
+	return w.console.Write(p)
+}
+
+// enableFile enables file logging. The file at path is only opened if no file was opened before.
+func (w *switchWriter) enableFile(path string, options RotationOptions) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.file == nil {
+		file, err := NewRotatingFile(path, options)
+		if err != nil {
+			return err
+		}
+		w.file = file
+	}
+
+	w.fileOn = true
+	return nil
+}
+
+func (w *switchWriter) disableFile() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.fileOn = false
+}
+
+func (w *switchWriter) closeFile() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.file == nil {
+		return nil
+	}
+
+	err := w.file.Close()
+	w.file = nil
+	w.fileOn = false
+
+	return err
+}
+
+func (w *switchWriter) filePath() string {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.file == nil {
+		return ""
+	}
+
+	return w.file.Path()
+}
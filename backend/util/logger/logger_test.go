@@ -0,0 +1,169 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+// captureOutput redirects all log output into the returned buffer until the test ends.
+func captureOutput(t *testing.T) *bytes.Buffer {
+	t.Helper()
+
+	var buffer bytes.Buffer
+
+	output.mutex.Lock()
+	previous := output.console
+	output.console = &buffer
+	output.mutex.Unlock()
+
+	t.Cleanup(func() {
+		output.mutex.Lock()
+		output.console = previous
+		output.mutex.Unlock()
+	})
+
+	return &buffer
+}
+
+func TestPackageLevels(t *testing.T) {
+	buffer := captureOutput(t)
+
+	SetLogLevel(Info)
+	SetPackageLogLevel("verbose", Debug)
+	SetPackageLogLevel("quiet", Warn)
+	t.Cleanup(func() {
+		packageLevels.Delete("verbose")
+		packageLevels.Delete("quiet")
+	})
+
+	New("verbose").Debug("verbose debug")
+	New("quiet").Info("quiet info")
+	New("quiet").Warn("quiet warn")
+	New("other").Debug("other debug")
+	New("other").Info("other info")
+
+	output := buffer.String()
+	for _, expected := range []string{"verbose debug", "quiet warn", "other info", "pkg=verbose"} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("expected %q in output:\n%s", expected, output)
+		}
+	}
+	for _, unexpected := range []string{"quiet info", "other debug"} {
+		if strings.Contains(output, unexpected) {
+			t.Errorf("unexpected %q in output:\n%s", unexpected, output)
+		}
+	}
+}
+
+func TestContextFields(t *testing.T) {
+	buffer := captureOutput(t)
+
+	ctx := WithRequestID(context.Background(), "req-1")
+	ctx = WithRoomID(ctx, "abc123")
+	ctx = WithClientID(ctx, "client-1")
+	ctx = WithMessageType(ctx, "sdp-offer")
+
+	New("test").InfoContext(ctx, "with context")
+
+	for _, expected := range []string{"request_id=req-1", "room_id=abc123", "client_id=client-1", "message_type=sdp-offer"} {
+		if !strings.Contains(buffer.String(), expected) {
+			t.Errorf("expected %q in output:\n%s", expected, buffer.String())
+		}
+	}
+
+	if RequestID(ctx) != "req-1" {
+		t.Errorf("expected request ID req-1, got %q", RequestID(ctx))
+	}
+}
+
+func TestTraceLevelName(t *testing.T) {
+	buffer := captureOutput(t)
+
+	SetLogLevel(Trace)
+	t.Cleanup(func() { SetLogLevel(Info) })
+
+	TraceContext(context.Background(), New("test"), "very verbose")
+
+	if !strings.Contains(buffer.String(), "level=TRACE") {
+		t.Errorf("expected level=TRACE in output:\n%s", buffer.String())
+	}
+}
+
+func TestConfigure(t *testing.T) {
+	buffer := captureOutput(t)
+	t.Cleanup(func() { Configure(DefaultOptions()) })
+
+	options := DefaultOptions()
+	options.Level = Warn
+	options.PackageLevels = map[string]LogLevel{"verbose": Debug}
+	options.JSON = true
+	options.RedactKeys = []string{"secret"}
+
+	// Package loggers are created before the config is loaded
+	log := New("verbose")
+	Configure(options)
+
+	log.Debug("verbose debug", "payload", Payload([]byte(`{"secret":"x","sdp":"v=0"}`)))
+	New("other").Info("other info")
+
+	output := buffer.String()
+	for _, expected := range []string{`"msg":"verbose debug"`, `secret\":\"[REDACTED`, `v=0`} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("expected %q in output:\n%s", expected, output)
+		}
+	}
+	if strings.Contains(output, "other info") {
+		t.Errorf("unexpected other info in output:\n%s", output)
+	}
+}
+
+func TestPayloadRedaction(t *testing.T) {
+	tests := []struct {
+		Name        string
+		input       string
+		contains    []string
+		notContains []string
+	}{
+		{
+			Name:        "SDP description is redacted",
+			input:       `{"remoteClientID":"abc","description":{"type":"offer","sdp":"v=0 o=- 192.168.0.1"}}`,
+			contains:    []string{`"remoteClientID":"abc"`, `"description":"[REDACTED`},
+			notContains: []string{"192.168.0.1"},
+		},
+		{
+			Name:        "Nested candidate is redacted",
+			input:       `{"msg":[{"candidate":"candidate:1 1 udp 10.0.0.1"}]}`,
+			contains:    []string{`"candidate":"[REDACTED`},
+			notContains: []string{"10.0.0.1"},
+		},
+		{
+			Name:     "Long payload is truncated",
+			input:    `{"clientID":"` + strings.Repeat("x", 2*payloadMaxLength) + `"}`,
+			contains: []string{"...[truncated"},
+		},
+		{
+			Name:     "Invalid JSON is logged as string",
+			input:    `not json`,
+			contains: []string{"not json"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			value := Payload([]byte(tt.input)).LogValue().String()
+
+			for _, expected := range tt.contains {
+				if !strings.Contains(value, expected) {
+					t.Errorf("expected %q in %s", expected, value)
+				}
+			}
+			for _, unexpected := range tt.notContains {
+				if strings.Contains(value, unexpected) {
+					t.Errorf("unexpected %q in %s", unexpected, value)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package logger
+
+// Options configure the logger, see [Configure].
+type Options struct {
+	// Level is the global level, see [SetLogLevel].
+	Level LogLevel
+	// PackageLevels holds the levels of the packages that don't use Level, see [SetPackageLogLevel].
+	PackageLevels map[string]LogLevel
+	// JSON writes records as JSON instead of text.
+	JSON bool
+	// PayloadMaxLength is the maximum length of logged message payloads in bytes, see [Payload].
+	PayloadMaxLength int
+	// RedactKeys are the JSON keys whose values are never logged, see [Payload].
+	RedactKeys []string
+	// File is the path of a log file written in addition to the console. Empty disables file logging.
+	File string
+	// Rotation configures the rotation of File.
+	Rotation RotationOptions
+}
+
+// DefaultOptions returns the options the logger starts with.
+// The redacted keys cover SDP and ICE data, which contain IP addresses.
+func DefaultOptions() Options {
+	return Options{
+		Level:            Info,
+		PayloadMaxLength: 512,
+		RedactKeys:       []string{"offer", "answer", "description", "candidate", "sdp"},
+		Rotation: RotationOptions{
+			MaxSize:    100 << 20,
+			Interval:   0,
+			MaxBackups: 7,
+			Compress:   true,
+		},
+	}
+}
+
+// Configure replaces the logger's settings with options.
+// It's meant to be called once at startup, before anything logs concurrently.
+func Configure(options Options) {
+	SetLogLevel(options.Level)
+
+	packageLevels.Clear()
+	for pkg, level := range options.PackageLevels {
+		SetPackageLogLevel(pkg, level)
+	}
+
+	jsonFormat.Store(options.JSON)
+
+	payloadMaxLength = options.PayloadMaxLength
+	redactKeys = make(map[string]bool, len(options.RedactKeys))
+	for _, key := range options.RedactKeys {
+		redactKeys[key] = true
+	}
+
+	logFilePath = options.File
+	rotationOptions = options.Rotation
+	if options.File != "" {
+		SetFileEnable(true)
+	}
+}
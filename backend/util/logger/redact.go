@@ -0,0 +1,74 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+)
+
+// payloadMaxLength is the maximum length of a logged payload, longer payloads are truncated, see [Options.PayloadMaxLength].
+var payloadMaxLength int
+
+// redactKeys holds JSON keys whose values are replaced, see [Options.RedactKeys].
+var redactKeys map[string]bool
+
+// Payload returns a log value for a JSON message payload.
+// Values of redacted keys (at any depth) are replaced by a placeholder and the result is truncated to the configured maximum length.
+// The payload is only processed if the record is actually logged.
+func Payload(data []byte) slog.LogValuer {
+	return payload(data)
+}
+
+type payload []byte
+
+func (p payload) LogValue() slog.Value {
+	return slog.StringValue(redactPayload(p))
+}
+
+func redactPayload(data []byte) string {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return truncate(string(data))
+	}
+
+	redacted, err := json.Marshal(redactValue(value))
+	if err != nil {
+		return truncate(string(data))
+	}
+
+	return truncate(string(redacted))
+}
+
+func redactValue(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, element := range v {
+			if redactKeys[key] {
+				raw, _ := json.Marshal(element)
+				v[key] = fmt.Sprintf("[REDACTED %d bytes]", len(raw))
+				continue
+			}
+			v[key] = redactValue(element)
+		}
+		return v
+	case []any:
+		for i, element := range v {
+			v[i] = redactValue(element)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+func truncate(s string) string {
+	if len(s) <= payloadMaxLength {
+		return s
+	}
+
+	return fmt.Sprintf("%s...[truncated %d bytes]", s[:payloadMaxLength], len(s)-payloadMaxLength)
+}
@@ -0,0 +1,369 @@
+package logger
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat is used in the names of rotated files. It sorts chronologically and contains no colons.
+const backupTimeFormat = "2006-01-02T15-04-05.000"
+
+// RotationOptions configures a RotatingFile.
+type RotationOptions struct {
+	// MaxSize is the size in bytes after which the file is rotated. 0 disables size-based rotation.
+	MaxSize int64
+	// Interval is the age after which the file is rotated. 0 disables time-based rotation.
+	Interval time.Duration
+	// MaxBackups is the number of rotated files to keep, older ones are deleted. 0 keeps all rotated files.
+	MaxBackups int
+	// Compress gzips rotated files.
+	Compress bool
+}
+
+// RotatingFile is an [io.WriteCloser] that writes to a file and rotates it when it grows too large or too old.
+//
+// A rotated file is renamed to <name>-<timestamp><ext> (e.g. screenecho-2025-01-31T12-00-00.000.log) next to the original file,
+// optionally compressed to <name>-<timestamp><ext>.gz and deleted once more than MaxBackups rotated files exist.
+// Compression and deletion happen in the background, Close waits for them to finish.
+//
+// RotatingFile is safe for concurrent use.
+type RotatingFile struct {
+	path    string
+	options RotationOptions
+	now     func() time.Time
+
+	// file is nil after a rotation failed to reopen the file, the next write retries opening it.
+	file     *os.File
+	size     int64
+	openedAt time.Time
+	closed   bool
+	mutex    sync.Mutex
+
+	// rotated wakes the mill goroutine after a rotation. It's never blocked on, a pending wake-up covers all
+	// rotations since, because the mill goroutine processes every rotated file it finds.
+	rotated  chan struct{}
+	millDone chan struct{}
+}
+
+// NewRotatingFile opens (or creates) the file at path for appending. Missing directories are created.
+func NewRotatingFile(path string, options RotationOptions) (*RotatingFile, error) {
+	return newRotatingFile(path, options, time.Now)
+}
+
+func newRotatingFile(path string, options RotationOptions, now func() time.Time) (*RotatingFile, error) {
+	rotatingFile := &RotatingFile{
+		path:     path,
+		options:  options,
+		now:      now,
+		rotated:  make(chan struct{}, 1),
+		millDone: make(chan struct{}),
+	}
+
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	err = rotatingFile.open()
+	if err != nil {
+		return nil, err
+	}
+
+	go rotatingFile.mill()
+
+	return rotatingFile, nil
+}
+
+// Path returns the path of the current log file.
+func (rf *RotatingFile) Path() string {
+	return rf.path
+}
+
+// Write writes p to the file, rotating it first if p would exceed MaxSize or the file is older than Interval.
+// A single write larger than MaxSize is written to a new file of its own.
+func (rf *RotatingFile) Write(p []byte) (int, error) {
+	rf.mutex.Lock()
+	defer rf.mutex.Unlock()
+
+	if rf.closed {
+		return 0, os.ErrClosed
+	}
+
+	if rf.file == nil {
+		err := rf.open()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	tooLarge := rf.options.MaxSize > 0 && rf.size > 0 && rf.size+int64(len(p)) > rf.options.MaxSize
+	tooOld := rf.options.Interval > 0 && rf.now().Sub(rf.openedAt) >= rf.options.Interval
+
+	if tooLarge || tooOld {
+		err := rf.rotate()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+
+	return n, err
+}
+
+// Rotate rotates the file immediately.
+func (rf *RotatingFile) Rotate() error {
+	rf.mutex.Lock()
+	defer rf.mutex.Unlock()
+
+	if rf.closed {
+		return os.ErrClosed
+	}
+
+	return rf.rotate()
+}
+
+// Close closes the file and waits until all rotated files are compressed and pruned.
+func (rf *RotatingFile) Close() error {
+	rf.mutex.Lock()
+
+	if rf.closed {
+		rf.mutex.Unlock()
+		return os.ErrClosed
+	}
+
+	var err error
+	if rf.file != nil {
+		err = rf.file.Close()
+		rf.file = nil
+	}
+	rf.closed = true
+	close(rf.rotated)
+
+	rf.mutex.Unlock()
+
+	<-rf.millDone
+
+	return err
+}
+
+// open opens the file at rf.path for appending. Must be called with rf.mutex locked.
+func (rf *RotatingFile) open() error {
+	file, err := os.OpenFile(rf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	rf.file = file
+	rf.size = info.Size()
+	rf.openedAt = rf.now()
+
+	return nil
+}
+
+// rotate renames the current file and opens a new one. Must be called with rf.mutex locked.
+// If the file can't be reopened, the failure is reported on stderr and the next write retries opening it.
+func (rf *RotatingFile) rotate() error {
+	if rf.file != nil {
+		err := rf.file.Close()
+		if err != nil {
+			return err
+		}
+		rf.file = nil
+	}
+
+	backupPath := rf.backupPath(rf.now())
+	renameErr := os.Rename(rf.path, backupPath)
+	if renameErr != nil && !os.IsNotExist(renameErr) {
+		// Keep writing to the current file rather than losing logs
+		_ = rf.reopen()
+		return renameErr
+	}
+
+	err := rf.reopen()
+	if err != nil {
+		return err
+	}
+
+	// The file may have been deleted externally, then there is nothing to compress
+	if renameErr == nil {
+		// Logging must not wait for the compression of earlier rotations
+		select {
+		case rf.rotated <- struct{}{}:
+		default:
+		}
+	}
+
+	return nil
+}
+
+// reopen opens the file after it was closed for a rotation and reports a failure on stderr,
+// as the logger can't log its own output failing. Must be called with rf.mutex locked.
+func (rf *RotatingFile) reopen() error {
+	err := rf.open()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "logger: failed to reopen log file %s after rotation, retrying on the next write: %v\n", rf.path, err)
+	}
+
+	return err
+}
+
+// backupPath returns an unused path for a file rotated at t.
+func (rf *RotatingFile) backupPath(t time.Time) string {
+	dir, prefix, ext := rf.nameParts()
+
+	for {
+		candidate := filepath.Join(dir, prefix+t.UTC().Format(backupTimeFormat)+ext)
+		if !fileExists(candidate) && !fileExists(candidate+".gz") {
+			return candidate
+		}
+
+		// Moving the timestamp instead of adding a counter keeps the names sorted chronologically
+		t = t.Add(time.Millisecond)
+	}
+}
+
+// nameParts splits rf.path into its directory, the prefix of rotated files ("<name>-") and the extension.
+func (rf *RotatingFile) nameParts() (dir string, prefix string, ext string) {
+	dir = filepath.Dir(rf.path)
+	ext = filepath.Ext(rf.path)
+	prefix = strings.TrimSuffix(filepath.Base(rf.path), ext) + "-"
+
+	return dir, prefix, ext
+}
+
+// mill compresses rotated files and deletes old ones whenever it's woken up, until rf.rotated is closed.
+func (rf *RotatingFile) mill() {
+	defer close(rf.millDone)
+
+	for range rf.rotated {
+		if rf.options.Compress {
+			rf.compressBackups()
+		}
+
+		if rf.options.MaxBackups > 0 {
+			rf.pruneBackups()
+		}
+	}
+}
+
+// compressBackups compresses all rotated files that aren't compressed yet.
+func (rf *RotatingFile) compressBackups() {
+	dir, _, ext := rf.nameParts()
+
+	for _, name := range rf.backups() {
+		if ext == ".gz" || strings.HasSuffix(name, ext+".gz") {
+			continue
+		}
+
+		backupPath := filepath.Join(dir, name)
+		err := compressFile(backupPath)
+		if err != nil && !os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "logger: failed to compress rotated log file %s: %v\n", backupPath, err)
+		}
+	}
+}
+
+// pruneBackups deletes the oldest rotated files so that at most MaxBackups remain.
+func (rf *RotatingFile) pruneBackups() {
+	dir, _, _ := rf.nameParts()
+
+	backups := rf.backups()
+	for len(backups) > rf.options.MaxBackups {
+		_ = os.Remove(filepath.Join(dir, backups[0]))
+		backups = backups[1:]
+	}
+}
+
+// backups returns the names of the rotated files, oldest first.
+func (rf *RotatingFile) backups() []string {
+	dir, prefix, ext := rf.nameParts()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+
+	var backups []string
+	for _, entry := range entries {
+		if isBackup(entry.Name(), prefix, ext) {
+			backups = append(backups, entry.Name())
+		}
+	}
+
+	// Timestamps sort chronologically
+	slices.Sort(backups)
+
+	return backups
+}
+
+// isBackup reports whether name is the name of a rotated file, see [RotatingFile.backupPath].
+// Other files sharing the prefix, like screenecho-access.log next to screenecho.log, aren't.
+func isBackup(name string, prefix string, ext string) bool {
+	timestamp, hasPrefix := strings.CutPrefix(name, prefix)
+	if !hasPrefix {
+		return false
+	}
+
+	timestamp, compressed := strings.CutSuffix(timestamp, ext+".gz")
+	if !compressed {
+		var hasExt bool
+		timestamp, hasExt = strings.CutSuffix(timestamp, ext)
+		if !hasExt {
+			return false
+		}
+	}
+
+	_, err := time.Parse(backupTimeFormat, timestamp)
+	return err == nil
+}
+
+// compressFile gzips the file at path to path.gz and removes the original.
+func compressFile(path string) error {
+	source, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	target, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+
+	gzipWriter := gzip.NewWriter(target)
+
+	_, err = io.Copy(gzipWriter, source)
+	if err == nil {
+		err = gzipWriter.Close()
+	}
+	if closeErr := target.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		_ = os.Remove(path + ".gz")
+		return err
+	}
+
+	return os.Remove(path)
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,359 @@
+package logger
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// backups returns the names of all rotated files next to path, oldest first.
+func backups(t *testing.T, path string) []string {
+	t.Helper()
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if entry.Name() != filepath.Base(path) {
+			names = append(names, entry.Name())
+		}
+	}
+	slices.Sort(names)
+
+	return names
+}
+
+// readLines returns all lines of the file at path, decompressing it if it ends with .gz.
+func readLines(t *testing.T, path string) []string {
+	t.Helper()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	if strings.HasSuffix(path, ".gz") {
+		gzipReader, err := gzip.NewReader(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		scanner = bufio.NewScanner(gzipReader)
+	}
+
+	var lines []string
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	return lines
+}
+
+func TestRotateBySize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+
+	file, err := NewRotatingFile(path, RotationOptions{MaxSize: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range []string{"first\n", "second\n", "third\n"} {
+		if _, err := file.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	names := backups(t, path)
+	if len(names) != 2 {
+		t.Fatalf("expected 2 rotated files, got %v", names)
+	}
+	for _, name := range names {
+		if !strings.HasPrefix(name, "app-") || !strings.HasSuffix(name, ".log") {
+			t.Errorf("unexpected rotated file name %q", name)
+		}
+	}
+
+	if lines := readLines(t, filepath.Join(filepath.Dir(path), names[0])); !slices.Equal(lines, []string{"first"}) {
+		t.Errorf("oldest rotated file contains %v", lines)
+	}
+	if lines := readLines(t, path); !slices.Equal(lines, []string{"third"}) {
+		t.Errorf("current file contains %v", lines)
+	}
+}
+
+func TestRotateByInterval(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+
+	now := time.Date(2025, 1, 31, 12, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+
+	file, err := newRotatingFile(path, RotationOptions{Interval: time.Hour}, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _ = file.Write([]byte("before\n"))
+	now = now.Add(30 * time.Minute)
+	_, _ = file.Write([]byte("still before\n"))
+	now = now.Add(30 * time.Minute)
+	_, _ = file.Write([]byte("after\n"))
+
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	names := backups(t, path)
+	if !slices.Equal(names, []string{"app-2025-01-31T13-00-00.000.log"}) {
+		t.Fatalf("unexpected rotated files %v", names)
+	}
+	if lines := readLines(t, filepath.Join(filepath.Dir(path), names[0])); !slices.Equal(lines, []string{"before", "still before"}) {
+		t.Errorf("rotated file contains %v", lines)
+	}
+	if lines := readLines(t, path); !slices.Equal(lines, []string{"after"}) {
+		t.Errorf("current file contains %v", lines)
+	}
+}
+
+func TestRotateCompressAndPrune(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+
+	now := time.Date(2025, 1, 31, 12, 0, 0, 0, time.UTC)
+	clock := func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+
+	file, err := newRotatingFile(path, RotationOptions{MaxBackups: 2, Compress: true}, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range 5 {
+		_, _ = fmt.Fprintf(file, "line %d\n", i)
+		if err := file.Rotate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	names := backups(t, path)
+	if len(names) != 2 {
+		t.Fatalf("expected 2 rotated files, got %v", names)
+	}
+	for i, name := range names {
+		if !strings.HasSuffix(name, ".log.gz") {
+			t.Fatalf("expected compressed file, got %q", name)
+		}
+
+		expected := fmt.Sprintf("line %d", i+3)
+		if lines := readLines(t, filepath.Join(filepath.Dir(path), name)); !slices.Equal(lines, []string{expected}) {
+			t.Errorf("%s contains %v, expected %q", name, lines, expected)
+		}
+	}
+}
+
+func TestRotateFasterThanCompression(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+
+	now := time.Date(2025, 1, 31, 12, 0, 0, 0, time.UTC)
+	clock := func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+
+	file, err := newRotatingFile(path, RotationOptions{Compress: true}, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Far more rotations than wake-ups can be pending, every rotated file is compressed anyway
+	const rotations = 100
+	for i := range rotations {
+		_, _ = fmt.Fprintf(file, "line %d\n", i)
+		if err := file.Rotate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	names := backups(t, path)
+	if len(names) != rotations {
+		t.Fatalf("expected %d rotated files, got %d", rotations, len(names))
+	}
+	for _, name := range names {
+		if !strings.HasSuffix(name, ".log.gz") {
+			t.Errorf("expected compressed file, got %q", name)
+		}
+	}
+}
+
+func TestPruneKeepsUnrelatedFiles(t *testing.T) {
+	tests := []struct {
+		Name      string
+		logFile   string
+		unrelated []string
+	}{
+		{Name: "With extension", logFile: "screenecho.log",
+			unrelated: []string{"screenecho-access.log", "screenecho-old.log.gz", "screenecho-2025-01-31.log", "other-2025-01-31T12-00-00.000.log"}},
+		{Name: "Without extension", logFile: "app",
+			unrelated: []string{"app-data", "app-config.json", "app-2025-01-31T12-00-00.000.log"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, test.logFile)
+
+			for _, name := range test.unrelated {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte("keep\n"), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			now := time.Date(2025, 1, 31, 12, 0, 0, 0, time.UTC)
+			clock := func() time.Time {
+				now = now.Add(time.Second)
+				return now
+			}
+
+			file, err := newRotatingFile(path, RotationOptions{MaxBackups: 1}, clock)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for range 3 {
+				_, _ = file.Write([]byte("line\n"))
+				if err := file.Rotate(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := file.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			names := backups(t, path)
+			for _, name := range test.unrelated {
+				if !slices.Contains(names, name) {
+					t.Errorf("expected unrelated file %q to survive pruning, got %v", name, names)
+				}
+			}
+			if len(names) != len(test.unrelated)+1 {
+				t.Errorf("expected 1 rotated file next to the unrelated files, got %v", names)
+			}
+		})
+	}
+}
+
+func TestRotateConcurrentWrites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+
+	file, err := NewRotatingFile(path, RotationOptions{MaxSize: 256, Compress: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const writers, linesPerWriter = 8, 100
+
+	var wg sync.WaitGroup
+	for w := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range linesPerWriter {
+				_, err := fmt.Fprintf(file, "writer %d line %d\n", w, i)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	seen := make(map[string]bool)
+	for _, name := range append(backups(t, path), filepath.Base(path)) {
+		for _, line := range readLines(t, filepath.Join(filepath.Dir(path), name)) {
+			if seen[line] {
+				t.Errorf("duplicate line %q", line)
+			}
+			seen[line] = true
+		}
+	}
+
+	if len(seen) != writers*linesPerWriter {
+		t.Errorf("expected %d lines, got %d", writers*linesPerWriter, len(seen))
+	}
+}
+
+func TestWriteAfterClose(t *testing.T) {
+	file, err := NewRotatingFile(filepath.Join(t.TempDir(), "app.log"), RotationOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = file.Close()
+
+	if _, err := file.Write([]byte("late\n")); err == nil {
+		t.Error("expected an error when writing to a closed file")
+	}
+}
+
+func TestWriteAfterFailedReopen(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "logs")
+	path := filepath.Join(dir, "app.log")
+
+	file, err := NewRotatingFile(path, RotationOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	// Without its directory, the file can't be reopened after the rotation
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Rotate(); err == nil {
+		t.Fatal("expected the rotation to fail to reopen the file")
+	}
+	if _, err := file.Write([]byte("lost\n")); err == nil || errors.Is(err, os.ErrClosed) {
+		t.Errorf("expected the write to retry opening the file and fail, got %v", err)
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.Write([]byte("recovered\n")); err != nil {
+		t.Fatalf("expected the write to reopen the file, got %v", err)
+	}
+
+	if lines := readLines(t, path); !slices.Equal(lines, []string{"recovered"}) {
+		t.Errorf("expected [recovered], got %v", lines)
+	}
+}
@@ -1,16 +1,45 @@
 package observer
 
 import (
+	"context"
+	"fmt"
+	"slices"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"bjoernblessin.de/screenecho/util/assert"
 	"bjoernblessin.de/screenecho/util/logger"
 )
 
-// Observable manages a set of subscribers (channels) that receive notifications.
+var log = logger.New("observer")
+
+// Observable manages a set of subscribers (channels or functions) that receive notifications.
 type Observable[T any] struct {
-	observers  map[chan T]struct{}
-	mu         sync.RWMutex
-	bufferSize int
+	observers map[chan T]*subscriber
+	// funcObservers are called synchronously by NotifyObservers, in the order they subscribed.
+	funcObservers []*funcObserver[T]
+	mu            sync.RWMutex
+	bufferSize    int
+	// replaySize is the number of recent values handed to new subscribers, see NewReplayObservable.
+	replaySize int
+	// history holds the last replaySize values, oldest first.
+	history []T
+}
+
+type funcObserver[T any] struct {
+	handler func(T)
+}
+
+// subscriber is the state of a subscriber channel.
+type subscriber struct {
+	// dropped counts the notifications that were skipped because the channel was full.
+	dropped atomic.Uint64
+	// done is closed when the channel is unsubscribed. Blocked notifications give up once it's closed.
+	done chan struct{}
+	// sending is read-locked while a notification is sent to the channel. The channel is closed with it locked,
+	// so that no notification sends to the closed channel.
+	sending sync.RWMutex
 }
 
 // NewObservable creates a new Observable instance.
@@ -19,24 +48,93 @@ type Observable[T any] struct {
 // Example: stringObservable := NewObservable[string]() creates an observable for string events.
 func NewObservable[T any](bufferSize int) *Observable[T] {
 	return &Observable[T]{
-		observers:  make(map[chan T]struct{}),
+		observers:  make(map[chan T]*subscriber),
 		bufferSize: bufferSize,
 	}
 }
 
+// NewReplayObservable creates an Observable that hands the last replaySize values to every new subscriber, oldest first.
+// It's useful for late subscribers that need the current state, with a replaySize of 1 it behaves like a behavior subject.
+// Subscriber channels are buffered with at least replaySize, so the replayed values always fit.
+// Every value is received exactly once by a subscriber, either replayed or notified.
+// Example: NewReplayObservable[string](10, 1) creates an observable that hands new subscribers the latest value.
+func NewReplayObservable[T any](bufferSize int, replaySize int) *Observable[T] {
+	assert.Assert(replaySize > 0, "replaySize must be positive")
+
+	observable := NewObservable[T](bufferSize)
+	observable.replaySize = replaySize
+
+	return observable
+}
+
 // Subscribe adds a new subscriber and returns a channel for receiving notifications.
 // The caller is responsible for consuming from the returned channel.
 // The channel will be closed when Unsubscribe is called or when the Observable is closed.
 // Example: msgChannel := myObservable.Subscribe() will return a new channel msgChannel that will receive notifications of type T.
 func (o *Observable[T]) Subscribe() chan T {
+	ch, _ := o.subscribe()
+	return ch
+}
+
+func (o *Observable[T]) subscribe() (chan T, *subscriber) {
 	o.mu.Lock()
 	defer o.mu.Unlock()
 
-	ch := make(chan T, o.bufferSize)
-	o.observers[ch] = struct{}{}
+	ch := make(chan T, max(o.bufferSize, o.replaySize))
+	for _, data := range o.history {
+		ch <- data
+	}
+
+	sub := &subscriber{done: make(chan struct{})}
+	o.observers[ch] = sub
+	return ch, sub
+}
+
+// SubscribeContext is like Subscribe, but the subscriber is removed and the channel closed once ctx is done.
+// Example: for data := range myObservable.SubscribeContext(ctx) { ... } stops when ctx is cancelled.
+func (o *Observable[T]) SubscribeContext(ctx context.Context) chan T {
+	ch, sub := o.subscribe()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			o.Unsubscribe(ch)
+		case <-sub.done:
+			// Unsubscribed before ctx was done
+		}
+	}()
+
 	return ch
 }
 
+// SubscribeFunc adds a subscriber function that is called synchronously by NotifyObservers and NotifyObserversBlock,
+// in the goroutine that notifies. Functions are called in the order they subscribed, after all channels were notified.
+// Calling the returned function removes the subscriber, it's safe to call it multiple times and from within handler.
+// The values of a replay observable are handed to handler before SubscribeFunc returns, handler must not use the observable for them.
+// A notification that started before the subscriber was removed may still call handler.
+// Example: unsubscribe := myObservable.SubscribeFunc(func(data string) { fmt.Println(data) }) prints every notification until unsubscribe() is called.
+func (o *Observable[T]) SubscribeFunc(handler func(T)) (unsubscribe func()) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	// The history is replayed with the lock held, so no notification can overtake it
+	for _, data := range o.history {
+		handler(data)
+	}
+
+	observer := &funcObserver[T]{handler: handler}
+	o.funcObservers = append(o.funcObservers, observer)
+
+	return func() {
+		o.mu.Lock()
+		defer o.mu.Unlock()
+
+		o.funcObservers = slices.DeleteFunc(o.funcObservers, func(other *funcObserver[T]) bool {
+			return other == observer
+		})
+	}
+}
+
 // SubscribeOnce adds a subscriber that will receive only one notification.
 // The returned channel will receive exactly one value and then be closed.
 // The subscription will be automatically cleaned up.
@@ -63,50 +161,199 @@ func (o *Observable[T]) SubscribeOnce() chan T {
 // Example: myObservable.Unsubscribe(msgChannel) will remove msgChannel from the subscribers and close it.
 func (o *Observable[T]) Unsubscribe(ch chan T) {
 	o.mu.Lock()
-	defer o.mu.Unlock()
+	sub, ok := o.observers[ch]
+	delete(o.observers, ch)
+	o.mu.Unlock()
+
+	if ok {
+		closeSubscriber(ch, sub)
+	}
+}
+
+// closeSubscriber closes sub.done and then ch, once the notifications sending to ch gave up.
+// sub must already be removed from the observers, so that it's closed only once.
+func closeSubscriber[T any](ch chan T, sub *subscriber) {
+	close(sub.done)
+
+	sub.sending.Lock()
+	defer sub.sending.Unlock()
+
+	close(ch)
+}
+
+// Dropped returns the number of notifications that were skipped for the subscriber channel ch because it was full.
+// It returns 0 if ch is not subscribed (anymore).
+func (o *Observable[T]) Dropped(ch chan T) uint64 {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
 
-	if _, ok := o.observers[ch]; ok {
-		delete(o.observers, ch)
-		close(ch)
+	sub, ok := o.observers[ch]
+	if !ok {
+		return 0
 	}
+
+	return sub.dropped.Load()
 }
 
 // NotifyObservers sends data to all currently subscribed channels.
 // This operation is non-blocking for the Observable. If a subscriber's channel buffer is full,
-// the notification for that subscriber might be dropped to prevent blocking NotifyObservers.
+// the notification for that subscriber is dropped to prevent blocking NotifyObservers, see Dropped.
 // Example: myObservable.NotifyObservers("hello world") will send "hello world" to all subscribed channels.
 func (o *Observable[T]) NotifyObservers(data T) {
-	o.mu.RLock()
-	defer o.mu.RUnlock()
+	o.notify(data, func(ch chan T, sub *subscriber) {
+		select {
+		case ch <- data:
+		default:
+			o.drop(sub)
+		}
+	})
+}
+
+// NotifyObserversBlock is similar to NotifyObservers but blocks until all subscribers have received the data.
+// Subscribers that are unsubscribed in the meantime, e.g. by SubscribeContext, are skipped.
+func (o *Observable[T]) NotifyObserversBlock(data T) {
+	o.notify(data, func(ch chan T, sub *subscriber) {
+		select {
+		case ch <- data:
+		case <-sub.done:
+		}
+	})
+}
+
+// NotifyObserversTimeout is similar to NotifyObserversBlock but blocks for at most timeout in total.
+// Once timeout elapsed, the notification is dropped for every subscriber whose channel is still full.
+// Subscriber functions are called regardless of the timeout.
+func (o *Observable[T]) NotifyObserversTimeout(data T, timeout time.Duration) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	expired := false
+	o.notify(data, func(ch chan T, sub *subscriber) {
+		if !expired {
+			select {
+			case ch <- data:
+				return
+			case <-sub.done:
+				return
+			case <-timer.C:
+				expired = true
+			}
+		}
 
-	for ch := range o.observers {
 		select {
 		case ch <- data:
 		default:
-			// Subscriber channel is full or closed, skip sending to this one
-			logger.Debugf("Observable[%T](%p): Subscriber channel is full or closed, skipping notification", data, o)
+			o.drop(sub)
+		}
+	})
+}
+
+// notify calls send for every subscriber channel, then calls the subscriber functions.
+// The subscribers are collected with the lock held, but send is called without it, so that a blocking send doesn't keep
+// subscribers from unsubscribing. A replay observable records data while collecting, so that a concurrent Subscribe
+// either replays data or is notified of it, but not both.
+func (o *Observable[T]) notify(data T, send func(ch chan T, sub *subscriber)) {
+	type target struct {
+		ch  chan T
+		sub *subscriber
+	}
+
+	if o.replaySize > 0 {
+		o.mu.Lock()
+		o.history = append(o.history, data)
+		if len(o.history) > o.replaySize {
+			o.history = slices.Delete(o.history, 0, len(o.history)-o.replaySize)
+		}
+	} else {
+		o.mu.RLock()
+	}
+
+	targets := make([]target, 0, len(o.observers))
+	for ch, sub := range o.observers {
+		targets = append(targets, target{ch: ch, sub: sub})
+	}
+	funcObservers := slices.Clone(o.funcObservers)
+
+	if o.replaySize > 0 {
+		o.mu.Unlock()
+	} else {
+		o.mu.RUnlock()
+	}
+
+	for _, target := range targets {
+		target.sub.sending.RLock()
+		select {
+		case <-target.sub.done:
+			// Unsubscribed after it was collected
+		default:
+			send(target.ch, target.sub)
 		}
+		target.sub.sending.RUnlock()
 	}
+
+	o.notifyFuncObservers(funcObservers, data)
 }
 
-// NotifyObservers is similar to NotifyObservers but blocks until all subscribers have received the data.
-func (o *Observable[T]) NotifyObserversBlock(data T) {
-	o.mu.RLock()
-	defer o.mu.RUnlock()
+func (o *Observable[T]) drop(sub *subscriber) {
+	sub.dropped.Add(1)
+	log.Debug("Subscriber channel is full, skipping notification", "observable", fmt.Sprintf("%T(%p)", o, o))
+}
 
-	for ch := range o.observers {
-		ch <- data
+// notifyFuncObservers calls the subscriber functions without holding the lock,
+// so that they can notify, subscribe and unsubscribe themselves.
+func (o *Observable[T]) notifyFuncObservers(funcObservers []*funcObserver[T], data T) {
+	for _, observer := range funcObservers {
+		observer.handler(data)
 	}
 }
 
-// ClearAllSubscribers removes all subscribers and closes their respective channels.
+// ClearAllSubscribers removes all subscribers and closes their respective channels. Subscriber functions are removed as well.
 // Example: myObservable.ClearAllSubscribers() will remove and close all subscriber channels.
 func (o *Observable[T]) ClearAllSubscribers() {
 	o.mu.Lock()
-	defer o.mu.Unlock()
+	observers := o.observers
+	o.observers = make(map[chan T]*subscriber)
+	o.funcObservers = nil
+	o.mu.Unlock()
 
-	for ch := range o.observers {
-		delete(o.observers, ch)
-		close(ch)
+	for ch, sub := range observers {
+		closeSubscriber(ch, sub)
 	}
 }
+
+// Replay returns a copy of the values a new subscriber of a replay observable receives, oldest first.
+// It's empty for observables created by NewObservable.
+func (o *Observable[T]) Replay() []T {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	return slices.Clone(o.history)
+}
+
+// Filter returns an observable that is notified with every value of source for which predicate returns true.
+// predicate is called synchronously by the notifications of source. Calling unsubscribe detaches the returned observable from source.
+// Example: errors, unsubscribe := Filter(events, func(event Event) bool { return event.IsError() })
+func Filter[T any](source *Observable[T], predicate func(T) bool) (filtered *Observable[T], unsubscribe func()) {
+	filtered = NewObservable[T](source.bufferSize)
+
+	unsubscribe = source.SubscribeFunc(func(data T) {
+		if predicate(data) {
+			filtered.NotifyObservers(data)
+		}
+	})
+
+	return filtered, unsubscribe
+}
+
+// Map returns an observable that is notified with transform applied to every value of source.
+// transform is called synchronously by the notifications of source. Calling unsubscribe detaches the returned observable from source.
+// Example: lengths, unsubscribe := Map(strings, func(s string) int { return len(s) })
+func Map[T any, U any](source *Observable[T], transform func(T) U) (mapped *Observable[U], unsubscribe func()) {
+	mapped = NewObservable[U](source.bufferSize)
+
+	unsubscribe = source.SubscribeFunc(func(data T) {
+		mapped.NotifyObservers(transform(data))
+	})
+
+	return mapped, unsubscribe
+}
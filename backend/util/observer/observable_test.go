@@ -0,0 +1,413 @@
+package observer
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// receive reads count values from ch or fails after a second.
+func receive[T any](t *testing.T, ch <-chan T, count int) []T {
+	t.Helper()
+
+	values := make([]T, 0, count)
+	for range count {
+		select {
+		case value := <-ch:
+			values = append(values, value)
+		case <-time.After(time.Second):
+			t.Fatalf("received %d of %d values: %v", len(values), count, values)
+		}
+	}
+
+	return values
+}
+
+// assertEmpty fails if ch holds a value.
+func assertEmpty[T any](t *testing.T, ch <-chan T) {
+	t.Helper()
+
+	select {
+	case value, ok := <-ch:
+		if ok {
+			t.Errorf("expected no value, got %v", value)
+		}
+	default:
+	}
+}
+
+// assertClosed fails if ch isn't closed within a second.
+func assertClosed[T any](t *testing.T, ch <-chan T) {
+	t.Helper()
+
+	for {
+		select {
+		case _, ok := <-ch:
+			if !ok {
+				return
+			}
+		case <-time.After(time.Second):
+			t.Fatal("expected the channel to be closed")
+		}
+	}
+}
+
+func TestNotifyObservers(t *testing.T) {
+	observable := NewObservable[int](2)
+	first, second := observable.Subscribe(), observable.Subscribe()
+
+	observable.NotifyObservers(1)
+	observable.NotifyObservers(2)
+
+	for _, ch := range []chan int{first, second} {
+		if values := receive(t, ch, 2); !slices.Equal(values, []int{1, 2}) {
+			t.Errorf("expected [1 2], got %v", values)
+		}
+	}
+
+	observable.Unsubscribe(first)
+	observable.NotifyObservers(3)
+
+	assertClosed(t, first)
+	if values := receive(t, second, 1); values[0] != 3 {
+		t.Errorf("expected 3, got %v", values)
+	}
+}
+
+func TestDropped(t *testing.T) {
+	observable := NewObservable[int](2)
+	slow, fast := observable.Subscribe(), observable.Subscribe()
+
+	for i := range 5 {
+		observable.NotifyObservers(i)
+		receive(t, fast, 1)
+	}
+
+	tests := []struct {
+		Name     string
+		Channel  chan int
+		Expected uint64
+	}{
+		{Name: "full channel", Channel: slow, Expected: 3},
+		{Name: "consumed channel", Channel: fast, Expected: 0},
+		{Name: "unknown channel", Channel: make(chan int), Expected: 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			if dropped := observable.Dropped(test.Channel); dropped != test.Expected {
+				t.Errorf("expected %d dropped notifications, got %d", test.Expected, dropped)
+			}
+		})
+	}
+
+	if values := receive(t, slow, 2); !slices.Equal(values, []int{0, 1}) {
+		t.Errorf("expected the first values to be kept, got %v", values)
+	}
+}
+
+func TestNotifyObserversTimeout(t *testing.T) {
+	observable := NewObservable[int](0)
+	ch := observable.Subscribe()
+
+	t.Run("delivered within timeout", func(t *testing.T) {
+		received := make(chan int)
+		go func() { received <- <-ch }()
+
+		observable.NotifyObserversTimeout(1, time.Second)
+
+		if value := <-received; value != 1 {
+			t.Errorf("expected 1, got %d", value)
+		}
+		if dropped := observable.Dropped(ch); dropped != 0 {
+			t.Errorf("expected no dropped notification, got %d", dropped)
+		}
+	})
+
+	t.Run("dropped after timeout", func(t *testing.T) {
+		start := time.Now()
+		observable.NotifyObserversTimeout(2, 50*time.Millisecond)
+
+		if elapsed := time.Since(start); elapsed < 50*time.Millisecond || elapsed > time.Second {
+			t.Errorf("expected to block for about the timeout, blocked %v", elapsed)
+		}
+		if dropped := observable.Dropped(ch); dropped != 1 {
+			t.Errorf("expected 1 dropped notification, got %d", dropped)
+		}
+	})
+
+	t.Run("timeout is shared by all subscribers", func(t *testing.T) {
+		for range 4 {
+			observable.Subscribe()
+		}
+
+		start := time.Now()
+		observable.NotifyObserversTimeout(3, 50*time.Millisecond)
+
+		if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+			t.Errorf("expected to block for the timeout once, blocked %v", elapsed)
+		}
+	})
+}
+
+func TestSubscribeContext(t *testing.T) {
+	observable := NewObservable[int](1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := observable.SubscribeContext(ctx)
+
+	observable.NotifyObservers(1)
+	receive(t, ch, 1)
+
+	cancel()
+	assertClosed(t, ch)
+
+	observable.NotifyObservers(2)
+	assertEmpty(t, ch)
+
+	t.Run("unsubscribed before cancel", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		ch := observable.SubscribeContext(ctx)
+		observable.Unsubscribe(ch)
+		assertClosed(t, ch)
+
+		// Cancelling afterwards must not close the channel a second time
+		cancel()
+		time.Sleep(10 * time.Millisecond)
+	})
+}
+
+func TestSubscribeContextCancelDuringNotify(t *testing.T) {
+	tests := []struct {
+		Name       string
+		Observable *Observable[int]
+		Notify     func(observable *Observable[int])
+	}{
+		{
+			Name:       "block",
+			Observable: NewObservable[int](0),
+			Notify:     func(observable *Observable[int]) { observable.NotifyObserversBlock(1) },
+		},
+		{
+			Name:       "timeout on replay observable",
+			Observable: NewReplayObservable[int](0, 1),
+			Notify:     func(observable *Observable[int]) { observable.NotifyObserversTimeout(1, time.Hour) },
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			// A replay observable hands the value to the new subscriber, which fills its channel
+			test.Observable.NotifyObservers(0)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			// Nobody reads from the channel, so the notification blocks until the subscriber is removed
+			ch := test.Observable.SubscribeContext(ctx)
+
+			notified := make(chan struct{})
+			go func() {
+				defer close(notified)
+				test.Notify(test.Observable)
+			}()
+
+			time.Sleep(10 * time.Millisecond)
+			cancel()
+
+			select {
+			case <-notified:
+			case <-time.After(time.Second):
+				t.Fatal("expected the notification to return after the subscriber was cancelled")
+			}
+			assertClosed(t, ch)
+		})
+	}
+}
+
+func TestSubscribeFunc(t *testing.T) {
+	observable := NewObservable[int](0)
+
+	var received []int
+	unsubscribe := observable.SubscribeFunc(func(value int) { received = append(received, value) })
+
+	observable.NotifyObservers(1)
+	observable.NotifyObserversBlock(2)
+	unsubscribe()
+	unsubscribe()
+	observable.NotifyObservers(3)
+
+	if !slices.Equal(received, []int{1, 2}) {
+		t.Errorf("expected [1 2], got %v", received)
+	}
+}
+
+func TestReplayObservable(t *testing.T) {
+	tests := []struct {
+		Name       string
+		ReplaySize int
+		Notified   []int
+		Expected   []int
+	}{
+		{Name: "nothing notified", ReplaySize: 3, Notified: nil, Expected: nil},
+		{Name: "fewer values than replay size", ReplaySize: 3, Notified: []int{1, 2}, Expected: []int{1, 2}},
+		{Name: "more values than replay size", ReplaySize: 3, Notified: []int{1, 2, 3, 4, 5}, Expected: []int{3, 4, 5}},
+		{Name: "behavior subject", ReplaySize: 1, Notified: []int{1, 2, 3}, Expected: []int{3}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			// An unbuffered observable, the replayed values must fit into the channel anyway
+			observable := NewReplayObservable[int](0, test.ReplaySize)
+			for _, value := range test.Notified {
+				observable.NotifyObservers(value)
+			}
+
+			if replay := observable.Replay(); !slices.Equal(replay, test.Expected) {
+				t.Errorf("expected replay %v, got %v", test.Expected, replay)
+			}
+
+			ch := observable.Subscribe()
+			if cap(ch) != test.ReplaySize {
+				t.Errorf("expected a channel buffered with the replay size %d, got %d", test.ReplaySize, cap(ch))
+			}
+			if values := receive(t, ch, len(test.Expected)); !slices.Equal(values, test.Expected) {
+				t.Errorf("expected channel to receive %v, got %v", test.Expected, values)
+			}
+			assertEmpty(t, ch)
+
+			var handled []int
+			observable.SubscribeFunc(func(value int) { handled = append(handled, value) })
+			if !slices.Equal(handled, test.Expected) {
+				t.Errorf("expected function to receive %v, got %v", test.Expected, handled)
+			}
+		})
+	}
+}
+
+func TestReplayObservableConcurrentSubscribe(t *testing.T) {
+	const count = 200
+	observable := NewReplayObservable[int](count, count)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := range count {
+			observable.NotifyObservers(i)
+		}
+	}()
+
+	// Every subscriber receives every value exactly once, either replayed or notified
+	subscribers := make([]chan int, 0, 10)
+	for range 10 {
+		subscribers = append(subscribers, observable.Subscribe())
+	}
+	wg.Wait()
+
+	for _, ch := range subscribers {
+		values := receive(t, ch, count)
+		for i, value := range values {
+			if value != i {
+				t.Fatalf("expected value %d at position %d, got %v", i, i, values)
+			}
+		}
+		assertEmpty(t, ch)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	source := NewObservable[int](10)
+	even, unsubscribe := Filter(source, func(value int) bool { return value%2 == 0 })
+	ch := even.Subscribe()
+
+	for i := range 6 {
+		source.NotifyObservers(i)
+	}
+
+	if values := receive(t, ch, 3); !slices.Equal(values, []int{0, 2, 4}) {
+		t.Errorf("expected [0 2 4], got %v", values)
+	}
+
+	unsubscribe()
+	source.NotifyObservers(6)
+	assertEmpty(t, ch)
+}
+
+func TestMap(t *testing.T) {
+	source := NewObservable[string](10)
+	lengths, unsubscribe := Map(source, func(value string) int { return len(value) })
+	ch := lengths.Subscribe()
+
+	source.NotifyObservers("a")
+	source.NotifyObservers("abc")
+
+	if values := receive(t, ch, 2); !slices.Equal(values, []int{1, 3}) {
+		t.Errorf("expected [1 3], got %v", values)
+	}
+
+	unsubscribe()
+	source.NotifyObservers("ab")
+	assertEmpty(t, ch)
+}
+
+func TestSubscribeOnce(t *testing.T) {
+	observable := NewObservable[int](1)
+	once := observable.SubscribeOnce()
+
+	observable.NotifyObservers(1)
+
+	if values := receive(t, once, 1); values[0] != 1 {
+		t.Errorf("expected 1, got %v", values)
+	}
+	assertClosed(t, once)
+}
+
+func TestClearAllSubscribers(t *testing.T) {
+	observable := NewObservable[int](1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ch, contextCh := observable.Subscribe(), observable.SubscribeContext(ctx)
+	called := false
+	observable.SubscribeFunc(func(int) { called = true })
+
+	observable.ClearAllSubscribers()
+	observable.NotifyObservers(1)
+
+	assertClosed(t, ch)
+	assertClosed(t, contextCh)
+	if called {
+		t.Error("expected the function to be removed")
+	}
+}
+
+func TestConcurrentNotifyAndUnsubscribe(t *testing.T) {
+	observable := NewReplayObservable[int](1, 5)
+
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for i := range 200 {
+				observable.NotifyObservers(i)
+				observable.NotifyObserversTimeout(i, time.Millisecond)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for range 200 {
+				ctx, cancel := context.WithCancel(context.Background())
+				ch := observable.SubscribeContext(ctx)
+				_ = observable.Dropped(ch)
+				cancel()
+				observable.SubscribeFunc(func(int) {})()
+			}
+		}()
+	}
+	wg.Wait()
+}
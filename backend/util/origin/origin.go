@@ -0,0 +1,164 @@
+// Package origin decides which browser origins are allowed to talk to the server.
+// The same Allowlist is used for the WebSocket upgrade and the CORS headers of the HTTP endpoints,
+// so both always agree on what is allowed.
+package origin
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"bjoernblessin.de/screenecho/util/logger"
+)
+
+var log = logger.New("origin")
+
+// Allowlist holds the origins that may access the server.
+//
+// Patterns are either exact origins like "https://screenecho.example.com" or wildcard subdomain patterns
+// like "https://*.example.com". A wildcard matches any subdomain (at any depth) but not the apex domain itself.
+// The single pattern "*" allows every origin.
+type Allowlist struct {
+	exact     map[string]bool
+	wildcards []wildcardPattern
+	allowAll  bool
+	// allowLocalhost allows any origin on localhost, 127.0.0.1 or ::1 regardless of scheme and port (dev mode).
+	allowLocalhost bool
+}
+
+type wildcardPattern struct {
+	scheme string
+	suffix string // host suffix including the leading dot, e.g. ".example.com"
+	port   string // empty matches only the default port
+}
+
+// NewAllowlist creates an Allowlist from patterns.
+// If allowLocalhost is true, every localhost origin is allowed additionally.
+// Invalid patterns are ignored with a warning. Without any pattern and allowLocalhost, all cross-origin requests are rejected.
+func NewAllowlist(patterns []string, allowLocalhost bool) *Allowlist {
+	allowlist := &Allowlist{
+		exact:          make(map[string]bool),
+		allowLocalhost: allowLocalhost,
+	}
+
+	for _, pattern := range patterns {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+
+		if pattern == "*" {
+			allowlist.allowAll = true
+			continue
+		}
+
+		scheme, host, port, ok := splitOrigin(pattern)
+		if !ok {
+			log.Warn("Ignoring invalid origin pattern", "pattern", pattern)
+			continue
+		}
+
+		if strings.HasPrefix(host, "*.") {
+			allowlist.wildcards = append(allowlist.wildcards, wildcardPattern{
+				scheme: scheme,
+				suffix: host[1:],
+				port:   port,
+			})
+			continue
+		}
+
+		allowlist.exact[joinOrigin(scheme, host, port)] = true
+	}
+
+	if !allowlist.allowAll && !allowLocalhost && len(allowlist.exact) == 0 && len(allowlist.wildcards) == 0 {
+		log.Warn("No allowed origins configured, all cross-origin requests will be rejected")
+	}
+
+	return allowlist
+}
+
+// IsAllowed reports whether the origin (the value of an Origin header) is allowed.
+// The comparison is case-insensitive, the literal origin "null" is never allowed.
+func (allowlist *Allowlist) IsAllowed(origin string) bool {
+	// Sent by sandboxed iframes and file:// pages, allowing it would allow all of them at once
+	if strings.EqualFold(origin, "null") {
+		return false
+	}
+
+	if allowlist.allowAll {
+		return true
+	}
+
+	scheme, host, port, ok := splitOrigin(origin)
+	if !ok || strings.Contains(host, "*") {
+		return false
+	}
+
+	if allowlist.allowLocalhost && isLocalhost(host) {
+		return true
+	}
+
+	if allowlist.exact[joinOrigin(scheme, host, port)] {
+		return true
+	}
+
+	for _, wildcard := range allowlist.wildcards {
+		if scheme == wildcard.scheme && port == wildcard.port &&
+			strings.HasSuffix(host, wildcard.suffix) && len(host) > len(wildcard.suffix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CheckOrigin can be used as [websocket.Upgrader.CheckOrigin].
+// Requests without an Origin header are not sent by browsers and are therefore allowed.
+func (allowlist *Allowlist) CheckOrigin(request *http.Request) bool {
+	origin := request.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	return allowlist.IsAllowed(origin)
+}
+
+// splitOrigin splits an origin like "https://example.com:8443" into its lower-cased parts.
+// Default ports are removed so that "https://example.com:443" equals "https://example.com".
+func splitOrigin(origin string) (scheme string, host string, port string, ok bool) {
+	parsed, err := url.Parse(strings.ToLower(origin))
+	if err != nil || parsed.Scheme == "" || parsed.Host == "" {
+		return "", "", "", false
+	}
+
+	if parsed.Path != "" && parsed.Path != "/" || parsed.RawQuery != "" || parsed.Fragment != "" || parsed.User != nil {
+		return "", "", "", false
+	}
+
+	host = parsed.Hostname()
+	port = parsed.Port()
+
+	if scheme := parsed.Scheme; scheme == "http" && port == "80" || scheme == "https" && port == "443" {
+		port = ""
+	}
+
+	return parsed.Scheme, host, port, true
+}
+
+func joinOrigin(scheme string, host string, port string) string {
+	if port == "" {
+		return scheme + "://" + host
+	}
+
+	return scheme + "://" + net.JoinHostPort(host, port)
+}
+
+func isLocalhost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
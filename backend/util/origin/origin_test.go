@@ -0,0 +1,95 @@
+package origin
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIsAllowed(t *testing.T) {
+	allowlist := NewAllowlist([]string{
+		"https://screenecho.example.com",
+		"https://*.example.org",
+		"http://intranet:8080",
+		"not an origin",
+	}, false)
+
+	tests := []struct {
+		Name     string
+		origin   string
+		expected bool
+	}{
+		{Name: "Exact match", origin: "https://screenecho.example.com", expected: true},
+		{Name: "Exact match different case", origin: "HTTPS://ScreenEcho.Example.com", expected: true},
+		{Name: "Exact match explicit default port", origin: "https://screenecho.example.com:443", expected: true},
+		{Name: "Exact match wrong scheme", origin: "http://screenecho.example.com", expected: false},
+		{Name: "Exact match wrong port", origin: "https://screenecho.example.com:8443", expected: false},
+		{Name: "Exact match with port", origin: "http://intranet:8080", expected: true},
+		{Name: "Wildcard subdomain", origin: "https://app.example.org", expected: true},
+		{Name: "Wildcard nested subdomain", origin: "https://a.b.example.org", expected: true},
+		{Name: "Wildcard apex", origin: "https://example.org", expected: false},
+		{Name: "Wildcard suffix trick", origin: "https://evilexample.org", expected: false},
+		{Name: "Wildcard wrong scheme", origin: "http://app.example.org", expected: false},
+		{Name: "Unknown origin", origin: "https://evil.com", expected: false},
+		{Name: "Null origin", origin: "null", expected: false},
+		{Name: "Localhost without dev mode", origin: "http://localhost:5173", expected: false},
+		{Name: "Origin with path", origin: "https://screenecho.example.com/room", expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			if actual := allowlist.IsAllowed(tt.origin); actual != tt.expected {
+				t.Errorf("IsAllowed(%q) = %v, expected %v", tt.origin, actual, tt.expected)
+			}
+		})
+	}
+}
+
+func TestIsAllowed_DevMode(t *testing.T) {
+	allowlist := NewAllowlist(nil, true)
+
+	for _, origin := range []string{"http://localhost:5173", "https://localhost", "http://127.0.0.1:8080", "http://[::1]:3000"} {
+		if !allowlist.IsAllowed(origin) {
+			t.Errorf("expected %q to be allowed in dev mode", origin)
+		}
+	}
+
+	if allowlist.IsAllowed("https://example.com") {
+		t.Errorf("expected non-localhost origin to be rejected in dev mode")
+	}
+}
+
+func TestIsAllowed_AllowAll(t *testing.T) {
+	allowlist := NewAllowlist([]string{"*"}, false)
+
+	tests := []struct {
+		Name     string
+		origin   string
+		expected bool
+	}{
+		{Name: "Any origin", origin: "https://anything.example.com", expected: true},
+		{Name: "Null origin", origin: "null", expected: false},
+		{Name: "Null origin different case", origin: "NULL", expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			if actual := allowlist.IsAllowed(tt.origin); actual != tt.expected {
+				t.Errorf("IsAllowed(%q) = %v, expected %v with pattern *", tt.origin, actual, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCheckOrigin_NoOriginHeader(t *testing.T) {
+	allowlist := NewAllowlist(nil, false)
+
+	request := httptest.NewRequest("GET", "/room/abc/connect", nil)
+	if !allowlist.CheckOrigin(request) {
+		t.Errorf("expected request without Origin header to be allowed")
+	}
+
+	request.Header.Set("Origin", "https://evil.com")
+	if allowlist.CheckOrigin(request) {
+		t.Errorf("expected request with unknown Origin header to be rejected")
+	}
+}
@@ -0,0 +1,107 @@
+package strictjson
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// legacyUnmarshal is the former implementation of Unmarshal. It marshals v to learn its keys, so it only validates
+// top-level fields and parses the input twice. It's the benchmark baseline.
+func legacyUnmarshal(data []byte, v any) error {
+	marshaledStruct, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	var structKeyMap map[string]any
+	if err := json.Unmarshal(marshaledStruct, &structKeyMap); err != nil {
+		return err
+	}
+
+	var jsonKeyMap map[string]any
+	if err := json.Unmarshal(data, &jsonKeyMap); err != nil {
+		return err
+	}
+
+	for key := range jsonKeyMap {
+		if _, exists := structKeyMap[key]; !exists {
+			return fmt.Errorf("Unexpected field: %s", key)
+		}
+	}
+
+	for key := range structKeyMap {
+		if _, exists := jsonKeyMap[key]; !exists {
+			return fmt.Errorf("Missing field: %s", key)
+		}
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+// The payloads mirror the messages of package signaling and the envelope parsed by package connection.
+
+type benchmarkTypedMessage struct {
+	Type string          `json:"type"`
+	Msg  json.RawMessage `json:"msg"`
+}
+
+type benchmarkSessionDescription struct {
+	Type string `json:"type"`
+	SDP  string `json:"sdp"`
+}
+
+type benchmarkSDPMessage struct {
+	RemoteClientID string                      `json:"remoteClientID"`
+	Description    benchmarkSessionDescription `json:"description"`
+}
+
+type benchmarkICEMessage struct {
+	RemoteClientID string          `json:"remoteClientID"`
+	Candidate      json.RawMessage `json:"candidate"`
+}
+
+var (
+	benchmarkSDP = strings.Repeat("a=candidate:1 1 udp 2113937151 192.168.1.2 54400 typ host generation 0\\r\\n", 40)
+
+	sdpPayload      = []byte(`{"remoteClientID": "6ba7b810-9dad-11d1-80b4-00c04fd430c8", "description": {"type": "offer", "sdp": "v=0\r\n` + benchmarkSDP + `"}}`)
+	icePayload      = []byte(`{"remoteClientID": "6ba7b810-9dad-11d1-80b4-00c04fd430c8", "candidate": {"candidate": "candidate:1 1 udp 2113937151 192.168.1.2 54400 typ host", "sdpMid": "0", "sdpMLineIndex": 0, "usernameFragment": "abcd"}}`)
+	envelopePayload = []byte(`{"type": "sdp-message", "msg": ` + string(sdpPayload) + `}`)
+)
+
+func BenchmarkUnmarshal(b *testing.B) {
+	payloads := []struct {
+		Name     string
+		data     []byte
+		newValue func() any
+	}{
+		{Name: "envelope", data: envelopePayload, newValue: func() any { return new(benchmarkTypedMessage) }},
+		{Name: "sdp-message", data: sdpPayload, newValue: func() any { return new(benchmarkSDPMessage) }},
+		{Name: "ice-candidate", data: icePayload, newValue: func() any { return new(benchmarkICEMessage) }},
+	}
+
+	implementations := []struct {
+		Name      string
+		unmarshal func(data []byte, v any) error
+	}{
+		{Name: "strictjson", unmarshal: Unmarshal},
+		{Name: "legacy", unmarshal: legacyUnmarshal},
+		{Name: "encoding-json", unmarshal: json.Unmarshal},
+	}
+
+	for _, payload := range payloads {
+		for _, implementation := range implementations {
+			b.Run(payload.Name+"/"+implementation.Name, func(b *testing.B) {
+				b.SetBytes(int64(len(payload.data)))
+				b.ReportAllocs()
+
+				for b.Loop() {
+					if err := implementation.unmarshal(payload.data, payload.newValue()); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
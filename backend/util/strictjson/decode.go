@@ -0,0 +1,718 @@
+package strictjson
+
+import (
+	"encoding"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	jsonUnmarshalerType = reflect.TypeFor[json.Unmarshaler]()
+	textUnmarshalerType = reflect.TypeFor[encoding.TextUnmarshaler]()
+	numberType          = reflect.TypeFor[json.Number]()
+)
+
+// decodeState is the state of a single Unmarshal call.
+type decodeState struct {
+	*json.Decoder
+	// violations are the values that violate the rules of their validate tags, see checkRules.
+	violations Errors
+}
+
+// planKind tells decodeValue how to decode a type.
+type planKind int
+
+const (
+	// opaqueKind types are decoded by encoding/json, e.g. types implementing json.Unmarshaler or any.
+	opaqueKind planKind = iota
+	textKind
+	pointerKind
+	structKind
+	mapKind
+	sliceKind
+	arrayKind
+	bytesKind
+	stringKind
+	numberKind
+	boolKind
+	intKind
+	uintKind
+	floatKind
+)
+
+// plan describes how values of a type are decoded. Plans are computed once per type and cached in plans.
+type plan struct {
+	typ  reflect.Type
+	kind planKind
+	// expected is the JSON type of the type, e.g. "integer".
+	expected string
+	// fields are the fields of a struct in the order of their declaration, fieldsByName indexes them.
+	fields       []*field
+	fieldsByName map[string]*field
+}
+
+// field is a struct field as seen by encoding/json.
+type field struct {
+	name string
+	// index is the index sequence for reflect.Value.FieldByIndex, it has multiple entries for promoted fields.
+	index []int
+	typ   reflect.Type
+	// position is the index of the field in plan.fields.
+	position int
+	// optional fields may be missing in the JSON input, see Unmarshal.
+	optional bool
+	// quoted fields have the ",string" option, their value is encoded as a JSON string.
+	quoted bool
+	// tagRules are the rules of the validate tag as written, rules are the compiled ones.
+	tagRules []Rule
+	rules    []rule
+}
+
+// plans caches a *plan per reflect.Type.
+var plans sync.Map
+
+// planFor returns the cached plan of typ, it's computed on first use.
+// Plans of nested types are looked up while decoding, so recursive types need no special handling.
+func planFor(typ reflect.Type) *plan {
+	if cached, ok := plans.Load(typ); ok {
+		return cached.(*plan)
+	}
+
+	cached, _ := plans.LoadOrStore(typ, newPlan(typ))
+	return cached.(*plan)
+}
+
+func newPlan(typ reflect.Type) *plan {
+	p := &plan{typ: typ, kind: kindOf(typ), expected: expectedType(typ)}
+
+	if p.kind == structKind {
+		p.fields = structFields(typ)
+		p.fieldsByName = make(map[string]*field, len(p.fields))
+		for i, f := range p.fields {
+			f.position = i
+			p.fieldsByName[f.name] = f
+		}
+	}
+
+	return p
+}
+
+func kindOf(typ reflect.Type) planKind {
+	if implements(typ, jsonUnmarshalerType) {
+		return opaqueKind
+	}
+	if implements(typ, textUnmarshalerType) {
+		return textKind
+	}
+
+	switch typ.Kind() {
+	case reflect.Pointer:
+		if kindOf(typ.Elem()) == opaqueKind {
+			// encoding/json handles the pointers on its way to the opaque value
+			return opaqueKind
+		}
+		return pointerKind
+	case reflect.Struct:
+		return structKind
+	case reflect.Map:
+		switch typ.Key().Kind() {
+		case reflect.String, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			return mapKind
+		}
+		return opaqueKind
+	case reflect.Slice:
+		if typ.Elem().Kind() == reflect.Uint8 {
+			return bytesKind
+		}
+		return sliceKind
+	case reflect.Array:
+		return arrayKind
+	case reflect.String:
+		if typ == numberType {
+			return numberKind
+		}
+		return stringKind
+	case reflect.Bool:
+		return boolKind
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return intKind
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return uintKind
+	case reflect.Float32, reflect.Float64:
+		return floatKind
+	default:
+		// Interfaces, and types encoding/json rejects itself like channels
+		return opaqueKind
+	}
+}
+
+// decodeValue decodes the next JSON value of dec into v, which must be settable. path is the JSON path of the value.
+func decodeValue(dec *decodeState, v reflect.Value, p *plan, path string) error {
+	if p.kind == opaqueKind {
+		return decodeOpaque(dec, v, path)
+	}
+
+	tok, err := token(dec)
+	if err != nil {
+		return err
+	}
+
+	return decodeToken(dec, tok, v, p, path)
+}
+
+// decodeOpaque lets encoding/json decode the next JSON value of dec into v.
+func decodeOpaque(dec *decodeState, v reflect.Value, path string) error {
+	if v.Kind() == reflect.Interface {
+		// dec uses json.Number, but any must hold float64 like with json.Unmarshal
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return err
+		}
+		return wrap(json.Unmarshal(raw, v.Addr().Interface()), path)
+	}
+
+	return wrap(dec.Decode(v.Addr().Interface()), path)
+}
+
+// decodeToken decodes the JSON value starting with tok into v.
+func decodeToken(dec *decodeState, tok json.Token, v reflect.Value, p *plan, path string) error {
+	if tok == nil {
+		switch p.kind {
+		case pointerKind, mapKind, sliceKind, bytesKind:
+			v.SetZero()
+			return nil
+		}
+		return mismatch(p, tok, path)
+	}
+
+	switch p.kind {
+	case pointerKind:
+		if v.IsNil() {
+			v.Set(reflect.New(p.typ.Elem()))
+		}
+		return decodeToken(dec, tok, v.Elem(), planFor(p.typ.Elem()), path)
+	case textKind:
+		s, ok := tok.(string)
+		if !ok {
+			return mismatch(p, tok, path)
+		}
+		return wrap(v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s)), path)
+	case structKind:
+		if tok != json.Delim('{') {
+			return mismatch(p, tok, path)
+		}
+		return decodeStruct(dec, v, p, path)
+	case mapKind:
+		if tok != json.Delim('{') {
+			return mismatch(p, tok, path)
+		}
+		return decodeMap(dec, v, p, path)
+	case sliceKind, arrayKind:
+		if tok != json.Delim('[') {
+			return mismatch(p, tok, path)
+		}
+		return decodeArray(dec, v, p, path)
+	case bytesKind:
+		s, ok := tok.(string)
+		if !ok {
+			return mismatch(p, tok, path)
+		}
+		decoded, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return wrap(err, path)
+		}
+		v.SetBytes(decoded)
+		return nil
+	case stringKind:
+		s, ok := tok.(string)
+		if !ok {
+			return mismatch(p, tok, path)
+		}
+		v.SetString(s)
+		return nil
+	case boolKind:
+		b, ok := tok.(bool)
+		if !ok {
+			return mismatch(p, tok, path)
+		}
+		v.SetBool(b)
+		return nil
+	case numberKind, intKind, uintKind, floatKind:
+		number, ok := tok.(json.Number)
+		if !ok {
+			return mismatch(p, tok, path)
+		}
+		return setNumber(v, p, number, tok, path)
+	default:
+		return mismatch(p, tok, path)
+	}
+}
+
+// decodeStruct decodes the members of a JSON object into the struct v, the opening brace was already read.
+// Unexpected fields and type mismatches are reported in the order they appear, missing fields once the object ended.
+func decodeStruct(dec *decodeState, v reflect.Value, p *plan, path string) error {
+	// seen marks the fields present in the object, the buffer avoids an allocation for typical structs
+	var seenBuffer [32]bool
+	var seen []bool
+	if len(p.fields) <= len(seenBuffer) {
+		seen = seenBuffer[:len(p.fields)]
+	} else {
+		seen = make([]bool, len(p.fields))
+	}
+
+	for dec.More() {
+		key, err := objectKey(dec)
+		if err != nil {
+			return err
+		}
+
+		f := p.fieldsByName[key]
+		if f == nil {
+			return &Error{Kind: UnexpectedField, Path: join(path, key)}
+		}
+		seen[f.position] = true
+
+		fieldValue, err := fieldByIndex(v, f.index)
+		if err != nil {
+			return wrap(err, join(path, key))
+		}
+
+		if f.quoted {
+			err = decodeQuoted(dec, fieldValue, join(path, key))
+		} else {
+			err = decodeValue(dec, fieldValue, planFor(f.typ), join(path, key))
+		}
+		if err != nil {
+			return err
+		}
+
+		if len(f.rules) > 0 {
+			checkRules(dec, fieldValue, f.rules, join(path, key))
+		}
+	}
+
+	if _, err := token(dec); err != nil {
+		return err
+	}
+
+	// Check for missing fields in JSON input
+	for i, f := range p.fields {
+		if !seen[i] && !f.optional {
+			return &Error{Kind: MissingField, Path: join(path, f.name)}
+		}
+	}
+
+	return nil
+}
+
+// decodeMap decodes the members of a JSON object into the map v, the opening brace was already read.
+func decodeMap(dec *decodeState, v reflect.Value, p *plan, path string) error {
+	if v.IsNil() {
+		v.Set(reflect.MakeMap(p.typ))
+	}
+	elemPlan := planFor(p.typ.Elem())
+
+	for dec.More() {
+		key, err := objectKey(dec)
+		if err != nil {
+			return err
+		}
+
+		keyValue := reflect.New(p.typ.Key()).Elem()
+		switch kindOf(p.typ.Key()) {
+		case stringKind:
+			keyValue.SetString(key)
+		default:
+			// Integer keys are encoded as strings
+			if err := setNumber(keyValue, planFor(p.typ.Key()), json.Number(key), key, join(path, key)); err != nil {
+				return err
+			}
+		}
+
+		elem := reflect.New(p.typ.Elem()).Elem()
+		if err := decodeValue(dec, elem, elemPlan, join(path, key)); err != nil {
+			return err
+		}
+		v.SetMapIndex(keyValue, elem)
+	}
+
+	_, err := token(dec)
+	return err
+}
+
+// decodeArray decodes the elements of a JSON array into the slice or array v, the opening bracket was already read.
+// Like with json.Unmarshal, additional elements of a Go array are discarded and missing elements are zeroed,
+// but the discarded elements are still validated.
+func decodeArray(dec *decodeState, v reflect.Value, p *plan, path string) error {
+	elemPlan := planFor(p.typ.Elem())
+
+	if p.kind == sliceKind {
+		v.Set(reflect.MakeSlice(p.typ, 0, 0))
+	}
+
+	i := 0
+	for ; dec.More(); i++ {
+		elemPath := path + "[" + strconv.Itoa(i) + "]"
+
+		var elem reflect.Value
+		switch {
+		case p.kind == sliceKind:
+			v.Grow(1)
+			v.SetLen(i + 1)
+			elem = v.Index(i)
+		case i < v.Len():
+			elem = v.Index(i)
+		default:
+			elem = reflect.New(p.typ.Elem()).Elem()
+		}
+
+		if err := decodeValue(dec, elem, elemPlan, elemPath); err != nil {
+			return err
+		}
+	}
+
+	for ; p.kind == arrayKind && i < v.Len(); i++ {
+		v.Index(i).SetZero()
+	}
+
+	_, err := token(dec)
+	return err
+}
+
+// decodeQuoted decodes the value of a field with the ",string" option, which is a JSON string holding the encoded value.
+func decodeQuoted(dec *decodeState, v reflect.Value, path string) error {
+	p := planFor(v.Type())
+	for p.kind == pointerKind {
+		if v.IsNil() {
+			v.Set(reflect.New(p.typ.Elem()))
+		}
+		v, p = v.Elem(), planFor(p.typ.Elem())
+	}
+
+	switch p.kind {
+	case stringKind, boolKind, intKind, uintKind, floatKind:
+	default:
+		// encoding/json ignores the option for other types
+		return decodeValue(dec, v, p, path)
+	}
+
+	tok, err := token(dec)
+	if err != nil {
+		return err
+	}
+	if tok == nil {
+		// Like with json.Unmarshal, null leaves the value unchanged
+		return nil
+	}
+
+	s, ok := tok.(string)
+	if !ok {
+		return &Error{Kind: TypeMismatch, Path: path, Expected: "string", Actual: jsonType(tok)}
+	}
+
+	switch p.kind {
+	case stringKind:
+		var unquoted string
+		if err := json.Unmarshal([]byte(s), &unquoted); err != nil {
+			return wrap(err, path)
+		}
+		v.SetString(unquoted)
+	case boolKind:
+		b, err := strconv.ParseBool(s)
+		if err != nil || (s != "true" && s != "false") {
+			return &Error{Kind: TypeMismatch, Path: path, Expected: "quoted boolean", Actual: strconv.Quote(s)}
+		}
+		v.SetBool(b)
+	default:
+		return setNumber(v, p, json.Number(s), s, path)
+	}
+
+	return nil
+}
+
+// setNumber stores number in v. tok is the token the number was read from, it's used for the error.
+func setNumber(v reflect.Value, p *plan, number json.Number, tok json.Token, path string) error {
+	s := number.String()
+
+	switch p.kind {
+	case numberKind:
+		if !isNumber(s) {
+			return mismatch(p, tok, path)
+		}
+		v.SetString(s)
+	case intKind:
+		n, err := strconv.ParseInt(s, 10, p.typ.Bits())
+		if err != nil {
+			return numberError(p, tok, err, path)
+		}
+		v.SetInt(n)
+	case uintKind:
+		n, err := strconv.ParseUint(s, 10, p.typ.Bits())
+		if err != nil {
+			return numberError(p, tok, err, path)
+		}
+		v.SetUint(n)
+	case floatKind:
+		if !isNumber(s) {
+			return mismatch(p, tok, path)
+		}
+		n, err := strconv.ParseFloat(s, p.typ.Bits())
+		if err != nil {
+			return numberError(p, tok, err, path)
+		}
+		v.SetFloat(n)
+	default:
+		return mismatch(p, tok, path)
+	}
+
+	return nil
+}
+
+func numberError(p *plan, tok json.Token, err error, path string) *Error {
+	if errors.Is(err, strconv.ErrRange) {
+		return &Error{Kind: TypeMismatch, Path: path, Expected: p.expected, Actual: "out of range " + jsonType(tok)}
+	}
+
+	return mismatch(p, tok, path)
+}
+
+// isNumber reports whether s is a JSON number. Numbers read from the decoder always are, quoted ones may not be.
+func isNumber(s string) bool {
+	return json.Valid([]byte(s)) && s != "" && (s[0] == '-' || (s[0] >= '0' && s[0] <= '9'))
+}
+
+// fieldByIndex returns the field of the struct v with index, embedded nil pointers on the way are allocated.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, error) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				if !v.CanSet() {
+					return reflect.Value{}, fmt.Errorf("cannot set embedded pointer to unexported struct %v", v.Type().Elem())
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+
+	return v, nil
+}
+
+// token returns the next token of dec. The end of the input is an error, because token is only called if a token is due.
+func token(dec *decodeState) (json.Token, error) {
+	tok, err := dec.Token()
+	if err == io.EOF {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	return tok, err
+}
+
+func objectKey(dec *decodeState) (string, error) {
+	tok, err := token(dec)
+	if err != nil {
+		return "", err
+	}
+
+	// The decoder only returns strings as object keys
+	return tok.(string), nil
+}
+
+// wrap adds path to err of encoding/json or an UnmarshalText method.
+func wrap(err error, path string) error {
+	if err == nil {
+		return nil
+	}
+	if path == "" {
+		return err
+	}
+
+	return fmt.Errorf("%s: %w", path, err)
+}
+
+// Field describes a struct field as Unmarshal decodes it.
+type Field struct {
+	// Name is the name of the field in JSON.
+	Name string
+	Type reflect.Type
+	// Optional fields may be missing in the JSON input.
+	Optional bool
+	// Quoted fields have the ",string" option, their value is encoded as a JSON string.
+	Quoted bool
+	// Rules are the rules of the field's validate tag.
+	Rules []Rule
+}
+
+// Fields returns the fields of the struct type typ as Unmarshal decodes them, in the order of their declaration.
+// Promoted fields of embedded structs are included. It's meant to document message types, e.g. as JSON Schema.
+func Fields(typ reflect.Type) []Field {
+	p := planFor(typ)
+
+	fields := make([]Field, len(p.fields))
+	for i, f := range p.fields {
+		fields[i] = Field{Name: f.name, Type: f.typ, Optional: f.optional, Quoted: f.quoted, Rules: f.tagRules}
+	}
+
+	return fields
+}
+
+// structFields returns the fields of the struct type typ that encoding/json decodes, including promoted fields of
+// embedded structs. Like encoding/json, a field of an outer struct hides a field with the same name of an embedded struct.
+func structFields(typ reflect.Type) []*field {
+	var fields []*field
+
+	for i := range typ.NumField() {
+		structField := typ.Field(i)
+
+		tag := structField.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+
+		if structField.Anonymous && name == "" {
+			embedded := structField.Type
+			if embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				for _, promoted := range structFields(embedded) {
+					promoted.index = append([]int{i}, promoted.index...)
+					// Fields of an embedded pointer can be missing, the pointer stays nil then
+					promoted.optional = promoted.optional || structField.Type.Kind() == reflect.Pointer
+					fields = append(fields, promoted)
+				}
+				continue
+			}
+		}
+
+		if !structField.IsExported() {
+			continue
+		}
+
+		if name == "" {
+			name = structField.Name
+		}
+
+		tagRules := ParseValidateTag(structField.Tag.Get("validate"))
+		fields = append(fields, &field{
+			name:     name,
+			index:    []int{i},
+			typ:      structField.Type,
+			optional: hasOption(options, "omitempty") || hasOption(options, "omitzero") || structField.Type.Kind() == reflect.Pointer,
+			quoted:   hasOption(options, "string"),
+			tagRules: tagRules,
+			rules:    compileRules(tagRules, structField.Type, typ.String()+"."+structField.Name),
+		})
+	}
+
+	// Keep the shallowest field of every name, encoding/json ignores the deeper ones
+	slices.SortStableFunc(fields, func(a, b *field) int { return len(a.index) - len(b.index) })
+	var visible []*field
+	for _, f := range fields {
+		if !slices.ContainsFunc(visible, func(other *field) bool { return other.name == f.name }) {
+			visible = append(visible, f)
+		}
+	}
+	slices.SortFunc(visible, func(a, b *field) int { return slices.Compare(a.index, b.index) })
+
+	return visible
+}
+
+func hasOption(options string, option string) bool {
+	for options != "" {
+		var current string
+		current, options, _ = strings.Cut(options, ",")
+		if current == option {
+			return true
+		}
+	}
+
+	return false
+}
+
+func implements(typ reflect.Type, iface reflect.Type) bool {
+	return typ.Implements(iface) || reflect.PointerTo(typ).Implements(iface)
+}
+
+func mismatch(p *plan, tok json.Token, path string) *Error {
+	return &Error{Kind: TypeMismatch, Path: path, Expected: p.expected, Actual: jsonType(tok)}
+}
+
+// expectedType returns the JSON type a value of typ is encoded as.
+func expectedType(typ reflect.Type) string {
+	if implements(typ, textUnmarshalerType) && !implements(typ, jsonUnmarshalerType) {
+		return "string"
+	}
+
+	switch typ.Kind() {
+	case reflect.Pointer:
+		return expectedType(typ.Elem())
+	case reflect.Struct, reflect.Map:
+		return "object"
+	case reflect.Slice:
+		if typ.Elem().Kind() == reflect.Uint8 {
+			return "string"
+		}
+		return "array"
+	case reflect.Array:
+		return "array"
+	case reflect.String:
+		if typ == numberType {
+			return "number"
+		}
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return "integer"
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	default:
+		return typ.String()
+	}
+}
+
+// jsonType returns the JSON type of the value starting with tok.
+func jsonType(tok json.Token) string {
+	switch tok := tok.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case json.Number:
+		if strings.ContainsAny(tok.String(), ".eE") {
+			return "number"
+		}
+		return "integer"
+	case json.Delim:
+		if tok == '[' {
+			return "array"
+		}
+		return "object"
+	default:
+		return fmt.Sprintf("%T", tok)
+	}
+}
+
+// join appends the object key to the JSON path.
+func join(path string, key string) string {
+	if path == "" {
+		return key
+	}
+
+	return path + "." + key
+}
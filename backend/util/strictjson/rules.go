@@ -0,0 +1,224 @@
+package strictjson
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"bjoernblessin.de/screenecho/util/assert"
+	"github.com/google/uuid"
+)
+
+// Rule is a rule of a validate tag as written, e.g. Name "min" and Argument "2" for "min=2". See Unmarshal.
+type Rule struct {
+	Name     string
+	Argument string
+}
+
+// ParseValidateTag splits a validate tag into its rules. The rules are not checked, see Unmarshal for the valid ones.
+func ParseValidateTag(tag string) []Rule {
+	var rules []Rule
+	for tag != "" {
+		var current string
+		if strings.HasPrefix(tag, "regex=") {
+			// The pattern may contain commas, so the regex rule is the last one
+			current, tag = tag, ""
+		} else {
+			current, tag, _ = strings.Cut(tag, ",")
+		}
+
+		name, argument, _ := strings.Cut(current, "=")
+		rules = append(rules, Rule{Name: name, Argument: argument})
+	}
+
+	return rules
+}
+
+// rule is a compiled constraint of a validate tag.
+type rule struct {
+	// check reports whether v satisfies the rule. If not, actual describes v, e.g. "3 characters".
+	check func(v reflect.Value) (ok bool, actual string)
+	// expected describes the rule, e.g. "at least 8 characters".
+	expected string
+}
+
+// compileRules compiles the rules of a validate tag of a field of type typ. Invalid rules are programming errors and panic.
+func compileRules(tagRules []Rule, typ reflect.Type, fieldName string) []rule {
+	for typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+
+	var rules []rule
+	for _, tagRule := range tagRules {
+		name, argument := tagRule.Name, tagRule.Argument
+		switch name {
+		case "uuid":
+			assert.Assert(typ.Kind() == reflect.String, "validate rule uuid needs a string field", fieldName)
+			rules = append(rules, uuidRule())
+		case "min", "max":
+			rules = append(rules, boundRule(name, argument, typ, fieldName))
+		case "oneof":
+			assert.Assert(argument != "", "validate rule oneof needs values", fieldName)
+			rules = append(rules, oneOfRule(strings.Fields(argument)))
+		case "regex":
+			assert.Assert(typ.Kind() == reflect.String, "validate rule regex needs a string field", fieldName)
+			rules = append(rules, regexRule(regexp.MustCompile(argument)))
+		default:
+			assert.Never("unknown validate rule", name, fieldName)
+		}
+	}
+
+	return rules
+}
+
+func uuidRule() rule {
+	return rule{
+		expected: "a UUID",
+		check: func(v reflect.Value) (bool, string) {
+			if _, err := uuid.Parse(v.String()); err != nil {
+				return false, strconv.Quote(truncate(v.String()))
+			}
+			return true, ""
+		},
+	}
+}
+
+// boundRule returns the rule of min or max. It bounds the length of strings (in characters), slices, arrays and maps
+// and the value of numbers.
+func boundRule(name string, argument string, typ reflect.Type, fieldName string) rule {
+	isMin := name == "min"
+	comparison := "at most"
+	if isMin {
+		comparison = "at least"
+	}
+
+	switch typ.Kind() {
+	case reflect.String, reflect.Slice, reflect.Array, reflect.Map:
+		bound, err := strconv.Atoi(argument)
+		assert.IsNil(err, "validate rule", name, "needs an integer", fieldName)
+
+		unit := "elements"
+		if typ.Kind() == reflect.String {
+			unit = "characters"
+		}
+
+		return rule{
+			expected: fmt.Sprintf("%s %d %s", comparison, bound, unit),
+			check: func(v reflect.Value) (bool, string) {
+				length := v.Len()
+				if v.Kind() == reflect.String {
+					length = utf8.RuneCountInString(v.String())
+				}
+				if (isMin && length < bound) || (!isMin && length > bound) {
+					return false, fmt.Sprintf("%d %s", length, unit)
+				}
+				return true, ""
+			},
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		bound, err := strconv.ParseFloat(argument, 64)
+		assert.IsNil(err, "validate rule", name, "needs a number", fieldName)
+
+		return rule{
+			expected: fmt.Sprintf("%s %s", comparison, argument),
+			check: func(v reflect.Value) (bool, string) {
+				value := numberValue(v)
+				if (isMin && value < bound) || (!isMin && value > bound) {
+					return false, formatValue(v)
+				}
+				return true, ""
+			},
+		}
+	default:
+		assert.Never("validate rule", name, "is not supported for", typ, fieldName)
+		return rule{}
+	}
+}
+
+func oneOfRule(values []string) rule {
+	return rule{
+		expected: "one of " + strings.Join(values, ", "),
+		check: func(v reflect.Value) (bool, string) {
+			if slices.Contains(values, formatValue(v)) {
+				return true, ""
+			}
+			if v.Kind() == reflect.String {
+				return false, strconv.Quote(truncate(v.String()))
+			}
+			return false, formatValue(v)
+		},
+	}
+}
+
+func regexRule(pattern *regexp.Regexp) rule {
+	return rule{
+		expected: "a match of " + pattern.String(),
+		check: func(v reflect.Value) (bool, string) {
+			if pattern.MatchString(v.String()) {
+				return true, ""
+			}
+			return false, strconv.Quote(truncate(v.String()))
+		},
+	}
+}
+
+// checkRules checks the decoded value v of the field at path against rules and records the first violation in dec.
+// A nil pointer satisfies all rules, it's an optional field that is missing.
+func checkRules(dec *decodeState, v reflect.Value, rules []rule, path string) {
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+
+	for _, r := range rules {
+		if ok, actual := r.check(v); !ok {
+			dec.violations = append(dec.violations, &Error{Kind: InvalidValue, Path: path, Expected: r.expected, Actual: actual})
+			return
+		}
+	}
+}
+
+func numberValue(v reflect.Value) float64 {
+	switch {
+	case v.CanInt():
+		return float64(v.Int())
+	case v.CanUint():
+		return float64(v.Uint())
+	default:
+		return v.Float()
+	}
+}
+
+func formatValue(v reflect.Value) string {
+	switch {
+	case v.Kind() == reflect.String:
+		return v.String()
+	case v.CanInt():
+		return strconv.FormatInt(v.Int(), 10)
+	case v.CanUint():
+		return strconv.FormatUint(v.Uint(), 10)
+	case v.CanFloat():
+		return strconv.FormatFloat(v.Float(), 'g', -1, 64)
+	default:
+		return fmt.Sprint(v.Interface())
+	}
+}
+
+// truncate shortens s for error messages, the value of a field is sent back to the client.
+func truncate(s string) string {
+	const maxLength = 64
+
+	if utf8.RuneCountInString(s) <= maxLength {
+		return s
+	}
+
+	return string([]rune(s)[:maxLength]) + "…"
+}
@@ -1,13 +1,42 @@
 package strictjson
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"reflect"
+	"strings"
 )
 
 // Unmarshal unmarshals JSON data into the provided struct v while ensuring strict validation of the input.
 //
 // It checks for any extra or missing fields in the JSON data that do not match the struct definition.
+// Nested structs, slices, arrays and maps are validated recursively. Fields tagged with omitempty and pointer fields
+// are optional, all other fields are required. A value whose JSON type doesn't match the field's type is rejected.
+// Values of types implementing [json.Unmarshaler], like [json.RawMessage], are only required to be present.
+//
+// Validation errors are of type [*Error] and name the JSON path of the offending field, e.g. "meta.resolution.width".
+// The first error in the order of the input is returned, a missing field is reported once its object ended.
+//
+// Fields can be constrained by a validate tag with comma-separated rules, all violations are returned together as [Errors]
+// once the input is otherwise valid:
+//   - uuid: the string is a UUID
+//   - min=N, max=N: the number of characters of a string, the number of elements of a slice, array or map,
+//     or the value of a number is at least or at most N
+//   - oneof=a b c: the value is one of the space-separated values
+//   - regex=pattern: the string matches the regular expression, it must be the last rule of the tag
+//
+// For example:
+//
+//	type ICEMessage struct {
+//	    RemoteClientID string `json:"remoteClientID" validate:"uuid"`
+//	    Kind           string `json:"kind" validate:"oneof=host srflx relay"`
+//	}
+//
+// Validation and decoding happen in a single pass over the input. The fields of every type are computed once and cached.
+// v is only modified if data is valid, fields that are missing in data are set to their zero value.
 //
 // Unlike [json.Unmarshal], Unmarshal is case-sensitive.
 //
@@ -24,42 +53,87 @@ import (
 //	    log.Fatal(err)
 //	}
 func Unmarshal(data []byte, v any) error {
-	// Marshal the struct to JSON
-	marshaledStruct, err := json.Marshal(v)
-	if err != nil {
-		return err
+	target := reflect.ValueOf(v)
+	if target.Kind() != reflect.Pointer || target.IsNil() {
+		return &json.InvalidUnmarshalError{Type: reflect.TypeOf(v)}
 	}
 
-	var structKeyMap map[string]any
-	if err := json.Unmarshal(marshaledStruct, &structKeyMap); err != nil {
-		return err
-	}
+	// Numbers are kept as written to tell integers from floats
+	dec := &decodeState{Decoder: json.NewDecoder(bytes.NewReader(data))}
+	dec.UseNumber()
 
-	// Unmarshal the JSON into a map
-	var jsonKeyMap map[string]any
-	if err := json.Unmarshal(data, &jsonKeyMap); err != nil {
+	// Decoded into a new value first, so that v is only modified if data is valid
+	value := reflect.New(target.Type().Elem())
+	if err := decodeValue(dec, value.Elem(), planFor(value.Type().Elem()), ""); err != nil {
 		return err
 	}
 
-	// Check for extra field in JSON input
-	for key := range jsonKeyMap {
-		if _, exists := structKeyMap[key]; !exists {
-			return fmt.Errorf("Unexpected field: %s", key)
+	if _, err := dec.Token(); err != io.EOF {
+		if err != nil {
+			return err
 		}
+		return errors.New("invalid data after top-level value")
 	}
 
-	// Check for missing fields in JSON input
-	for key := range structKeyMap {
-		if _, exists := jsonKeyMap[key]; !exists {
-			return fmt.Errorf("Missing field: %s", key)
+	if len(dec.violations) > 0 {
+		return dec.violations
+	}
+
+	target.Elem().Set(value.Elem())
+
+	return nil
+}
+
+// ErrorKind describes why a JSON value was rejected.
+type ErrorKind int
+
+const (
+	// UnexpectedField is a field in the JSON input that the struct doesn't define.
+	UnexpectedField ErrorKind = iota
+	// MissingField is a required field of the struct that the JSON input lacks.
+	MissingField
+	// TypeMismatch is a JSON value whose type doesn't match the field's type.
+	TypeMismatch
+	// InvalidValue is a value that violates a rule of its validate tag.
+	InvalidValue
+)
+
+// Error is returned by Unmarshal if the JSON input doesn't match the struct definition.
+type Error struct {
+	Kind ErrorKind
+	// Path is the JSON path of the offending value, e.g. "meta.resolution.width" or "candidates[2]".
+	// It's empty for the top-level value.
+	Path string
+	// Expected and Actual are the JSON types of a TypeMismatch, e.g. "integer" and "string",
+	// or the violated rule and the offending value of an InvalidValue, e.g. "at least 8 characters" and "3 characters".
+	Expected string
+	Actual   string
+}
+
+func (err *Error) Error() string {
+	switch err.Kind {
+	case UnexpectedField:
+		return fmt.Sprintf("Unexpected field: %s", err.Path)
+	case MissingField:
+		return fmt.Sprintf("Missing field: %s", err.Path)
+	case InvalidValue:
+		return fmt.Sprintf("Invalid value at %s: expected %s, got %s", err.Path, err.Expected, err.Actual)
+	default:
+		if err.Path == "" {
+			return fmt.Sprintf("Type mismatch: expected %s, got %s", err.Expected, err.Actual)
 		}
+		return fmt.Sprintf("Type mismatch at %s: expected %s, got %s", err.Path, err.Expected, err.Actual)
 	}
+}
 
-	// Finally store JSON into struct v
-	err = json.Unmarshal(data, v)
-	if err != nil {
-		return err
+// Errors is returned by Unmarshal if values violate the rules of their validate tags. It holds an *Error per field.
+type Errors []*Error
+
+func (errs Errors) Error() string {
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = err.Error()
 	}
 
-	return nil
+	return strings.Join(messages, "; ")
 }
@@ -1,7 +1,14 @@
 package strictjson
 
 import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
+
+	"github.com/google/uuid"
 )
 
 type TestStruct struct {
@@ -97,3 +104,484 @@ func TestUnmarshalStrict_NilStruct(t *testing.T) {
 		t.Errorf("expected error for nil struct, but got none")
 	}
 }
+
+type Resolution struct {
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+type Meta struct {
+	Resolution Resolution `json:"resolution"`
+	Label      string     `json:"label,omitempty"`
+}
+
+type Track struct {
+	ID    string   `json:"id"`
+	Muted *bool    `json:"muted"`
+	Tags  []string `json:"tags"`
+}
+
+type Embedded struct {
+	Source string `json:"source"`
+}
+
+type NestedStruct struct {
+	Embedded
+	Meta        Meta               `json:"meta"`
+	Tracks      []Track            `json:"tracks"`
+	Scores      map[string]float64 `json:"scores,omitempty"`
+	Description json.RawMessage    `json:"description"`
+	Fps         float64            `json:"fps"`
+	Count       int64              `json:"count,string,omitempty"`
+	Ignored     string             `json:"-"`
+}
+
+func TestUnmarshalStrict_Nested(t *testing.T) {
+	const valid = `"source": "screen", "meta": {"resolution": {"width": 1920, "height": 1080}}, "tracks": [{"id": "a", "tags": []}], "description": {"type": "offer"}, "fps": 29.97`
+
+	tests := []struct {
+		Name     string
+		input    string
+		expected *Error
+	}{
+		{
+			Name:  "Valid nested JSON",
+			input: `{` + valid + `}`,
+		},
+		{
+			Name:  "Optional fields present",
+			input: `{` + valid + `, "scores": {"a": 1.5}, "count": "3"}`,
+		},
+		{
+			Name:  "Optional nested field present",
+			input: `{"source": "screen", "meta": {"resolution": {"width": 1, "height": 1}, "label": "x"}, "tracks": [{"id": "a", "muted": true, "tags": ["b"]}], "description": null, "fps": 30}`,
+		},
+		{
+			Name:     "Unexpected nested field",
+			input:    `{"source": "screen", "meta": {"resolution": {"width": 1, "height": 1, "depth": 3}}, "tracks": [], "description": {}, "fps": 30}`,
+			expected: &Error{Kind: UnexpectedField, Path: "meta.resolution.depth"},
+		},
+		{
+			Name:     "Missing nested field",
+			input:    `{"source": "screen", "meta": {"resolution": {"width": 1}}, "tracks": [], "description": {}, "fps": 30}`,
+			expected: &Error{Kind: MissingField, Path: "meta.resolution.height"},
+		},
+		{
+			Name:     "Missing field in array element",
+			input:    `{"source": "screen", "meta": {"resolution": {"width": 1, "height": 1}}, "tracks": [{"id": "a", "tags": []}, {"id": "b"}], "description": {}, "fps": 30}`,
+			expected: &Error{Kind: MissingField, Path: "tracks[1].tags"},
+		},
+		{
+			Name:     "Missing embedded field",
+			input:    `{"meta": {"resolution": {"width": 1, "height": 1}}, "tracks": [], "description": {}, "fps": 30}`,
+			expected: &Error{Kind: MissingField, Path: "source"},
+		},
+		{
+			Name:     "Missing raw message",
+			input:    `{"source": "screen", "meta": {"resolution": {"width": 1, "height": 1}}, "tracks": [], "fps": 30}`,
+			expected: &Error{Kind: MissingField, Path: "description"},
+		},
+		{
+			Name:     "Ignored field",
+			input:    `{` + valid + `, "Ignored": "x"}`,
+			expected: &Error{Kind: UnexpectedField, Path: "Ignored"},
+		},
+		{
+			Name:     "String instead of integer",
+			input:    `{"source": "screen", "meta": {"resolution": {"width": "1920", "height": 1080}}, "tracks": [], "description": {}, "fps": 30}`,
+			expected: &Error{Kind: TypeMismatch, Path: "meta.resolution.width", Expected: "integer", Actual: "string"},
+		},
+		{
+			Name:     "Float instead of integer",
+			input:    `{"source": "screen", "meta": {"resolution": {"width": 19.5, "height": 1080}}, "tracks": [], "description": {}, "fps": 30}`,
+			expected: &Error{Kind: TypeMismatch, Path: "meta.resolution.width", Expected: "integer", Actual: "number"},
+		},
+		{
+			Name:     "Object instead of array",
+			input:    `{"source": "screen", "meta": {"resolution": {"width": 1, "height": 1}}, "tracks": {}, "description": {}, "fps": 30}`,
+			expected: &Error{Kind: TypeMismatch, Path: "tracks", Expected: "array", Actual: "object"},
+		},
+		{
+			Name:     "Wrong array element type",
+			input:    `{"source": "screen", "meta": {"resolution": {"width": 1, "height": 1}}, "tracks": [{"id": "a", "tags": ["b", 3]}], "description": {}, "fps": 30}`,
+			expected: &Error{Kind: TypeMismatch, Path: "tracks[0].tags[1]", Expected: "string", Actual: "integer"},
+		},
+		{
+			Name:     "Wrong pointer type",
+			input:    `{"source": "screen", "meta": {"resolution": {"width": 1, "height": 1}}, "tracks": [{"id": "a", "muted": "yes", "tags": []}], "description": {}, "fps": 30}`,
+			expected: &Error{Kind: TypeMismatch, Path: "tracks[0].muted", Expected: "boolean", Actual: "string"},
+		},
+		{
+			Name:     "Wrong map value type",
+			input:    `{` + valid + `, "scores": {"a": true}}`,
+			expected: &Error{Kind: TypeMismatch, Path: "scores.a", Expected: "number", Actual: "boolean"},
+		},
+		{
+			Name:     "Null instead of struct",
+			input:    `{"source": "screen", "meta": null, "tracks": [], "description": {}, "fps": 30}`,
+			expected: &Error{Kind: TypeMismatch, Path: "meta", Expected: "object", Actual: "null"},
+		},
+		{
+			Name:     "Number instead of quoted number",
+			input:    `{` + valid + `, "count": 3}`,
+			expected: &Error{Kind: TypeMismatch, Path: "count", Expected: "string", Actual: "integer"},
+		},
+		{
+			Name:     "Array instead of object",
+			input:    `[]`,
+			expected: &Error{Kind: TypeMismatch, Path: "", Expected: "object", Actual: "array"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			var result NestedStruct
+			err := Unmarshal([]byte(tt.input), &result)
+
+			if tt.expected == nil {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+
+			var strictErr *Error
+			if !errors.As(err, &strictErr) {
+				t.Fatalf("expected error %v, but got %v", tt.expected, err)
+			}
+			if *strictErr != *tt.expected {
+				t.Errorf("expected error %+v (%v), but got %+v (%v)", tt.expected, tt.expected, strictErr, strictErr)
+			}
+		})
+	}
+}
+
+func TestUnmarshalStrict_NestedResult(t *testing.T) {
+	input := `{"source": "screen", "meta": {"resolution": {"width": 1920, "height": 1080}}, "tracks": [{"id": "a", "muted": false, "tags": ["x"]}], "description": {"sdp": "v=0"}, "fps": 60, "count": "7"}`
+
+	var result NestedStruct
+	if err := Unmarshal([]byte(input), &result); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if result.Source != "screen" || result.Meta.Resolution.Width != 1920 || len(result.Tracks) != 1 ||
+		result.Tracks[0].Muted == nil || result.Count != 7 || string(result.Description) != `{"sdp": "v=0"}` {
+		t.Errorf("unexpected result %+v", result)
+	}
+}
+
+func TestError(t *testing.T) {
+	tests := []struct {
+		Name     string
+		err      *Error
+		expected string
+	}{
+		{Name: "Unexpected field", err: &Error{Kind: UnexpectedField, Path: "meta.depth"}, expected: "Unexpected field: meta.depth"},
+		{Name: "Missing field", err: &Error{Kind: MissingField, Path: "tracks[1].tags"}, expected: "Missing field: tracks[1].tags"},
+		{Name: "Type mismatch", err: &Error{Kind: TypeMismatch, Path: "width", Expected: "integer", Actual: "string"}, expected: "Type mismatch at width: expected integer, got string"},
+		{Name: "Type mismatch at root", err: &Error{Kind: TypeMismatch, Expected: "object", Actual: "array"}, expected: "Type mismatch: expected object, got array"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			if message := tt.err.Error(); message != tt.expected {
+				t.Errorf("expected '%s', but got '%s'", tt.expected, message)
+			}
+		})
+	}
+}
+
+type Node struct {
+	Value    int     `json:"value"`
+	Children []*Node `json:"children,omitempty"`
+}
+
+type Various struct {
+	ID      uuid.UUID      `json:"id"`
+	Any     any            `json:"any"`
+	Pair    [2]int         `json:"pair"`
+	Counts  map[int]string `json:"counts"`
+	Data    []byte         `json:"data"`
+	Number  json.Number    `json:"number"`
+	Small   uint8          `json:"small"`
+	Enabled *bool          `json:"enabled,string"`
+}
+
+func TestUnmarshalStrict_Types(t *testing.T) {
+	const id = "6ba7b810-9dad-11d1-80b4-00c04fd430c8"
+	valid := func(field string, value string) string {
+		fields := map[string]string{
+			"id": `"` + id + `"`, "any": `{"a": 1}`, "pair": `[1, 2]`, "counts": `{"1": "one"}`,
+			"data": `"aGk="`, "number": `1.5`, "small": `255`, "enabled": `"true"`,
+		}
+		fields[field] = value
+
+		var input []string
+		for _, key := range []string{"id", "any", "pair", "counts", "data", "number", "small", "enabled"} {
+			input = append(input, `"`+key+`": `+fields[key])
+		}
+		return "{" + strings.Join(input, ", ") + "}"
+	}
+
+	tests := []struct {
+		Name     string
+		input    string
+		expected *Error
+		errorMsg string
+	}{
+		{Name: "Valid", input: valid("", "")},
+		{Name: "Short array", input: valid("pair", "[1]")},
+		{Name: "Long array", input: valid("pair", "[1, 2, 3]")},
+		{Name: "Invalid element of long array", input: valid("pair", `[1, 2, "3"]`), expected: &Error{Kind: TypeMismatch, Path: "pair[2]", Expected: "integer", Actual: "string"}},
+		{Name: "UUID as number", input: valid("id", "1"), expected: &Error{Kind: TypeMismatch, Path: "id", Expected: "string", Actual: "integer"}},
+		{Name: "Invalid UUID", input: valid("id", `"nope"`), errorMsg: "id: invalid UUID length: 4"},
+		{Name: "Non-integer map key", input: valid("counts", `{"x": "one"}`), expected: &Error{Kind: TypeMismatch, Path: "counts.x", Expected: "integer", Actual: "string"}},
+		{Name: "Integer out of range", input: valid("small", "256"), expected: &Error{Kind: TypeMismatch, Path: "small", Expected: "integer", Actual: "out of range integer"}},
+		{Name: "Negative unsigned integer", input: valid("small", "-1"), expected: &Error{Kind: TypeMismatch, Path: "small", Expected: "integer", Actual: "integer"}},
+		{Name: "Number as string", input: valid("number", `"1.5"`), expected: &Error{Kind: TypeMismatch, Path: "number", Expected: "number", Actual: "string"}},
+		{Name: "Invalid quoted boolean", input: valid("enabled", `"yes"`), expected: &Error{Kind: TypeMismatch, Path: "enabled", Expected: "quoted boolean", Actual: `"yes"`}},
+		{Name: "Unquoted boolean", input: valid("enabled", `true`), expected: &Error{Kind: TypeMismatch, Path: "enabled", Expected: "string", Actual: "boolean"}},
+		{Name: "Trailing data", input: valid("", "") + ` {}`, errorMsg: "invalid data after top-level value"},
+		{Name: "Empty input", input: ``, errorMsg: "unexpected EOF"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			var result Various
+			err := Unmarshal([]byte(tt.input), &result)
+
+			switch {
+			case tt.expected != nil:
+				var strictErr *Error
+				if !errors.As(err, &strictErr) || *strictErr != *tt.expected {
+					t.Errorf("expected error %+v, but got %v", tt.expected, err)
+				}
+			case tt.errorMsg != "":
+				if err == nil || err.Error() != tt.errorMsg {
+					t.Errorf("expected error message '%s', but got '%v'", tt.errorMsg, err)
+				}
+			case err != nil:
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestUnmarshalStrict_MatchesEncodingJSON(t *testing.T) {
+	tests := []struct {
+		Name     string
+		input    string
+		newValue func() any
+	}{
+		{Name: "Various", newValue: func() any { return new(Various) }, input: `{"id": "6ba7b810-9dad-11d1-80b4-00c04fd430c8", "any": {"a": [1, 2.5, "x", null]}, "pair": [7], "counts": {"-3": "minus three"}, "data": "aGk=", "number": 1e3, "small": 0, "enabled": "false"}`},
+		{Name: "Nested", newValue: func() any { return new(NestedStruct) }, input: `{"source": "s", "meta": {"resolution": {"width": 1, "height": 2}, "label": "l"}, "tracks": [{"id": "a", "muted": null, "tags": null}], "description": [1, {"a": "b"}], "fps": -0.5}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			strict, expected := tt.newValue(), tt.newValue()
+			if err := json.Unmarshal([]byte(tt.input), expected); err != nil {
+				t.Fatalf("invalid test input: %v", err)
+			}
+
+			if err := Unmarshal([]byte(tt.input), strict); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(strict, expected) {
+				t.Errorf("expected %+v, but got %+v", expected, strict)
+			}
+		})
+	}
+}
+
+func TestUnmarshalStrict_Recursive(t *testing.T) {
+	var result Node
+	err := Unmarshal([]byte(`{"value": 1, "children": [{"value": 2, "children": [{"value": 3}]}, {"value": 4}]}`), &result)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Children[0].Children[0].Value != 3 || result.Children[1].Value != 4 {
+		t.Errorf("unexpected result %+v", result)
+	}
+
+	err = Unmarshal([]byte(`{"value": 1, "children": [{"value": 2, "children": [{}]}]}`), &result)
+	if err == nil || err.Error() != "Missing field: children[0].children[0].value" {
+		t.Errorf("expected missing deep field, but got %v", err)
+	}
+}
+
+func TestUnmarshalStrict_UnchangedOnError(t *testing.T) {
+	result := TestStruct{Name: "Jane", Age: 40}
+	err := Unmarshal([]byte(`{"Name": "John", "aGe": "thirty"}`), &result)
+	if err == nil {
+		t.Fatal("expected error but got none")
+	}
+	if result.Name != "Jane" || result.Age != 40 {
+		t.Errorf("expected result to be unchanged, but got %+v", result)
+	}
+}
+
+func TestUnmarshalStrict_Concurrent(t *testing.T) {
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 100 {
+				var result Node
+				if err := Unmarshal([]byte(`{"value": 1, "children": [{"value": 2}]}`), &result); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+type ConstrainedStruct struct {
+	ClientID string            `json:"clientID" validate:"uuid"`
+	Name     string            `json:"name" validate:"min=2,max=5"`
+	Kind     string            `json:"kind" validate:"oneof=offer answer"`
+	Code     string            `json:"code" validate:"regex=^[a-z]{2,3}$"`
+	Volume   float64           `json:"volume" validate:"min=0,max=1"`
+	Level    int               `json:"level" validate:"oneof=1 2 3"`
+	Tags     []string          `json:"tags" validate:"max=2"`
+	Nested   []ConstrainedNode `json:"nested"`
+	Optional *int              `json:"optional" validate:"min=10"`
+}
+
+type ConstrainedNode struct {
+	Port uint16 `json:"port" validate:"min=1024"`
+}
+
+func TestUnmarshalStrict_Rules(t *testing.T) {
+	const id = "6ba7b810-9dad-11d1-80b4-00c04fd430c8"
+	const valid = `"clientID": "` + id + `", "name": "abc", "kind": "offer", "code": "de", "volume": 0.5, "level": 2, "tags": ["a"], "nested": [{"port": 8080}]`
+
+	tests := []struct {
+		Name     string
+		input    string
+		expected Errors
+	}{
+		{Name: "Valid", input: `{` + valid + `}`},
+		{Name: "Valid optional", input: `{` + valid + `, "optional": 10}`},
+		{
+			Name:     "Invalid UUID",
+			input:    `{` + strings.Replace(valid, id, "nope", 1) + `}`,
+			expected: Errors{{Kind: InvalidValue, Path: "clientID", Expected: "a UUID", Actual: `"nope"`}},
+		},
+		{
+			Name:     "Too short in characters",
+			input:    `{` + strings.Replace(valid, `"abc"`, `"ü"`, 1) + `}`,
+			expected: Errors{{Kind: InvalidValue, Path: "name", Expected: "at least 2 characters", Actual: "1 characters"}},
+		},
+		{
+			Name:     "Too long",
+			input:    `{` + strings.Replace(valid, `"abc"`, `"abcdef"`, 1) + `}`,
+			expected: Errors{{Kind: InvalidValue, Path: "name", Expected: "at most 5 characters", Actual: "6 characters"}},
+		},
+		{
+			Name:     "Not one of",
+			input:    `{` + strings.Replace(valid, `"offer"`, `"rollback"`, 1) + `}`,
+			expected: Errors{{Kind: InvalidValue, Path: "kind", Expected: "one of offer, answer", Actual: `"rollback"`}},
+		},
+		{
+			Name:     "Number not one of",
+			input:    `{` + strings.Replace(valid, `"level": 2`, `"level": 4`, 1) + `}`,
+			expected: Errors{{Kind: InvalidValue, Path: "level", Expected: "one of 1, 2, 3", Actual: "4"}},
+		},
+		{
+			Name:     "Regex mismatch",
+			input:    `{` + strings.Replace(valid, `"de"`, `"DE"`, 1) + `}`,
+			expected: Errors{{Kind: InvalidValue, Path: "code", Expected: "a match of ^[a-z]{2,3}$", Actual: `"DE"`}},
+		},
+		{
+			Name:  "Aggregated violations",
+			input: `{` + strings.NewReplacer(`0.5`, `1.5`, `["a"]`, `["a", "b", "c"]`, `8080`, `80`).Replace(valid) + `, "optional": 9}`,
+			expected: Errors{
+				{Kind: InvalidValue, Path: "volume", Expected: "at most 1", Actual: "1.5"},
+				{Kind: InvalidValue, Path: "tags", Expected: "at most 2 elements", Actual: "3 elements"},
+				{Kind: InvalidValue, Path: "nested[0].port", Expected: "at least 1024", Actual: "80"},
+				{Kind: InvalidValue, Path: "optional", Expected: "at least 10", Actual: "9"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			var result ConstrainedStruct
+			err := Unmarshal([]byte(tt.input), &result)
+
+			if tt.expected == nil {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+
+			var errs Errors
+			if !errors.As(err, &errs) || !reflect.DeepEqual(errs, tt.expected) {
+				t.Errorf("expected errors %v, but got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestUnmarshalStrict_RulesAfterStructure(t *testing.T) {
+	// Structural errors are reported instead of rule violations
+	var result ConstrainedStruct
+	err := Unmarshal([]byte(`{"clientID": "nope", "name": "abc"}`), &result)
+
+	var strictErr *Error
+	if !errors.As(err, &strictErr) || strictErr.Kind != MissingField {
+		t.Errorf("expected missing field, but got %v", err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	errs := Errors{
+		{Kind: InvalidValue, Path: "name", Expected: "at most 5 characters", Actual: "6 characters"},
+		{Kind: InvalidValue, Path: "kind", Expected: "one of offer, answer", Actual: `"x"`},
+	}
+
+	expected := `Invalid value at name: expected at most 5 characters, got 6 characters; Invalid value at kind: expected one of offer, answer, got "x"`
+	if errs.Error() != expected {
+		t.Errorf("expected '%s', but got '%s'", expected, errs.Error())
+	}
+}
+
+func TestUnmarshalStrict_InvalidRule(t *testing.T) {
+	type InvalidRule struct {
+		Count int `json:"count" validate:"uuid"`
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic for an invalid rule")
+		}
+	}()
+
+	var result InvalidRule
+	_ = Unmarshal([]byte(`{"count": 1}`), &result)
+}
+
+func TestFields(t *testing.T) {
+	type WithPointer struct {
+		Embedded
+		Count *int   `json:"count" validate:"min=1"`
+		Code  string `json:"code,omitempty" validate:"min=2,regex=^[a-z],[0-9]$"`
+		ID    int64  `json:"id,string"`
+	}
+
+	expected := []Field{
+		{Name: "source", Type: reflect.TypeFor[string]()},
+		{Name: "count", Type: reflect.TypeFor[*int](), Optional: true, Rules: []Rule{{Name: "min", Argument: "1"}}},
+		{Name: "code", Type: reflect.TypeFor[string](), Optional: true, Rules: []Rule{{Name: "min", Argument: "2"}, {Name: "regex", Argument: "^[a-z],[0-9]$"}}},
+		{Name: "id", Type: reflect.TypeFor[int64](), Quoted: true},
+	}
+
+	if fields := Fields(reflect.TypeFor[WithPointer]()); !reflect.DeepEqual(fields, expected) {
+		t.Errorf("expected %+v, got %+v", expected, fields)
+	}
+}
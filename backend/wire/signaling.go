@@ -0,0 +1,41 @@
+package wire
+
+import "encoding/json"
+
+const SDP_OFFER_MESSAGE_TYPE = "sdp-offer"
+const SDP_ANSWER_MESSAGE_TYPE = "sdp-answer"
+const ICE_CANDIDATE_MESSAGE_TYPE = "new-ice-candidate"
+const SDP_MESSAGE_TYPE = "sdp-message"
+
+// SessionDescription is the JSON form of a WebRTC session description (RTCSessionDescriptionInit).
+type SessionDescription struct {
+	Type string `json:"type" validate:"oneof=offer answer pranswer rollback"`
+	SDP  string `json:"sdp"`
+}
+
+// SDPMessage carries a session description between two peers. The receiver gets the sender's ID as RemoteClientID.
+type SDPMessage struct {
+	RemoteClientID string             `json:"remoteClientID" validate:"uuid"`
+	Description    SessionDescription `json:"description"`
+}
+
+type ClientSDPOfferMessage struct {
+	CalleeClientID string          `json:"calleeClientID" validate:"uuid"`
+	Offer          json.RawMessage `json:"offer"`
+}
+
+type ServerSDPOfferMessage struct {
+	CallerClientID string          `json:"callerClientID"`
+	Offer          json.RawMessage `json:"offer"`
+}
+
+type SDPAnswerMessage struct {
+	CallerClientID string          `json:"callerClientID" validate:"uuid"`
+	Answer         json.RawMessage `json:"answer"`
+}
+
+// ICEMessage carries an ICE candidate between two peers. The receiver gets the sender's ID as RemoteClientID.
+type ICEMessage struct {
+	RemoteClientID string          `json:"remoteClientID" validate:"uuid"`
+	Candidate      json.RawMessage `json:"candidate"`
+}
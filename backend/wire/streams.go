@@ -0,0 +1,17 @@
+package wire
+
+const STREAM_STARTED_MESSAGE_TYPE = "stream-started"
+const AVAILABLE_STREAMS_MESSAGE_TYPE = "streams-available"
+const STREAM_STOPPED_MESSAGE_TYPE = "stream-stopped"
+
+type StreamStartedMessage struct {
+	ClientID string `json:"clientID" validate:"uuid"`
+}
+
+type StreamStoppedMessage struct {
+	ClientID string `json:"clientID" validate:"uuid"`
+}
+
+type AvailableStreamsMessage struct {
+	ClientIDs []string `json:"clientIDs"`
+}
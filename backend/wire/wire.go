@@ -0,0 +1,69 @@
+// Package wire defines the messages of the ScreenEcho protocol as they are sent over the WebSocket: the envelope
+// [TypedMessage], the message types and their payloads.
+//
+// It only depends on the standard library, so the server and the Go client in package client share it without the
+// client pulling in the server. The server packages re-export the types they send and handle, e.g. connection.TypedMessage.
+package wire
+
+type MessageType string
+
+type TypedMessage[T any] struct {
+	Type MessageType `json:"type"`
+	Msg  T           `json:"msg"`
+}
+
+const ERROR_MESSAGE_TYPE = "error"
+
+type ErrorMessage struct {
+	ErrorMessage string `json:"errorMessage"`
+	Expected     string `json:"expected,omitempty"`
+	Actual       string `json:"actual,omitempty"`
+	// Fields lists the invalid fields of a received message.
+	Fields []FieldError `json:"fields,omitempty"`
+}
+
+// FieldError describes an invalid field of a received message.
+type FieldError struct {
+	// Path is the JSON path of the field within the message, e.g. "msg.description.type".
+	Path     string `json:"path"`
+	Expected string `json:"expected,omitempty"`
+	Actual   string `json:"actual,omitempty"`
+}
+
+// INTERNAL_ERROR_MESSAGE_TYPE is sent to a client after a handler panicked while processing one of its messages.
+const INTERNAL_ERROR_MESSAGE_TYPE = "internal-error"
+
+type InternalErrorMessage struct {
+	ErrorMessage string `json:"errorMessage"`
+	// MessageType is the type of the message whose handler panicked.
+	MessageType MessageType `json:"messageType"`
+}
+
+// SERVER_SHUTDOWN_MESSAGE_TYPE is sent to all clients right before the server closes their connections during a shutdown.
+const SERVER_SHUTDOWN_MESSAGE_TYPE = "server-shutdown"
+
+type ServerShutdownMessage struct {
+	Reason string `json:"reason"`
+	// ReconnectAfterMs is the number of milliseconds a client should wait before trying to reconnect.
+	ReconnectAfterMs int64 `json:"reconnectAfterMs"`
+}
+
+const CLIENT_ID_MESSAGE_TYPE = "client-id"
+
+// ClientIDMessage is the first message a client receives, it holds the ID the server assigned to the client.
+type ClientIDMessage struct {
+	ClientID string `json:"clientID"`
+	// ResumeToken gets the same ClientID again when reconnecting, see RESUME_QUERY_PARAMETER.
+	// It can be used once, shortly after the connection was lost.
+	ResumeToken string `json:"resumeToken"`
+}
+
+// RESUME_QUERY_PARAMETER is the query parameter of the connect URL that holds the ResumeToken of a lost connection.
+const RESUME_QUERY_PARAMETER = "resume"
+
+const CLIENT_DISCONNECT_MESSAGE_TYPE = "client-disconnect"
+
+// ClientDisconnectMessage is sent to the remaining clients of a room after a client left.
+type ClientDisconnectMessage struct {
+	ClientID string `json:"clientID"`
+}
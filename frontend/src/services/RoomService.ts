@@ -13,6 +13,17 @@ export type ErrorMessage = {
     errorMessage: string;
     expected?: string;
     actual?: string;
+    fields?: FieldError[];
+};
+
+/**
+ * Describes an invalid field of a message the server received.
+ */
+export type FieldError = {
+    /** JSON path of the field, e.g. "msg.description.type". */
+    path: string;
+    expected?: string;
+    actual?: string;
 };
 
 type ClientIDMessage = {
@@ -0,0 +1,104 @@
+// Code generated by screenecho-schema. DO NOT EDIT.
+// Protocol version 1.0.0, see backend/protocol.
+
+export type AvailableStreamsMessage = {
+    clientIDs: string[];
+};
+
+export type ClientDisconnectMessage = {
+    clientID: string;
+};
+
+export type ClientIDMessage = {
+    clientID: string;
+    resumeToken: string;
+};
+
+export type ClientSDPOfferMessage = {
+    calleeClientID: string;
+    offer: unknown;
+};
+
+export type ErrorMessage = {
+    errorMessage: string;
+    expected?: string;
+    actual?: string;
+    fields?: FieldError[];
+};
+
+export type FieldError = {
+    path: string;
+    expected?: string;
+    actual?: string;
+};
+
+export type ICEMessage = {
+    remoteClientID: string;
+    candidate: unknown;
+};
+
+export type InternalErrorMessage = {
+    errorMessage: string;
+    messageType: string;
+};
+
+export type SDPAnswerMessage = {
+    callerClientID: string;
+    answer: unknown;
+};
+
+export type SDPMessage = {
+    remoteClientID: string;
+    description: SessionDescription;
+};
+
+export type ServerSDPOfferMessage = {
+    callerClientID: string;
+    offer: unknown;
+};
+
+export type ServerShutdownMessage = {
+    reason: string;
+    reconnectAfterMs: number;
+};
+
+export type SessionDescription = {
+    type: "offer" | "answer" | "pranswer" | "rollback";
+    sdp: string;
+};
+
+export type StreamStartedMessage = {
+    clientID: string;
+};
+
+export type StreamStoppedMessage = {
+    clientID: string;
+};
+
+/** Messages clients send to the server. */
+export type ClientMessage =
+    | { type: "new-ice-candidate"; msg: ICEMessage }
+    | { type: "sdp-answer"; msg: SDPAnswerMessage }
+    | { type: "sdp-message"; msg: SDPMessage }
+    | { type: "sdp-offer"; msg: ClientSDPOfferMessage }
+    | { type: "stream-started"; msg: StreamStartedMessage }
+    | { type: "stream-stopped"; msg: StreamStoppedMessage };
+
+export type ClientMessageType = ClientMessage["type"];
+
+/** Messages the server sends to clients. */
+export type ServerMessage =
+    | { type: "client-disconnect"; msg: ClientDisconnectMessage }
+    | { type: "client-id"; msg: ClientIDMessage }
+    | { type: "error"; msg: ErrorMessage }
+    | { type: "internal-error"; msg: InternalErrorMessage }
+    | { type: "new-ice-candidate"; msg: ICEMessage }
+    | { type: "sdp-answer"; msg: SDPAnswerMessage }
+    | { type: "sdp-message"; msg: SDPMessage }
+    | { type: "sdp-offer"; msg: ServerSDPOfferMessage }
+    | { type: "server-shutdown"; msg: ServerShutdownMessage }
+    | { type: "stream-started"; msg: StreamStartedMessage }
+    | { type: "stream-stopped"; msg: StreamStoppedMessage }
+    | { type: "streams-available"; msg: AvailableStreamsMessage };
+
+export type ServerMessageType = ServerMessage["type"];